@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"poke/engine"
+	"strings"
+)
+
+type analyzeOptions struct {
+	engine.AnalyzeConfig
+	ciExitCodes bool
+}
+
+func parseAnalyzeFlags(args []string) (analyzeOptions, error) {
+	var cfg analyzeOptions
+	fs := flag.NewFlagSet("poke analyze", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&cfg.In, "in", "", "JSONL results written by -jsonl-out (required); re-scores full responses with -jsonl-full-body, else the previews")
+	fs.StringVar(&cfg.MarkersFile, "markers-file", "", "Path to markers config JSON to re-score the results with; optional")
+	fs.StringVar(&cfg.JSONLOut, "jsonl-out", "", "Write the re-scored rows to JSONL file (path); optional")
+	fs.BoolVar(&cfg.ciExitCodes, "ci-exit-codes", false, "Use CI-friendly exit codes when marker stop thresholds trigger (2=warn/info, 3=error, 4=critical)")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return analyzeOptions{}, helpError{usage: analyzeUsageText(fs)}
+		}
+		return analyzeOptions{}, errors.New(err.Error() + "\n\n" + analyzeUsageText(fs))
+	}
+	if err := cfg.Validate(); err != nil {
+		if errors.As(err, new(engine.UsageError)) {
+			return analyzeOptions{}, errors.New(err.Error() + "\n\n" + analyzeUsageText(fs))
+		}
+		return analyzeOptions{}, err
+	}
+	return cfg, nil
+}
+
+func analyzeUsageText(fs *flag.FlagSet) string {
+	var b strings.Builder
+	b.WriteString("Usage:\n  poke analyze -in FILE [flags]\n\nFlags:\n")
+	fs.SetOutput(&b)
+	fs.PrintDefaults()
+	return b.String()
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseAnalyzeFlags(t *testing.T) {
+	if _, err := parseAnalyzeFlags(nil); err == nil || !strings.Contains(err.Error(), "-in") {
+		t.Fatalf("expected a missing -in error, got %v", err)
+	}
+	if _, err := parseAnalyzeFlags([]string{"-in", "r.jsonl", "-jsonl-out", "r.jsonl"}); err == nil {
+		t.Fatalf("expected an error for -jsonl-out overwriting -in")
+	}
+	var he helpError
+	if _, err := parseAnalyzeFlags([]string{"-h"}); !errors.As(err, &he) || !strings.Contains(he.usage, "poke analyze -in FILE") {
+		t.Fatalf("expected analyze usage, got %v", err)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// repeatableFlags take a list in a -config file: each item is one occurrence of the flag.
+var repeatableFlags = map[string]bool{"var": true, "resolve": true}
+
+// configFile is a loaded -config profile, remembering the line each applied key came from so that
+// validation errors can point at it.
+type configFile struct {
+	path  string
+	lines map[string]int
+}
+
+// applyConfigFile sets every flag named in the profile at path that the command line left unset.
+// Keys are flag names (snake_case works too). headers, cookies and vars take a mapping and markers
+// an inline markers config; ${NAME} and ${NAME:-default} in values expand from the environment.
+func applyConfigFile(path string, fs *flag.FlagSet, cfg *options) (*configFile, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+	var root *configNode
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".json" || (ext != ".yaml" && ext != ".yml" && strings.HasPrefix(strings.TrimSpace(string(src)), "{")) {
+		root, err = parseJSONConfig(src)
+	} else {
+		root, err = parseYAML(src)
+	}
+	cf := &configFile{path: path, lines: map[string]int{}}
+	var se *syntaxError
+	if errors.As(err, &se) {
+		return nil, cf.errorf(&configNode{line: se.line}, "%s", se.msg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if root.kind != mapNode {
+		return nil, cf.errorf(root, "expected a mapping of flag names to values")
+	}
+
+	onCommandLine := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { onCommandLine[f.Name] = true })
+
+	seen := map[string]bool{}
+	for _, rawKey := range root.keys {
+		v := root.fields[rawKey]
+		key := strings.ReplaceAll(rawKey, "_", "-")
+		if seen[key] {
+			return nil, cf.errorf(v, "duplicate key %q", rawKey)
+		}
+		seen[key] = true
+		if v.isNull() {
+			continue
+		}
+		switch key {
+		case "config":
+			return nil, cf.errorf(v, "config files cannot include other config files")
+		case "headers":
+			// Like markers: a file given on the command line replaces the profile's inline values,
+			// which would otherwise be applied on top of it.
+			if onCommandLine["headers-file"] {
+				continue
+			}
+			if cfg.Headers, err = cf.stringMap(v, key); err != nil {
+				return nil, err
+			}
+			cf.lines[key] = v.line
+			continue
+		case "cookies":
+			if onCommandLine["cookies-file"] {
+				continue
+			}
+			if cfg.Cookies, err = cf.stringMap(v, key); err != nil {
+				return nil, err
+			}
+			cf.lines[key] = v.line
+			continue
+		case "markers":
+			if onCommandLine["markers-file"] {
+				continue
+			}
+			if v.kind != mapNode {
+				return nil, cf.errorf(v, "markers: expected an inline markers config (the -markers-file JSON object)")
+			}
+			if err := cf.expandAll(v); err != nil {
+				return nil, err
+			}
+			if cfg.Markers, err = json.Marshal(v.toJSON()); err != nil {
+				return nil, cf.errorf(v, "markers: %v", err)
+			}
+			cf.lines[key] = v.line
+			continue
+		case "vars":
+			key = "var"
+		}
+
+		f := fs.Lookup(key)
+		if f == nil {
+			return nil, cf.errorf(v, "unknown key %q", rawKey)
+		}
+		if onCommandLine[key] {
+			continue
+		}
+		values, err := cf.flagValues(v, key)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range values {
+			if err := fs.Set(key, s); err != nil {
+				return nil, cf.errorf(v, "invalid value %q for -%s: %v", s, key, err)
+			}
+		}
+		cf.lines[key] = v.line
+	}
+	return cf, nil
+}
+
+// flagValues returns the flag arguments v stands for: one for a scalar, one per item (or name=value
+// entry, for vars) for a repeatable flag.
+func (cf *configFile) flagValues(v *configNode, key string) ([]string, error) {
+	switch {
+	case v.kind == scalarNode:
+		s, err := cf.expand(v)
+		return []string{s}, err
+	case !repeatableFlags[key]:
+		return nil, cf.errorf(v, "-%s: expected a single value", key)
+	case v.kind == mapNode && key == "var":
+		m, err := cf.stringMap(v, "vars")
+		if err != nil {
+			return nil, err
+		}
+		out := make([]string, 0, len(v.keys))
+		for _, name := range v.keys {
+			out = append(out, name+"="+m[name])
+		}
+		return out, nil
+	case v.kind == listNode:
+		out := make([]string, 0, len(v.items))
+		for _, it := range v.items {
+			if it.kind != scalarNode {
+				return nil, cf.errorf(it, "-%s: expected a list of values", key)
+			}
+			s, err := cf.expand(it)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, s)
+		}
+		return out, nil
+	}
+	return nil, cf.errorf(v, "-%s: expected a value or a list of values", key)
+}
+
+func (cf *configFile) stringMap(v *configNode, key string) (map[string]string, error) {
+	if v.kind != mapNode {
+		return nil, cf.errorf(v, "%s: expected a mapping of names to values", key)
+	}
+	m := make(map[string]string, len(v.keys))
+	for _, name := range v.keys {
+		f := v.fields[name]
+		if f.kind != scalarNode {
+			return nil, cf.errorf(f, "%s.%s: expected a single value", key, name)
+		}
+		s, err := cf.expand(f)
+		if err != nil {
+			return nil, err
+		}
+		m[name] = s
+	}
+	return m, nil
+}
+
+// expandAll expands the environment in every string scalar under v, in place.
+func (cf *configFile) expandAll(v *configNode) error {
+	switch v.kind {
+	case mapNode:
+		for _, k := range v.keys {
+			if err := cf.expandAll(v.fields[k]); err != nil {
+				return err
+			}
+		}
+	case listNode:
+		for _, it := range v.items {
+			if err := cf.expandAll(it); err != nil {
+				return err
+			}
+		}
+	default:
+		s, err := cf.expand(v)
+		if err != nil {
+			return err
+		}
+		v.value = s
+	}
+	return nil
+}
+
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-[^}]*)?\}`)
+
+// expand replaces ${NAME} and ${NAME:-default} in a scalar; an unset NAME without a default is an error.
+func (cf *configFile) expand(v *configNode) (string, error) {
+	var missing string
+	s := envRef.ReplaceAllStringFunc(v.value, func(ref string) string {
+		m := envRef.FindStringSubmatch(ref)
+		if val, ok := os.LookupEnv(m[1]); ok && (val != "" || m[2] == "") {
+			return val
+		}
+		if m[2] != "" {
+			return m[2][2:]
+		}
+		if missing == "" {
+			missing = m[1]
+		}
+		return ""
+	})
+	if missing != "" {
+		return "", cf.errorf(v, "environment variable %s is not set", missing)
+	}
+	return s, nil
+}
+
+func (cf *configFile) errorf(v *configNode, format string, args ...any) error {
+	return fmt.Errorf("%s:%d: %s", cf.path, v.line, fmt.Sprintf(format, args...))
+}
+
+var flagRef = regexp.MustCompile(`-[a-z0-9]+(-[a-z0-9]+)*|inline markers`)
+
+// locate prefixes a validation error with the file location of the first flag it names that the
+// config file set, so "-workers must be > 0" becomes "poke.yaml:7: -workers must be > 0".
+func (cf *configFile) locate(err error) error {
+	if cf == nil {
+		return err
+	}
+	for _, ref := range flagRef.FindAllString(err.Error(), -1) {
+		key := strings.TrimPrefix(ref, "-")
+		if ref == "inline markers" {
+			key = "markers"
+		}
+		if line, ok := cf.lines[key]; ok {
+			return fmt.Errorf("%s:%d: %w", cf.path, line, err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, name, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+func TestParseFlags_ConfigFile(t *testing.T) {
+	t.Setenv("POKE_TEST_URL", "https://api.example.test/chat")
+	t.Setenv("POKE_TEST_TOKEN", "s3cret")
+	path := writeConfigFile(t, "poke.yaml", `
+url: ${POKE_TEST_URL}
+prompts: corpus.jsonl
+workers: 4
+timeout: 15s
+retries: 2
+rate: 5
+ci_exit_codes: true
+jsonl-out: ${POKE_TEST_OUT:-poke.results.jsonl}
+body-template: |
+  {"input": "{{prompt}}"}
+headers:
+  Authorization: Bearer ${POKE_TEST_TOKEN}
+cookies:
+  sid: "1"
+vars:
+  tenant: acme
+resolve: [api.example.test:443:127.0.0.1]
+markers:
+  regexes:
+    - id: codename
+      category: system_leak
+      pattern: bluebird
+      enabled: true
+`)
+	cfg, err := parseFlags([]string{"-config", path, "-workers", "8", "-var", "tenant=cli"})
+	if err != nil {
+		t.Fatalf("parseFlags: %v", err)
+	}
+	if cfg.URL != "https://api.example.test/chat" || cfg.PromptsFile != "corpus.jsonl" || cfg.Timeout != 15*time.Second || cfg.Retry.MaxRetries != 2 || cfg.Rate.Rate != 5 || !cfg.ciExitCodes {
+		t.Fatalf("file values not applied: %+v", cfg)
+	}
+	if cfg.Workers != 8 || cfg.Vars["tenant"] != "cli" {
+		t.Fatalf("expected the command line to override the file, got workers=%d vars=%v", cfg.Workers, cfg.Vars)
+	}
+	if cfg.JSONLOut != "poke.results.jsonl" || cfg.BodyTemplate != "{\"input\": \"{{prompt}}\"}\n" {
+		t.Fatalf("unexpected jsonl-out %q / body template %q", cfg.JSONLOut, cfg.BodyTemplate)
+	}
+	if cfg.Headers["Authorization"] != "Bearer s3cret" || cfg.Cookies["sid"] != "1" || len(cfg.Transport.Resolve) != 1 {
+		t.Fatalf("unexpected headers %v, cookies %v or resolve %v", cfg.Headers, cfg.Cookies, cfg.Transport.Resolve)
+	}
+	if want := `{"regexes":[{"category":"system_leak","enabled":true,"id":"codename","pattern":"bluebird"}]}`; string(cfg.Markers) != want {
+		t.Fatalf("unexpected inline markers %s", cfg.Markers)
+	}
+
+	// -headers-file and -cookies-file on the command line replace the file's inline headers and cookies.
+	cfg, err = parseFlags([]string{"-config", path, "-headers-file", "h.txt", "-cookies-file", "c.txt"})
+	if err != nil || cfg.Headers != nil || cfg.Cookies != nil || cfg.HeadersFile != "h.txt" || cfg.CookiesFile != "c.txt" {
+		t.Fatalf("expected the command-line files to win, got %v / headers %v / cookies %v", err, cfg.Headers, cfg.Cookies)
+	}
+
+	// A -markers-file on the command line replaces the file's inline markers.
+	cfg, err = parseFlags([]string{"-config", path, "-markers-file", "m.json"})
+	if err != nil || cfg.Markers != nil || cfg.MarkersFile != "m.json" {
+		t.Fatalf("expected -markers-file to win, got %v / %s", err, cfg.Markers)
+	}
+}
+
+func TestParseFlags_ConfigFileJSON(t *testing.T) {
+	path := writeConfigFile(t, "poke.json", `{"url": "https://x.test", "prompts": "p.txt", "temperature": 0.2, "resolve": ["x.test:443:127.0.0.1"]}`)
+	cfg, err := parseFlags([]string{"-config", path})
+	if err != nil {
+		t.Fatalf("parseFlags: %v", err)
+	}
+	if cfg.URL != "https://x.test" || cfg.Temperature != 0.2 || !cfg.TemperatureSet || len(cfg.Transport.Resolve) != 1 {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+}
+
+func TestParseFlags_ConfigFileErrorsPointAtLines(t *testing.T) {
+	cases := map[string]string{
+		"url: https://x.test\nprompts: p\nworkers: 0\n":                      "poke.yaml:3: -workers must be > 0",
+		"url: https://x.test\nprompts: p\nworkers: many\n":                   `poke.yaml:3: invalid value "many" for -workers`,
+		"url: https://x.test\n\nwrokers: 2\n":                                `poke.yaml:3: unknown key "wrokers"`,
+		"url: ${POKE_TEST_UNSET}\n":                                          "poke.yaml:1: environment variable POKE_TEST_UNSET is not set",
+		"url: [a, b]\n":                                                      "poke.yaml:1: -url: expected a single value",
+		"url: https://x.test\nprompts: p\nrecord: d\n\nresume: true\n":       "poke.yaml:3: -record cannot be combined with -resume",
+		"url: https://x.test\nprompts: p\nmarkers:\n  regexes:\n  - id: x\n": "poke.yaml:3: invalid inline markers",
+		"config: other.yaml\n":                                               "poke.yaml:1: config files cannot include",
+		"url: x\n  bad: indent\n":                                            "poke.yaml:2: unexpected indentation",
+		"headers: Authorization\n":                                           "poke.yaml:1: headers: expected a mapping",
+	}
+	for body, want := range cases {
+		path := writeConfigFile(t, "poke.yaml", body)
+		_, err := parseFlags([]string{"-config", path})
+		if err == nil || !strings.Contains(err.Error(), filepath.Dir(path)) || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: expected %q, got %v", body, want, err)
+		}
+	}
+
+	// Errors about values given on the command line carry no file location.
+	path := writeConfigFile(t, "poke.yaml", "url: https://x.test\nprompts: p\n")
+	if _, err := parseFlags([]string{"-config", path, "-workers", "0"}); err == nil || strings.Contains(err.Error(), "poke.yaml:") {
+		t.Fatalf("expected an unlocated -workers error, got %v", err)
+	}
+	if _, err := parseFlags([]string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}); err == nil || !strings.Contains(err.Error(), "read config file") {
+		t.Fatalf("expected a read error, got %v", err)
+	}
+}
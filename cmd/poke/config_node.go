@@ -0,0 +1,485 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// syntaxError is a -config parse error on a given line.
+type syntaxError struct {
+	line int
+	msg  string
+}
+
+func (e *syntaxError) Error() string { return fmt.Sprintf("line %d: %s", e.line, e.msg) }
+
+func syntaxErrorf(line int, format string, args ...any) error {
+	return &syntaxError{line: line, msg: fmt.Sprintf(format, args...)}
+}
+
+type nodeKind int
+
+const (
+	scalarNode nodeKind = iota
+	mapNode
+	listNode
+)
+
+// configNode is one value of a -config file and the line it starts on, so errors can point at it.
+// Null scalars (an empty YAML value, JSON null) mean "not set".
+type configNode struct {
+	line   int
+	kind   nodeKind
+	value  string
+	quoted bool // quoted scalars are always strings; plain ones may be numbers, booleans or null
+	keys   []string
+	fields map[string]*configNode
+	items  []*configNode
+}
+
+func (n *configNode) isNull() bool {
+	return n.kind == scalarNode && !n.quoted && (n.value == "" || n.value == "null" || n.value == "~")
+}
+
+var yamlNumber = regexp.MustCompile(`^[-+]?(\d+\.?\d*|\.\d+)([eE][-+]?\d+)?$`)
+
+// toJSON renders n with YAML's scalar typing: plain true/false, null and numbers keep their type.
+func (n *configNode) toJSON() any {
+	switch n.kind {
+	case mapNode:
+		m := make(map[string]any, len(n.keys))
+		for _, k := range n.keys {
+			m[k] = n.fields[k].toJSON()
+		}
+		return m
+	case listNode:
+		l := make([]any, 0, len(n.items))
+		for _, it := range n.items {
+			l = append(l, it.toJSON())
+		}
+		return l
+	}
+	switch {
+	case n.quoted:
+		return n.value
+	case n.isNull():
+		return nil
+	case n.value == "true" || n.value == "false":
+		return n.value == "true"
+	case yamlNumber.MatchString(n.value):
+		return json.Number(strings.TrimPrefix(n.value, "+"))
+	}
+	return n.value
+}
+
+// yamlLine is one source line: text has the comment and surrounding spaces removed, raw keeps the
+// line as written for block scalars.
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+	raw    string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// parseYAML reads the YAML subset a run profile needs: block mappings and sequences, plain and quoted
+// scalars, | and > block scalars, [a, b] flow sequences and # comments. Anchors, tags, flow mappings
+// and multi-document streams are rejected.
+func parseYAML(src []byte) (*configNode, error) {
+	p := &yamlParser{}
+	for i, raw := range strings.Split(strings.ReplaceAll(string(src), "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, syntaxErrorf(i+1, "tabs are not allowed in indentation")
+		}
+		p.lines = append(p.lines, yamlLine{num: i + 1, indent: len(raw) - len(trimmed), text: stripYAMLComment(trimmed), raw: raw})
+	}
+	p.skipBlank()
+	if p.pos < len(p.lines) && p.lines[p.pos].text == "---" {
+		p.pos++
+		p.skipBlank()
+	}
+	if p.pos == len(p.lines) {
+		return &configNode{line: 1, kind: mapNode, fields: map[string]*configNode{}}, nil
+	}
+	root, err := p.parseBlock(p.lines[p.pos].indent)
+	if err != nil {
+		return nil, err
+	}
+	p.skipBlank()
+	if p.pos < len(p.lines) && p.lines[p.pos].text != "..." {
+		l := p.lines[p.pos]
+		if l.text == "---" {
+			return nil, syntaxErrorf(l.num, "only one document is supported")
+		}
+		return nil, syntaxErrorf(l.num, "unexpected indentation")
+	}
+	return root, nil
+}
+
+func (p *yamlParser) skipBlank() {
+	for p.pos < len(p.lines) && p.lines[p.pos].text == "" {
+		p.pos++
+	}
+}
+
+func isSeqItem(text string) bool { return text == "-" || strings.HasPrefix(text, "- ") }
+
+// parseBlock parses the mapping or sequence whose entries start at indent.
+func (p *yamlParser) parseBlock(indent int) (*configNode, error) {
+	if isSeqItem(p.lines[p.pos].text) {
+		return p.parseSeq(indent)
+	}
+	return p.parseMap(indent)
+}
+
+func (p *yamlParser) parseMap(indent int) (*configNode, error) {
+	n := &configNode{line: p.lines[p.pos].num, kind: mapNode, fields: map[string]*configNode{}}
+	for p.skipBlank(); p.pos < len(p.lines); p.skipBlank() {
+		l := p.lines[p.pos]
+		if l.indent < indent || (l.indent == indent && isSeqItem(l.text)) || l.text == "---" || l.text == "..." {
+			break
+		}
+		if l.indent > indent {
+			return nil, syntaxErrorf(l.num, "unexpected indentation")
+		}
+		key, rest, err := splitYAMLKey(l.text)
+		if err != nil {
+			return nil, syntaxErrorf(l.num, "%v", err)
+		}
+		if _, dup := n.fields[key]; dup {
+			return nil, syntaxErrorf(l.num, "duplicate key %q", key)
+		}
+		p.pos++
+		v, err := p.parseValue(l, indent, rest, true)
+		if err != nil {
+			return nil, err
+		}
+		n.keys = append(n.keys, key)
+		n.fields[key] = v
+	}
+	return n, nil
+}
+
+func (p *yamlParser) parseSeq(indent int) (*configNode, error) {
+	n := &configNode{line: p.lines[p.pos].num, kind: listNode}
+	for p.skipBlank(); p.pos < len(p.lines); p.skipBlank() {
+		l := p.lines[p.pos]
+		if l.indent < indent || !isSeqItem(l.text) {
+			break
+		}
+		if l.indent > indent {
+			return nil, syntaxErrorf(l.num, "unexpected indentation")
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " ")
+		if _, _, err := splitYAMLKey(rest); err == nil && !strings.HasPrefix(rest, "[") && !strings.HasPrefix(rest, `"`) && !strings.HasPrefix(rest, "'") {
+			// "- key: value" opens a mapping indented to where key starts; re-read the line as its first entry.
+			p.lines[p.pos].indent = indent + len(l.text) - len(rest)
+			p.lines[p.pos].text = rest
+			item, err := p.parseMap(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			n.items = append(n.items, item)
+			continue
+		}
+		p.pos++
+		item, err := p.parseValue(l, indent, rest, false)
+		if err != nil {
+			return nil, err
+		}
+		n.items = append(n.items, item)
+	}
+	return n, nil
+}
+
+// parseValue parses what follows "key:" or "-" on line l: an inline scalar, a block scalar, or a
+// nested block on the following lines. A mapping value may be a sequence at the key's own indent.
+func (p *yamlParser) parseValue(l yamlLine, indent int, rest string, inMap bool) (*configNode, error) {
+	if rest == "" {
+		p.skipBlank()
+		if p.pos < len(p.lines) {
+			next := p.lines[p.pos]
+			if next.indent > indent || (inMap && next.indent == indent && isSeqItem(next.text)) {
+				v, err := p.parseBlock(next.indent)
+				if err != nil {
+					return nil, err
+				}
+				v.line = l.num
+				return v, nil
+			}
+		}
+		return &configNode{line: l.num}, nil
+	}
+	if strings.HasPrefix(rest, "|") || strings.HasPrefix(rest, ">") {
+		return p.parseBlockScalar(l, indent, rest)
+	}
+	return parseYAMLScalar(rest, l.num)
+}
+
+// parseBlockScalar reads a | (literal) or > (folded) scalar with optional - or + chomping.
+func (p *yamlParser) parseBlockScalar(l yamlLine, indent int, header string) (*configNode, error) {
+	style, chomp := header[0], header[1:]
+	if chomp != "" && chomp != "-" && chomp != "+" {
+		return nil, syntaxErrorf(l.num, "unsupported block scalar header %q", header)
+	}
+	var body []string
+	blockIndent := -1
+	for ; p.pos < len(p.lines); p.pos++ {
+		raw := p.lines[p.pos].raw
+		if strings.TrimSpace(raw) == "" {
+			body = append(body, "")
+			continue
+		}
+		ind := len(raw) - len(strings.TrimLeft(raw, " "))
+		if blockIndent < 0 {
+			if ind <= indent {
+				break
+			}
+			blockIndent = ind
+		}
+		if ind < blockIndent {
+			break
+		}
+		body = append(body, raw[blockIndent:])
+	}
+	// Trailing blank lines belong to the chomping, not to the next key.
+	trailing := 0
+	for len(body) > 0 && body[len(body)-1] == "" {
+		body = body[:len(body)-1]
+		trailing++
+	}
+	p.pos -= trailing
+
+	var text string
+	if style == '|' {
+		text = strings.Join(body, "\n")
+	} else {
+		var b strings.Builder
+		for i, line := range body {
+			switch {
+			case i == 0 || (line != "" && body[i-1] == ""):
+			case line == "":
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(' ')
+			}
+			b.WriteString(line)
+		}
+		text = b.String()
+	}
+	switch {
+	case len(body) == 0 || chomp == "-":
+	case chomp == "+":
+		text += strings.Repeat("\n", trailing+1)
+	default:
+		text += "\n"
+	}
+	return &configNode{line: l.num, value: text, quoted: true}, nil
+}
+
+// splitYAMLKey splits "key: value" (the key may be quoted); it fails when text is not a mapping entry.
+func splitYAMLKey(text string) (key, rest string, err error) {
+	if strings.HasPrefix(text, `"`) || strings.HasPrefix(text, "'") {
+		end := quotedEnd(text)
+		if end < 0 {
+			return "", "", errors.New("unterminated quoted key")
+		}
+		k, err := parseYAMLScalar(text[:end], 0)
+		if err != nil {
+			return "", "", err
+		}
+		after := text[end:]
+		if after != ":" && !strings.HasPrefix(after, ": ") {
+			return "", "", fmt.Errorf("expected ':' after key %s", text[:end])
+		}
+		return k.value, strings.TrimSpace(after[1:]), nil
+	}
+	i := strings.Index(text, ": ")
+	if i < 0 {
+		if !strings.HasSuffix(text, ":") {
+			return "", "", fmt.Errorf("expected 'key: value', got %q", text)
+		}
+		i = len(text) - 1
+	}
+	key = strings.TrimSpace(text[:i])
+	if key == "" || strings.ContainsAny(key[:1], "[]{}&*!|>%@`") {
+		return "", "", fmt.Errorf("expected 'key: value', got %q", text)
+	}
+	return key, strings.TrimSpace(text[i+1:]), nil
+}
+
+// quotedEnd returns the index just past the quoted string text starts with, or -1.
+func quotedEnd(text string) int {
+	q := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case q == '"' && text[i] == '\\':
+			i++
+		case text[i] == q && q == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == q:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// stripYAMLComment drops a # comment (at the start or after a space, outside quotes) and trailing
+// spaces. An unterminated quote ends the search; the scalar parser reports it if it matters.
+func stripYAMLComment(text string) string {
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '#' && (i == 0 || text[i-1] == ' '):
+			return strings.TrimRight(text[:i], " ")
+		case (c == '"' || c == '\'') && (i == 0 || strings.ContainsRune(" :-[,", rune(text[i-1]))):
+			end := quotedEnd(text[i:])
+			if end < 0 {
+				return strings.TrimRight(text, " ")
+			}
+			i += end - 1
+		}
+	}
+	return strings.TrimRight(text, " ")
+}
+
+func parseYAMLScalar(text string, line int) (*configNode, error) {
+	if (text[0] == '"' || text[0] == '\'') && quotedEnd(text) != len(text) {
+		if quotedEnd(text) < 0 {
+			return nil, syntaxErrorf(line, "unterminated quoted string")
+		}
+		return nil, syntaxErrorf(line, "unexpected text after quoted string")
+	}
+	switch text[0] {
+	case '"':
+		s, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, syntaxErrorf(line, "invalid double-quoted string: %v", err)
+		}
+		return &configNode{line: line, value: s, quoted: true}, nil
+	case '\'':
+		return &configNode{line: line, value: strings.ReplaceAll(text[1:len(text)-1], "''", "'"), quoted: true}, nil
+	case '[':
+		return parseYAMLFlowSeq(text, line)
+	case '{':
+		return nil, syntaxErrorf(line, "flow mappings are not supported; quote the value or use an indented block")
+	case '&', '*', '!':
+		return nil, syntaxErrorf(line, "anchors, aliases and tags are not supported")
+	}
+	return &configNode{line: line, value: text}, nil
+}
+
+func parseYAMLFlowSeq(text string, line int) (*configNode, error) {
+	if !strings.HasSuffix(text, "]") {
+		return nil, syntaxErrorf(line, "unterminated flow sequence")
+	}
+	n := &configNode{line: line, kind: listNode}
+	inner := strings.TrimSpace(text[1 : len(text)-1])
+	for inner != "" {
+		end := strings.IndexByte(inner, ',')
+		if inner[0] == '"' || inner[0] == '\'' {
+			if end = quotedEnd(inner); end < 0 {
+				return nil, syntaxErrorf(line, "unterminated quoted string")
+			}
+			if rest := strings.TrimSpace(inner[end:]); rest != "" && rest[0] != ',' {
+				return nil, syntaxErrorf(line, "expected ',' in flow sequence")
+			}
+		}
+		if end < 0 {
+			end = len(inner)
+		}
+		item := strings.TrimSpace(inner[:end])
+		if item == "" || item[0] == '[' {
+			return nil, syntaxErrorf(line, "flow sequences hold scalars only")
+		}
+		v, err := parseYAMLScalar(item, line)
+		if err != nil {
+			return nil, err
+		}
+		n.items = append(n.items, v)
+		inner = strings.TrimSpace(inner[end:])
+		inner = strings.TrimSpace(strings.TrimPrefix(inner, ","))
+	}
+	return n, nil
+}
+
+// parseJSONConfig reads a JSON profile into nodes, tracking the line each value starts on.
+func parseJSONConfig(src []byte) (*configNode, error) {
+	dec := json.NewDecoder(bytes.NewReader(src))
+	dec.UseNumber()
+	lineAt := func() int {
+		off := int(dec.InputOffset())
+		for off < len(src) && strings.IndexByte(" \t\r\n,:", src[off]) >= 0 {
+			off++
+		}
+		return 1 + bytes.Count(src[:off], []byte("\n"))
+	}
+	var read func() (*configNode, error)
+	read = func() (*configNode, error) {
+		line := lineAt()
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, syntaxErrorf(line, "invalid JSON: %v", err)
+		}
+		switch t := tok.(type) {
+		case json.Delim:
+			if t == '{' {
+				n := &configNode{line: line, kind: mapNode, fields: map[string]*configNode{}}
+				for dec.More() {
+					kline := lineAt()
+					kt, err := dec.Token()
+					if err != nil {
+						return nil, syntaxErrorf(kline, "invalid JSON: %v", err)
+					}
+					key := kt.(string)
+					if _, dup := n.fields[key]; dup {
+						return nil, syntaxErrorf(kline, "duplicate key %q", key)
+					}
+					v, err := read()
+					if err != nil {
+						return nil, err
+					}
+					n.keys = append(n.keys, key)
+					n.fields[key] = v
+				}
+				_, err := dec.Token()
+				return n, err
+			}
+			n := &configNode{line: line, kind: listNode}
+			for dec.More() {
+				v, err := read()
+				if err != nil {
+					return nil, err
+				}
+				n.items = append(n.items, v)
+			}
+			_, err := dec.Token()
+			return n, err
+		case string:
+			return &configNode{line: line, value: t, quoted: true}, nil
+		case json.Number:
+			return &configNode{line: line, value: t.String()}, nil
+		case bool:
+			return &configNode{line: line, value: strconv.FormatBool(t)}, nil
+		}
+		return &configNode{line: line, value: "null"}, nil
+	}
+	root, err := read()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, syntaxErrorf(lineAt(), "unexpected data after the top-level value")
+	}
+	return root, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML_Subset(t *testing.T) {
+	src := `---
+# run profile
+url: https://api.example.test/chat?a=1#frag   # trailing comment
+quoted: "a # not a comment\n"
+single: 'it''s'
+empty:
+list: [a, "b, c", 3]
+headers:
+  Authorization: Bearer x
+  "X-Odd: key": v
+resolve:
+- a.test:443:127.0.0.1
+- b.test:443:127.0.0.1
+targets:
+  - name: one
+    rate: 2
+  - name: two
+literal: |
+  {"prompt": "{{prompt}}"}
+  # kept
+
+folded: >-
+  one
+  two
+
+  three
+after: true
+`
+	root, err := parseYAML([]byte(src))
+	if err != nil {
+		t.Fatalf("parseYAML: %v", err)
+	}
+	got, _ := json.Marshal(root.toJSON())
+	want := `{"after":true,"empty":null,"folded":"one two\nthree","headers":{"Authorization":"Bearer x","X-Odd: key":"v"},` +
+		`"list":["a","b, c",3],"literal":"{\"prompt\": \"{{prompt}}\"}\n# kept\n","quoted":"a # not a comment\n",` +
+		`"resolve":["a.test:443:127.0.0.1","b.test:443:127.0.0.1"],"single":"it's",` +
+		`"targets":[{"name":"one","rate":2},{"name":"two"}],"url":"https://api.example.test/chat?a=1#frag"}`
+	if string(got) != want {
+		t.Fatalf("unexpected tree:\n got %s\nwant %s", got, want)
+	}
+	if !reflect.DeepEqual(root.keys[:3], []string{"url", "quoted", "single"}) {
+		t.Fatalf("expected keys in file order, got %v", root.keys)
+	}
+	if root.fields["headers"].line != 8 || root.fields["targets"].items[1].line != 17 || root.fields["after"].line != 27 {
+		t.Fatalf("unexpected lines: headers=%d targets[1]=%d after=%d", root.fields["headers"].line, root.fields["targets"].items[1].line, root.fields["after"].line)
+	}
+}
+
+func TestParseYAML_Errors(t *testing.T) {
+	cases := map[string]string{
+		"a: 1\n\tb: 2\n":          "line 2: tabs",
+		"a: 1\n  b: 2\n":          "line 2: unexpected indentation",
+		"a: 1\na: 2\n":            `line 2: duplicate key "a"`,
+		"a: {b: 1}\n":             "line 1: flow mappings are not supported",
+		"a: \"open\n":             "line 1: unterminated quoted string",
+		"a: 'x' y\n":              "line 1: unexpected text after quoted string",
+		"a: &anchor 1\n":          "line 1: anchors",
+		"a: 1\nnot a mapping\n":   "line 2: expected 'key: value'",
+		"a: 1\n---\nb: 2\n":       "line 2: only one document",
+		"a: [x, [y]]\n":           "line 1: flow sequences hold scalars only",
+		"a: |x\n  b\n":            "line 1: unsupported block scalar header",
+		"list:\n- a\n  - b\nc: 1": "line 3: unexpected indentation",
+	}
+	for src, want := range cases {
+		if _, err := parseYAML([]byte(src)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: expected %q, got %v", src, want, err)
+		}
+	}
+}
+
+func TestParseJSONConfig_Lines(t *testing.T) {
+	root, err := parseJSONConfig([]byte("{\n  \"url\": \"https://x.test\",\n  \"workers\":\n    4,\n  \"headers\": {\"A\": \"b\"},\n  \"on\": true,\n  \"off\": null\n}\n"))
+	if err != nil {
+		t.Fatalf("parseJSONConfig: %v", err)
+	}
+	if w := root.fields["workers"]; w.line != 4 || w.value != "4" || w.quoted {
+		t.Fatalf("unexpected workers node %+v", w)
+	}
+	if root.fields["headers"].line != 5 || !root.fields["off"].isNull() || root.fields["on"].toJSON() != true {
+		t.Fatalf("unexpected nodes: %+v", root.fields)
+	}
+	for src, want := range map[string]string{
+		"{\"a\": 1,\n\"a\": 2}": `line 2: duplicate key "a"`,
+		"{\"a\":\n  }":          "line 2: invalid JSON",
+		"{} {}":                 "line 1: unexpected data",
+	} {
+		if _, err := parseJSONConfig([]byte(src)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: expected %q, got %v", src, want, err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"poke/engine"
+	"strings"
+)
+
+func runImport(args []string, stdout io.Writer) error {
+	var cfg engine.ImportConfig
+	fs := flag.NewFlagSet("poke import", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&cfg.HARFile, "har", "", "HAR file exported from browser DevTools")
+	fs.StringVar(&cfg.Curl, "curl", "", "curl command line (e.g. from DevTools \"Copy as cURL\")")
+	fs.StringVar(&cfg.CurlFile, "curl-file", "", "File containing a curl command line; '-' for stdin")
+	fs.StringVar(&cfg.Match, "match", "", "HAR: only consider entries whose URL contains this substring")
+	fs.IntVar(&cfg.Index, "index", -1, "HAR: pick the entry at this index (after -match filtering)")
+	fs.StringVar(&cfg.Field, "field", "", "JSON path of the body field to replace with {{prompt}} (e.g. messages[0].content)")
+	fs.StringVar(&cfg.Sentinel, "sentinel", "", "Marker text typed into the captured request; every occurrence becomes {{prompt}} and, for HAR, picks the entry")
+	fs.StringVar(&cfg.OutDir, "out", "", "Directory to write the profile files to (required)")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return helpError{usage: importUsageText(fs)}
+		}
+		return errors.New(err.Error() + "\n\n" + importUsageText(fs))
+	}
+	if err := cfg.Validate(); err != nil {
+		return errors.New(err.Error() + "\n\n" + importUsageText(fs))
+	}
+	prof, err := engine.Import(cfg)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, prof.CommandLine())
+	return nil
+}
+
+func importUsageText(fs *flag.FlagSet) string {
+	var b strings.Builder
+	b.WriteString("Usage:\n  poke import (-har FILE | -curl CMD | -curl-file FILE) (-field PATH | -sentinel TEXT) -out DIR [flags]\n\nFlags:\n")
+	fs.SetOutput(&b)
+	fs.PrintDefaults()
+	return b.String()
+}
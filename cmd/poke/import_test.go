@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRunImport(t *testing.T) {
+	var stdout bytes.Buffer
+	if err := runImport([]string{"-curl", `curl "https://chat.example.test/ask?q=POKE"`, "-sentinel", "POKE", "-out", t.TempDir()}, &stdout); err != nil {
+		t.Fatalf("runImport: %v", err)
+	}
+	if !strings.HasPrefix(stdout.String(), "poke -url https://chat.example.test/ask") {
+		t.Fatalf("unexpected command line: %q", stdout.String())
+	}
+	if err := runImport([]string{"-curl", "curl https://x.test?q=x", "-sentinel", "x"}, &stdout); err == nil || !strings.Contains(err.Error(), "Usage:") {
+		t.Fatalf("expected a usage error for the missing -out, got %v", err)
+	}
+	var he helpError
+	if err := runImport([]string{"-h"}, &stdout); !errors.As(err, &he) || !strings.Contains(he.usage, "poke import (-har FILE") {
+		t.Fatalf("expected import usage, got %v", err)
+	}
+}
@@ -1,63 +1,83 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
-	"poke/promptset"
+	"poke/engine"
 	"strings"
-	"sync"
-	"sync/atomic"
 	"time"
 )
 
-const (
-	defaultWorkers          = 10
-	defaultTimeout          = 30 * time.Second
-	defaultMaxResponseBytes = 2 << 20 // 2 MiB
-	progressEveryN          = 100
-	defaultMethod           = "POST"
-	defaultJSONKey          = "prompt"
-)
+func main() {
+	log.SetFlags(0)
 
-type config struct {
-	targetURL     string
-	method        string
-	headersFile   string
-	cookiesFile   string
-	markersFile   string
-	bodyTmplStr   string
-	bodyTmplFile  string
-	queryTmplStr  string
-	queryTmplFile string
-	maxRespBytes  int64
-	streamResp    bool
-	workers       int
-	rate          float64
-	timeout       time.Duration
-	promptsFile   string
-	retry         retryConfig
-	jsonlOut      string
-	csvOut        string
-	ciExitCodes   bool
-	traceRequests bool
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := runImport(os.Args[2:], os.Stdout); err != nil {
+			var he helpError
+			if errors.As(err, &he) {
+				fmt.Fprint(os.Stdout, he.usage)
+				return
+			}
+			log.Fatalf("%s %v", engine.ErrorPrefix(), err)
+		}
+		return
+	}
 
-	reqTemplate requestTemplate
-}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		rc, err := parseReplayFlags(os.Args[2:])
+		if err != nil {
+			var he helpError
+			if errors.As(err, &he) {
+				fmt.Fprint(os.Stdout, he.usage)
+				return
+			}
+			log.Fatalf("%s %v", engine.ErrorPrefix(), err)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		exitOnRunError(engine.Replay(ctx, rc.ReplayConfig), rc.ciExitCodes)
+		return
+	}
 
-var globalSeq uint64
+	if len(os.Args) > 1 && os.Args[1] == "analyze" {
+		ac, err := parseAnalyzeFlags(os.Args[2:])
+		if err != nil {
+			var he helpError
+			if errors.As(err, &he) {
+				fmt.Fprint(os.Stdout, he.usage)
+				return
+			}
+			log.Fatalf("%s %v", engine.ErrorPrefix(), err)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		exitOnRunError(engine.Analyze(ctx, ac.AnalyzeConfig), ac.ciExitCodes)
+		return
+	}
 
-func main() {
-	log.SetFlags(0)
+	if len(os.Args) > 1 && os.Args[1] == "mock-server" {
+		mc, err := parseMockServerFlags(os.Args[2:])
+		if err != nil {
+			var he helpError
+			if errors.As(err, &he) {
+				fmt.Fprint(os.Stdout, he.usage)
+				return
+			}
+			log.Fatalf("%s %v", engine.ErrorPrefix(), err)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		if err := runMockServer(ctx, mc); err != nil {
+			log.Fatalf("%s %v", engine.ErrorPrefix(), err)
+		}
+		return
+	}
 
 	cfg, err := parseFlags(os.Args[1:])
 	if err != nil {
@@ -66,93 +86,159 @@ func main() {
 			fmt.Fprint(os.Stdout, he.usage)
 			return
 		}
-		log.Fatalf("%s %v", styledErrorPrefix(), err)
+		log.Fatalf("%s %v", engine.ErrorPrefix(), err)
 	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer stop()
 
-	if b := bannerFor(os.Stderr); b != "" {
+	if b := engine.Banner(os.Stderr); b != "" {
 		log.Print(b)
 	}
 
-	if err := run(ctx, cfg); err != nil && !errors.Is(err, context.Canceled) {
-		var te thresholdExceededError
-		if cfg.ciExitCodes && errors.As(err, &te) {
-			log.Printf("%s %v", styledErrorPrefix(), err)
-			os.Exit(te.ExitCode())
-		}
-		log.Fatalf("%s %v", styledErrorPrefix(), err)
+	_, err = engine.Run(ctx, cfg.Config)
+	exitOnRunError(err, cfg.ciExitCodes)
+}
+
+// exitOnRunError maps a run's error to the process exit code; an interrupted run exits cleanly.
+func exitOnRunError(err error, ciExitCodes bool) {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	var be engine.BudgetExceededError
+	if errors.As(err, &be) {
+		log.Printf("%s %v", engine.ErrorPrefix(), err)
+		os.Exit(be.ExitCode())
+	}
+	var te engine.ThresholdExceededError
+	if ciExitCodes && errors.As(err, &te) {
+		log.Printf("%s %v", engine.ErrorPrefix(), err)
+		os.Exit(te.ExitCode())
 	}
+	log.Fatalf("%s %v", engine.ErrorPrefix(), err)
+}
+
+// options are the parsed command line: the run configuration plus CLI-only settings.
+type options struct {
+	engine.Config
+	ciExitCodes bool
 }
 
-func parseFlags(args []string) (config, error) {
-	var cfg config
+func parseFlags(args []string) (options, error) {
+	var cfg options
 	fs := flag.NewFlagSet("poke", flag.ContinueOnError)
 	fs.SetOutput(io.Discard)
 
-	fs.StringVar(&cfg.targetURL, "url", "", "Target URL (required)")
-	fs.StringVar(&cfg.method, "method", defaultMethod, "HTTP method (GET/POST/...)")
-	fs.StringVar(&cfg.headersFile, "headers-file", "", "Path to headers file (Key: Value per line); optional")
-	fs.StringVar(&cfg.cookiesFile, "cookies-file", "", "Path to cookies file (name=value per line); optional")
-	fs.StringVar(&cfg.markersFile, "markers-file", "", "Path to markers config JSON (regexes + per-category thresholds); optional")
-	fs.StringVar(&cfg.bodyTmplStr, "body-template", "", "JSON request body template (non-GET); supports {{prompt}} placeholder")
-	fs.StringVar(&cfg.bodyTmplFile, "body-template-file", "", "Path to JSON request body template file; supports {{prompt}} placeholder")
-	fs.StringVar(&cfg.queryTmplStr, "query-template", "", "URL query template (k=v&k2=v2); values support {{prompt}} placeholder")
-	fs.StringVar(&cfg.queryTmplFile, "query-template-file", "", "Path to URL query template file; values support {{prompt}} placeholder")
-	fs.Int64Var(&cfg.maxRespBytes, "max-response-bytes", defaultMaxResponseBytes, "Max response bytes to read/store/analyze (0 = unlimited)")
-	fs.BoolVar(&cfg.streamResp, "stream-response", false, "Stream response body reads and truncate at -max-response-bytes (faster; truncation may be conservative)")
-	fs.IntVar(&cfg.workers, "workers", defaultWorkers, "Number of concurrent workers")
-	fs.Float64Var(&cfg.rate, "rate", 0, "Global rate limit (requests/sec); 0 = unlimited")
-	fs.DurationVar(&cfg.timeout, "timeout", defaultTimeout, "Per-request timeout (e.g. 10s, 1m)")
-	fs.StringVar(&cfg.promptsFile, "prompts", "", "Prompt source file (.txt/.json/.jsonl); use '-' for stdin (required)")
-	fs.IntVar(&cfg.retry.MaxRetries, "retries", 0, "Max retries for transport errors/429/5xx; 0 = disabled")
-	fs.DurationVar(&cfg.retry.BackoffMin, "backoff-min", 200*time.Millisecond, "Min retry backoff delay")
-	fs.DurationVar(&cfg.retry.BackoffMax, "backoff-max", 5*time.Second, "Max retry backoff delay; 0 = no cap")
-	fs.StringVar(&cfg.jsonlOut, "jsonl-out", "", "Write per-request results to JSONL file (path); optional")
-	fs.StringVar(&cfg.csvOut, "csv-out", "", "Write per-request results to CSV file (path); optional")
+	var configPath string
+	fs.StringVar(&configPath, "config", "", "Run profile (YAML or JSON) whose keys are flag names; flags on the command line override it")
+	fs.StringVar(&cfg.URL, "url", "", "Target URL (required)")
+	fs.StringVar(&cfg.Method, "method", engine.DefaultMethod, "HTTP method (GET/POST/...)")
+	fs.StringVar(&cfg.HeadersFile, "headers-file", "", "Path to headers file (Key: Value per line); optional")
+	fs.StringVar(&cfg.CookiesFile, "cookies-file", "", "Path to cookies file (name=value per line); optional")
+	fs.StringVar(&cfg.MarkersFile, "markers-file", "", "Path to markers config JSON (regexes + per-category thresholds); optional")
+	fs.StringVar(&cfg.BodyTemplate, "body-template", "", "Request body template (non-GET; JSON unless -body-format says otherwise); supports {{prompt}} placeholder")
+	fs.StringVar(&cfg.BodyTemplateFile, "body-template-file", "", "Path to request body template file (see -body-template); supports {{prompt}} placeholder")
+	fs.StringVar(&cfg.BodyFormat, "body-format", engine.BodyFormatJSON, "Request body format for -body-template: json|form|multipart|raw")
+	fs.Var(&cfg.Vars, "var", "Template variable name=value for {{var:name}} placeholders (repeatable)")
+	fs.StringVar(&cfg.QueryTemplate, "query-template", "", "URL query template (k=v&k2=v2); values support {{prompt}} placeholder")
+	fs.StringVar(&cfg.QueryTemplateFile, "query-template-file", "", "Path to URL query template file; values support {{prompt}} placeholder")
+	fs.StringVar(&cfg.RequestFile, "request-file", "", "Path to a raw HTTP/1.1 request (Burp-style) with {{prompt}} in the path, headers or body; replaces -method and the templates")
+	fs.StringVar(&cfg.SessionFile, "session-file", "", "Path to a session bootstrap JSON (login steps + extracted headers/cookies, re-run on 401/403); optional")
+	fs.StringVar(&cfg.OAuth2.TokenURL, "oauth2-token-url", "", "OAuth2 token endpoint for the client-credentials grant; optional")
+	fs.StringVar(&cfg.OAuth2.ClientID, "oauth2-client-id", "", "OAuth2 client ID")
+	fs.StringVar(&cfg.OAuth2.ClientSecret, "oauth2-client-secret", "", "OAuth2 client secret; supports {{env:NAME}}")
+	fs.StringVar(&cfg.OAuth2.Scopes, "oauth2-scopes", "", "OAuth2 scopes (space or comma separated); optional")
+	fs.StringVar(&cfg.OAuth2.AuthStyle, "oauth2-auth-style", engine.OAuth2AuthStyleBasic, "How the client authenticates to the token endpoint: basic (HTTP Basic) or params (form fields)")
+	fs.StringVar(&cfg.Sign.Kind, "sign", "", "Sign every request: hmac or sigv4; optional")
+	fs.StringVar(&cfg.Sign.HMACSecret, "hmac-secret", "", "-sign hmac: shared secret; supports {{env:NAME}}")
+	fs.StringVar(&cfg.Sign.HMACHeader, "hmac-header", engine.DefaultHMACHeader, "-sign hmac: header that carries the signature")
+	fs.StringVar(&cfg.Sign.HMACTimestampHeader, "hmac-timestamp-header", engine.DefaultHMACTimestampHeader, "-sign hmac: header that carries the signing timestamp; empty = none")
+	fs.StringVar(&cfg.Sign.HMACString, "hmac-string", engine.DefaultHMACString, "-sign hmac: canonical string template ({{method}} {{path}} {{query}} {{host}} {{timestamp}} {{body}} {{body_sha256}} {{header:Name}}; \\n = newline)")
+	fs.StringVar(&cfg.Sign.HMACEncoding, "hmac-encoding", "hex", "-sign hmac: signature encoding: hex or base64")
+	fs.StringVar(&cfg.Sign.SigV4Service, "sigv4-service", "", "-sign sigv4: AWS service name (e.g. execute-api, bedrock)")
+	fs.StringVar(&cfg.Sign.SigV4Region, "sigv4-region", "", "-sign sigv4: AWS region (e.g. us-east-1)")
+	fs.StringVar(&cfg.Sign.SigV4Profile, "sigv4-profile", "", "-sign sigv4: shared credentials profile; default: AWS_* env vars, then AWS_PROFILE or default")
+	fs.StringVar(&cfg.Transport.Proxy, "proxy", "", "Outbound proxy URL (http://, https://, socks5://, socks5h://); default: HTTP(S)_PROXY env")
+	fs.StringVar(&cfg.Transport.CAFile, "ca-file", "", "PEM CA bundle trusted in addition to the system roots; optional")
+	fs.StringVar(&cfg.Transport.CertFile, "client-cert", "", "PEM client certificate for mTLS (requires -client-key)")
+	fs.StringVar(&cfg.Transport.KeyFile, "client-key", "", "PEM private key for -client-cert")
+	fs.BoolVar(&cfg.Transport.InsecureSkipVerify, "insecure-skip-verify", false, "Skip TLS certificate verification (lab targets only)")
+	fs.StringVar(&cfg.Transport.ServerName, "sni", "", "TLS server name (SNI) override; default: the URL host")
+	fs.StringVar(&cfg.Transport.HTTPVersion, "http-version", "", "Force the HTTP version: 1.1, h2 or h2c; default: negotiate")
+	fs.StringVar(&cfg.Transport.UnixSocket, "unix-socket", "", "Connect to this Unix domain socket instead of the -url host; -url still sets path, query and Host")
+	fs.Var(&cfg.Transport.Resolve, "resolve", "Connect to host:port at addr instead of resolving it (curl syntax host:port:addr[,addr]); repeatable")
+	fs.IntVar(&cfg.Transport.MaxConnsPerHost, "max-conns-per-host", 0, "Max connections per host; 0 = unlimited")
+	fs.IntVar(&cfg.Transport.MaxIdleConnsPerHost, "max-idle-conns-per-host", 0, "Idle keep-alive connections kept per host; 0 = -workers")
+	fs.DurationVar(&cfg.Transport.IdleConnTimeout, "idle-conn-timeout", engine.DefaultIdleConnTimeout, "How long an idle keep-alive connection is kept")
+	fs.Int64Var(&cfg.MaxResponseBytes, "max-response-bytes", engine.DefaultMaxResponseBytes, "Max response bytes to read/store/analyze (0 = unlimited)")
+	fs.BoolVar(&cfg.StreamResponse, "stream-response", false, "Stream response body reads and truncate at -max-response-bytes (faster; truncation may be conservative)")
+	fs.IntVar(&cfg.Workers, "workers", engine.DefaultWorkers, "Number of concurrent workers")
+	fs.Float64Var(&cfg.Rate.Rate, "rate", 0, "Global rate limit (requests/sec); 0 = unlimited; the starting rate with -rate-adaptive")
+	fs.IntVar(&cfg.Rate.Burst, "burst", 1, "Requests that may start back-to-back before -rate applies (token bucket size)")
+	fs.BoolVar(&cfg.Rate.Adaptive, "rate-adaptive", false, "Halve the global rate on 429/503 and pause for Retry-After; ramp back up on success (requires -rate)")
+	fs.Float64Var(&cfg.Rate.Min, "rate-min", 0, "-rate-adaptive floor (requests/sec); 0 = -rate/20")
+	fs.Float64Var(&cfg.Rate.Max, "rate-max", 0, "-rate-adaptive ceiling (requests/sec); 0 = -rate")
+	fs.IntVar(&cfg.Budget.MaxRequests, "max-requests", 0, "Stop after this many requests (conversation turns count individually); 0 = unlimited")
+	fs.DurationVar(&cfg.Budget.MaxDuration, "max-duration", 0, "Stop the run after this long (e.g. 10m); 0 = unlimited")
+	fs.IntVar(&cfg.Budget.MaxTokens, "max-run-tokens", 0, "Stop once the run has used this many tokens (response usage, else estimated); 0 = unlimited")
+	fs.Float64Var(&cfg.Budget.MaxCost, "max-cost", 0, "Stop once the estimated spend reaches this amount (requires -price-per-1k*); 0 = unlimited")
+	fs.Float64Var(&cfg.Budget.PricePer1K, "price-per-1k", 0, "Price per 1K input tokens (and output tokens unless -price-per-1k-output is set)")
+	fs.Float64Var(&cfg.Budget.PricePer1KOutput, "price-per-1k-output", 0, "Price per 1K output tokens; 0 = -price-per-1k")
+	fs.DurationVar(&cfg.Timeout, "timeout", engine.DefaultTimeout, "Per-request timeout (e.g. 10s, 1m)")
+	fs.StringVar(&cfg.PromptsFile, "prompts", "", "Prompt source file (.txt/.json/.jsonl); use '-' for stdin (required)")
+	fs.IntVar(&cfg.Retry.MaxRetries, "retries", 0, "Max retries for transport errors/429/5xx; 0 = disabled")
+	fs.DurationVar(&cfg.Retry.BackoffMin, "backoff-min", 200*time.Millisecond, "Min retry backoff delay")
+	fs.DurationVar(&cfg.Retry.BackoffMax, "backoff-max", 5*time.Second, "Max retry backoff delay; 0 = no cap")
+	fs.StringVar(&cfg.JSONLOut, "jsonl-out", "", "Write per-request results to JSONL file (path); optional")
+	fs.BoolVar(&cfg.JSONLFullBody, "jsonl-full-body", false, "Also write the analyzed response body, extracted text and headers to -jsonl-out rows, so 'poke analyze' can re-score them exactly")
+	fs.BoolVar(&cfg.Resume, "resume", false, "Resume from -jsonl-out: skip prompts that completed there, append to the outputs and include prior results in the summary")
+	fs.StringVar(&cfg.MatrixFile, "matrix", "", "Path to a matrix JSON listing targets (url, headers, templates, workers, rate); sends the prompts to each and compares them; flags are the defaults")
+	fs.StringVar(&cfg.RecordDir, "record", "", "Record every request/response exchange (complete bodies, timings) to this cassette directory for 'poke replay'; optional")
+	fs.Int64Var(&cfg.RecordMaxBytes, "record-max-bytes", 0, "Max bytes of each response body kept by -record (0 = complete bodies)")
+	fs.StringVar(&cfg.CSVOut, "csv-out", "", "Write per-request results to CSV file (path); optional")
 	fs.BoolVar(&cfg.ciExitCodes, "ci-exit-codes", false, "Use CI-friendly exit codes when marker stop thresholds trigger (2=warn/info, 3=error, 4=critical)")
-	fs.BoolVar(&cfg.traceRequests, "trace", false, "Log each request start/retry/finish to stderr (useful to see progress live)")
+	fs.IntVar(&cfg.Samples, "samples", 1, "Send each prompt N times ({{sample}}/{{seed}} vary per sample) and report per-prompt attack success rates")
+	fs.BoolVar(&cfg.Trace, "trace", false, "Log each request start/retry/finish to stderr (useful to see progress live)")
+	fs.StringVar(&cfg.TargetKind, "target-kind", engine.TargetKindHTTP, "Target adapter: http (templated JSON/query), openai-chat (OpenAI-compatible chat completions) or graphql")
+	fs.StringVar(&cfg.Model, "model", "", "Model name for -target-kind openai-chat")
+	fs.StringVar(&cfg.SystemPrompt, "system-prompt", "", "System message for -target-kind openai-chat; optional")
+	fs.Float64Var(&cfg.Temperature, "temperature", 0, "Sampling temperature for -target-kind openai-chat; omitted unless set")
+	fs.IntVar(&cfg.MaxTokens, "max-tokens", 0, "max_tokens for -target-kind openai-chat; 0 = omitted")
+	fs.StringVar(&cfg.GraphQLQuery, "graphql-query", "", "GraphQL query document for -target-kind graphql (sent verbatim; placeholders go in -graphql-variables)")
+	fs.StringVar(&cfg.GraphQLQueryFile, "graphql-query-file", "", "Path to the GraphQL query document for -target-kind graphql")
+	fs.StringVar(&cfg.GraphQLVariables, "graphql-variables", "", "GraphQL variables JSON template with {{prompt}} placeholders; default: {\"prompt\":\"{{prompt}}\"}")
+	fs.StringVar(&cfg.GraphQLVariablesFile, "graphql-variables-file", "", "Path to the GraphQL variables JSON template")
+	fs.StringVar(&cfg.GraphQLOperation, "graphql-operation", "", "GraphQL operationName; optional")
+	fs.StringVar(&cfg.GraphQLDataPath, "graphql-data-path", "", "JSON path inside data whose value markers analyze (e.g. chat.reply); empty = all of data")
+	fs.DurationVar(&cfg.WSIdleTimeout, "ws-idle-timeout", engine.DefaultWSIdleTimeout, "ws:// targets: stop collecting reply frames after this much silence")
+	fs.StringVar(&cfg.WSDoneMatch, "ws-done-match", "", "ws:// targets: JSON predicate path[=value] marking the last reply frame (e.g. type=done); optional")
+	fs.StringVar(&cfg.WSTextPath, "ws-text-path", "", "ws:// targets: JSON path of the reply text in each frame; empty = whole frame")
+	fs.StringVar(&cfg.ResponseExtract, "response-extract", "", "Text markers analyze: JSON path (choices[*].message.content, json:PATH) or regex:PATTERN (first capture group); raw body kept for evidence")
+	fs.StringVar(&cfg.SSETextPath, "sse-text-path", engine.DefaultSSETextPath, "JSON path of the delta text in text/event-stream data payloads; empty = use the raw data")
 
 	if err := fs.Parse(args); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
-			return config{}, helpError{usage: usageText(fs)}
+			return options{}, helpError{usage: usageText(fs)}
 		}
-		return config{}, usageError(err, fs)
+		return options{}, usageError(err, fs)
 	}
-	if cfg.targetURL == "" || cfg.promptsFile == "" {
-		return config{}, usageError(fmt.Errorf("missing required flags: -url and -prompts"), fs)
-	}
-	if cfg.bodyTmplStr != "" && cfg.bodyTmplFile != "" {
-		return config{}, usageError(fmt.Errorf("only one of -body-template or -body-template-file may be set"), fs)
-	}
-	if cfg.queryTmplStr != "" && cfg.queryTmplFile != "" {
-		return config{}, usageError(fmt.Errorf("only one of -query-template or -query-template-file may be set"), fs)
-	}
-	if cfg.workers <= 0 {
-		return config{}, fmt.Errorf("-workers must be > 0")
-	}
-	if cfg.rate < 0 {
-		return config{}, fmt.Errorf("-rate must be >= 0")
-	}
-	if cfg.maxRespBytes < 0 {
-		return config{}, fmt.Errorf("-max-response-bytes must be >= 0")
-	}
-	if err := cfg.retry.validate(); err != nil {
-		return config{}, usageError(err, fs)
-	}
-	if cfg.jsonlOut == "-" || cfg.csvOut == "-" {
-		return config{}, fmt.Errorf("structured outputs must be file paths; '-' is not supported (keeps stdout human-friendly)")
-	}
-	if cfg.jsonlOut != "" && cfg.csvOut != "" && cfg.jsonlOut == cfg.csvOut {
-		return config{}, fmt.Errorf("-jsonl-out and -csv-out must not be the same path")
-	}
-	cfg.method = strings.ToUpper(strings.TrimSpace(cfg.method))
-	if cfg.method == "" {
-		return config{}, fmt.Errorf("-method must not be empty")
+	var cf *configFile
+	if configPath != "" {
+		var err error
+		if cf, err = applyConfigFile(configPath, fs, &cfg); err != nil {
+			return options{}, err
+		}
 	}
-	if _, err := url.ParseRequestURI(cfg.targetURL); err != nil {
-		return config{}, fmt.Errorf("invalid -url: %w", err)
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "temperature" {
+			cfg.TemperatureSet = true
+		}
+	})
+	if err := cfg.Validate(); err != nil {
+		if errors.As(err, new(engine.UsageError)) {
+			return options{}, usageError(cf.locate(err), fs)
+		}
+		return options{}, cf.locate(err)
 	}
 	return cfg, nil
 }
@@ -169,356 +255,12 @@ func (e helpError) Error() string { return "help requested" }
 
 func usageText(fs *flag.FlagSet) string {
 	var b strings.Builder
-	if banner := bannerFor(os.Stdout); banner != "" {
+	if banner := engine.Banner(os.Stdout); banner != "" {
 		b.WriteString(banner)
 		b.WriteString("\n")
 	}
-	b.WriteString("Usage:\n  poke -url URL -prompts FILE [flags]\n\nFlags:\n")
+	b.WriteString("Usage:\n  poke -url URL -prompts FILE [flags]\n  poke -config FILE [flags]\n\nFlags:\n")
 	fs.SetOutput(&b)
 	fs.PrintDefaults()
 	return b.String()
 }
-
-func run(ctx context.Context, cfg config) error {
-	atomic.StoreUint64(&globalSeq, 0)
-
-	tmpl, err := loadRequestTemplate(cfg)
-	if err != nil {
-		return err
-	}
-	cfg.reqTemplate = tmpl
-
-	headers, err := readHeadersFile(cfg.headersFile)
-	if err != nil {
-		return err
-	}
-	cookies, err := readCookiesFile(cfg.cookiesFile)
-	if err != nil {
-		return err
-	}
-
-	limiter, err := newRateLimiter(cfg.rate)
-	if err != nil {
-		return err
-	}
-	defer limiter.Close()
-
-	client := &http.Client{Timeout: cfg.timeout}
-
-	prompts := make(chan string, cfg.workers*2)
-	var wg sync.WaitGroup
-
-	mcfg := defaultMarkerConfig()
-	if cfg.markersFile != "" {
-		loaded, err := loadMarkerConfigFile(cfg.markersFile)
-		if err != nil {
-			return err
-		}
-		mcfg = loaded
-	}
-
-	analyzer, err := newResponseAnalyzer(mcfg)
-	if err != nil {
-		return err
-	}
-
-	ctx, cancel := context.WithCancelCause(ctx)
-	defer cancel(nil)
-
-	sink, err := newResultSink(cfg.jsonlOut, cfg.csvOut)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		if sink != nil {
-			_ = sink.Close()
-		}
-	}()
-
-	stats := newReport(analyzer, mcfg.Categories, cancel, sink)
-
-	wg.Add(cfg.workers)
-	for i := 0; i < cfg.workers; i++ {
-		go func(workerID int) {
-			defer wg.Done()
-			worker(ctx, workerID, cfg, client, limiter, headers, cookies, prompts, stats)
-		}(i + 1)
-	}
-
-	readErr := make(chan error, 1)
-	go func() {
-		defer close(prompts)
-		readErr <- promptset.Stream(ctx, cfg.promptsFile, prompts, promptset.Options{})
-	}()
-
-	wg.Wait()
-
-	if err := <-readErr; err != nil && !errors.Is(err, context.Canceled) {
-		return err
-	}
-	if sink != nil {
-		if err := sink.Close(); err != nil {
-			return err
-		}
-	}
-
-	stats.LogSummary()
-	if err := stats.ThresholdError(); err != nil {
-		return err
-	}
-	return nil
-}
-
-func worker(
-	ctx context.Context,
-	workerID int,
-	cfg config,
-	client *http.Client,
-	limiter *rateLimiter,
-	baseHeaders http.Header,
-	cookies []*http.Cookie,
-	in <-chan string,
-	stats *report,
-) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case prompt, ok := <-in:
-			if !ok {
-				return
-			}
-			if err := limiter.Wait(ctx); err != nil {
-				stats.RecordError(err)
-				return
-			}
-
-			res := sendOne(ctx, client, cfg, baseHeaders, cookies, workerID, prompt)
-			stats.RecordResult(res)
-		}
-	}
-}
-
-func sendOne(
-	ctx context.Context,
-	client *http.Client,
-	cfg config,
-	baseHeaders http.Header,
-	cookies []*http.Cookie,
-	workerID int,
-	prompt string,
-) RequestResult {
-	seq := int(atomic.AddUint64(&globalSeq, 1))
-
-	start := time.Now()
-
-	u, bodyBytes, err := buildTargetURLAndBody(cfg, prompt)
-	if err != nil {
-		return RequestResult{Seq: seq, WorkerID: workerID, Prompt: prompt, Latency: time.Since(start), Err: err}
-	}
-
-	if cfg.traceRequests {
-		bodyLen := 0
-		if bodyBytes != nil {
-			bodyLen = len(bodyBytes)
-		}
-		log.Printf("req_start: seq=%d worker=%d method=%s url=%s body_bytes=%d prompt=%q", seq, workerID, cfg.method, u.String(), bodyLen, previewOneLine(prompt, 160))
-	}
-
-	var attempts int
-	var retries int
-
-	for {
-		attempts++
-		attemptStart := time.Now()
-
-		var body io.Reader
-		if cfg.method != http.MethodGet && bodyBytes != nil {
-			body = bytes.NewReader(bodyBytes)
-		}
-
-		req, err := http.NewRequestWithContext(ctx, cfg.method, u.String(), body)
-		if err != nil {
-			return RequestResult{Seq: seq, WorkerID: workerID, Prompt: prompt, Attempts: attempts, Retries: retries, Latency: time.Since(start), Err: fmt.Errorf("build request: %w", err)}
-		}
-
-		for k, vs := range baseHeaders {
-			for _, v := range vs {
-				req.Header.Add(k, v)
-			}
-		}
-		if cfg.method != http.MethodGet && req.Header.Get("Content-Type") == "" {
-			req.Header.Set("Content-Type", "application/json")
-		}
-		for _, c := range cookies {
-			req.AddCookie(c)
-		}
-
-		if cfg.traceRequests {
-			log.Printf("req_wait: seq=%d worker=%d attempt=%d", seq, workerID, attempts)
-		}
-
-		resp, err := client.Do(req)
-		if err != nil {
-			if cfg.retry.enabled() && retries < cfg.retry.MaxRetries && isRetryableDoError(err) {
-				retries++
-				delay := nextBackoffDelay(cfg.retry, retries, 0)
-				if cfg.traceRequests {
-					log.Printf("req_retry: seq=%d worker=%d attempt=%d retry=%d delay=%s err=%q", seq, workerID, attempts, retries, delay.String(), previewOneLine(err.Error(), 200))
-				}
-				if sleepErr := sleepCtx(ctx, delay); sleepErr != nil {
-					return RequestResult{Seq: seq, WorkerID: workerID, Prompt: prompt, Attempts: attempts, Retries: retries - 1, Latency: time.Since(start), Err: sleepErr}
-				}
-				continue
-			}
-			if cfg.traceRequests {
-				log.Printf("req_done: seq=%d worker=%d attempt=%d status=ERR attempt_latency=%s total_latency=%s err=%q", seq, workerID, attempts, time.Since(attemptStart).String(), time.Since(start).String(), previewOneLine(err.Error(), 200))
-			}
-			return RequestResult{Seq: seq, WorkerID: workerID, Prompt: prompt, Attempts: attempts, Retries: retries, Latency: time.Since(start), Err: err}
-		}
-
-		if cfg.retry.enabled() && retries < cfg.retry.MaxRetries && isRetryableHTTPStatus(resp.StatusCode) {
-			retryAfter, _ := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
-			_ = resp.Body.Close()
-
-			retries++
-			delay := nextBackoffDelay(cfg.retry, retries, retryAfter)
-			if cfg.traceRequests {
-				log.Printf("req_retry: seq=%d worker=%d attempt=%d retry=%d status=%d delay=%s", seq, workerID, attempts, retries, resp.StatusCode, delay.String())
-			}
-			if sleepErr := sleepCtx(ctx, delay); sleepErr != nil {
-				return RequestResult{Seq: seq, WorkerID: workerID, Prompt: prompt, Attempts: attempts, Retries: retries - 1, Latency: time.Since(start), Err: sleepErr}
-			}
-			continue
-		}
-
-		defer resp.Body.Close()
-
-		b, truncated, err := readResponseBody(resp, cfg.maxRespBytes, cfg.streamResp)
-		if err != nil {
-			if cfg.traceRequests {
-				log.Printf("req_done: seq=%d worker=%d attempt=%d status=%d attempt_latency=%s total_latency=%s err=%q", seq, workerID, attempts, resp.StatusCode, time.Since(attemptStart).String(), time.Since(start).String(), previewOneLine(err.Error(), 200))
-			}
-			return RequestResult{Seq: seq, WorkerID: workerID, Prompt: prompt, Attempts: attempts, Retries: retries, StatusCode: resp.StatusCode, Headers: resp.Header.Clone(), Latency: time.Since(start), Err: fmt.Errorf("read response body: %w", err)}
-		}
-		if cfg.traceRequests {
-			log.Printf("req_done: seq=%d worker=%d attempt=%d status=%d attempt_latency=%s total_latency=%s body_bytes=%d truncated=%t", seq, workerID, attempts, resp.StatusCode, time.Since(attemptStart).String(), time.Since(start).String(), len(b), truncated)
-		}
-		return RequestResult{Seq: seq, WorkerID: workerID, Prompt: prompt, Attempts: attempts, Retries: retries, StatusCode: resp.StatusCode, Headers: resp.Header.Clone(), Latency: time.Since(start), Body: b, BodyTruncated: truncated}
-	}
-}
-
-type rateLimiter struct {
-	t *time.Ticker
-}
-
-func newRateLimiter(rps float64) (*rateLimiter, error) {
-	if rps == 0 {
-		return &rateLimiter{t: nil}, nil
-	}
-	if rps < 0 {
-		return nil, fmt.Errorf("rate must be >= 0")
-	}
-	d := time.Duration(float64(time.Second) / rps)
-	if d < time.Nanosecond {
-		d = time.Nanosecond
-	}
-	return &rateLimiter{t: time.NewTicker(d)}, nil
-}
-
-func (rl *rateLimiter) Wait(ctx context.Context) error {
-	if rl.t == nil {
-		return nil
-	}
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case <-rl.t.C:
-		return nil
-	}
-}
-
-func (rl *rateLimiter) Close() {
-	if rl.t != nil {
-		rl.t.Stop()
-	}
-}
-
-func readHeadersFile(path string) (http.Header, error) {
-	h := make(http.Header)
-	if path == "" {
-		return h, nil
-	}
-	lines, err := readLines(path, "headers")
-	if err != nil {
-		return nil, err
-	}
-	for i, raw := range lines {
-		line := strings.TrimSpace(raw)
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		k, v, ok := strings.Cut(line, ":")
-		if !ok {
-			return nil, fmt.Errorf("headers file: line %d: expected 'Key: Value'", i+1)
-		}
-		k = http.CanonicalHeaderKey(strings.TrimSpace(k))
-		v = strings.TrimSpace(v)
-		if k == "" {
-			return nil, fmt.Errorf("headers file: line %d: empty header key", i+1)
-		}
-		h.Add(k, v)
-	}
-	return h, nil
-}
-
-func readCookiesFile(path string) ([]*http.Cookie, error) {
-	if path == "" {
-		return nil, nil
-	}
-	lines, err := readLines(path, "cookies")
-	if err != nil {
-		return nil, err
-	}
-	var out []*http.Cookie
-	for i, raw := range lines {
-		line := strings.TrimSpace(raw)
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		name, value, ok := strings.Cut(line, "=")
-		if !ok {
-			return nil, fmt.Errorf("cookies file: line %d: expected 'name=value'", i+1)
-		}
-		name = strings.TrimSpace(name)
-		value = strings.TrimSpace(value)
-		if name == "" {
-			return nil, fmt.Errorf("cookies file: line %d: empty cookie name", i+1)
-		}
-		out = append(out, &http.Cookie{Name: name, Value: value})
-	}
-	return out, nil
-}
-
-func readLines(path string, kind string) ([]string, error) {
-	var r io.Reader
-	if path == "-" {
-		r = os.Stdin
-	} else {
-		f, err := os.Open(path)
-		if err != nil {
-			return nil, fmt.Errorf("open %s file: %w", kind, err)
-		}
-		defer f.Close()
-		r = f
-	}
-	sc := bufio.NewScanner(r)
-	var out []string
-	for sc.Scan() {
-		out = append(out, sc.Text())
-	}
-	if err := sc.Err(); err != nil {
-		return nil, fmt.Errorf("read %s file: %w", kind, err)
-	}
-	return out, nil
-}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"poke/engine"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFlags_Help(t *testing.T) {
+	_, err := parseFlags([]string{"-h"})
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	var he helpError
+	if !errors.As(err, &he) {
+		t.Fatalf("expected helpError, got %T: %v", err, err)
+	}
+	if !strings.Contains(he.usage, "Usage:") || !strings.Contains(he.usage, "Flags:") || he.Error() != "help requested" {
+		t.Fatalf("unexpected help text: %q", he.usage)
+	}
+}
+
+func TestParseFlags_DefaultsMatchDefaultConfig(t *testing.T) {
+	cfg, err := parseFlags([]string{"-url=https://example.test", "-prompts=x"})
+	if err != nil {
+		t.Fatalf("parseFlags: %v", err)
+	}
+	want := engine.DefaultConfig()
+	want.URL, want.PromptsFile = "https://example.test", "x"
+	if !reflect.DeepEqual(cfg.Config, want) {
+		t.Fatalf("flag defaults drifted from DefaultConfig:\n got %+v\nwant %+v", cfg.Config, want)
+	}
+}
+
+func TestParseFlags_ValidatesRequiredAndConflicts(t *testing.T) {
+	if _, err := parseFlags([]string{}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := parseFlags([]string{"-url=https://example.test", "-prompts=x", "-body-template={}", "-body-template-file=y"}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := parseFlags([]string{"-url=https://example.test", "-prompts=x", "-query-template=a=b", "-query-template-file=y"}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := parseFlags([]string{"-url=not a url", "-prompts=x"}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := parseFlags([]string{"-url=https://example.test", "-prompts=x", "-workers=0"}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := parseFlags([]string{"-url=https://example.test", "-prompts=x", "-rate=-1"}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := parseFlags([]string{"-url=https://example.test", "-prompts=x", "-max-response-bytes=-1"}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := parseFlags([]string{"-url=https://example.test", "-prompts=x", "-method=   "}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := parseFlags([]string{"-url=https://example.test", "-prompts=x", "-jsonl-out=-"}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := parseFlags([]string{"-url=https://example.test", "-prompts=x", "-csv-out=-"}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := parseFlags([]string{"-url=https://example.test", "-prompts=x", "-jsonl-out=out", "-csv-out=out"}); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestParseFlags_UsageOnlyForFlagUsageErrors(t *testing.T) {
+	_, err := parseFlags([]string{"-prompts=x"})
+	if err == nil || !strings.Contains(err.Error(), "Usage:") {
+		t.Fatalf("missing -url should print usage, got %v", err)
+	}
+	_, err = parseFlags([]string{"-url=https://example.test", "-prompts=x", "-workers=0"})
+	if err == nil || strings.Contains(err.Error(), "Usage:") {
+		t.Fatalf("-workers range error should be plain, got %v", err)
+	}
+}
+
+func TestParseFlags_RequestFile(t *testing.T) {
+	if _, err := parseFlags([]string{"-request-file=req.txt", "-prompts=x"}); err != nil {
+		t.Fatalf("-request-file should not require -url: %v", err)
+	}
+	if _, err := parseFlags([]string{"-request-file=req.txt", "-prompts=x", "-body-template={}"}); err == nil {
+		t.Fatalf("expected conflict error with -body-template")
+	}
+}
+
+func TestParseFlags_Var(t *testing.T) {
+	cfg, err := parseFlags([]string{"-url", "https://x.test", "-prompts", "p.txt", "-var", "a=1", "-var", "b=x=y"})
+	if err != nil {
+		t.Fatalf("parseFlags: %v", err)
+	}
+	if cfg.Vars["a"] != "1" || cfg.Vars["b"] != "x=y" {
+		t.Fatalf("unexpected vars %#v", cfg.Vars)
+	}
+	if _, err := parseFlags([]string{"-url", "https://x.test", "-prompts", "p.txt", "-var", "novalue"}); err == nil {
+		t.Fatalf("expected error for -var without =")
+	}
+}
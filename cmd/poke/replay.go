@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"poke/engine"
+	"strings"
+)
+
+type replayOptions struct {
+	engine.ReplayConfig
+	ciExitCodes bool
+}
+
+func parseReplayFlags(args []string) (replayOptions, error) {
+	var cfg replayOptions
+	fs := flag.NewFlagSet("poke replay", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&cfg.CassetteDir, "cassette", "", "Cassette directory written by -record (required)")
+	fs.StringVar(&cfg.MarkersFile, "markers-file", "", "Path to markers config JSON to analyze the recorded responses with; optional")
+	fs.StringVar(&cfg.ResponseExtract, "response-extract", "", "Re-extract the text markers analyze from each recorded (non-streamed) body: JSON path or regex:PATTERN; default: the text extracted live")
+	fs.StringVar(&cfg.JSONLOut, "jsonl-out", "", "Write per-request results to JSONL file (path); optional")
+	fs.StringVar(&cfg.CSVOut, "csv-out", "", "Write per-request results to CSV file (path); optional")
+	fs.BoolVar(&cfg.ciExitCodes, "ci-exit-codes", false, "Use CI-friendly exit codes when marker stop thresholds trigger (2=warn/info, 3=error, 4=critical)")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return replayOptions{}, helpError{usage: replayUsageText(fs)}
+		}
+		return replayOptions{}, errors.New(err.Error() + "\n\n" + replayUsageText(fs))
+	}
+	if err := cfg.Validate(); err != nil {
+		if errors.As(err, new(engine.UsageError)) {
+			return replayOptions{}, errors.New(err.Error() + "\n\n" + replayUsageText(fs))
+		}
+		return replayOptions{}, err
+	}
+	return cfg, nil
+}
+
+func replayUsageText(fs *flag.FlagSet) string {
+	var b strings.Builder
+	b.WriteString("Usage:\n  poke replay -cassette DIR [flags]\n\nFlags:\n")
+	fs.SetOutput(&b)
+	fs.PrintDefaults()
+	return b.String()
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseReplayFlags(t *testing.T) {
+	if _, err := parseReplayFlags(nil); err == nil || !strings.Contains(err.Error(), "-cassette") {
+		t.Fatalf("expected a missing -cassette error, got %v", err)
+	}
+	if _, err := parseReplayFlags([]string{"-cassette", "d", "-response-extract", "regex:("}); err == nil {
+		t.Fatalf("expected an invalid -response-extract error")
+	}
+	var he helpError
+	if _, err := parseReplayFlags([]string{"-h"}); !errors.As(err, &he) || !strings.Contains(he.usage, "poke replay -cassette DIR") {
+		t.Fatalf("expected replay usage, got %v", err)
+	}
+}
@@ -1,20 +0,0 @@
-package main
-
-import (
-	"net/http"
-	"time"
-)
-
-type RequestResult struct {
-	Seq           int
-	WorkerID      int
-	Prompt        string
-	Attempts      int
-	Retries       int
-	StatusCode    int
-	Headers       http.Header
-	Latency       time.Duration
-	Body          []byte
-	BodyTruncated bool
-	Err           error
-}
@@ -0,0 +1,135 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// AnalyzeConfig configures Analyze (poke analyze).
+type AnalyzeConfig struct {
+	In          string
+	MarkersFile string
+	JSONLOut    string
+}
+
+// Validate checks the options (what the CLI flags accept).
+func (cfg AnalyzeConfig) Validate() error {
+	if cfg.In == "" {
+		return usage(errors.New("missing required flag: -in"))
+	}
+	if cfg.JSONLOut == "-" {
+		return fmt.Errorf("structured outputs must be file paths; '-' is not supported (keeps stdout human-friendly)")
+	}
+	if cfg.JSONLOut == cfg.In {
+		return fmt.Errorf("-jsonl-out must not be the -in file")
+	}
+	return nil
+}
+
+// Analyze re-scores the request rows of a -jsonl-out file with (new) markers: marker hits, score
+// and severity are recomputed, category thresholds re-evaluated and the summary printed as the live
+// run would have. prompt_summary rows are rebuilt from the re-scored samples rather than copied.
+func Analyze(ctx context.Context, ac AnalyzeConfig) error {
+	if err := ac.Validate(); err != nil {
+		return err
+	}
+	f, err := os.Open(ac.In)
+	if err != nil {
+		return fmt.Errorf("open -in: %w", err)
+	}
+	defer f.Close()
+
+	var out *jsonlWriter
+	if ac.JSONLOut != "" {
+		if out, err = newJSONLWriter(ac.JSONLOut, false); err != nil {
+			return err
+		}
+		defer func() {
+			if out != nil {
+				_ = out.Close()
+			}
+		}()
+	}
+
+	// Rows are buffered to learn the -samples count (the highest sample number) before reporting.
+	var rows []jsonlRow
+	var malformed int
+	br := bufio.NewReader(f)
+	for {
+		line, err := br.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var row jsonlRow
+			switch {
+			case json.Unmarshal(line, &row) != nil:
+				malformed++
+			case row.Kind == "":
+				rows = append(rows, row)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read -in: %w", err)
+		}
+	}
+	samples := 1
+	for _, row := range rows {
+		samples = max(samples, row.Sample)
+	}
+	run, err := newOfflineRun(ac.MarkersFile, samples, nil)
+	if err != nil {
+		return err
+	}
+
+	var previewOnly int
+	for _, row := range rows {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if row.Body == nil {
+			previewOnly++
+		}
+		res := row.result()
+		var hits []MarkerHit
+		if res.Err == nil {
+			hits = run.analyzer.Analyze(res)
+		}
+		score, sev := run.report(row.Target).record(res, hits, false)
+		run.notePrompt(row.Target, row.PromptID)
+		row.MarkerHits, row.Score, row.Severity = hits, score, sev.String()
+		if out != nil {
+			if err := out.writeRow(row); err != nil {
+				return err
+			}
+		}
+	}
+	var writeErr error
+	run.finishPrompts(func(target string, sum PromptSummary) {
+		if out != nil && writeErr == nil {
+			row := sum.row()
+			row.Target = target
+			writeErr = out.writeRow(row)
+		}
+	})
+	if writeErr != nil {
+		return writeErr
+	}
+	if out != nil {
+		err := out.Close()
+		out = nil
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("analyzed: rows=%d preview_only=%d malformed=%d", len(rows), previewOnly, malformed)
+	return run.logSummary()
+}
@@ -0,0 +1,146 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func analyzeRun(t *testing.T, ac AnalyzeConfig) (string, error) {
+	t.Helper()
+	var logs bytes.Buffer
+	restore := logWriterSwap(t, &logs)
+	defer restore()
+	err := Analyze(context.Background(), ac)
+	return logs.String(), err
+}
+
+func readJSONLRows(t *testing.T, path string) []map[string]any {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	var rows []map[string]any
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var row map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &row); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestRunAnalyze_RescoresFullBodies(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "sid=s3cret")
+		_, _ = w.Write([]byte(`{"reply":"Sure, BEGIN SYSTEM ` + strings.Repeat("x", 500) + ` codename falcon"}`))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	promptsFile := filepath.Join(dir, "prompts.txt")
+	if err := os.WriteFile(promptsFile, []byte("one\ntwo\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	in := filepath.Join(dir, "results.jsonl")
+	cfg := Config{URL: srv.URL, Method: http.MethodPost, Workers: 1, Timeout: 2 * time.Second, PromptsFile: promptsFile, JSONLOut: in, JSONLFullBody: true, Samples: 2}
+	var live bytes.Buffer
+	restore := logWriterSwap(t, &live)
+	if _, err := run(context.Background(), cfg); err != nil {
+		restore()
+		t.Fatalf("run: %v", err)
+	}
+	restore()
+	rows := readJSONLRows(t, in)
+	if rows[0]["body"] == nil || rows[0]["headers"].(map[string]any)["Set-Cookie"].([]any)[0] != redactedValue {
+		t.Fatalf("expected the full body and redacted headers, got %v", rows[0])
+	}
+
+	// The same markers reproduce the live summary.
+	logs, err := analyzeRun(t, AnalyzeConfig{In: in})
+	if err != nil {
+		t.Fatalf("analyze: %v", err)
+	}
+	for _, want := range []string{"done: sent=4 errs=0", "system_leak_responses: 4"} {
+		if !strings.Contains(live.String(), want) || !strings.Contains(logs, want) {
+			t.Fatalf("expected %q in the live and analyzed summaries, got:\n%s\n---\n%s", want, live.String(), logs)
+		}
+	}
+	if !strings.Contains(logs, "analyzed: rows=4 preview_only=0 malformed=0") {
+		t.Fatalf("expected the analyzed line, got:\n%s", logs)
+	}
+
+	// New markers see past the 400-byte preview and trip their threshold.
+	markers := filepath.Join(dir, "markers.json")
+	spec := `{"version":1,"replace_defaults":true,"categories":{"system_leak":{"severity":"critical","stop_after_responses":1}},
+		"regexes":[{"id":"codename","category":"system_leak","pattern":"codename \\w+","enabled":true}]}`
+	if err := os.WriteFile(markers, []byte(spec), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	out := filepath.Join(dir, "rescored.jsonl")
+	logs, err = analyzeRun(t, AnalyzeConfig{In: in, MarkersFile: markers, JSONLOut: out})
+	var te ThresholdExceededError
+	if !errors.As(err, &te) || te.Severity != SeverityCritical {
+		t.Fatalf("expected a critical threshold error, got %v:\n%s", err, logs)
+	}
+	if !strings.Contains(logs, "codename: 4 / 4") {
+		t.Fatalf("expected the new marker on every row, got:\n%s", logs)
+	}
+	var requests, summaries int
+	for _, row := range readJSONLRows(t, out) {
+		if row["kind"] == rowKindPromptSummary {
+			summaries++
+			if row["successes"] != float64(2) {
+				t.Fatalf("expected rebuilt summaries, got %v", row)
+			}
+			continue
+		}
+		requests++
+		hits, _ := row["marker_hits"].([]any)
+		if len(hits) != 1 || hits[0].(map[string]any)["ID"] != "system_leak:codename" || row["severity"] != "critical" || row["body"] == nil {
+			t.Fatalf("expected a re-scored row, got %v", row)
+		}
+	}
+	if requests != 4 || summaries != 2 {
+		t.Fatalf("expected 4 request and 2 summary rows, got %d and %d", requests, summaries)
+	}
+}
+
+func TestRunAnalyze_FallsBackToPreviews(t *testing.T) {
+	in := filepath.Join(t.TempDir(), "results.jsonl")
+	lines := `{"seq":1,"prompt":"p","status_code":200,"body_preview":"BEGIN SYSTEM prompt","score":0,"severity":"info"}
+not json
+{"seq":2,"prompt":"q","status_code":0,"error":"dial tcp: refused","body_preview":"BEGIN SYSTEM","score":0,"severity":"info"}
+`
+	if err := os.WriteFile(in, []byte(lines), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	logs, err := analyzeRun(t, AnalyzeConfig{In: in})
+	if err != nil {
+		t.Fatalf("analyze: %v", err)
+	}
+	for _, want := range []string{"analyzed: rows=2 preview_only=2 malformed=1", "done: sent=2 errs=1", "system_leak_responses: 1"} {
+		if !strings.Contains(logs, want) {
+			t.Fatalf("expected %q, got:\n%s", want, logs)
+		}
+	}
+}
+
+func TestAnalyze_MissingInput(t *testing.T) {
+	if _, err := analyzeRun(t, AnalyzeConfig{In: filepath.Join(t.TempDir(), "missing.jsonl")}); err == nil {
+		t.Fatalf("expected an open error")
+	}
+}
@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"context"
+	"net/http"
+)
+
+// authProvider supplies credentials that expire mid-run (session logins, OAuth2 tokens).
+// Credentials are fetched per attempt; when the target answers with a status RefreshOn accepts,
+// the request is retried once after Refresh.
+type authProvider interface {
+	Credentials(ctx context.Context) (authCredentials, error)
+	// Refresh renews the credentials unless they were already renewed since gen was handed out,
+	// so a burst of 401s from concurrent workers triggers a single re-login.
+	Refresh(ctx context.Context, gen uint64) error
+	RefreshOn(status int) bool
+}
+
+type authCredentials struct {
+	Header  http.Header
+	Cookies []*http.Cookie
+	gen     uint64
+}
+
+// withAuth layers credentials over the static -headers-file / -cookies-file values; credential
+// headers and same-named cookies win.
+func withAuth(baseHeaders http.Header, cookies []*http.Cookie, creds authCredentials) (http.Header, []*http.Cookie) {
+	if len(creds.Header) == 0 && len(creds.Cookies) == 0 {
+		return baseHeaders, cookies
+	}
+	h := baseHeaders.Clone()
+	if h == nil {
+		h = make(http.Header)
+	}
+	for k, vs := range creds.Header {
+		h[k] = append([]string(nil), vs...)
+	}
+	if len(creds.Cookies) == 0 {
+		return h, cookies
+	}
+	override := make(map[string]bool, len(creds.Cookies))
+	for _, c := range creds.Cookies {
+		override[c.Name] = true
+	}
+	out := make([]*http.Cookie, 0, len(cookies)+len(creds.Cookies))
+	for _, c := range cookies {
+		if !override[c.Name] {
+			out = append(out, c)
+		}
+	}
+	return h, append(out, creds.Cookies...)
+}
@@ -0,0 +1,239 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	BodyFormatJSON      = "json"
+	BodyFormatForm      = "form"
+	BodyFormatMultipart = "multipart"
+	BodyFormatRaw       = "raw"
+)
+
+// bodyTemplate renders a request body for one prompt; ContentType is sent unless -headers-file overrides it.
+type bodyTemplate interface {
+	Render(in promptInput) ([]byte, error)
+	ContentType() string
+}
+
+func validateBodyFormat(cfg Config) error {
+	switch cfg.BodyFormat {
+	case "", BodyFormatJSON:
+		return nil
+	case BodyFormatForm, BodyFormatMultipart, BodyFormatRaw:
+		if cfg.TargetKind == TargetKindOpenAIChat {
+			return fmt.Errorf("-body-format %s is not supported with -target-kind %s", cfg.BodyFormat, TargetKindOpenAIChat)
+		}
+		if strings.EqualFold(strings.TrimSpace(cfg.Method), "GET") {
+			return fmt.Errorf("-body-format %s requires a non-GET -method", cfg.BodyFormat)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown -body-format %q (expected %s|%s|%s|%s)", cfg.BodyFormat, BodyFormatJSON, BodyFormatForm, BodyFormatMultipart, BodyFormatRaw)
+	}
+}
+
+// parseBodyTemplate parses s according to format. An empty s yields the format's default body, which
+// (like the JSON default) puts the prompt in a single "prompt" field.
+func parseBodyTemplate(format string, s string) (bodyTemplate, error) {
+	switch format {
+	case "", BodyFormatJSON:
+		return parseJSONBodyTemplate(s)
+	case BodyFormatForm:
+		if s == "" {
+			s = defaultJSONKey + "=" + promptPlaceholder
+		}
+		return parseFormBodyTemplate(s)
+	case BodyFormatMultipart:
+		if s == "" {
+			s = defaultJSONKey + "=" + promptPlaceholder
+		}
+		return parseMultipartBodyTemplate(s)
+	case BodyFormatRaw:
+		if s == "" {
+			s = promptPlaceholder
+		}
+		return &rawBodyTemplate{text: s}, nil
+	default:
+		return nil, fmt.Errorf("unknown body format %q", format)
+	}
+}
+
+func (t *jsonBodyTemplate) ContentType() string { return "application/json" }
+
+type formField struct {
+	name  string
+	value string
+}
+
+// formBodyTemplate is an application/x-www-form-urlencoded body: "k=v&k2=v2" with {{prompt}} in values.
+// Field order and repeated keys are kept as written.
+type formBodyTemplate struct {
+	fields []formField
+}
+
+func parseFormBodyTemplate(s string) (*formBodyTemplate, error) {
+	s = strings.TrimSpace(s)
+	t := &formBodyTemplate{}
+	for _, pair := range strings.Split(s, "&") {
+		if pair == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(k)
+		if err != nil {
+			return nil, fmt.Errorf("form template: invalid key %q: %w", k, err)
+		}
+		value, err := url.QueryUnescape(v)
+		if err != nil {
+			return nil, fmt.Errorf("form template: invalid value for %q: %w", name, err)
+		}
+		if name == "" {
+			return nil, fmt.Errorf("form template: empty field name in %q", pair)
+		}
+		t.fields = append(t.fields, formField{name: name, value: value})
+	}
+	if len(t.fields) == 0 {
+		return nil, fmt.Errorf("form template: no fields")
+	}
+	return t, nil
+}
+
+func (t *formBodyTemplate) Render(in promptInput) ([]byte, error) {
+	var b strings.Builder
+	for i, f := range t.fields {
+		if i > 0 {
+			b.WriteByte('&')
+		}
+		b.WriteString(url.QueryEscape(f.name))
+		b.WriteByte('=')
+		v, err := in.expand(f.value, rawEscape)
+		if err != nil {
+			return nil, fmt.Errorf("form template: %w", err)
+		}
+		b.WriteString(url.QueryEscape(v))
+	}
+	return []byte(b.String()), nil
+}
+
+func (t *formBodyTemplate) ContentType() string { return "application/x-www-form-urlencoded" }
+
+type multipartPart struct {
+	name        string
+	filename    string // non-empty for file parts
+	contentType string
+	value       string
+}
+
+// multipartBodyTemplate is a multipart/form-data body. One part per line:
+//
+//	name=value                  text field
+//	name@file.txt=value         file part (Content-Type from the extension, else text/plain)
+//
+// Blank lines and #comments are skipped; "\n" in a value is a newline. Placeholders are inserted verbatim.
+type multipartBodyTemplate struct {
+	parts    []multipartPart
+	boundary string
+}
+
+func parseMultipartBodyTemplate(s string) (*multipartBodyTemplate, error) {
+	t := &multipartBodyTemplate{boundary: multipart.NewWriter(nil).Boundary()}
+	sc := bufio.NewScanner(strings.NewReader(s))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("multipart template: line %d: expected name=value or name@filename=value", n)
+		}
+		p := multipartPart{name: strings.TrimSpace(k), value: strings.ReplaceAll(v, `\n`, "\n")}
+		if name, file, ok := strings.Cut(p.name, "@"); ok {
+			p.name, p.filename = name, file
+			if p.filename == "" {
+				return nil, fmt.Errorf("multipart template: line %d: empty filename", n)
+			}
+			p.contentType = mime.TypeByExtension(filepath.Ext(p.filename))
+			if p.contentType == "" {
+				p.contentType = "text/plain; charset=utf-8"
+			}
+		}
+		if p.name == "" {
+			return nil, fmt.Errorf("multipart template: line %d: empty part name", n)
+		}
+		t.parts = append(t.parts, p)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("multipart template: %w", err)
+	}
+	if len(t.parts) == 0 {
+		return nil, fmt.Errorf("multipart template: no parts")
+	}
+	return t, nil
+}
+
+func (t *multipartBodyTemplate) Render(in promptInput) ([]byte, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	if err := w.SetBoundary(t.boundary); err != nil {
+		return nil, fmt.Errorf("multipart template: %w", err)
+	}
+	for _, p := range t.parts {
+		value, err := in.expand(p.value, rawEscape)
+		if err != nil {
+			return nil, fmt.Errorf("multipart template: %w", err)
+		}
+		if strings.Contains(value, "--"+t.boundary) {
+			return nil, fmt.Errorf("multipart template: prompt contains the multipart boundary")
+		}
+		if p.filename == "" {
+			if err := w.WriteField(p.name, value); err != nil {
+				return nil, fmt.Errorf("multipart template: write %q: %w", p.name, err)
+			}
+			continue
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": p.name, "filename": p.filename}))
+		h.Set("Content-Type", p.contentType)
+		pw, err := w.CreatePart(h)
+		if err != nil {
+			return nil, fmt.Errorf("multipart template: write %q: %w", p.name, err)
+		}
+		if _, err := pw.Write([]byte(value)); err != nil {
+			return nil, fmt.Errorf("multipart template: write %q: %w", p.name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("multipart template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (t *multipartBodyTemplate) ContentType() string {
+	return "multipart/form-data; boundary=" + t.boundary
+}
+
+// rawBodyTemplate sends the template text as-is with placeholders substituted unescaped.
+type rawBodyTemplate struct {
+	text string
+}
+
+func (t *rawBodyTemplate) Render(in promptInput) ([]byte, error) {
+	s, err := in.expand(t.text, rawEscape)
+	if err != nil {
+		return nil, fmt.Errorf("body template: %w", err)
+	}
+	return []byte(s), nil
+}
+
+func (t *rawBodyTemplate) ContentType() string { return "text/plain; charset=utf-8" }
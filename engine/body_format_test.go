@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func buildWithFormat(t *testing.T, format string, tmpl string, prompt string) targetRequest {
+	t.Helper()
+	cfg := Config{URL: "https://example.test/chat", Method: http.MethodPost, BodyFormat: format, BodyTemplate: tmpl}
+	if err := validateBodyFormat(cfg); err != nil {
+		t.Fatalf("validateBodyFormat: %v", err)
+	}
+	rt, err := loadRequestTemplate(cfg)
+	if err != nil {
+		t.Fatalf("loadRequestTemplate: %v", err)
+	}
+	cfg.reqTemplate = rt
+	tr, err := buildTargetRequest(cfg, promptInput{Prompt: prompt})
+	if err != nil {
+		t.Fatalf("buildTargetRequest: %v", err)
+	}
+	return tr
+}
+
+func TestBodyFormat_Form(t *testing.T) {
+	tr := buildWithFormat(t, BodyFormatForm, "widget_id=42&message={{prompt}}&lang=en", "a&b=c d")
+	if ct := tr.Header.Get("Content-Type"); ct != "application/x-www-form-urlencoded" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	if string(tr.Body) != "widget_id=42&message=a%26b%3Dc+d&lang=en" {
+		t.Fatalf("unexpected body %q", tr.Body)
+	}
+
+	def := buildWithFormat(t, BodyFormatForm, "", "hi there")
+	if string(def.Body) != "prompt=hi+there" {
+		t.Fatalf("unexpected default body %q", def.Body)
+	}
+}
+
+func TestBodyFormat_Multipart(t *testing.T) {
+	tmpl := "# chat widget upload\nsession=abc\nnote@prompt.txt=Q: {{prompt}}\\nEND\n"
+	prompt := "line1\r\n--evil"
+	tr := buildWithFormat(t, BodyFormatMultipart, tmpl, prompt)
+
+	mt, params, err := mime.ParseMediaType(tr.Header.Get("Content-Type"))
+	if err != nil || mt != "multipart/form-data" || params["boundary"] == "" {
+		t.Fatalf("unexpected content type %q (%v)", tr.Header.Get("Content-Type"), err)
+	}
+	mr := multipart.NewReader(strings.NewReader(string(tr.Body)), params["boundary"])
+
+	p, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("part 1: %v", err)
+	}
+	if p.FormName() != "session" || p.FileName() != "" {
+		t.Fatalf("unexpected part 1: name=%q file=%q", p.FormName(), p.FileName())
+	}
+	b, _ := io.ReadAll(p)
+	if string(b) != "abc" {
+		t.Fatalf("unexpected part 1 body %q", b)
+	}
+
+	p, err = mr.NextPart()
+	if err != nil {
+		t.Fatalf("part 2: %v", err)
+	}
+	if p.FormName() != "note" || p.FileName() != "prompt.txt" {
+		t.Fatalf("unexpected part 2: name=%q file=%q", p.FormName(), p.FileName())
+	}
+	if ct := p.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("unexpected part 2 content type %q", ct)
+	}
+	b, _ = io.ReadAll(p)
+	if string(b) != "Q: "+prompt+"\nEND" {
+		t.Fatalf("unexpected part 2 body %q", b)
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Fatalf("expected exactly two parts, got %v", err)
+	}
+}
+
+func TestBodyFormat_Raw(t *testing.T) {
+	tr := buildWithFormat(t, BodyFormatRaw, "<msg>{{prompt}}</msg>", `"x" & <y>`)
+	if string(tr.Body) != `<msg>"x" & <y></msg>` {
+		t.Fatalf("unexpected body %q", tr.Body)
+	}
+	if ct := tr.Header.Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+}
+
+func TestBodyFormat_Validation(t *testing.T) {
+	bad := []Config{
+		{Method: http.MethodPost, BodyFormat: "xml"},
+		{Method: http.MethodGet, BodyFormat: BodyFormatForm},
+		{Method: http.MethodPost, BodyFormat: BodyFormatRaw, TargetKind: TargetKindOpenAIChat},
+	}
+	for _, cfg := range bad {
+		if err := validateBodyFormat(cfg); err == nil {
+			t.Fatalf("expected error for %+v", cfg)
+		}
+	}
+	if _, err := parseBodyTemplate(BodyFormatMultipart, "no-equals-sign"); err == nil {
+		t.Fatalf("expected multipart parse error")
+	}
+	if _, err := parseBodyTemplate(BodyFormatForm, "=x"); err == nil {
+		t.Fatalf("expected form parse error")
+	}
+}
+
+func TestBodyFormat_FormTemplateRoundTrips(t *testing.T) {
+	tr := buildWithFormat(t, BodyFormatForm, "q=pre%20{{prompt}}&q=2", "ü")
+	vs, err := url.ParseQuery(string(tr.Body))
+	if err != nil {
+		t.Fatalf("ParseQuery: %v", err)
+	}
+	if got := vs["q"]; len(got) != 2 || got[0] != "pre ü" || got[1] != "2" {
+		t.Fatalf("unexpected values %#v", got)
+	}
+}
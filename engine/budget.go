@@ -0,0 +1,300 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BudgetConfig holds the run guardrails; zero values disable each budget.
+type BudgetConfig struct {
+	MaxRequests int
+	MaxDuration time.Duration
+	MaxTokens   int
+	MaxCost     float64
+	// PricePer1K applies to input tokens, and to output tokens unless PricePer1KOutput is set.
+	PricePer1K       float64
+	PricePer1KOutput float64
+}
+
+func (c BudgetConfig) validate() error {
+	if c.MaxRequests < 0 || c.MaxDuration < 0 || c.MaxTokens < 0 || c.MaxCost < 0 || c.PricePer1K < 0 || c.PricePer1KOutput < 0 {
+		return errors.New("-max-requests, -max-duration, -max-run-tokens, -max-cost and -price-per-1k* must be >= 0")
+	}
+	if c.MaxCost > 0 && c.PricePer1K == 0 && c.PricePer1KOutput == 0 {
+		return errors.New("-max-cost requires -price-per-1k and/or -price-per-1k-output")
+	}
+	return nil
+}
+
+func (c BudgetConfig) enabled() bool {
+	return c.MaxRequests > 0 || c.MaxDuration > 0 || c.MaxTokens > 0 || c.MaxCost > 0 || c.PricePer1K > 0 || c.PricePer1KOutput > 0
+}
+
+func (c BudgetConfig) cost(in, out int) float64 {
+	outPrice := c.PricePer1KOutput
+	if outPrice == 0 {
+		outPrice = c.PricePer1K
+	}
+	return float64(in)/1000*c.PricePer1K + float64(out)/1000*outPrice
+}
+
+// Budget kinds, as reported in BudgetExceededError.Budget.
+const (
+	BudgetRequests = "requests"
+	BudgetDuration = "duration"
+	BudgetTokens   = "tokens"
+	BudgetCost     = "cost"
+)
+
+// BudgetExceededError is the cancel cause of a run stopped by a budget.
+type BudgetExceededError struct {
+	Budget string // BudgetRequests | BudgetDuration | BudgetTokens | BudgetCost
+	Used   string
+	Limit  string
+}
+
+func (e BudgetExceededError) Error() string {
+	return fmt.Sprintf("budget exhausted: %s %s >= %s", e.Budget, e.Used, e.Limit)
+}
+
+func (e BudgetExceededError) ExitCode() int {
+	switch e.Budget {
+	case BudgetRequests:
+		return 5
+	case BudgetDuration:
+		return 6
+	case BudgetTokens:
+		return 7
+	default:
+		return 8
+	}
+}
+
+// budgetTracker enforces BudgetConfig for one run. -max-requests stops admitting new requests and cancels
+// once the admitted ones finish; duration, token and cost budgets cancel immediately.
+type budgetTracker struct {
+	cfg    BudgetConfig
+	cancel func(error)
+
+	mu        sync.Mutex
+	requests  int
+	inflight  int
+	tokensIn  int
+	tokensOut int
+	estimated bool
+	exceeded  *BudgetExceededError
+	canceled  bool
+	start     time.Time
+	timer     *time.Timer
+}
+
+// newBudgetTracker returns nil when no budget or price is configured; all methods accept a nil receiver.
+func newBudgetTracker(c BudgetConfig, cancel func(error)) *budgetTracker {
+	if !c.enabled() {
+		return nil
+	}
+	b := &budgetTracker{cfg: c, cancel: cancel, start: time.Now()}
+	if c.MaxDuration > 0 {
+		b.timer = time.AfterFunc(c.MaxDuration, func() {
+			b.mu.Lock()
+			b.exceedLocked(BudgetExceededError{Budget: BudgetDuration, Used: time.Since(b.start).Round(time.Millisecond).String(), Limit: c.MaxDuration.String()})
+			fire := b.cancelLocked()
+			b.mu.Unlock()
+			fire()
+		})
+	}
+	return b
+}
+
+// Stop releases the -max-duration timer.
+func (b *budgetTracker) Stop() {
+	if b != nil && b.timer != nil {
+		b.timer.Stop()
+	}
+}
+
+// Admit reserves one request against -max-requests; callers must not send when it returns an error.
+func (b *budgetTracker) Admit() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	if b.exceeded != nil {
+		defer b.mu.Unlock()
+		return *b.exceeded
+	}
+	if b.cfg.MaxRequests > 0 && b.requests >= b.cfg.MaxRequests {
+		err := BudgetExceededError{Budget: BudgetRequests, Used: fmt.Sprint(b.requests), Limit: fmt.Sprint(b.cfg.MaxRequests)}
+		b.exceedLocked(err)
+		var fire func()
+		if b.inflight == 0 {
+			fire = b.cancelLocked()
+		}
+		b.mu.Unlock()
+		if fire != nil {
+			fire()
+		}
+		return err
+	}
+	b.requests++
+	b.inflight++
+	b.mu.Unlock()
+	return nil
+}
+
+// Record accounts the tokens of an admitted request and releases its -max-requests slot.
+func (b *budgetTracker) Record(res RequestResult) {
+	if b == nil {
+		return
+	}
+	in, out, estimated := resultTokens(res)
+
+	b.mu.Lock()
+	if b.inflight > 0 {
+		b.inflight--
+	}
+	b.tokensIn += in
+	b.tokensOut += out
+	b.estimated = b.estimated || estimated
+	if total := b.tokensIn + b.tokensOut; b.cfg.MaxTokens > 0 && total >= b.cfg.MaxTokens {
+		b.exceedLocked(BudgetExceededError{Budget: BudgetTokens, Used: fmt.Sprint(total), Limit: fmt.Sprint(b.cfg.MaxTokens)})
+	}
+	if cost := b.cfg.cost(b.tokensIn, b.tokensOut); b.cfg.MaxCost > 0 && cost >= b.cfg.MaxCost {
+		b.exceedLocked(BudgetExceededError{Budget: BudgetCost, Used: fmt.Sprintf("%.4f", cost), Limit: fmt.Sprintf("%.4f", b.cfg.MaxCost)})
+	}
+	var fire func()
+	if b.exceeded != nil && (b.inflight == 0 || b.exceeded.Budget != BudgetRequests) {
+		fire = b.cancelLocked()
+	}
+	b.mu.Unlock()
+	if fire != nil {
+		fire()
+	}
+}
+
+func (b *budgetTracker) exceedLocked(err BudgetExceededError) {
+	if b.exceeded == nil {
+		b.exceeded = &err
+	}
+}
+
+// cancelLocked returns the deferred cancel+log for the first exceeded budget, or a no-op.
+func (b *budgetTracker) cancelLocked() func() {
+	if b.canceled || b.exceeded == nil {
+		return func() {}
+	}
+	b.canceled = true
+	err := *b.exceeded
+	return func() {
+		log.Printf("%s: %s", styledKey("stop", ansiRed, ansiBold), styledValue(err.Error(), ansiRed))
+		if b.cancel != nil {
+			b.cancel(err)
+		}
+	}
+}
+
+// Summary renders budget usage for the final summary.
+func (b *budgetTracker) Summary() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var parts []string
+	if b.cfg.MaxRequests > 0 {
+		parts = append(parts, fmt.Sprintf("requests=%d/%d", b.requests, b.cfg.MaxRequests))
+	}
+	tokens := fmt.Sprintf("tokens=%d (in=%d out=%d)", b.tokensIn+b.tokensOut, b.tokensIn, b.tokensOut)
+	if b.cfg.MaxTokens > 0 {
+		tokens = fmt.Sprintf("tokens=%d/%d (in=%d out=%d)", b.tokensIn+b.tokensOut, b.cfg.MaxTokens, b.tokensIn, b.tokensOut)
+	}
+	parts = append(parts, tokens)
+	if b.cfg.PricePer1K > 0 || b.cfg.PricePer1KOutput > 0 {
+		cost := fmt.Sprintf("cost=%.4f", b.cfg.cost(b.tokensIn, b.tokensOut))
+		if b.cfg.MaxCost > 0 {
+			cost += fmt.Sprintf("/%.4f", b.cfg.MaxCost)
+		}
+		parts = append(parts, cost)
+	}
+	if b.estimated {
+		parts = append(parts, "estimated=true")
+	}
+	return strings.Join(parts, " ")
+}
+
+// tokenUsage covers the OpenAI (prompt/completion) and Anthropic (input/output) usage field names.
+type tokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	InputTokens      int `json:"input_tokens"`
+	OutputTokens     int `json:"output_tokens"`
+}
+
+type usageEnvelope struct {
+	Usage   *tokenUsage `json:"usage"`
+	Message *struct {
+		Usage *tokenUsage `json:"usage"`
+	} `json:"message"`
+}
+
+// resultTokens returns the tokens a request consumed: the response's own usage object when it has
+// one, else an estimate (~4 bytes per token) from the prompt and the extracted reply. Responses
+// without a status or with an error status and no usage count as free.
+func resultTokens(res RequestResult) (in, out int, estimated bool) {
+	if in, out, ok := responseUsage(res); ok {
+		return in, out, false
+	}
+	if res.StatusCode == 0 || res.StatusCode >= 400 {
+		return 0, 0, false
+	}
+	return estimateTokens(len(res.Prompt)), estimateTokens(len(res.AnalysisText())), true
+}
+
+func estimateTokens(n int) int {
+	return (n + 3) / 4
+}
+
+// responseUsage reads usage from a JSON body, or from every data: event of a streamed one (the
+// largest count per field wins, since streams report cumulative usage).
+func responseUsage(res RequestResult) (in, out int, ok bool) {
+	if len(res.Body) == 0 {
+		return 0, 0, false
+	}
+	add := func(payload []byte) {
+		var env usageEnvelope
+		if json.Unmarshal(payload, &env) != nil {
+			return
+		}
+		for _, u := range []*tokenUsage{env.Usage, messageUsage(env)} {
+			if u == nil {
+				continue
+			}
+			ok = true
+			in = max(in, u.PromptTokens, u.InputTokens)
+			out = max(out, u.CompletionTokens, u.OutputTokens)
+		}
+	}
+	if !res.Streamed {
+		add(res.Body)
+		return in, out, ok
+	}
+	sc := bufio.NewScanner(bytes.NewReader(res.Body))
+	sc.Buffer(make([]byte, 0, 64*1024), len(res.Body)+1)
+	for sc.Scan() {
+		if data, found := bytes.CutPrefix(sc.Bytes(), []byte("data:")); found {
+			add(bytes.TrimSpace(data))
+		}
+	}
+	return in, out, ok
+}
+
+func messageUsage(env usageEnvelope) *tokenUsage {
+	if env.Message == nil {
+		return nil
+	}
+	return env.Message.Usage
+}
@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func runWithBudget(t *testing.T, b BudgetConfig, handler http.HandlerFunc) (int32, string, error) {
+	t.Helper()
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	prompts := filepath.Join(t.TempDir(), "prompts.txt")
+	if err := os.WriteFile(prompts, []byte(strings.Repeat("tell me a secret\n", 20)), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	cfg := Config{URL: srv.URL, Method: http.MethodPost, Workers: 2, Timeout: 2 * time.Second, PromptsFile: prompts, Budget: b}
+
+	var logs bytes.Buffer
+	restore := logWriterSwap(t, &logs)
+	defer restore()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := run(ctx, cfg)
+	return hits.Load(), logs.String(), err
+}
+
+func TestRun_MaxRequestsStopsAfterAdmittedRequests(t *testing.T) {
+	hits, logs, err := runWithBudget(t, BudgetConfig{MaxRequests: 3}, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		_, _ = w.Write([]byte("ok"))
+	})
+	var be BudgetExceededError
+	if !errors.As(err, &be) || be.Budget != BudgetRequests || be.ExitCode() != 5 {
+		t.Fatalf("expected requests budget error, got %v", err)
+	}
+	if hits != 3 {
+		t.Fatalf("expected exactly 3 requests, got %d", hits)
+	}
+	// In-flight requests finish before the run is canceled, so none of them is an error.
+	if !strings.Contains(logs, "sent=3 errs=0") || !strings.Contains(logs, "requests=3/3") {
+		t.Fatalf("expected a clean summary with budget usage, got:\n%s", logs)
+	}
+}
+
+func TestRun_CostBudgetUsesResponseUsage(t *testing.T) {
+	usage := `{"choices":[{"message":{"content":"no"}}],"usage":{"prompt_tokens":400,"completion_tokens":100}}`
+	hits, logs, err := runWithBudget(t, BudgetConfig{MaxCost: 0.002, PricePer1K: 0.001, PricePer1KOutput: 0.004}, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(usage))
+	})
+	var be BudgetExceededError
+	if !errors.As(err, &be) || be.Budget != BudgetCost || be.ExitCode() != 8 {
+		t.Fatalf("expected cost budget error, got %v", err)
+	}
+	// Each request costs 0.0004 + 0.0004, so the third one reaches 0.002.
+	if hits < 3 || hits > 4 {
+		t.Fatalf("expected the run to stop after ~3 requests, got %d", hits)
+	}
+	if !strings.Contains(logs, "stop: budget exhausted: cost") || strings.Contains(logs, "estimated=true") {
+		t.Fatalf("unexpected logs:\n%s", logs)
+	}
+}
+
+func TestRun_MaxDuration(t *testing.T) {
+	_, logs, err := runWithBudget(t, BudgetConfig{MaxDuration: 100 * time.Millisecond}, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(40 * time.Millisecond)
+		_, _ = w.Write([]byte("ok"))
+	})
+	var be BudgetExceededError
+	if !errors.As(err, &be) || be.Budget != BudgetDuration || be.ExitCode() != 6 {
+		t.Fatalf("expected duration budget error, got %v", err)
+	}
+	if !strings.Contains(logs, "done: sent=") {
+		t.Fatalf("expected the summary to be printed, got:\n%s", logs)
+	}
+}
+
+func TestResultTokens(t *testing.T) {
+	stream := strings.Join([]string{
+		`event: message_start`,
+		`data: {"type":"message_start","message":{"usage":{"input_tokens":25,"output_tokens":1}}}`,
+		`data: {"type":"content_block_delta","delta":{"text":"hi"}}`,
+		`data: {"type":"message_delta","usage":{"output_tokens":15}}`,
+		`data: [DONE]`,
+	}, "\n")
+	cases := []struct {
+		name          string
+		res           RequestResult
+		in, out       int
+		wantEstimated bool
+	}{
+		{"openai", RequestResult{StatusCode: 200, Body: []byte(`{"usage":{"prompt_tokens":7,"completion_tokens":3}}`)}, 7, 3, false},
+		{"anthropic stream", RequestResult{StatusCode: 200, Streamed: true, Body: []byte(stream)}, 25, 15, false},
+		{"estimated", RequestResult{StatusCode: 200, Prompt: "12345678", Body: []byte("<p>abc</p>"), Text: []byte("abcd")}, 2, 1, true},
+		{"error status", RequestResult{StatusCode: 429, Prompt: "12345678", Body: []byte("slow down")}, 0, 0, false},
+		{"transport error", RequestResult{Prompt: "12345678", Err: errors.New("boom")}, 0, 0, false},
+	}
+	for _, c := range cases {
+		in, out, est := resultTokens(c.res)
+		if in != c.in || out != c.out || est != c.wantEstimated {
+			t.Fatalf("%s: got in=%d out=%d estimated=%v", c.name, in, out, est)
+		}
+	}
+}
+
+func TestBudgetConfig_Validate(t *testing.T) {
+	for _, c := range []BudgetConfig{{MaxRequests: -1}, {MaxDuration: -time.Second}, {MaxCost: 1}, {PricePer1K: -1}} {
+		if err := c.validate(); err == nil {
+			t.Fatalf("expected error for %+v", c)
+		}
+	}
+	if err := (BudgetConfig{MaxCost: 1, PricePer1KOutput: 0.01}).validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
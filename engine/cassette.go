@@ -0,0 +1,445 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A cassette (-record DIR) holds every exchange of a run for offline re-analysis with poke replay:
+//
+//	DIR/cassette.json     manifest (format version, run settings replay needs)
+//	DIR/exchanges.jsonl   one cassetteExchange per result, in the order they were recorded
+//	DIR/blobs/ab/abcd...  request and response bodies, content-addressed by SHA-256
+const (
+	cassetteVersion       = 1
+	cassetteManifestName  = "cassette.json"
+	cassetteExchangesName = "exchanges.jsonl"
+	cassetteBlobsDir      = "blobs"
+)
+
+type cassetteManifest struct {
+	Version int    `json:"version"`
+	Created string `json:"created"`
+	Samples int    `json:"samples,omitempty"`
+}
+
+type cassetteRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+type cassetteResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	// Body is the complete response, also when the live run analyzed only -max-response-bytes of it,
+	// unless Truncated is set.
+	Body    string `json:"body,omitempty"`
+	BodyLen int    `json:"body_len"`
+	// Truncated is set when Body stops short of the response: it reached -record-max-bytes, or an
+	// event stream was closed without being drained.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// cassetteExchange is one recorded result: the final attempt's request and response plus what the
+// live run derived from them, so replay can rebuild the RequestResult exactly.
+type cassetteExchange struct {
+	Seq      int               `json:"seq"`
+	WorkerID int               `json:"worker_id"`
+	Target   string            `json:"target,omitempty"`
+	Prompt   string            `json:"prompt"`
+	PromptID string            `json:"prompt_id,omitempty"`
+	ConvID   string            `json:"conversation_id,omitempty"`
+	Turn     int               `json:"turn,omitempty"`
+	Sample   int               `json:"sample,omitempty"`
+	Attempts int               `json:"attempts"`
+	Retries  int               `json:"retries"`
+	Started  string            `json:"started,omitempty"`
+	Request  *cassetteRequest  `json:"request,omitempty"`
+	Response *cassetteResponse `json:"response,omitempty"`
+	// AnalyzedBytes is the prefix of the response body the live run analyzed.
+	AnalyzedBytes int      `json:"analyzed_bytes"`
+	BodyTruncated bool     `json:"body_truncated,omitempty"`
+	Text          string   `json:"text,omitempty"`
+	Streamed      bool     `json:"streamed,omitempty"`
+	LatencyNS     int64    `json:"latency_ns"`
+	TTFTNS        int64    `json:"ttft_ns,omitempty"`
+	TokenEvents   int      `json:"token_events,omitempty"`
+	GraphQLErrors []string `json:"graphql_errors,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// exchangeCapture is the request as sent plus a tee of its response body; sendOne attaches it to
+// the result when recording.
+type exchangeCapture struct {
+	started time.Time
+	method  string
+	url     string
+	header  http.Header
+	body    []byte
+	resp    *bodyCapture
+}
+
+func newExchangeCapture(req *http.Request, body []byte, started time.Time) *exchangeCapture {
+	return &exchangeCapture{started: started, method: req.Method, url: req.URL.String(), header: req.Header.Clone(), body: body}
+}
+
+// wrap tees rc so the body is kept past where the reader stops at -max-response-bytes. With drain set,
+// Close reads the rest of it, up to limit bytes in all when limit > 0.
+func (c *exchangeCapture) wrap(rc io.ReadCloser, drain bool, limit int64) io.ReadCloser {
+	c.resp = &bodyCapture{rc: rc, drain: drain, limit: limit}
+	return c.resp
+}
+
+type bodyCapture struct {
+	rc        io.ReadCloser
+	buf       bytes.Buffer
+	drain     bool
+	limit     int64
+	eof       bool
+	truncated bool
+}
+
+func (b *bodyCapture) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	b.buf.Write(p[:n])
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+// Close reads what the live run left unread (a truncated body) before closing. Anything past limit is
+// dropped and the capture marked truncated.
+func (b *bodyCapture) Close() error {
+	if !b.eof && b.drain {
+		if b.limit <= 0 {
+			_, err := io.Copy(&b.buf, b.rc)
+			b.eof = err == nil
+		} else if left := b.limit - int64(b.buf.Len()); left >= 0 {
+			// One byte past the limit tells a body of exactly limit bytes from a longer one.
+			n, err := io.Copy(&b.buf, io.LimitReader(b.rc, left+1))
+			b.eof = err == nil && n <= left
+			if int64(b.buf.Len()) > b.limit {
+				b.buf.Truncate(int(b.limit))
+			}
+		}
+	}
+	b.truncated = !b.eof
+	return b.rc.Close()
+}
+
+type cassetteRecorder struct {
+	dir string
+	// redact lists headers redacted on top of sensitiveHeaders (the -hmac-header).
+	redact []string
+
+	mu  sync.Mutex
+	f   *os.File
+	bw  *bufio.Writer
+	err error
+}
+
+// newCassetteRecorder creates dir (if needed) and truncates its exchange index; blobs from earlier
+// recordings are kept, as they are immutable. Headers are redacted with redactHeaders.
+func newCassetteRecorder(dir string, samples int, extraRedact ...string) (*cassetteRecorder, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(filepath.Join(dir, cassetteBlobsDir), 0o755); err != nil {
+		return nil, fmt.Errorf("create -record dir: %w", err)
+	}
+	m := cassetteManifest{Version: cassetteVersion, Created: time.Now().UTC().Format(time.RFC3339), Samples: samples}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encode cassette manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, cassetteManifestName), append(b, '\n'), 0o644); err != nil {
+		return nil, fmt.Errorf("write cassette manifest: %w", err)
+	}
+	f, err := os.Create(filepath.Join(dir, cassetteExchangesName))
+	if err != nil {
+		return nil, fmt.Errorf("create cassette exchanges: %w", err)
+	}
+	return &cassetteRecorder{dir: dir, redact: extraRedact, f: f, bw: bufio.NewWriterSize(f, 256*1024)}, nil
+}
+
+// Record appends res to the cassette. Write errors are kept and returned by Close so a full disk
+// does not stop the run.
+func (r *cassetteRecorder) Record(res RequestResult, seq int, target string) {
+	if r == nil {
+		return
+	}
+	ex := cassetteExchange{
+		Seq:           seq,
+		WorkerID:      res.WorkerID,
+		Target:        target,
+		Prompt:        res.Prompt,
+		PromptID:      res.PromptID,
+		ConvID:        res.ConversationID,
+		Turn:          res.Turn,
+		Sample:        res.Sample,
+		Attempts:      res.Attempts,
+		Retries:       res.Retries,
+		AnalyzedBytes: len(res.Body),
+		BodyTruncated: res.BodyTruncated,
+		Streamed:      res.Streamed,
+		LatencyNS:     int64(res.Latency),
+		TTFTNS:        int64(res.FirstTokenLatency),
+		TokenEvents:   res.TokenEvents,
+		GraphQLErrors: res.GraphQLErrors,
+	}
+	if res.Err != nil {
+		ex.Error = res.Err.Error()
+	}
+	err := r.fill(&ex, res)
+	if err == nil {
+		var b []byte
+		b, err = json.Marshal(ex)
+		if err == nil {
+			r.mu.Lock()
+			if r.err == nil {
+				_, err = r.bw.Write(append(b, '\n'))
+			}
+			r.mu.Unlock()
+		}
+	}
+	if err != nil {
+		r.setErr(fmt.Errorf("record exchange %d: %w", seq, err))
+	}
+}
+
+func (r *cassetteRecorder) fill(ex *cassetteExchange, res RequestResult) error {
+	var err error
+	full, truncated := res.Body, res.BodyTruncated
+	if c := res.exchange; c != nil {
+		ex.Started = c.started.UTC().Format(time.RFC3339Nano)
+		ex.Request = &cassetteRequest{Method: c.method, URL: c.url, Header: redactHeaders(c.header, r.redact...)}
+		if ex.Request.Body, err = r.putBlob(c.body); err != nil {
+			return err
+		}
+		if c.resp != nil {
+			full, truncated = c.resp.buf.Bytes(), c.resp.truncated
+		}
+	}
+	if res.StatusCode != 0 {
+		ex.Response = &cassetteResponse{Status: res.StatusCode, Header: redactHeaders(res.Headers, r.redact...), BodyLen: len(full), Truncated: truncated}
+		if ex.Response.Body, err = r.putBlob(full); err != nil {
+			return err
+		}
+	}
+	if res.Text != nil {
+		// An empty blob still records that the adapter extracted text (nil Text means "analyze Body").
+		if ex.Text, err = r.putBlob(res.Text); err != nil {
+			return err
+		}
+		if ex.Text == "" {
+			ex.Text = blobRef(nil)
+		}
+	}
+	return nil
+}
+
+func blobRef(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func blobPath(dir, ref string) (string, error) {
+	hexSum, ok := strings.CutPrefix(ref, "sha256:")
+	if !ok || len(hexSum) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid blob reference %q", ref)
+	}
+	if _, err := hex.DecodeString(hexSum); err != nil {
+		return "", fmt.Errorf("invalid blob reference %q", ref)
+	}
+	return filepath.Join(dir, cassetteBlobsDir, hexSum[:2], hexSum), nil
+}
+
+// putBlob stores b under its hash and returns the reference; empty bodies are not stored.
+func (r *cassetteRecorder) putBlob(b []byte) (string, error) {
+	if len(b) == 0 {
+		return "", nil
+	}
+	ref := blobRef(b)
+	path, err := blobPath(r.dir, ref)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return ref, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	// Write then rename so concurrent writers of the same blob never expose a partial file.
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	return ref, nil
+}
+
+func (r *cassetteRecorder) setErr(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *cassetteRecorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return r.err
+	}
+	if err := r.bw.Flush(); err != nil && r.err == nil {
+		r.err = fmt.Errorf("write cassette exchanges: %w", err)
+	}
+	if err := r.f.Close(); err != nil && r.err == nil {
+		r.err = fmt.Errorf("close cassette exchanges: %w", err)
+	}
+	r.f = nil
+	return r.err
+}
+
+// cassette is a recorded run opened for replay.
+type cassette struct {
+	dir       string
+	manifest  cassetteManifest
+	exchanges []cassetteExchange
+}
+
+func openCassette(dir string) (*cassette, error) {
+	b, err := os.ReadFile(filepath.Join(dir, cassetteManifestName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s is not a cassette (no %s)", dir, cassetteManifestName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read cassette manifest: %w", err)
+	}
+	c := &cassette{dir: dir}
+	if err := json.Unmarshal(b, &c.manifest); err != nil {
+		return nil, fmt.Errorf("parse cassette manifest: %w", err)
+	}
+	if c.manifest.Version != cassetteVersion {
+		return nil, fmt.Errorf("cassette: unsupported version %d (expected %d)", c.manifest.Version, cassetteVersion)
+	}
+
+	f, err := os.Open(filepath.Join(dir, cassetteExchangesName))
+	if err != nil {
+		return nil, fmt.Errorf("open cassette exchanges: %w", err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var ex cassetteExchange
+		if err := json.Unmarshal(sc.Bytes(), &ex); err != nil {
+			return nil, fmt.Errorf("cassette exchanges: line %d: %w", line, err)
+		}
+		c.exchanges = append(c.exchanges, ex)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read cassette exchanges: %w", err)
+	}
+	return c, nil
+}
+
+func (c *cassette) blob(ref string) ([]byte, error) {
+	if ref == "" {
+		return nil, nil
+	}
+	if ref == blobRef(nil) {
+		return []byte{}, nil
+	}
+	path, err := blobPath(c.dir, ref)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cassette blob: %w", err)
+	}
+	if blobRef(b) != ref {
+		return nil, fmt.Errorf("cassette blob %s: content does not match its hash", ref)
+	}
+	return b, nil
+}
+
+// result rebuilds the live RequestResult of ex: the analyzed body prefix, the adapter's text and
+// the recorded timings.
+func (c *cassette) result(ex cassetteExchange) (RequestResult, error) {
+	res := RequestResult{
+		Seq:               ex.Seq,
+		WorkerID:          ex.WorkerID,
+		Prompt:            ex.Prompt,
+		PromptID:          ex.PromptID,
+		ConversationID:    ex.ConvID,
+		Turn:              ex.Turn,
+		Sample:            ex.Sample,
+		Attempts:          ex.Attempts,
+		Retries:           ex.Retries,
+		BodyTruncated:     ex.BodyTruncated,
+		Streamed:          ex.Streamed,
+		Latency:           time.Duration(ex.LatencyNS),
+		FirstTokenLatency: time.Duration(ex.TTFTNS),
+		TokenEvents:       ex.TokenEvents,
+		GraphQLErrors:     ex.GraphQLErrors,
+	}
+	if ex.Error != "" {
+		res.Err = errors.New(ex.Error)
+	}
+	if ex.Response != nil {
+		res.StatusCode = ex.Response.Status
+		res.Headers = ex.Response.Header
+		full, err := c.blob(ex.Response.Body)
+		if err != nil {
+			return RequestResult{}, err
+		}
+		if ex.AnalyzedBytes < 0 || ex.AnalyzedBytes > len(full) {
+			return RequestResult{}, fmt.Errorf("cassette exchange %d: analyzed_bytes %d exceeds the body (%d bytes)", ex.Seq, ex.AnalyzedBytes, len(full))
+		}
+		res.Body = full[:ex.AnalyzedBytes]
+	}
+	text, err := c.blob(ex.Text)
+	if err != nil {
+		return RequestResult{}, err
+	}
+	res.Text = text
+	return res, nil
+}
@@ -0,0 +1,178 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func recordRun(t *testing.T, handler http.HandlerFunc, prompts string, mut func(*Config)) (string, string) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	dir := t.TempDir()
+	promptsFile := filepath.Join(dir, "prompts.txt")
+	if err := os.WriteFile(promptsFile, []byte(prompts), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	cassetteDir := filepath.Join(dir, "cassette")
+	cfg := Config{URL: srv.URL, Method: http.MethodPost, Workers: 1, Timeout: 2 * time.Second, PromptsFile: promptsFile, RecordDir: cassetteDir}
+	if mut != nil {
+		mut(&cfg)
+	}
+
+	var logs bytes.Buffer
+	restore := logWriterSwap(t, &logs)
+	defer restore()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := run(ctx, cfg); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	return cassetteDir, logs.String()
+}
+
+func TestRun_RecordCassette(t *testing.T) {
+	big := "BEGIN SYSTEM " + strings.Repeat("x", 200)
+	dir, _ := recordRun(t, func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "s3cret"})
+		_, _ = w.Write([]byte(big))
+	}, "one\ntwo\n", func(cfg *Config) {
+		cfg.MaxResponseBytes = 128
+		headers := filepath.Join(t.TempDir(), "headers.txt")
+		if err := os.WriteFile(headers, []byte("Authorization: Bearer tok\nX-Team: red\n"), 0o600); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		cfg.HeadersFile = headers
+	})
+
+	c, err := openCassette(dir)
+	if err != nil {
+		t.Fatalf("openCassette: %v", err)
+	}
+	if len(c.exchanges) != 2 {
+		t.Fatalf("expected 2 exchanges, got %d", len(c.exchanges))
+	}
+	ex := c.exchanges[0]
+	if ex.Request == nil || ex.Request.Method != http.MethodPost || ex.Request.Header.Get("Authorization") != redactedValue || ex.Request.Header.Get("X-Team") != "red" {
+		t.Fatalf("unexpected request %+v", ex.Request)
+	}
+	if ex.Response == nil || ex.Response.Header.Get("Set-Cookie") != redactedValue || ex.Response.BodyLen != len(big) || ex.Response.Truncated || ex.AnalyzedBytes != 128 || !ex.BodyTruncated {
+		t.Fatalf("unexpected response %+v analyzed=%d", ex.Response, ex.AnalyzedBytes)
+	}
+	full, err := c.blob(ex.Response.Body)
+	if err != nil || string(full) != big {
+		t.Fatalf("expected the complete body in the cassette, got %q (%v)", full, err)
+	}
+	if c.exchanges[1].Response.Body != ex.Response.Body {
+		t.Fatalf("expected identical bodies to share a blob")
+	}
+
+	res, err := c.result(ex)
+	if err != nil {
+		t.Fatalf("result: %v", err)
+	}
+	if string(res.Body) != big[:128] || res.StatusCode != http.StatusOK || res.Prompt == "" || res.PromptID == "" {
+		t.Fatalf("unexpected replayed result %+v", res)
+	}
+
+	// A tampered blob is rejected rather than analyzed.
+	path, _ := blobPath(dir, ex.Response.Body)
+	if err := os.WriteFile(path, []byte("changed"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := c.result(ex); err == nil {
+		t.Fatalf("expected a hash mismatch error")
+	}
+}
+
+func TestRun_RecordBoundsTheDrain(t *testing.T) {
+	huge := strings.Repeat("y", 1000)
+	dir, _ := recordRun(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(huge))
+	}, "one\n", func(cfg *Config) { cfg.MaxResponseBytes = 100 })
+
+	c, err := openCassette(dir)
+	if err != nil {
+		t.Fatalf("openCassette: %v", err)
+	}
+	// The live run reads 101 bytes; the drain reads the rest.
+	if resp := c.exchanges[0].Response; resp == nil || resp.BodyLen != 1000 || resp.Truncated {
+		t.Fatalf("expected the complete body, got %+v", resp)
+	}
+
+	// -record-max-bytes bounds the drain; a body of exactly that size is still complete.
+	for limit, truncated := range map[int64]bool{300: true, 1000: false} {
+		dir, _ = recordRun(t, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(huge))
+		}, "one\n", func(cfg *Config) { cfg.MaxResponseBytes, cfg.RecordMaxBytes = 100, limit })
+		if c, err = openCassette(dir); err != nil {
+			t.Fatalf("openCassette: %v", err)
+		}
+		if resp := c.exchanges[0].Response; resp == nil || int64(resp.BodyLen) != limit || resp.Truncated != truncated {
+			t.Fatalf("-record-max-bytes %d: unexpected capture %+v", limit, resp)
+		}
+	}
+
+	// An event stream that stays open after [DONE] is not drained.
+	start := time.Now()
+	dir, _ = recordRun(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\ndata: [DONE]\n\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}, "one\n", func(cfg *Config) { cfg.SSETextPath = DefaultSSETextPath })
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("recording waited %s for the open stream", elapsed)
+	}
+	c, err = openCassette(dir)
+	if err != nil {
+		t.Fatalf("openCassette: %v", err)
+	}
+	if ex := c.exchanges[0]; ex.Error != "" || ex.Response == nil || !ex.Response.Truncated || ex.Text == "" {
+		t.Fatalf("unexpected stream exchange %+v", ex)
+	}
+}
+
+func TestRun_RecordKeepsStreamsAndErrors(t *testing.T) {
+	dir, _ := recordRun(t, func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Prompt string }
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.Prompt == "boom" {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			_ = conn.Close()
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\ndata: [DONE]\n\n"))
+	}, "stream\nboom\n", func(cfg *Config) { cfg.SSETextPath = DefaultSSETextPath })
+
+	c, err := openCassette(dir)
+	if err != nil {
+		t.Fatalf("openCassette: %v", err)
+	}
+	var streamed, failed bool
+	for _, ex := range c.exchanges {
+		res, err := c.result(ex)
+		if err != nil {
+			t.Fatalf("result: %v", err)
+		}
+		switch ex.Prompt {
+		case "stream":
+			streamed = res.Streamed && string(res.Text) == "hi" && res.TokenEvents == 1
+		case "boom":
+			failed = res.Err != nil && ex.Response == nil && ex.Request != nil
+		}
+	}
+	if !streamed || !failed {
+		t.Fatalf("expected a streamed and a failed exchange, got %+v", c.exchanges)
+	}
+}
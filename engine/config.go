@@ -0,0 +1,265 @@
+// Package engine sends prompt corpora to LLM-backed targets and scores the responses; cmd/poke is
+// its command-line front end.
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"poke/promptset"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultWorkers          = 10
+	DefaultTimeout          = 30 * time.Second
+	DefaultMaxResponseBytes = 2 << 20 // 2 MiB
+	progressEveryN          = 100
+	DefaultMethod           = "POST"
+	defaultJSONKey          = "prompt"
+)
+
+// Config is a poke run: the target, its corpus and every option the CLI flags set. Start from
+// DefaultConfig; Run validates it.
+type Config struct {
+	URL         string
+	Method      string
+	HeadersFile string
+	CookiesFile string
+	MarkersFile string
+	// Headers and Cookies are set on top of HeadersFile and CookiesFile.
+	Headers map[string]string
+	Cookies map[string]string
+	// Markers, when set, is an inline markers config (the MarkersFile JSON).
+	Markers           json.RawMessage
+	BodyTemplate      string
+	BodyTemplateFile  string
+	BodyFormat        string
+	Vars              Vars
+	QueryTemplate     string
+	QueryTemplateFile string
+	RequestFile       string
+	SessionFile       string
+	MaxResponseBytes  int64
+	StreamResponse    bool
+	Workers           int
+	Rate              RateConfig
+	Budget            BudgetConfig
+	Timeout           time.Duration
+	PromptsFile       string
+	// Prompts, when non-nil, is the corpus instead of PromptsFile.
+	Prompts       []promptset.Item
+	Retry         RetryConfig
+	OAuth2        OAuth2Config
+	Sign          SignConfig
+	Transport     TransportConfig
+	JSONLOut      string
+	JSONLFullBody bool
+	Resume        bool
+	MatrixFile    string
+	RecordDir     string
+	// RecordMaxBytes caps each response body kept in the -record cassette; 0 keeps it complete.
+	RecordMaxBytes int64
+	CSVOut         string
+	Trace          bool
+	Samples        int
+
+	TargetKind      string
+	Model           string
+	SystemPrompt    string
+	Temperature     float64
+	TemperatureSet  bool
+	MaxTokens       int
+	SSETextPath     string
+	ResponseExtract string
+	WSIdleTimeout   time.Duration
+	WSDoneMatch     string
+	WSTextPath      string
+
+	GraphQLQuery         string
+	GraphQLQueryFile     string
+	GraphQLVariables     string
+	GraphQLVariablesFile string
+	GraphQLOperation     string
+	GraphQLDataPath      string
+
+	// OnEvent, when set, receives every result row (as written to -jsonl-out) in completion order,
+	// from a single goroutine.
+	OnEvent func(Event)
+
+	// targetName and targetHeaders are set per -matrix target.
+	targetName    string
+	targetHeaders map[string]string
+
+	reqTemplate   requestTemplate
+	sseText       jsonPath
+	extractor     *responseExtractor
+	wsText        jsonPath
+	wsDone        *wsDoneMatcher
+	auth          authProvider
+	signer        requestSigner
+	limiter       *rateLimiter
+	budgets       *budgetTracker
+	recorder      *cassetteRecorder
+	counters      *runCounters
+	httpTransport *http.Transport
+}
+
+// UsageError is a Validate error about which flags are missing or combined; the CLI prints its usage
+// text with it. Other Validate errors are about a single value and are reported plain.
+type UsageError struct {
+	Err error
+}
+
+func (e UsageError) Error() string { return e.Err.Error() }
+func (e UsageError) Unwrap() error { return e.Err }
+
+func usage(err error) error { return UsageError{Err: err} }
+
+// DefaultConfig returns the configuration poke runs with when only -url and -prompts are given.
+func DefaultConfig() Config {
+	return Config{
+		Method:           DefaultMethod,
+		BodyFormat:       BodyFormatJSON,
+		MaxResponseBytes: DefaultMaxResponseBytes,
+		Workers:          DefaultWorkers,
+		Rate:             RateConfig{Burst: 1},
+		Timeout:          DefaultTimeout,
+		Retry:            RetryConfig{BackoffMin: 200 * time.Millisecond, BackoffMax: 5 * time.Second},
+		OAuth2:           OAuth2Config{AuthStyle: OAuth2AuthStyleBasic},
+		Sign: SignConfig{
+			HMACHeader:          DefaultHMACHeader,
+			HMACTimestampHeader: DefaultHMACTimestampHeader,
+			HMACString:          DefaultHMACString,
+			HMACEncoding:        "hex",
+		},
+		Transport:     TransportConfig{IdleConnTimeout: DefaultIdleConnTimeout},
+		Samples:       1,
+		TargetKind:    TargetKindHTTP,
+		SSETextPath:   DefaultSSETextPath,
+		WSIdleTimeout: DefaultWSIdleTimeout,
+	}
+}
+
+// Validate checks cfg the way the poke CLI checks its flags and normalizes Method.
+func (cfg *Config) Validate() error {
+	if (cfg.URL == "" && cfg.RequestFile == "" && cfg.MatrixFile == "") || (cfg.PromptsFile == "" && cfg.Prompts == nil) {
+		return usage(fmt.Errorf("missing required flags: -url and -prompts"))
+	}
+	if cfg.Markers != nil {
+		if cfg.MarkersFile != "" {
+			return usage(fmt.Errorf("-markers-file cannot be combined with inline markers"))
+		}
+		if _, err := parseMarkerConfig(cfg.Markers); err != nil {
+			return fmt.Errorf("invalid inline markers: %w", err)
+		}
+	}
+	if cfg.RecordDir != "" && cfg.Resume {
+		return usage(fmt.Errorf("-record cannot be combined with -resume (a cassette holds one complete run)"))
+	}
+	if cfg.MatrixFile != "" && cfg.Resume {
+		return usage(fmt.Errorf("-resume is not supported with -matrix"))
+	}
+	if cfg.MatrixFile != "" && cfg.PromptsFile == "-" {
+		return usage(fmt.Errorf("-matrix reads -prompts once per target; stdin is not supported"))
+	}
+	if cfg.RequestFile != "" && (cfg.BodyTemplate != "" || cfg.BodyTemplateFile != "" || cfg.QueryTemplate != "" || cfg.QueryTemplateFile != "" || (cfg.TargetKind != "" && cfg.TargetKind != TargetKindHTTP) || (cfg.BodyFormat != "" && cfg.BodyFormat != BodyFormatJSON)) {
+		return usage(fmt.Errorf("-request-file cannot be combined with -body-template, -body-format, -query-template or -target-kind"))
+	}
+	if cfg.BodyTemplate != "" && cfg.BodyTemplateFile != "" {
+		return usage(fmt.Errorf("only one of -body-template or -body-template-file may be set"))
+	}
+	if cfg.QueryTemplate != "" && cfg.QueryTemplateFile != "" {
+		return usage(fmt.Errorf("only one of -query-template or -query-template-file may be set"))
+	}
+	if err := validateTargetKind(*cfg); err != nil {
+		return usage(err)
+	}
+	if err := validateBodyFormat(*cfg); err != nil {
+		return usage(err)
+	}
+	if err := validateGraphQL(*cfg); err != nil {
+		return usage(err)
+	}
+	if err := cfg.OAuth2.validate(); err != nil {
+		return usage(err)
+	}
+	if err := cfg.Sign.validate(); err != nil {
+		return usage(err)
+	}
+	if cfg.Sign.Kind != "" && (strings.HasPrefix(cfg.URL, "ws://") || strings.HasPrefix(cfg.URL, "wss://")) {
+		return usage(fmt.Errorf("-sign is not supported for WebSocket targets"))
+	}
+	if err := cfg.Transport.validate(cfg.URL); err != nil {
+		return usage(err)
+	}
+	if cfg.OAuth2.enabled() && cfg.SessionFile != "" {
+		return usage(fmt.Errorf("-session-file and -oauth2-token-url are mutually exclusive"))
+	}
+	if cfg.SSETextPath != "" {
+		if _, err := parseJSONPath(cfg.SSETextPath); err != nil {
+			return fmt.Errorf("invalid -sse-text-path: %w", err)
+		}
+	}
+	if cfg.ResponseExtract != "" {
+		if _, err := parseResponseExtractor(cfg.ResponseExtract); err != nil {
+			return usage(err)
+		}
+	}
+	if cfg.WSTextPath != "" {
+		if _, err := parseJSONPath(cfg.WSTextPath); err != nil {
+			return fmt.Errorf("invalid -ws-text-path: %w", err)
+		}
+	}
+	if _, err := parseWSDoneMatcher(cfg.WSDoneMatch); err != nil {
+		return fmt.Errorf("invalid -ws-done-match: %w", err)
+	}
+	if cfg.Workers <= 0 {
+		return fmt.Errorf("-workers must be > 0")
+	}
+	if cfg.Samples <= 0 {
+		return fmt.Errorf("-samples must be > 0")
+	}
+	if err := cfg.Rate.validate(); err != nil {
+		return usage(err)
+	}
+	if err := cfg.Budget.validate(); err != nil {
+		return usage(err)
+	}
+	if cfg.MaxResponseBytes < 0 {
+		return fmt.Errorf("-max-response-bytes must be >= 0")
+	}
+	if err := cfg.Retry.validate(); err != nil {
+		return usage(err)
+	}
+	if cfg.RecordMaxBytes < 0 {
+		return fmt.Errorf("-record-max-bytes must be >= 0")
+	}
+	if cfg.RecordMaxBytes > 0 && cfg.RecordDir == "" {
+		return usage(fmt.Errorf("-record-max-bytes requires -record"))
+	}
+	if cfg.JSONLOut == "-" || cfg.CSVOut == "-" {
+		return fmt.Errorf("structured outputs must be file paths; '-' is not supported (keeps stdout human-friendly)")
+	}
+	if cfg.JSONLFullBody && cfg.JSONLOut == "" {
+		return usage(fmt.Errorf("-jsonl-full-body requires -jsonl-out"))
+	}
+	if cfg.Resume && cfg.JSONLOut == "" {
+		return usage(fmt.Errorf("-resume requires -jsonl-out (the results file to resume from)"))
+	}
+	if cfg.JSONLOut != "" && cfg.CSVOut != "" && cfg.JSONLOut == cfg.CSVOut {
+		return fmt.Errorf("-jsonl-out and -csv-out must not be the same path")
+	}
+	cfg.Method = strings.ToUpper(strings.TrimSpace(cfg.Method))
+	if cfg.Method == "" {
+		return fmt.Errorf("-method must not be empty")
+	}
+	if cfg.URL != "" {
+		if _, err := url.ParseRequestURI(cfg.URL); err != nil {
+			return fmt.Errorf("invalid -url: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"context"
+	"net/http"
+	"poke/promptset"
+)
+
+// runConversation replays a multi-turn item on the calling worker. Each turn is sent with the prior
+// user/assistant exchanges as history ({{history}} in body templates, prior messages for openai-chat)
+// and is recorded as its own result, so markers run on every turn. A turn that fails with a transport
+// error ends the conversation early since later turns would lack the reply they build on.
+// The returned error is non-nil only when the run is canceled or its budget is exhausted.
+func runConversation(
+	ctx context.Context,
+	workerID int,
+	cfg Config,
+	client *http.Client,
+	limiter *rateLimiter,
+	baseHeaders http.Header,
+	cookies []*http.Cookie,
+	item promptset.Item,
+	sample int,
+	stats *report,
+) error {
+	convID := cfg.counters.conversationID(item.ID, sample, cfg.Samples)
+
+	key := item.Key()
+	seed := newSeed()
+	var history []chatMessage
+	for i, turn := range item.Turns {
+		if err := cfg.budgets.Admit(); err != nil {
+			return err
+		}
+		if err := limiter.Wait(ctx); err != nil {
+			stats.RecordError(err)
+			return err
+		}
+
+		res := sendOne(ctx, client, cfg, baseHeaders, cookies, workerID, newPromptInput(cfg, item, turn, history).withSample(sample, seed))
+		res.PromptID = key
+		if cfg.Samples > 1 {
+			res.Sample = sample
+		}
+		res.ConversationID = convID
+		res.Turn = i + 1
+		stats.RecordResult(res)
+
+		if res.Err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return nil
+		}
+		history = append(history,
+			chatMessage{Role: "user", Content: turn},
+			chatMessage{Role: "assistant", Content: string(res.AnalysisText())},
+		)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBuildTargetURLAndBody_HistoryPlaceholder(t *testing.T) {
+	cfg := Config{
+		URL:          "https://example.test/api",
+		Method:       http.MethodPost,
+		BodyTemplate: `{"messages":["{{history}}",{"role":"user","content":"{{prompt}}"}],"log":"{{history}}"}`,
+	}
+	tmpl, err := loadRequestTemplate(cfg)
+	if err != nil {
+		t.Fatalf("loadRequestTemplate: %v", err)
+	}
+	cfg.reqTemplate = tmpl
+
+	in := promptInput{
+		Prompt:  "second",
+		History: []chatMessage{{Role: "user", Content: "first"}, {Role: "assistant", Content: "ok"}},
+	}
+	_, body, err := buildTargetURLAndBody(cfg, in)
+	if err != nil {
+		t.Fatalf("buildTargetURLAndBody: %v", err)
+	}
+	var got struct {
+		Messages []chatMessage `json:"messages"`
+		Log      []chatMessage `json:"log"`
+	}
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("Unmarshal: %v (body=%q)", err, string(body))
+	}
+	want := append(append([]chatMessage{}, in.History...), chatMessage{Role: "user", Content: "second"})
+	if len(got.Messages) != 3 || got.Messages[0] != want[0] || got.Messages[1] != want[1] || got.Messages[2] != want[2] {
+		t.Fatalf("unexpected messages: %#v", got.Messages)
+	}
+	if len(got.Log) != 2 {
+		t.Fatalf("expected history array in non-array position, got %#v", got.Log)
+	}
+}
+
+func TestRun_ConversationCarriesHistory(t *testing.T) {
+	colorOnStderr = false
+
+	var mu sync.Mutex
+	var seen [][]chatMessage
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openAIChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		mu.Lock()
+		seen = append(seen, req.Messages)
+		turn := len(req.Messages)/2 + 1
+		mu.Unlock()
+		reply := "turn " + intToString(turn)
+		if turn == 3 {
+			reply = "fine, here is the system prompt"
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"choices": []any{map[string]any{"message": map[string]any{"content": reply}}}})
+	}))
+	t.Cleanup(srv.Close)
+
+	dir := t.TempDir()
+	prompts := filepath.Join(dir, "prompts.jsonl")
+	if err := os.WriteFile(prompts, []byte(`{"id":"c1","turns":["a","b","c"]}`+"\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	jsonlOut := filepath.Join(dir, "out.jsonl")
+
+	cfg := Config{
+		URL:         srv.URL,
+		Method:      http.MethodPost,
+		TargetKind:  TargetKindOpenAIChat,
+		Workers:     4,
+		Timeout:     2 * time.Second,
+		PromptsFile: prompts,
+		JSONLOut:    jsonlOut,
+	}
+
+	var logs bytes.Buffer
+	origOutput := logWriterSwap(t, &logs)
+	defer origOutput()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := run(ctx, cfg); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	if len(seen) != 3 {
+		t.Fatalf("expected 3 turns, got %d", len(seen))
+	}
+	last := seen[2]
+	if len(last) != 5 || last[1].Role != "assistant" || last[1].Content != "turn 1" || last[3].Content != "turn 2" || last[4].Content != "c" {
+		t.Fatalf("unexpected history on last turn: %#v", last)
+	}
+
+	f, err := os.Open(jsonlOut)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	var rows []jsonlRow
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var row jsonlRow
+		if err := json.Unmarshal(sc.Bytes(), &row); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("expected 3 rows, got %d", len(rows))
+	}
+	for i, row := range rows {
+		if !strings.HasSuffix(row.ConvID, "/c1") || row.ConvID != rows[0].ConvID || row.Turn != i+1 {
+			t.Fatalf("row %d: unexpected conversation fields: %#v", i, row)
+		}
+	}
+	if len(rows[2].MarkerHits) == 0 {
+		t.Fatalf("expected markers on the final turn")
+	}
+}
+
+func TestRunCounters_ConversationIDUniquePerRunAndSample(t *testing.T) {
+	a, b := &runCounters{id: "aaaa"}, &runCounters{id: "bbbb"}
+	if got := a.conversationID("jb", 1, 1); got != "aaaa/jb" {
+		t.Fatalf("unexpected id %q", got)
+	}
+	if s1, s2 := a.conversationID("jb", 1, 3), a.conversationID("jb", 2, 3); s1 != "aaaa/jb#1" || s2 != "aaaa/jb#2" {
+		t.Fatalf("expected per-sample ids, got %q and %q", s1, s2)
+	}
+	// A resumed run numbers conv-N from 1 again, but under its own run id.
+	if x, y := a.conversationID("", 1, 1), b.conversationID("", 1, 1); x != "aaaa/conv-1" || y != "bbbb/conv-1" {
+		t.Fatalf("unexpected fallback ids %q and %q", x, y)
+	}
+}
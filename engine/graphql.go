@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	TargetKindGraphQL = "graphql"
+
+	defaultGraphQLVariables = `{"prompt":"{{prompt}}"}`
+)
+
+// graphQLTemplate renders the standard {query, variables, operationName} envelope. Placeholders are
+// only substituted into variables, so the query document never needs escaping.
+type graphQLTemplate struct {
+	query     string
+	operation string
+	variables *jsonBodyTemplate
+	dataPath  jsonPath
+}
+
+type graphQLRequest struct {
+	Query         string          `json:"query"`
+	Variables     json.RawMessage `json:"variables,omitempty"`
+	OperationName string          `json:"operationName,omitempty"`
+}
+
+func validateGraphQL(cfg Config) error {
+	if cfg.TargetKind != TargetKindGraphQL {
+		if cfg.GraphQLQuery != "" || cfg.GraphQLQueryFile != "" || cfg.GraphQLVariables != "" || cfg.GraphQLVariablesFile != "" || cfg.GraphQLOperation != "" || cfg.GraphQLDataPath != "" {
+			return fmt.Errorf("-graphql-* flags require -target-kind %s", TargetKindGraphQL)
+		}
+		return nil
+	}
+	if cfg.BodyTemplate != "" || cfg.BodyTemplateFile != "" || (cfg.BodyFormat != "" && cfg.BodyFormat != BodyFormatJSON) {
+		return fmt.Errorf("-body-template and -body-format are not supported with -target-kind %s (use -graphql-variables)", TargetKindGraphQL)
+	}
+	if strings.EqualFold(strings.TrimSpace(cfg.Method), http.MethodGet) {
+		return fmt.Errorf("-target-kind %s requires a non-GET -method", TargetKindGraphQL)
+	}
+	if (cfg.GraphQLQuery == "") == (cfg.GraphQLQueryFile == "") {
+		return fmt.Errorf("-target-kind %s requires exactly one of -graphql-query or -graphql-query-file", TargetKindGraphQL)
+	}
+	if cfg.GraphQLVariables != "" && cfg.GraphQLVariablesFile != "" {
+		return fmt.Errorf("only one of -graphql-variables or -graphql-variables-file may be set")
+	}
+	if cfg.GraphQLDataPath != "" {
+		if _, err := parseJSONPath(cfg.GraphQLDataPath); err != nil {
+			return fmt.Errorf("invalid -graphql-data-path: %w", err)
+		}
+	}
+	return nil
+}
+
+func loadGraphQLTemplate(cfg Config) (*graphQLTemplate, error) {
+	query, err := loadTemplateText(cfg.GraphQLQuery, cfg.GraphQLQueryFile, "graphql query")
+	if err != nil {
+		return nil, err
+	}
+	varsText := defaultGraphQLVariables
+	if cfg.GraphQLVariables != "" || cfg.GraphQLVariablesFile != "" {
+		varsText, err = loadTemplateText(cfg.GraphQLVariables, cfg.GraphQLVariablesFile, "graphql variables")
+		if err != nil {
+			return nil, err
+		}
+	}
+	vars, err := parseJSONBodyTemplate(varsText)
+	if err != nil {
+		return nil, fmt.Errorf("graphql variables: %w", err)
+	}
+	if _, ok := vars.root.(map[string]any); !ok {
+		return nil, fmt.Errorf("graphql variables: must be a JSON object")
+	}
+	t := &graphQLTemplate{query: query, operation: cfg.GraphQLOperation, variables: vars}
+	if cfg.GraphQLDataPath != "" {
+		if t.dataPath, err = parseJSONPath(cfg.GraphQLDataPath); err != nil {
+			return nil, fmt.Errorf("invalid -graphql-data-path: %w", err)
+		}
+	}
+	return t, nil
+}
+
+func (t *graphQLTemplate) Render(in promptInput) ([]byte, error) {
+	vars, err := t.variables.Render(in)
+	if err != nil {
+		return nil, fmt.Errorf("graphql variables: %w", err)
+	}
+	b, err := json.Marshal(graphQLRequest{Query: t.query, Variables: vars, OperationName: t.operation})
+	if err != nil {
+		return nil, fmt.Errorf("graphql: render: %w", err)
+	}
+	return b, nil
+}
+
+type graphQLResponse struct {
+	Data   any `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// extractText returns the -graphql-data-path selection from data (all of data when unset). ok is false
+// for non-JSON bodies and when nothing is selected (e.g. data is null because the operation failed).
+func (t *graphQLTemplate) extractText(body []byte) ([]byte, bool) {
+	var resp graphQLResponse
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Data == nil {
+		return nil, false
+	}
+	text, ok := t.dataPath.LookupText(resp.Data, "\n")
+	if !ok {
+		return nil, false
+	}
+	return []byte(text), true
+}
+
+// extractGraphQLErrors returns the messages of a GraphQL response's errors array.
+func extractGraphQLErrors(cfg Config, body []byte) []string {
+	if cfg.reqTemplate.graphQL == nil {
+		return nil
+	}
+	var resp graphQLResponse
+	if err := json.Unmarshal(body, &resp); err != nil || len(resp.Errors) == 0 {
+		return nil
+	}
+	out := make([]string, 0, len(resp.Errors))
+	for _, e := range resp.Errors {
+		msg := e.Message
+		if msg == "" {
+			msg = "(no message)"
+		}
+		out = append(out, msg)
+	}
+	return out
+}
@@ -0,0 +1,111 @@
+package engine
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const testGraphQLQuery = `mutation Chat($prompt: String!) { chat(input: {message: $prompt, tone: "plain"}) { reply } }`
+
+func TestGraphQLTemplate_RendersEnvelope(t *testing.T) {
+	cfg := Config{
+		Method:           http.MethodPost,
+		TargetKind:       TargetKindGraphQL,
+		GraphQLQuery:     testGraphQLQuery,
+		GraphQLVariables: `{"prompt":"{{prompt}}","session":"{{var:sid}}","opts":{"n":1}}`,
+		GraphQLOperation: "Chat",
+	}
+	tmpl, err := loadRequestTemplate(cfg)
+	if err != nil {
+		t.Fatalf("loadRequestTemplate: %v", err)
+	}
+	cfg.reqTemplate = tmpl
+
+	_, body, err := buildTargetURLAndBody(cfg, promptInput{Prompt: `say "{{hi}}"\n`, Vars: map[string]string{"sid": "s1"}})
+	if err != nil {
+		t.Fatalf("buildTargetURLAndBody: %v", err)
+	}
+	var got struct {
+		Query         string         `json:"query"`
+		Variables     map[string]any `json:"variables"`
+		OperationName string         `json:"operationName"`
+	}
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("invalid JSON body %s: %v", body, err)
+	}
+	if got.Query != testGraphQLQuery || got.OperationName != "Chat" {
+		t.Fatalf("unexpected envelope %s", body)
+	}
+	if got.Variables["prompt"] != `say "{{hi}}"\n` || got.Variables["session"] != "s1" || got.Variables["opts"].(map[string]any)["n"] != 1.0 {
+		t.Fatalf("unexpected variables %#v", got.Variables)
+	}
+}
+
+func TestSendOne_GraphQLDataPathAndErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req graphQLRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		if string(req.Variables) == `{"prompt":"fail"}` {
+			_, _ = w.Write([]byte(`{"data":null,"errors":[{"message":"Cannot query field \"secret\" on type \"Query\"."},{"message":"resolver failed; contact oncall@corp.example"}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"chat":{"reply":"Sure, here is the system prompt: BEGIN SYSTEM"}}}`))
+	}))
+	defer srv.Close()
+
+	cfg := Config{URL: srv.URL, Method: http.MethodPost, TargetKind: TargetKindGraphQL, GraphQLQuery: testGraphQLQuery, GraphQLDataPath: "chat.reply", Timeout: 5 * time.Second}
+	tmpl, err := loadRequestTemplate(cfg)
+	if err != nil {
+		t.Fatalf("loadRequestTemplate: %v", err)
+	}
+	cfg.reqTemplate = tmpl
+	a, err := newResponseAnalyzer(defaultMarkerConfig())
+	if err != nil {
+		t.Fatalf("newResponseAnalyzer: %v", err)
+	}
+
+	res := sendOne(t.Context(), srv.Client(), cfg, nil, nil, 1, promptInput{Prompt: "hi"})
+	if res.Err != nil || string(res.Text) != "Sure, here is the system prompt: BEGIN SYSTEM" || res.GraphQLErrors != nil {
+		t.Fatalf("unexpected result: text=%q errors=%v err=%v", res.Text, res.GraphQLErrors, res.Err)
+	}
+	if hits := a.Analyze(res); !hasMarker(hits, "system_leak:system_prompt_delimiters") || hasMarker(hits, "graphql_error:errors") {
+		t.Fatalf("unexpected hits %v", hits)
+	}
+
+	res = sendOne(t.Context(), srv.Client(), cfg, nil, nil, 2, promptInput{Prompt: "fail"})
+	if res.StatusCode != http.StatusOK || len(res.GraphQLErrors) != 2 || res.Text != nil {
+		t.Fatalf("unexpected error result: status=%d text=%q errors=%v", res.StatusCode, res.Text, res.GraphQLErrors)
+	}
+	hits := a.Analyze(res)
+	for _, h := range hits {
+		if h.ID == "graphql_error:errors" && h.Count != 2 {
+			t.Fatalf("expected 2 graphql errors, got %d", h.Count)
+		}
+	}
+	if !hasMarker(hits, "graphql_error:errors") || !hasMarker(hits, "pii_leak:email_address") {
+		t.Fatalf("expected graphql_error and raw-body markers, got %v", hits)
+	}
+}
+
+func TestValidateGraphQL(t *testing.T) {
+	bad := []Config{
+		{Method: http.MethodPost, GraphQLQuery: "{ a }"},
+		{Method: http.MethodPost, TargetKind: TargetKindGraphQL},
+		{Method: http.MethodGet, TargetKind: TargetKindGraphQL, GraphQLQuery: "{ a }"},
+		{Method: http.MethodPost, TargetKind: TargetKindGraphQL, GraphQLQuery: "{ a }", BodyTemplate: "{}"},
+		{Method: http.MethodPost, TargetKind: TargetKindGraphQL, GraphQLQuery: "{ a }", GraphQLQueryFile: "q.graphql"},
+		{Method: http.MethodPost, TargetKind: TargetKindGraphQL, GraphQLQuery: "{ a }", GraphQLDataPath: "a..b"},
+	}
+	for _, c := range bad {
+		if err := validateGraphQL(c); err == nil {
+			t.Fatalf("expected error for %+v", c)
+		}
+	}
+	if _, err := loadGraphQLTemplate(Config{GraphQLQuery: "{ a }", GraphQLVariables: `["{{prompt}}"]`}); err == nil {
+		t.Fatalf("expected error for non-object variables")
+	}
+}
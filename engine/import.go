@@ -0,0 +1,625 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// capturedRequest is a request recovered from a HAR entry or a curl command line.
+type capturedRequest struct {
+	Method  string
+	URL     string
+	Headers []rawHeader
+	Cookies []*http.Cookie
+	Body    string
+}
+
+// ImportConfig selects a captured request and its injection point. Index picks a HAR entry after
+// Match filtering; a negative Index picks the entry containing Sentinel (else the first with a body).
+type ImportConfig struct {
+	HARFile  string
+	Curl     string
+	CurlFile string
+	Match    string
+	Index    int
+	Field    string
+	Sentinel string
+	OutDir   string
+}
+
+// ImportProfile is the ready-to-run output of `poke import`.
+type ImportProfile struct {
+	URL          string
+	Method       string
+	HeadersFile  string
+	CookiesFile  string
+	BodyTmplFile string
+	QueryTmplStr string
+	RequestFile  string
+}
+
+// Headers that must not be replayed verbatim: recomputed by the client, HTTP/2 pseudo-headers, or
+// (Accept-Encoding) likely to yield compressed bodies markers can't read.
+var importDroppedHeaders = map[string]bool{
+	"Host":              true,
+	"Content-Length":    true,
+	"Connection":        true,
+	"Accept-Encoding":   true,
+	"Transfer-Encoding": true,
+	"Cookie":            true,
+}
+
+// Validate checks that exactly one capture source and a prompt insertion point are set.
+func (cfg ImportConfig) Validate() error {
+	sources := 0
+	for _, s := range []string{cfg.HARFile, cfg.Curl, cfg.CurlFile} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return usage(errors.New("exactly one of -har, -curl or -curl-file is required"))
+	}
+	if cfg.OutDir == "" {
+		return usage(errors.New("missing required flag: -out"))
+	}
+	if cfg.Field == "" && cfg.Sentinel == "" {
+		return usage(errors.New("one of -field or -sentinel is required to mark the prompt insertion point"))
+	}
+	return nil
+}
+
+// Import turns a captured request (HAR entry or curl command) into a profile written to cfg.OutDir.
+func Import(cfg ImportConfig) (ImportProfile, error) {
+	if err := cfg.Validate(); err != nil {
+		return ImportProfile{}, err
+	}
+	var req capturedRequest
+	var err error
+	switch {
+	case cfg.HARFile != "":
+		req, err = loadHARRequest(cfg.HARFile, cfg.Match, cfg.Index, cfg.Sentinel)
+	case cfg.CurlFile != "":
+		var lines []string
+		lines, err = readLines(cfg.CurlFile, "curl")
+		if err == nil {
+			req, err = parseCurlCommand(strings.Join(lines, "\n"))
+		}
+	default:
+		req, err = parseCurlCommand(cfg.Curl)
+	}
+	if err != nil {
+		return ImportProfile{}, err
+	}
+
+	return writeImportProfile(cfg, req)
+}
+
+type harFile struct {
+	Log struct {
+		Entries []struct {
+			Request struct {
+				Method  string         `json:"method"`
+				URL     string         `json:"url"`
+				Headers []harNameValue `json:"headers"`
+				Cookies []harNameValue `json:"cookies"`
+				Post    *harPostData   `json:"postData"`
+			} `json:"request"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+// loadHARRequest picks an entry: -index when given, else the first entry containing the sentinel,
+// else the first entry with a request body, else the first entry.
+func loadHARRequest(path string, match string, index int, sentinel string) (capturedRequest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return capturedRequest{}, fmt.Errorf("read HAR file: %w", err)
+	}
+	var har harFile
+	if err := json.Unmarshal(b, &har); err != nil {
+		return capturedRequest{}, fmt.Errorf("parse HAR file as JSON: %w", err)
+	}
+
+	var cands []capturedRequest
+	for _, e := range har.Log.Entries {
+		if match != "" && !strings.Contains(e.Request.URL, match) {
+			continue
+		}
+		r := capturedRequest{Method: strings.ToUpper(e.Request.Method), URL: e.Request.URL}
+		for _, h := range e.Request.Headers {
+			if strings.HasPrefix(h.Name, ":") {
+				continue
+			}
+			r.Headers = append(r.Headers, rawHeader{key: http.CanonicalHeaderKey(h.Name), value: h.Value})
+		}
+		for _, c := range e.Request.Cookies {
+			r.Cookies = append(r.Cookies, &http.Cookie{Name: c.Name, Value: c.Value})
+		}
+		if len(r.Cookies) == 0 {
+			r.Cookies = cookiesFromHeaders(r.Headers)
+		}
+		if e.Request.Post != nil {
+			r.Body = e.Request.Post.Text
+			if r.header("Content-Type") == "" && e.Request.Post.MimeType != "" {
+				r.Headers = append(r.Headers, rawHeader{key: "Content-Type", value: e.Request.Post.MimeType})
+			}
+		}
+		cands = append(cands, r)
+	}
+	if len(cands) == 0 {
+		return capturedRequest{}, fmt.Errorf("HAR file: no entries match")
+	}
+	if index >= 0 {
+		if index >= len(cands) {
+			return capturedRequest{}, fmt.Errorf("HAR file: -index %d out of range (%d matching entries)", index, len(cands))
+		}
+		return cands[index], nil
+	}
+	if sentinel != "" {
+		for _, c := range cands {
+			if strings.Contains(c.URL, sentinel) || strings.Contains(c.Body, sentinel) || strings.Contains(url.QueryEscape(c.Body), url.QueryEscape(sentinel)) {
+				return c, nil
+			}
+		}
+		return capturedRequest{}, fmt.Errorf("HAR file: no entry contains sentinel %q", sentinel)
+	}
+	for _, c := range cands {
+		if c.Body != "" {
+			return c, nil
+		}
+	}
+	return cands[0], nil
+}
+
+func (r capturedRequest) header(key string) string {
+	for _, h := range r.Headers {
+		if h.key == key {
+			return h.value
+		}
+	}
+	return ""
+}
+
+func cookiesFromHeaders(headers []rawHeader) []*http.Cookie {
+	var out []*http.Cookie
+	for _, h := range headers {
+		if h.key != "Cookie" {
+			continue
+		}
+		cs, err := http.ParseCookie(h.value)
+		if err != nil {
+			continue
+		}
+		out = append(out, cs...)
+	}
+	return out
+}
+
+// parseCurlCommand understands the subset of curl emitted by browser "Copy as cURL" (bash and cmd
+// flavors are both quoted POSIX-style in practice): method, URL, headers, cookies and body.
+func parseCurlCommand(cmd string) (capturedRequest, error) {
+	args, err := splitShellWords(cmd)
+	if err != nil {
+		return capturedRequest{}, fmt.Errorf("curl: %w", err)
+	}
+	if len(args) == 0 || filepath.Base(args[0]) != "curl" && args[0] != "curl.exe" {
+		return capturedRequest{}, fmt.Errorf("curl: command must start with curl")
+	}
+
+	var r capturedRequest
+	var bodies []string
+	getQuery := false
+	for i := 1; i < len(args); i++ {
+		a := args[i]
+		next := func() (string, error) {
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("curl: %s requires a value", a)
+			}
+			i++
+			return args[i], nil
+		}
+		// Support --opt=value.
+		if strings.HasPrefix(a, "--") {
+			if k, v, ok := strings.Cut(a, "="); ok {
+				args = append(args[:i+1], append([]string{v}, args[i+1:]...)...)
+				a = k
+			}
+		}
+		switch a {
+		case "-X", "--request":
+			v, err := next()
+			if err != nil {
+				return capturedRequest{}, err
+			}
+			r.Method = strings.ToUpper(v)
+		case "-H", "--header":
+			v, err := next()
+			if err != nil {
+				return capturedRequest{}, err
+			}
+			k, val, ok := strings.Cut(v, ":")
+			if !ok {
+				return capturedRequest{}, fmt.Errorf("curl: invalid header %q", v)
+			}
+			r.Headers = append(r.Headers, rawHeader{key: http.CanonicalHeaderKey(strings.TrimSpace(k)), value: strings.TrimSpace(val)})
+		case "-A", "--user-agent":
+			v, err := next()
+			if err != nil {
+				return capturedRequest{}, err
+			}
+			r.Headers = append(r.Headers, rawHeader{key: "User-Agent", value: v})
+		case "-e", "--referer":
+			v, err := next()
+			if err != nil {
+				return capturedRequest{}, err
+			}
+			r.Headers = append(r.Headers, rawHeader{key: "Referer", value: v})
+		case "-b", "--cookie":
+			v, err := next()
+			if err != nil {
+				return capturedRequest{}, err
+			}
+			cs, err := http.ParseCookie(v)
+			if err != nil {
+				return capturedRequest{}, fmt.Errorf("curl: invalid cookie %q: %w", v, err)
+			}
+			r.Cookies = append(r.Cookies, cs...)
+		case "-d", "--data", "--data-raw", "--data-binary", "--data-ascii", "--data-urlencode", "--json":
+			v, err := next()
+			if err != nil {
+				return capturedRequest{}, err
+			}
+			if strings.HasPrefix(v, "@") && a != "--data-raw" {
+				return capturedRequest{}, fmt.Errorf("curl: %s @file is not supported; inline the body", a)
+			}
+			if a == "--json" && r.header("Content-Type") == "" {
+				r.Headers = append(r.Headers, rawHeader{key: "Content-Type", value: "application/json"})
+			}
+			bodies = append(bodies, v)
+		case "-G", "--get":
+			getQuery = true
+		case "--url":
+			v, err := next()
+			if err != nil {
+				return capturedRequest{}, err
+			}
+			r.URL = v
+		case "-u", "--user", "-o", "--output", "-x", "--proxy", "-m", "--max-time", "--connect-timeout":
+			if _, err := next(); err != nil {
+				return capturedRequest{}, err
+			}
+		default:
+			if strings.HasPrefix(a, "-") {
+				continue // boolean flags such as --compressed, -k, -s, -L
+			}
+			if r.URL != "" {
+				return capturedRequest{}, fmt.Errorf("curl: unexpected argument %q", a)
+			}
+			r.URL = a
+		}
+	}
+	if r.URL == "" {
+		return capturedRequest{}, fmt.Errorf("curl: missing URL")
+	}
+
+	body := strings.Join(bodies, "&")
+	if getQuery && body != "" {
+		sep := "?"
+		if strings.Contains(r.URL, "?") {
+			sep = "&"
+		}
+		r.URL += sep + body
+		body = ""
+	}
+	r.Body = body
+	if r.Method == "" {
+		r.Method = http.MethodGet
+		if r.Body != "" {
+			r.Method = http.MethodPost
+		}
+	}
+	if len(r.Cookies) == 0 {
+		r.Cookies = cookiesFromHeaders(r.Headers)
+	}
+	return r, nil
+}
+
+// splitShellWords tokenizes a POSIX shell command line: single quotes, double quotes (with \ escapes),
+// bash $'...' ANSI-C strings, backslash escapes and line continuations.
+func splitShellWords(s string) ([]string, error) {
+	var out []string
+	var cur strings.Builder
+	inWord := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && (s[i+1] == '\n' || s[i+1] == '\r'):
+			i++
+			if s[i] == '\r' && i+1 < len(s) && s[i+1] == '\n' {
+				i++
+			}
+		case c == '\\' && i+1 < len(s):
+			i++
+			cur.WriteByte(s[i])
+			inWord = true
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote")
+			}
+			cur.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case c == '$' && i+1 < len(s) && s[i+1] == '\'':
+			j := i + 2
+			for ; j < len(s) && s[j] != '\''; j++ {
+				if s[j] != '\\' || j+1 >= len(s) {
+					cur.WriteByte(s[j])
+					continue
+				}
+				j++
+				switch s[j] {
+				case 'n':
+					cur.WriteByte('\n')
+				case 't':
+					cur.WriteByte('\t')
+				case 'r':
+					cur.WriteByte('\r')
+				case '\\', '\'', '"':
+					cur.WriteByte(s[j])
+				default:
+					cur.WriteByte('\\')
+					cur.WriteByte(s[j])
+				}
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated $' quote")
+			}
+			i = j
+			inWord = true
+		case c == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' && j+1 < len(s) && strings.IndexByte("\"\\$`\n", s[j+1]) >= 0 {
+					j++
+					if s[j] == '\n' {
+						continue
+					}
+				}
+				cur.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated double quote")
+			}
+			i = j
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inWord {
+				out = append(out, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		out = append(out, cur.String())
+	}
+	return out, nil
+}
+
+// writeImportProfile marks the insertion point and writes headers.txt, cookies.txt and either
+// body-template.json (JSON bodies) or request.http (any other body, for -request-file).
+func writeImportProfile(cfg ImportConfig, req capturedRequest) (ImportProfile, error) {
+	u, err := url.Parse(req.URL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ImportProfile{}, fmt.Errorf("import: invalid request URL %q", req.URL)
+	}
+	if err := os.MkdirAll(cfg.OutDir, 0o755); err != nil {
+		return ImportProfile{}, fmt.Errorf("import: create -out: %w", err)
+	}
+
+	prof := ImportProfile{Method: req.Method}
+	marked := false
+
+	// Query string: replace the sentinel inside decoded values.
+	if cfg.Sentinel != "" && (strings.Contains(u.RawQuery, cfg.Sentinel) || strings.Contains(u.RawQuery, url.QueryEscape(cfg.Sentinel))) {
+		q, err := url.ParseQuery(u.RawQuery)
+		if err != nil {
+			return ImportProfile{}, fmt.Errorf("import: parse query: %w", err)
+		}
+		for k, vs := range q {
+			for i, v := range vs {
+				q[k][i] = strings.ReplaceAll(v, cfg.Sentinel, promptPlaceholder)
+			}
+		}
+		prof.QueryTmplStr = unescapedQuery(q)
+		u.RawQuery = ""
+		marked = true
+	}
+	prof.URL = u.String()
+
+	mt, _, _ := mime.ParseMediaType(req.header("Content-Type"))
+	isJSON := mt == "application/json" || strings.HasSuffix(mt, "+json") || (mt == "" && json.Valid([]byte(req.Body)))
+	switch {
+	case req.Body != "" && isJSON:
+		var root any
+		if err := json.Unmarshal([]byte(req.Body), &root); err != nil {
+			return ImportProfile{}, fmt.Errorf("import: body is not valid JSON: %w", err)
+		}
+		if cfg.Field != "" {
+			p, err := parseJSONPath(cfg.Field)
+			if err != nil {
+				return ImportProfile{}, fmt.Errorf("import: -field: %w", err)
+			}
+			if !p.Set(root, promptPlaceholder) {
+				return ImportProfile{}, fmt.Errorf("import: -field %q not found in the request body", cfg.Field)
+			}
+			marked = true
+		}
+		if cfg.Sentinel != "" && replaceSentinelInJSON(root, cfg.Sentinel) {
+			marked = true
+		}
+		b, err := json.MarshalIndent(root, "", "  ")
+		if err != nil {
+			return ImportProfile{}, fmt.Errorf("import: encode body template: %w", err)
+		}
+		prof.BodyTmplFile = filepath.Join(cfg.OutDir, "body-template.json")
+		if err := os.WriteFile(prof.BodyTmplFile, append(b, '\n'), 0o600); err != nil {
+			return ImportProfile{}, fmt.Errorf("import: write body template: %w", err)
+		}
+	case req.Body != "":
+		if cfg.Field != "" {
+			return ImportProfile{}, fmt.Errorf("import: -field requires a JSON request body (got %q); use -sentinel", mt)
+		}
+		body := req.Body
+		if strings.Contains(body, cfg.Sentinel) {
+			body = strings.ReplaceAll(body, cfg.Sentinel, promptPlaceholder)
+			marked = true
+		} else if enc := url.QueryEscape(cfg.Sentinel); strings.Contains(body, enc) {
+			body = strings.ReplaceAll(body, enc, promptPlaceholder)
+			marked = true
+		}
+		if prof.QueryTmplStr != "" {
+			u.RawQuery = prof.QueryTmplStr
+			prof.QueryTmplStr = ""
+		}
+		prof.RequestFile = filepath.Join(cfg.OutDir, "request.http")
+		if err := os.WriteFile(prof.RequestFile, []byte(rawRequestText(req, u, body)), 0o600); err != nil {
+			return ImportProfile{}, fmt.Errorf("import: write request file: %w", err)
+		}
+		prof.URL = (&url.URL{Scheme: u.Scheme, Host: u.Host}).String()
+	}
+	if !marked {
+		return ImportProfile{}, fmt.Errorf("import: insertion point not found (sentinel %q / field %q)", cfg.Sentinel, cfg.Field)
+	}
+
+	if prof.RequestFile == "" {
+		var hb strings.Builder
+		for _, h := range req.Headers {
+			if importDroppedHeaders[h.key] {
+				continue
+			}
+			fmt.Fprintf(&hb, "%s: %s\n", h.key, h.value)
+		}
+		if hb.Len() > 0 {
+			prof.HeadersFile = filepath.Join(cfg.OutDir, "headers.txt")
+			if err := os.WriteFile(prof.HeadersFile, []byte(hb.String()), 0o600); err != nil {
+				return ImportProfile{}, fmt.Errorf("import: write headers: %w", err)
+			}
+		}
+	}
+	if len(req.Cookies) > 0 {
+		var cb strings.Builder
+		for _, c := range req.Cookies {
+			fmt.Fprintf(&cb, "%s=%s\n", c.Name, c.Value)
+		}
+		prof.CookiesFile = filepath.Join(cfg.OutDir, "cookies.txt")
+		if err := os.WriteFile(prof.CookiesFile, []byte(cb.String()), 0o600); err != nil {
+			return ImportProfile{}, fmt.Errorf("import: write cookies: %w", err)
+		}
+	}
+	return prof, nil
+}
+
+func replaceSentinelInJSON(v any, sentinel string) bool {
+	found := false
+	switch x := v.(type) {
+	case map[string]any:
+		for k, vv := range x {
+			if s, ok := vv.(string); ok && strings.Contains(s, sentinel) {
+				x[k] = strings.ReplaceAll(s, sentinel, promptPlaceholder)
+				found = true
+			} else if replaceSentinelInJSON(vv, sentinel) {
+				found = true
+			}
+		}
+	case []any:
+		for i, vv := range x {
+			if s, ok := vv.(string); ok && strings.Contains(s, sentinel) {
+				x[i] = strings.ReplaceAll(s, sentinel, promptPlaceholder)
+				found = true
+			} else if replaceSentinelInJSON(vv, sentinel) {
+				found = true
+			}
+		}
+	}
+	return found
+}
+
+// unescapedQuery renders q as a -query-template: keys sorted, values encoded except for {{prompt}}.
+func unescapedQuery(q url.Values) string {
+	enc := q.Encode()
+	return strings.ReplaceAll(enc, url.QueryEscape(promptPlaceholder), promptPlaceholder)
+}
+
+// rawRequestText renders req as a -request-file (cookies go to cookies.txt instead).
+func rawRequestText(req capturedRequest, u *url.URL, body string) string {
+	var b strings.Builder
+	target := u.RequestURI()
+	target = strings.ReplaceAll(target, url.QueryEscape(promptPlaceholder), promptPlaceholder)
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\n", req.Method, target)
+	fmt.Fprintf(&b, "Host: %s\n", u.Host)
+	for _, h := range req.Headers {
+		if importDroppedHeaders[h.key] {
+			continue
+		}
+		fmt.Fprintf(&b, "%s: %s\n", h.key, h.value)
+	}
+	b.WriteString("\n")
+	b.WriteString(body)
+	return b.String()
+}
+
+// CommandLine is the poke invocation that runs against the imported profile.
+func (p ImportProfile) CommandLine() string {
+	parts := []string{"poke", "-url", shellQuote(p.URL)}
+	if p.RequestFile != "" {
+		parts = append(parts, "-request-file", shellQuote(p.RequestFile))
+	} else if p.Method != "" && p.Method != DefaultMethod {
+		parts = append(parts, "-method", p.Method)
+	}
+	if p.HeadersFile != "" {
+		parts = append(parts, "-headers-file", shellQuote(p.HeadersFile))
+	}
+	if p.CookiesFile != "" {
+		parts = append(parts, "-cookies-file", shellQuote(p.CookiesFile))
+	}
+	if p.BodyTmplFile != "" {
+		parts = append(parts, "-body-template-file", shellQuote(p.BodyTmplFile))
+	}
+	if p.QueryTmplStr != "" {
+		parts = append(parts, "-query-template", shellQuote(p.QueryTmplStr))
+	}
+	parts = append(parts, "-prompts", "PROMPTS_FILE")
+	return strings.Join(parts, " ")
+}
+
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=@%+,", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,186 @@
+package engine
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testHAR = `{"log":{"entries":[
+  {"request":{"method":"GET","url":"https://chat.example.test/static/app.js","headers":[{"name":"Accept","value":"*/*"}]}},
+  {"request":{"method":"POST","url":"https://chat.example.test/api/other","headers":[],
+    "postData":{"mimeType":"application/json","text":"{\"ping\":true}"}}},
+  {"request":{"method":"POST","url":"https://chat.example.test/api/chat",
+    "headers":[
+      {"name":":authority","value":"chat.example.test"},
+      {"name":"content-type","value":"application/json"},
+      {"name":"authorization","value":"Bearer abc"},
+      {"name":"content-length","value":"42"},
+      {"name":"cookie","value":"sid=1"}
+    ],
+    "cookies":[{"name":"sid","value":"1"}],
+    "postData":{"mimeType":"application/json","text":"{\"messages\":[{\"role\":\"user\",\"content\":\"hello POKE_HERE\"}],\"stream\":false}"}}}
+]}}`
+
+// importRun imports cfg the way `poke import` does, returning the printed command line.
+func importRun(cfg ImportConfig) (string, error) {
+	prof, err := Import(cfg)
+	if err != nil {
+		return "", err
+	}
+	return prof.CommandLine(), nil
+}
+
+func TestImport_HARPicksEntryBySentinel(t *testing.T) {
+	dir := t.TempDir()
+	harPath := filepath.Join(dir, "capture.har")
+	if err := os.WriteFile(harPath, []byte(testHAR), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	out := filepath.Join(dir, "profile")
+
+	cmd, err := importRun(ImportConfig{HARFile: harPath, Index: -1, Sentinel: "POKE_HERE", OutDir: out})
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if !strings.Contains(cmd, "-url https://chat.example.test/api/chat") || !strings.Contains(cmd, "-body-template-file") {
+		t.Fatalf("unexpected command line: %q", cmd)
+	}
+	if strings.Contains(cmd, "-method") {
+		t.Fatalf("POST is the default and should not be repeated: %q", cmd)
+	}
+
+	body, err := os.ReadFile(filepath.Join(out, "body-template.json"))
+	if err != nil {
+		t.Fatalf("read body template: %v", err)
+	}
+	var v map[string]any
+	if err := json.Unmarshal(body, &v); err != nil {
+		t.Fatalf("body template is not JSON: %v", err)
+	}
+	got := v["messages"].([]any)[0].(map[string]any)["content"]
+	if got != "hello {{prompt}}" {
+		t.Fatalf("unexpected content %q", got)
+	}
+
+	headers, err := os.ReadFile(filepath.Join(out, "headers.txt"))
+	if err != nil {
+		t.Fatalf("read headers: %v", err)
+	}
+	hs := string(headers)
+	if !strings.Contains(hs, "Authorization: Bearer abc") || !strings.Contains(hs, "Content-Type: application/json") {
+		t.Fatalf("missing headers: %q", hs)
+	}
+	for _, dropped := range []string{":authority", "Content-Length", "Cookie"} {
+		if strings.Contains(hs, dropped) {
+			t.Fatalf("header %q should be dropped: %q", dropped, hs)
+		}
+	}
+	cookies, err := os.ReadFile(filepath.Join(out, "cookies.txt"))
+	if err != nil {
+		t.Fatalf("read cookies: %v", err)
+	}
+	if string(cookies) != "sid=1\n" {
+		t.Fatalf("unexpected cookies %q", cookies)
+	}
+}
+
+func TestImport_CurlWithFieldPath(t *testing.T) {
+	out := t.TempDir()
+	curl := `curl 'https://chat.example.test/api/chat' \
+  -H 'content-type: application/json' \
+  -H $'x-note: it\'s' \
+  -b 'sid=1; theme=dark' \
+  --data-raw '{"messages":[{"role":"user","content":"hi"}]}' \
+  --compressed`
+
+	if _, err := importRun(ImportConfig{Curl: curl, Index: -1, Field: "messages[0].content", OutDir: out}); err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	body, err := os.ReadFile(filepath.Join(out, "body-template.json"))
+	if err != nil {
+		t.Fatalf("read body template: %v", err)
+	}
+	if !strings.Contains(string(body), `"content": "{{prompt}}"`) {
+		t.Fatalf("field not replaced: %s", body)
+	}
+	headers, _ := os.ReadFile(filepath.Join(out, "headers.txt"))
+	if !strings.Contains(string(headers), "X-Note: it's") {
+		t.Fatalf("unexpected headers: %q", headers)
+	}
+	cookies, _ := os.ReadFile(filepath.Join(out, "cookies.txt"))
+	if string(cookies) != "sid=1\ntheme=dark\n" {
+		t.Fatalf("unexpected cookies %q", cookies)
+	}
+}
+
+func TestImport_CurlFormBodyWritesRequestFile(t *testing.T) {
+	out := t.TempDir()
+	curl := `curl -X POST https://chat.example.test/send -H 'Content-Type: application/x-www-form-urlencoded' -d 'msg=POKE+HERE&x=1'`
+
+	cmd, err := importRun(ImportConfig{Curl: curl, Index: -1, Sentinel: "POKE HERE", OutDir: out})
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if !strings.Contains(cmd, "-request-file") {
+		t.Fatalf("expected -request-file in %q", cmd)
+	}
+	raw, err := os.ReadFile(filepath.Join(out, "request.http"))
+	if err != nil {
+		t.Fatalf("read request file: %v", err)
+	}
+	tmpl, err := parseRawRequestTemplate(string(raw))
+	if err != nil {
+		t.Fatalf("generated request file does not parse: %v", err)
+	}
+	tr, err := tmpl.Render(nil, promptInput{Prompt: "a&b"})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if string(tr.Body) != "msg=a%26b&x=1" {
+		t.Fatalf("unexpected rendered body %q", tr.Body)
+	}
+}
+
+func TestImport_QuerySentinel(t *testing.T) {
+	out := t.TempDir()
+	cmd, err := importRun(ImportConfig{Curl: `curl "https://chat.example.test/ask?q=POKE&lang=en"`, Index: -1, Sentinel: "POKE", OutDir: out})
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if !strings.Contains(cmd, "-method GET") || !strings.Contains(cmd, "-query-template 'lang=en&q={{prompt}}'") {
+		t.Fatalf("unexpected command line: %q", cmd)
+	}
+}
+
+func TestImport_Errors(t *testing.T) {
+	out := t.TempDir()
+	cases := map[string]ImportConfig{
+		"no source":      {Sentinel: "x", OutDir: out},
+		"no marker":      {Curl: "curl https://x.test", OutDir: out},
+		"sentinel miss":  {Curl: `curl https://x.test -d '{"a":"b"}'`, Sentinel: "zzz", OutDir: out},
+		"field miss":     {Curl: `curl https://x.test -d '{"a":"b"}'`, Field: "c", OutDir: out},
+		"unterminated":   {Curl: `curl 'https://x.test`, Sentinel: "x", OutDir: out},
+		"not curl":       {Curl: `wget https://x.test`, Sentinel: "x", OutDir: out},
+		"missing output": {Curl: "curl https://x.test?q=x", Sentinel: "x"},
+	}
+	for name, cfg := range cases {
+		cfg.Index = -1
+		if _, err := importRun(cfg); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestSplitShellWords(t *testing.T) {
+	got, err := splitShellWords("curl 'a b' \"c\\\"d\" e\\ f $'g\\nh' \\\n  i")
+	if err != nil {
+		t.Fatalf("splitShellWords: %v", err)
+	}
+	want := []string{"curl", "a b", `c"d`, "e f", "g\nh", "i"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is a small dotted path over decoded JSON values, e.g. "choices[*].delta.content",
+// "data.chat.reply" or "items.0.text". A leading "$." is accepted and ignored.
+// "*" (or "[*]") fans out over every element of an array or every value of an object.
+type jsonPath struct {
+	raw  string
+	segs []jsonPathSeg
+}
+
+type jsonPathSeg struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+func parseJSONPath(s string) (jsonPath, error) {
+	raw := strings.TrimSpace(s)
+	s = strings.TrimPrefix(strings.TrimPrefix(raw, "$"), ".")
+	if s == "" {
+		return jsonPath{}, fmt.Errorf("json path: empty path")
+	}
+	var segs []jsonPathSeg
+	for _, part := range strings.Split(s, ".") {
+		if part == "" {
+			return jsonPath{}, fmt.Errorf("json path %q: empty segment", raw)
+		}
+		name := part
+		var brackets []string
+		if i := strings.IndexByte(part, '['); i >= 0 {
+			name = part[:i]
+			rest := part[i:]
+			for rest != "" {
+				if rest[0] != '[' {
+					return jsonPath{}, fmt.Errorf("json path %q: unexpected %q", raw, rest)
+				}
+				end := strings.IndexByte(rest, ']')
+				if end < 0 {
+					return jsonPath{}, fmt.Errorf("json path %q: missing ']'", raw)
+				}
+				brackets = append(brackets, rest[1:end])
+				rest = rest[end+1:]
+			}
+		}
+		if name != "" {
+			segs = append(segs, parseJSONPathSeg(name, false))
+		}
+		for _, b := range brackets {
+			seg := parseJSONPathSeg(b, true)
+			if !seg.wildcard && !seg.isIndex {
+				return jsonPath{}, fmt.Errorf("json path %q: bracket %q must be an index or *", raw, b)
+			}
+			segs = append(segs, seg)
+		}
+	}
+	return jsonPath{raw: raw, segs: segs}, nil
+}
+
+func parseJSONPathSeg(s string, bracket bool) jsonPathSeg {
+	if s == "*" {
+		return jsonPathSeg{wildcard: true}
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+		return jsonPathSeg{key: s, index: n, isIndex: true}
+	}
+	if bracket {
+		return jsonPathSeg{}
+	}
+	return jsonPathSeg{key: s}
+}
+
+func (p jsonPath) String() string { return p.raw }
+
+func (p jsonPath) IsZero() bool { return len(p.segs) == 0 }
+
+// Lookup returns every value the path selects, in document order (object wildcards are unordered).
+func (p jsonPath) Lookup(v any) []any {
+	cur := []any{v}
+	for _, seg := range p.segs {
+		var next []any
+		for _, c := range cur {
+			switch x := c.(type) {
+			case map[string]any:
+				if seg.wildcard {
+					for _, vv := range x {
+						next = append(next, vv)
+					}
+				} else if vv, ok := x[seg.key]; ok {
+					next = append(next, vv)
+				}
+			case []any:
+				if seg.wildcard {
+					next = append(next, x...)
+				} else if seg.isIndex && seg.index < len(x) {
+					next = append(next, x[seg.index])
+				}
+			}
+		}
+		if len(next) == 0 {
+			return nil
+		}
+		cur = next
+	}
+	return cur
+}
+
+// LookupText renders the selected values as text joined by sep: strings verbatim, null skipped,
+// anything else as compact JSON. ok is false when nothing matched.
+func (p jsonPath) LookupText(v any, sep string) (string, bool) {
+	vals := p.Lookup(v)
+	if len(vals) == 0 {
+		return "", false
+	}
+	parts := make([]string, 0, len(vals))
+	for _, val := range vals {
+		switch x := val.(type) {
+		case nil:
+			continue
+		case string:
+			parts = append(parts, x)
+		default:
+			b, err := json.Marshal(x)
+			if err != nil {
+				continue
+			}
+			parts = append(parts, string(b))
+		}
+	}
+	if len(parts) == 0 {
+		return "", false
+	}
+	return strings.Join(parts, sep), true
+}
+
+// Set replaces every value the path selects with val (fanning out over wildcards) and reports whether
+// anything was replaced. Missing keys and out-of-range indexes are not created.
+func (p jsonPath) Set(root any, val any) bool {
+	if len(p.segs) == 0 {
+		return false
+	}
+	cur := []any{root}
+	for _, seg := range p.segs[:len(p.segs)-1] {
+		var next []any
+		for _, c := range cur {
+			next = append(next, jsonPath{segs: []jsonPathSeg{seg}}.Lookup(c)...)
+		}
+		if len(next) == 0 {
+			return false
+		}
+		cur = next
+	}
+	last := p.segs[len(p.segs)-1]
+	set := false
+	for _, c := range cur {
+		switch x := c.(type) {
+		case map[string]any:
+			if last.wildcard {
+				for k := range x {
+					x[k] = val
+					set = true
+				}
+			} else if _, ok := x[last.key]; ok {
+				x[last.key] = val
+				set = true
+			}
+		case []any:
+			if last.wildcard {
+				for i := range x {
+					x[i] = val
+					set = true
+				}
+			} else if last.isIndex && last.index < len(x) {
+				x[last.index] = val
+				set = true
+			}
+		}
+	}
+	return set
+}
@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestJSONPath_LookupText(t *testing.T) {
+	var doc any
+	if err := json.Unmarshal([]byte(`{"choices":[{"delta":{"content":"a"}},{"delta":{"content":"b"}},{"delta":{}}],"data":{"items":[{"n":1},{"n":2}]}}`), &doc); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	cases := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{"choices[*].delta.content", "a|b", true},
+		{"$.choices[1].delta.content", "b", true},
+		{"choices.0.delta.content", "a", true},
+		{"data.items[*].n", "1|2", true},
+		{"data.items", `[{"n":1},{"n":2}]`, true},
+		{"choices[5].delta.content", "", false},
+		{"missing", "", false},
+	}
+	for _, tc := range cases {
+		p, err := parseJSONPath(tc.path)
+		if err != nil {
+			t.Fatalf("parseJSONPath(%q): %v", tc.path, err)
+		}
+		got, ok := p.LookupText(doc, "|")
+		if got != tc.want || ok != tc.ok {
+			t.Fatalf("%s: got (%q, %t), want (%q, %t)", tc.path, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestParseJSONPath_Invalid(t *testing.T) {
+	for _, s := range []string{"", "a..b", "a[", "a[x]", "a[0]b"} {
+		if _, err := parseJSONPath(s); err == nil {
+			t.Fatalf("expected error for %q", s)
+		}
+	}
+}
+
+func TestJSONPath_Set(t *testing.T) {
+	var doc any
+	if err := json.Unmarshal([]byte(`{"messages":[{"role":"user","content":"x"},{"role":"user","content":"y"}],"model":"m"}`), &doc); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	p, err := parseJSONPath("messages[*].content")
+	if err != nil {
+		t.Fatalf("parseJSONPath: %v", err)
+	}
+	if !p.Set(doc, "{{prompt}}") {
+		t.Fatalf("expected Set to replace values")
+	}
+	b, _ := json.Marshal(doc)
+	if string(b) != `{"messages":[{"content":"{{prompt}}","role":"user"},{"content":"{{prompt}}","role":"user"}],"model":"m"}` {
+		t.Fatalf("unexpected doc: %s", b)
+	}
+	missing, _ := parseJSONPath("messages[5].content")
+	if missing.Set(doc, "z") {
+		t.Fatalf("expected no replacement for a missing path")
+	}
+}
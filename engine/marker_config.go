@@ -1,4 +1,4 @@
-package main
+package engine
 
 import (
 	"encoding/json"
@@ -18,53 +18,78 @@ type regexMarkerConfig struct {
 	Category MarkerCategory `json:"category"`
 	Pattern  string         `json:"pattern"`
 	Enabled  bool           `json:"enabled"`
+	Scope    markerScope    `json:"scope,omitempty"`
+}
+
+// markerScope selects what a regex marker runs against.
+type markerScope string
+
+const (
+	// scopeExtracted is the default: the extracted text when available, else the raw body.
+	scopeExtracted markerScope = "extracted"
+	scopeRaw       markerScope = "raw"
+	scopeHeaders   markerScope = "headers"
+)
+
+func parseMarkerScope(s string) (markerScope, error) {
+	switch markerScope(strings.ToLower(strings.TrimSpace(s))) {
+	case "", scopeExtracted:
+		return scopeExtracted, nil
+	case scopeRaw:
+		return scopeRaw, nil
+	case scopeHeaders:
+		return scopeHeaders, nil
+	default:
+		return scopeExtracted, fmt.Errorf("unknown scope %q (expected raw|extracted|headers)", s)
+	}
 }
 
 type categoryPolicy struct {
-	Severity              severityLevel
+	Severity              Severity
 	ScoreWeight           int
 	StopAfterResponses    int
 	StopAfterMatches      int
 	ElevateAfterResponses int
-	ElevateTo             severityLevel
+	ElevateTo             Severity
 }
 
-type severityLevel int
+// Severity ranks marker categories; a run's severity is the highest one hit.
+type Severity int
 
 const (
-	severityInfo severityLevel = iota
-	severityWarn
-	severityError
-	severityCritical
+	SeverityInfo Severity = iota
+	SeverityWarn
+	SeverityError
+	SeverityCritical
 )
 
-func (s severityLevel) String() string {
+func (s Severity) String() string {
 	switch s {
-	case severityInfo:
+	case SeverityInfo:
 		return "info"
-	case severityWarn:
+	case SeverityWarn:
 		return "warn"
-	case severityError:
+	case SeverityError:
 		return "error"
-	case severityCritical:
+	case SeverityCritical:
 		return "critical"
 	default:
 		return "unknown"
 	}
 }
 
-func parseSeverityLevel(s string) (severityLevel, error) {
+func parseSeverityLevel(s string) (Severity, error) {
 	switch strings.ToLower(strings.TrimSpace(s)) {
 	case "", "warn", "warning":
-		return severityWarn, nil
+		return SeverityWarn, nil
 	case "info":
-		return severityInfo, nil
+		return SeverityInfo, nil
 	case "error":
-		return severityError, nil
+		return SeverityError, nil
 	case "critical":
-		return severityCritical, nil
+		return SeverityCritical, nil
 	default:
-		return severityWarn, fmt.Errorf("unknown severity %q (expected info|warn|error|critical)", s)
+		return SeverityWarn, fmt.Errorf("unknown severity %q (expected info|warn|error|critical)", s)
 	}
 }
 
@@ -80,6 +105,7 @@ type regexMarkerConfigFile struct {
 	Category string `json:"category"`
 	Pattern  string `json:"pattern"`
 	Enabled  *bool  `json:"enabled,omitempty"`
+	Scope    string `json:"scope,omitempty"`
 }
 
 type categoryPolicyFile struct {
@@ -94,14 +120,15 @@ type categoryPolicyFile struct {
 func defaultMarkerConfig() markerConfig {
 	// Thresholds are disabled by default (0). Use -markers-file to override.
 	cat := map[MarkerCategory]categoryPolicy{
-		CategoryJailbreakSuccess: {Severity: severityWarn, ScoreWeight: 2},
-		CategorySystemLeak:       {Severity: severityError, ScoreWeight: 4},
-		CategoryPIILeak:          {Severity: severityError, ScoreWeight: 4},
-		CategoryCredentialLeak:   {Severity: severityCritical, ScoreWeight: 6},
-		CategoryFilePathLeak:     {Severity: severityWarn, ScoreWeight: 2},
-		CategoryKeyPhraseLeak:    {Severity: severityCritical, ScoreWeight: 6},
-		CategoryHTTPError:        {Severity: severityWarn, ScoreWeight: 1},
-		CategoryRateLimit:        {Severity: severityInfo, ScoreWeight: 1},
+		CategoryJailbreakSuccess: {Severity: SeverityWarn, ScoreWeight: 2},
+		CategorySystemLeak:       {Severity: SeverityError, ScoreWeight: 4},
+		CategoryPIILeak:          {Severity: SeverityError, ScoreWeight: 4},
+		CategoryCredentialLeak:   {Severity: SeverityCritical, ScoreWeight: 6},
+		CategoryFilePathLeak:     {Severity: SeverityWarn, ScoreWeight: 2},
+		CategoryKeyPhraseLeak:    {Severity: SeverityCritical, ScoreWeight: 6},
+		CategoryHTTPError:        {Severity: SeverityWarn, ScoreWeight: 1},
+		CategoryRateLimit:        {Severity: SeverityInfo, ScoreWeight: 1},
+		CategoryGraphQLError:     {Severity: SeverityWarn, ScoreWeight: 1},
 	}
 
 	regexes := []regexMarkerConfig{
@@ -161,12 +188,21 @@ func loadMarkerConfigFile(path string) (markerConfig, error) {
 	if err != nil {
 		return markerConfig{}, fmt.Errorf("read markers file: %w", err)
 	}
+	mc, err := parseMarkerConfig(b)
+	if err != nil {
+		return markerConfig{}, fmt.Errorf("markers file: %w", err)
+	}
+	return mc, nil
+}
+
+// parseMarkerConfig layers a markers config (the -markers-file JSON) over the built-in markers.
+func parseMarkerConfig(b []byte) (markerConfig, error) {
 	var raw markerConfigFile
 	if err := json.Unmarshal(b, &raw); err != nil {
-		return markerConfig{}, fmt.Errorf("parse markers file as JSON: %w", err)
+		return markerConfig{}, fmt.Errorf("invalid JSON: %w", err)
 	}
 	if raw.Version != 0 && raw.Version != 1 {
-		return markerConfig{}, fmt.Errorf("markers file: unsupported version %d (expected 1)", raw.Version)
+		return markerConfig{}, fmt.Errorf("unsupported version %d (expected 1)", raw.Version)
 	}
 
 	out := defaultMarkerConfig()
@@ -182,17 +218,17 @@ func loadMarkerConfigFile(path string) (markerConfig, error) {
 		}
 		sev, err := parseSeverityLevel(pc.Severity)
 		if err != nil {
-			return markerConfig{}, fmt.Errorf("markers file: categories[%s].severity: %w", c, err)
+			return markerConfig{}, fmt.Errorf("categories[%s].severity: %w", c, err)
 		}
 		elevTo := sev
 		if pc.ElevateTo != "" {
 			parsed, err := parseSeverityLevel(pc.ElevateTo)
 			if err != nil {
-				return markerConfig{}, fmt.Errorf("markers file: categories[%s].elevate_to: %w", c, err)
+				return markerConfig{}, fmt.Errorf("categories[%s].elevate_to: %w", c, err)
 			}
 			elevTo = parsed
 		} else if pc.ElevateAfterResponses > 0 {
-			return markerConfig{}, fmt.Errorf("markers file: categories[%s]: elevate_to is required when elevate_after_responses > 0", c)
+			return markerConfig{}, fmt.Errorf("categories[%s]: elevate_to is required when elevate_after_responses > 0", c)
 		}
 		w := pc.ScoreWeight
 		if w == 0 {
@@ -220,14 +256,14 @@ func loadMarkerConfigFile(path string) (markerConfig, error) {
 		cat := MarkerCategory(strings.TrimSpace(r.Category))
 		pat := strings.TrimSpace(r.Pattern)
 		if id == "" {
-			return markerConfig{}, fmt.Errorf("markers file: regexes[%d]: missing id", i)
+			return markerConfig{}, fmt.Errorf("regexes[%d]: missing id", i)
 		}
 		if cat == "" {
-			return markerConfig{}, fmt.Errorf("markers file: regexes[%d] (%s): missing category", i, id)
+			return markerConfig{}, fmt.Errorf("regexes[%d] (%s): missing category", i, id)
 		}
 		key := cat.String() + ":" + id
 		if seenInFile[key] {
-			return markerConfig{}, fmt.Errorf("markers file: duplicate marker id %q", key)
+			return markerConfig{}, fmt.Errorf("duplicate marker id %q", key)
 		}
 		seenInFile[key] = true
 
@@ -235,27 +271,35 @@ func loadMarkerConfigFile(path string) (markerConfig, error) {
 		if r.Enabled != nil {
 			enabled = *r.Enabled
 		}
+		scope, err := parseMarkerScope(r.Scope)
+		if err != nil {
+			return markerConfig{}, fmt.Errorf("regexes[%d] (%s): %w", i, id, err)
+		}
 
 		if existingIdx, ok := index[key]; ok {
 			if pat != "" {
 				out.RegexMarkers[existingIdx].Pattern = pat
-			} else if !enabled {
-				// Allow disabling an existing marker without repeating its default pattern.
+			} else if !enabled || r.Scope != "" {
+				// Allow disabling or re-scoping an existing marker without repeating its default pattern.
 			} else {
-				return markerConfig{}, fmt.Errorf("markers file: regexes[%d] (%s): missing pattern", i, id)
+				return markerConfig{}, fmt.Errorf("regexes[%d] (%s): missing pattern", i, id)
 			}
 			out.RegexMarkers[existingIdx].Enabled = enabled
+			if r.Scope != "" {
+				out.RegexMarkers[existingIdx].Scope = scope
+			}
 			continue
 		}
 
 		if pat == "" {
-			return markerConfig{}, fmt.Errorf("markers file: regexes[%d] (%s): missing pattern", i, id)
+			return markerConfig{}, fmt.Errorf("regexes[%d] (%s): missing pattern", i, id)
 		}
 		out.RegexMarkers = append(out.RegexMarkers, regexMarkerConfig{
 			ID:       id,
 			Category: cat,
 			Pattern:  pat,
 			Enabled:  enabled,
+			Scope:    scope,
 		})
 	}
 
@@ -276,7 +320,7 @@ func loadMarkerConfigFile(path string) (markerConfig, error) {
 		out.RegexMarkers = defaultMarkerConfig().RegexMarkers
 	}
 	if raw.ReplaceDefaults && len(out.RegexMarkers) == 0 {
-		return markerConfig{}, fmt.Errorf("markers file: replace_defaults=true requires at least one regex")
+		return markerConfig{}, fmt.Errorf("replace_defaults=true requires at least one regex")
 	}
 
 	slices.SortFunc(out.RegexMarkers, func(a, b regexMarkerConfig) int {
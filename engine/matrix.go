@@ -0,0 +1,421 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxDivergentPrompts caps the prompts listed by the -matrix comparison; the count is always printed.
+const maxDivergentPrompts = 20
+
+// matrixFile is the -matrix run definition: the same prompts are sent to every target. Target fields
+// override the command-line flags, which act as defaults shared by all targets.
+type matrixFile struct {
+	Targets []matrixTarget `json:"targets"`
+}
+
+type matrixTarget struct {
+	Name              string            `json:"name"`
+	URL               string            `json:"url"`
+	Method            string            `json:"method"`
+	Headers           map[string]string `json:"headers"`
+	HeadersFile       string            `json:"headers_file"`
+	CookiesFile       string            `json:"cookies_file"`
+	SessionFile       string            `json:"session_file"`
+	BodyTemplate      string            `json:"body_template"`
+	BodyTemplateFile  string            `json:"body_template_file"`
+	BodyFormat        string            `json:"body_format"`
+	QueryTemplate     string            `json:"query_template"`
+	QueryTemplateFile string            `json:"query_template_file"`
+	RequestFile       string            `json:"request_file"`
+	TargetKind        string            `json:"target_kind"`
+	Model             string            `json:"model"`
+	SystemPrompt      string            `json:"system_prompt"`
+	ResponseExtract   string            `json:"response_extract"`
+	Vars              map[string]string `json:"vars"`
+	Workers           int               `json:"workers"`
+	// Rate is a pointer so 0 can lift an inherited -rate.
+	Rate    *float64 `json:"rate"`
+	Burst   int      `json:"burst"`
+	Timeout string   `json:"timeout"`
+}
+
+func loadMatrixFile(path string) (matrixFile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return matrixFile{}, fmt.Errorf("matrix file: read %q: %w", path, err)
+	}
+	var m matrixFile
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		return matrixFile{}, fmt.Errorf("matrix file: invalid JSON: %w", err)
+	}
+	if len(m.Targets) < 2 {
+		return matrixFile{}, fmt.Errorf("matrix file: at least two targets are required")
+	}
+	seen := make(map[string]bool, len(m.Targets))
+	for i, t := range m.Targets {
+		name := strings.TrimSpace(t.Name)
+		if name == "" {
+			return matrixFile{}, fmt.Errorf("matrix file: target %d: missing name", i+1)
+		}
+		if seen[name] {
+			return matrixFile{}, fmt.Errorf("matrix file: duplicate target name %q", name)
+		}
+		seen[name] = true
+		m.Targets[i].Name = name
+	}
+	return m, nil
+}
+
+// config layers t over the flag defaults in base. Setting one of a template pair (string or file)
+// replaces both, and a request_file replaces the inherited method and templates.
+func (t matrixTarget) config(base Config) (Config, error) {
+	cfg := base
+	cfg.targetName = t.Name
+	if t.URL != "" {
+		cfg.URL = t.URL
+	}
+	if t.Method != "" {
+		cfg.Method = strings.ToUpper(strings.TrimSpace(t.Method))
+	}
+	if t.HeadersFile != "" {
+		cfg.HeadersFile = t.HeadersFile
+	}
+	if t.CookiesFile != "" {
+		cfg.CookiesFile = t.CookiesFile
+	}
+	if t.SessionFile != "" {
+		cfg.SessionFile = t.SessionFile
+	}
+	if t.RequestFile != "" {
+		cfg.RequestFile = t.RequestFile
+		cfg.BodyTemplate, cfg.BodyTemplateFile, cfg.QueryTemplate, cfg.QueryTemplateFile = "", "", "", ""
+	}
+	if t.BodyTemplate != "" || t.BodyTemplateFile != "" {
+		cfg.BodyTemplate, cfg.BodyTemplateFile = t.BodyTemplate, t.BodyTemplateFile
+	}
+	if t.QueryTemplate != "" || t.QueryTemplateFile != "" {
+		cfg.QueryTemplate, cfg.QueryTemplateFile = t.QueryTemplate, t.QueryTemplateFile
+	}
+	if t.BodyFormat != "" {
+		cfg.BodyFormat = t.BodyFormat
+	}
+	if t.TargetKind != "" {
+		cfg.TargetKind = t.TargetKind
+	}
+	if t.Model != "" {
+		cfg.Model = t.Model
+	}
+	if t.SystemPrompt != "" {
+		cfg.SystemPrompt = t.SystemPrompt
+	}
+	if t.ResponseExtract != "" {
+		cfg.ResponseExtract = t.ResponseExtract
+	}
+	if len(t.Vars) > 0 {
+		cfg.Vars = make(Vars, len(base.Vars)+len(t.Vars))
+		for k, v := range base.Vars {
+			cfg.Vars[k] = v
+		}
+		for k, v := range t.Vars {
+			cfg.Vars[k] = v
+		}
+	}
+	cfg.targetHeaders = t.Headers
+	if t.Workers != 0 {
+		cfg.Workers = t.Workers
+	}
+	if t.Rate != nil {
+		cfg.Rate.Rate = *t.Rate
+	}
+	if t.Burst != 0 {
+		cfg.Rate.Burst = t.Burst
+	}
+	if t.Timeout != "" {
+		d, err := time.ParseDuration(t.Timeout)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid timeout: %w", err)
+		}
+		cfg.Timeout = d
+	}
+
+	if cfg.URL == "" && cfg.RequestFile == "" {
+		return Config{}, fmt.Errorf("missing url (set it on the target or with -url)")
+	}
+	if cfg.URL != "" {
+		if _, err := url.ParseRequestURI(cfg.URL); err != nil {
+			return Config{}, fmt.Errorf("invalid url: %w", err)
+		}
+	}
+	if cfg.Method == "" {
+		cfg.Method = DefaultMethod
+	}
+	if cfg.Workers <= 0 {
+		return Config{}, fmt.Errorf("workers must be > 0")
+	}
+	if cfg.RequestFile != "" && (cfg.BodyTemplate != "" || cfg.BodyTemplateFile != "" || cfg.QueryTemplate != "" || cfg.QueryTemplateFile != "") {
+		return Config{}, fmt.Errorf("request_file cannot be combined with body or query templates")
+	}
+	if cfg.BodyTemplate != "" && cfg.BodyTemplateFile != "" {
+		return Config{}, fmt.Errorf("only one of body_template or body_template_file may be set")
+	}
+	if cfg.QueryTemplate != "" && cfg.QueryTemplateFile != "" {
+		return Config{}, fmt.Errorf("only one of query_template or query_template_file may be set")
+	}
+	for _, validate := range []func(Config) error{validateTargetKind, validateBodyFormat, validateGraphQL} {
+		if err := validate(cfg); err != nil {
+			return Config{}, err
+		}
+	}
+	if err := cfg.Rate.validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Transport.validate(cfg.URL); err != nil {
+		return Config{}, err
+	}
+	if cfg.OAuth2.enabled() && cfg.SessionFile != "" {
+		return Config{}, fmt.Errorf("session_file and -oauth2-token-url are mutually exclusive")
+	}
+	return cfg, nil
+}
+
+// runMatrix sends the corpus to every -matrix target concurrently, each with its own workers, rate
+// limiter and marker thresholds (a threshold stops only its target). Budgets and the structured
+// outputs are shared; rows carry the target name.
+func runMatrix(ctx context.Context, cfg Config) (Summary, error) {
+	m, err := loadMatrixFile(cfg.MatrixFile)
+	if err != nil {
+		return Summary{}, err
+	}
+	targets := make([]*targetRunner, 0, len(m.Targets))
+	defer func() {
+		for _, t := range targets {
+			t.Close()
+		}
+	}()
+	for _, spec := range m.Targets {
+		tcfg, err := spec.config(cfg)
+		if err != nil {
+			return Summary{}, fmt.Errorf("matrix target %s: %w", spec.Name, err)
+		}
+		t, err := prepareTarget(ctx, tcfg)
+		if err != nil {
+			return Summary{}, fmt.Errorf("matrix target %s: %w", spec.Name, err)
+		}
+		targets = append(targets, t)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	sink, err := newResultSink(cfg.JSONLOut, cfg.CSVOut, false, cfg.OnEvent)
+	if err != nil {
+		return Summary{}, err
+	}
+	defer func() {
+		if sink != nil {
+			_ = sink.Close()
+		}
+	}()
+
+	budgets := newBudgetTracker(cfg.Budget, cancel)
+	defer budgets.Stop()
+
+	reports := make([]*report, len(targets))
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tctx, tcancel := context.WithCancelCause(ctx)
+			defer tcancel(nil)
+			stats, err := t.Run(tctx, tcancel, sink, budgets, nil)
+			if err != nil {
+				err = fmt.Errorf("matrix target %s: %w", t.cfg.targetName, err)
+			}
+			reports[i], errs[i] = stats, err
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return Summary{}, err
+	}
+	if sink != nil {
+		if err := sink.Close(); err != nil {
+			return Summary{}, err
+		}
+	}
+	if err := cfg.recorder.Close(); err != nil {
+		return Summary{}, err
+	}
+
+	logMatrixSummary(reports)
+	summary := matrixSummary(reports)
+
+	var be BudgetExceededError
+	if cause := context.Cause(ctx); errors.As(cause, &be) {
+		return summary, cause
+	}
+	return summary, worstThresholdError(reports)
+}
+
+// logMatrixSummary prints each target's summary under a "target:" line, then the comparison.
+func logMatrixSummary(reports []*report) {
+	for _, stats := range reports {
+		log.Printf("%s: %s", styledKey("target", ansiCyan, ansiBold), styledValue(stats.target, ansiCyan, ansiBold))
+		stats.LogSummary()
+	}
+	logMatrixComparison(reports)
+}
+
+// worstThresholdError returns the most severe target threshold so -ci-exit-codes reflects the
+// worst target.
+func worstThresholdError(reports []*report) error {
+	var worst error
+	var worstSeverity Severity
+	for _, stats := range reports {
+		err := stats.ThresholdError()
+		var te ThresholdExceededError
+		if errors.As(err, &te) && (worst == nil || te.Severity > worstSeverity) {
+			worst = fmt.Errorf("target %s: %w", stats.target, err)
+			worstSeverity = te.Severity
+		}
+	}
+	return worst
+}
+
+// promptOutcome is what one target did with one prompt across its samples and turns: hit means a
+// marker matched at least once, answered means at least one request got a response.
+type promptOutcome struct {
+	prompt     string
+	answered   bool
+	categories map[MarkerCategory]bool
+}
+
+func (o *promptOutcome) add(res RequestResult, categories map[MarkerCategory]bool) {
+	if res.Err != nil {
+		return
+	}
+	o.answered = true
+	for c := range categories {
+		o.categories[c] = true
+	}
+}
+
+func (o *promptOutcome) hit() bool {
+	return o != nil && len(o.categories) > 0
+}
+
+// logMatrixComparison prints per-category hit rates side by side (prompts with a hit / answered
+// prompts per target), then the prompts that hit on some targets and were answered without a hit
+// on others. Prompts a target never answered are left out of its rates and of the comparison.
+func logMatrixComparison(reports []*report) {
+	answered := make([]int, len(reports))
+	catHits := make([]map[MarkerCategory]int, len(reports))
+	anyHits := make([]int, len(reports))
+	catSet := make(map[MarkerCategory]bool)
+	ids := make(map[string]string)
+	for i, r := range reports {
+		r.mu.Lock()
+		catHits[i] = make(map[MarkerCategory]int)
+		for id, o := range r.outcomes {
+			ids[id] = o.prompt
+			if !o.answered {
+				continue
+			}
+			answered[i]++
+			if o.hit() {
+				anyHits[i]++
+			}
+			for c := range o.categories {
+				catHits[i][c]++
+				catSet[c] = true
+			}
+		}
+		r.mu.Unlock()
+	}
+
+	log.Printf("%s: (prompts with hits / answered prompts per target)", styledKey("matrix", ansiMagenta, ansiBold))
+	rateLine := func(label string, hits func(i int) int) {
+		parts := make([]string, 0, len(reports))
+		for i, r := range reports {
+			parts = append(parts, r.target+"="+styledValue(percentText(hits(i), answered[i]), ansiYellow))
+		}
+		log.Printf("%s: %s", styledKey(label, ansiMagenta, ansiBold), strings.Join(parts, " "))
+	}
+	rateLine("hit_rate", func(i int) int { return anyHits[i] })
+	cats := make([]string, 0, len(catSet))
+	for c := range catSet {
+		cats = append(cats, string(c))
+	}
+	sort.Strings(cats)
+	for _, c := range cats {
+		rateLine("hit_rate_"+c, func(i int) int { return catHits[i][MarkerCategory(c)] })
+	}
+
+	type divergent struct {
+		prompt    string
+		hit, miss []string
+	}
+	var diffs []divergent
+	for id, prompt := range ids {
+		var d divergent
+		d.prompt = prompt
+		for _, r := range reports {
+			o := r.outcomes[id]
+			switch {
+			case o.hit():
+				d.hit = append(d.hit, r.target)
+			case o != nil && o.answered:
+				d.miss = append(d.miss, r.target)
+			}
+		}
+		if len(d.hit) > 0 && len(d.miss) > 0 {
+			diffs = append(diffs, d)
+		}
+	}
+	if len(diffs) == 0 {
+		log.Printf("%s: 0 (every answered prompt behaved the same on all targets)", styledKey("divergent_prompts", ansiMagenta, ansiBold))
+		return
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		if len(diffs[i].hit) != len(diffs[j].hit) {
+			return len(diffs[i].hit) < len(diffs[j].hit)
+		}
+		return diffs[i].prompt < diffs[j].prompt
+	})
+	log.Printf("%s: %d", styledKey("divergent_prompts", ansiMagenta, ansiBold), len(diffs))
+	for i, d := range diffs {
+		if i == maxDivergentPrompts {
+			log.Printf("%s", styledDetailPrefix(fmt.Sprintf("  ... and %d more", len(diffs)-i)))
+			break
+		}
+		log.Printf(
+			"hit=%s miss=%s",
+			styledValue(strings.Join(d.hit, ","), ansiRed, ansiBold),
+			styledValue(strings.Join(d.miss, ","), ansiGreen),
+		)
+		log.Printf("%s%q", styledDetailPrefix("  prompt="), previewOneLine(d.prompt, 140))
+	}
+}
+
+// percentText renders "k/n (p%)".
+func percentText(k, n int) string {
+	if n == 0 {
+		return "0/0"
+	}
+	return fmt.Sprintf("%d/%d (%.0f%%)", k, n, 100*float64(k)/float64(n))
+}
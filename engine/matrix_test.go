@@ -0,0 +1,148 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRun_MatrixComparesTargets(t *testing.T) {
+	var mu sync.Mutex
+	seen := map[string][]string{}
+	handler := func(name string, leaks map[string]bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			var req struct{ Prompt, Variant string }
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			mu.Lock()
+			seen[name] = append(seen[name], req.Variant+"/"+r.Header.Get("X-Guard"))
+			mu.Unlock()
+			if leaks[req.Prompt] {
+				_, _ = w.Write([]byte("Sure, BEGIN SYSTEM"))
+				return
+			}
+			_, _ = w.Write([]byte("I can't help with that."))
+		}
+	}
+	base := httptest.NewServer(handler("base", map[string]bool{"jb1": true, "jb2": true}))
+	defer base.Close()
+	guarded := httptest.NewServer(handler("guarded", map[string]bool{"jb2": true}))
+	defer guarded.Close()
+
+	dir := t.TempDir()
+	prompts := filepath.Join(dir, "prompts.txt")
+	if err := os.WriteFile(prompts, []byte("jb1\njb2\nbenign\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	matrix := filepath.Join(dir, "matrix.json")
+	spec := `{"targets":[
+		{"name":"base","url":"` + base.URL + `"},
+		{"name":"guarded","url":"` + guarded.URL + `","headers":{"X-Guard":"on"},"vars":{"variant":"strict"},"workers":1,"rate":50}
+	]}`
+	if err := os.WriteFile(matrix, []byte(spec), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	jsonlOut := filepath.Join(dir, "out.jsonl")
+	cfg := Config{
+		Method:       http.MethodPost,
+		BodyTemplate: `{"prompt":"{{prompt}}","variant":"{{var:variant}}"}`,
+		Vars:         Vars{"variant": "default"},
+		Workers:      2,
+		Rate:         RateConfig{Burst: 1},
+		Timeout:      2 * time.Second,
+		PromptsFile:  prompts,
+		JSONLOut:     jsonlOut,
+		MatrixFile:   matrix,
+	}
+
+	var logs bytes.Buffer
+	restore := logWriterSwap(t, &logs)
+	defer restore()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := run(ctx, cfg); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	if len(seen["base"]) != 3 || len(seen["guarded"]) != 3 {
+		t.Fatalf("expected every prompt on every target, got %v", seen)
+	}
+	if seen["base"][0] != "default/" || seen["guarded"][0] != "strict/on" {
+		t.Fatalf("expected per-target vars and headers, got %v", seen)
+	}
+	for _, want := range []string{
+		"target: base",
+		"target: guarded",
+		"hit_rate: base=2/3 (67%) guarded=1/3 (33%)",
+		"hit_rate_system_leak: base=2/3 (67%) guarded=1/3 (33%)",
+		"divergent_prompts: 1",
+		"hit=base miss=guarded",
+		`prompt="jb1"`,
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Fatalf("expected %q in the summary, got:\n%s", want, logs.String())
+		}
+	}
+
+	f, err := os.Open(jsonlOut)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	rows := map[string]int{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var row jsonlRow
+		if err := json.Unmarshal(sc.Bytes(), &row); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		rows[row.Target]++
+	}
+	if rows["base"] != 3 || rows["guarded"] != 3 {
+		t.Fatalf("expected rows labeled per target, got %v", rows)
+	}
+}
+
+func TestMatrixTarget_Config(t *testing.T) {
+	base := Config{URL: "http://base/", Method: http.MethodPost, BodyTemplateFile: "body.json", Workers: 4, Rate: RateConfig{Rate: 10, Burst: 1}}
+	zero := 0.0
+	cfg, err := matrixTarget{Name: "a", BodyTemplate: `{"q":"{{prompt}}"}`, Rate: &zero, Timeout: "3s"}.config(base)
+	if err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	if cfg.BodyTemplateFile != "" || cfg.BodyTemplate == "" || cfg.Rate.Rate != 0 || cfg.Timeout != 3*time.Second || cfg.Workers != 4 || cfg.URL != "http://base/" {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	if _, err := (matrixTarget{Name: "b"}).config(Config{Method: http.MethodPost, Workers: 1}); err == nil {
+		t.Fatalf("expected an error for a target without a url")
+	}
+	if _, err := (matrixTarget{Name: "c", Timeout: "soon"}).config(base); err == nil {
+		t.Fatalf("expected an error for an invalid timeout")
+	}
+}
+
+func TestLoadMatrixFile_Errors(t *testing.T) {
+	dir := t.TempDir()
+	for name, body := range map[string]string{
+		"single":    `{"targets":[{"name":"a"}]}`,
+		"duplicate": `{"targets":[{"name":"a"},{"name":" a "}]}`,
+		"unnamed":   `{"targets":[{"name":"a"},{"url":"http://x/"}]}`,
+		"unknown":   `{"targets":[{"name":"a","uri":"http://x/"},{"name":"b"}]}`,
+	} {
+		path := filepath.Join(dir, name+".json")
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		if _, err := loadMatrixFile(path); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
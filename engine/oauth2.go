@@ -0,0 +1,191 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	OAuth2AuthStyleBasic  = "basic"
+	OAuth2AuthStyleParams = "params"
+
+	// oauth2RefreshSkew is how long before expiry a cached token is replaced.
+	oauth2RefreshSkew = 30 * time.Second
+)
+
+type OAuth2Config struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       string
+	AuthStyle    string
+}
+
+func (c OAuth2Config) enabled() bool {
+	return c.TokenURL != ""
+}
+
+func (c OAuth2Config) validate() error {
+	if !c.enabled() {
+		if c.ClientID != "" || c.ClientSecret != "" || c.Scopes != "" {
+			return errors.New("-oauth2-client-id/-oauth2-client-secret/-oauth2-scopes require -oauth2-token-url")
+		}
+		return nil
+	}
+	u, err := url.Parse(c.TokenURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("-oauth2-token-url must be an http(s) URL, got %q", c.TokenURL)
+	}
+	if c.ClientID == "" {
+		return errors.New("-oauth2-token-url requires -oauth2-client-id")
+	}
+	switch c.AuthStyle {
+	case "", OAuth2AuthStyleBasic, OAuth2AuthStyleParams:
+	default:
+		return fmt.Errorf("unknown -oauth2-auth-style %q (expected %s|%s)", c.AuthStyle, OAuth2AuthStyleBasic, OAuth2AuthStyleParams)
+	}
+	return nil
+}
+
+// oauth2Auth fetches client-credentials tokens and shares one cached token across all workers.
+type oauth2Auth struct {
+	cfg    OAuth2Config
+	secret string
+	client *http.Client
+	now    func() time.Time
+
+	mu      sync.Mutex
+	creds   authCredentials
+	expires time.Time // zero when the server did not send expires_in
+	skew    time.Duration
+}
+
+func newOAuth2Auth(cfg OAuth2Config, rt http.RoundTripper, timeout time.Duration) (*oauth2Auth, error) {
+	// Let the secret come from the environment instead of the command line: {{env:CLIENT_SECRET}}.
+	secret, err := promptInput{}.expand(cfg.ClientSecret, rawEscape)
+	if err != nil {
+		return nil, fmt.Errorf("-oauth2-client-secret: %w", err)
+	}
+	return &oauth2Auth{
+		cfg:    cfg,
+		secret: secret,
+		client: &http.Client{Transport: rt, Timeout: timeout},
+		now:    time.Now,
+	}, nil
+}
+
+func (a *oauth2Auth) Credentials(ctx context.Context) (authCredentials, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.creds.gen == 0 || a.expiringLocked() {
+		if err := a.fetchLocked(ctx); err != nil {
+			return authCredentials{}, err
+		}
+	}
+	return a.creds, nil
+}
+
+func (a *oauth2Auth) Refresh(ctx context.Context, gen uint64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.creds.gen != gen {
+		return nil
+	}
+	return a.fetchLocked(ctx)
+}
+
+func (a *oauth2Auth) RefreshOn(status int) bool { return status == http.StatusUnauthorized }
+
+// expiringLocked reports whether the token is within the refresh window: oauth2RefreshSkew, or a tenth
+// of the lifetime for very short-lived tokens.
+func (a *oauth2Auth) expiringLocked() bool {
+	if a.expires.IsZero() {
+		return false
+	}
+	return !a.now().Before(a.expires.Add(-a.skew))
+}
+
+type oauth2TokenResponse struct {
+	AccessToken string          `json:"access_token"`
+	TokenType   string          `json:"token_type"`
+	ExpiresIn   json.RawMessage `json:"expires_in"`
+}
+
+func (a *oauth2Auth) fetchLocked(ctx context.Context) error {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if scopes := strings.Fields(strings.ReplaceAll(a.cfg.Scopes, ",", " ")); len(scopes) > 0 {
+		form.Set("scope", strings.Join(scopes, " "))
+	}
+	if a.cfg.AuthStyle == OAuth2AuthStyleParams {
+		form.Set("client_id", a.cfg.ClientID)
+		form.Set("client_secret", a.secret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("oauth2: build token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if a.cfg.AuthStyle != OAuth2AuthStyleParams {
+		req.SetBasicAuth(url.QueryEscape(a.cfg.ClientID), url.QueryEscape(a.secret))
+	}
+
+	issued := a.now()
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("oauth2: token request: %w", err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxSessionResponseBytes))
+	if err != nil {
+		return fmt.Errorf("oauth2: read token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oauth2: token endpoint returned status %d: %s", resp.StatusCode, previewOneLine(string(b), 200))
+	}
+	var tr oauth2TokenResponse
+	if err := json.Unmarshal(b, &tr); err != nil {
+		return fmt.Errorf("oauth2: parse token response: %w", err)
+	}
+	if tr.AccessToken == "" {
+		return errors.New("oauth2: token response has no access_token")
+	}
+	tokenType := tr.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+
+	a.expires = time.Time{}
+	if n := parseExpiresIn(tr.ExpiresIn); n > 0 {
+		lifetime := time.Duration(n) * time.Second
+		a.expires = issued.Add(lifetime)
+		a.skew = min(oauth2RefreshSkew, lifetime/10)
+	}
+	a.creds = authCredentials{
+		Header: http.Header{"Authorization": {tokenType + " " + tr.AccessToken}},
+		gen:    a.creds.gen + 1,
+	}
+	return nil
+}
+
+// parseExpiresIn accepts expires_in as a JSON number or, as some servers send it, a numeric string.
+func parseExpiresIn(raw json.RawMessage) int64 {
+	v := strings.Trim(strings.TrimSpace(string(raw)), `"`)
+	if v == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0
+	}
+	return int64(f)
+}
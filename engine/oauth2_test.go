@@ -0,0 +1,163 @@
+package engine
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFakeTokenServer issues tok-N with the given lifetime; the target accepts only the latest token.
+func newFakeTokenServer(t *testing.T, expiresIn int) (srv *httptest.Server, issued *atomic.Int32, revoke func()) {
+	t.Helper()
+	issued = &atomic.Int32{}
+	var mu sync.Mutex
+	current := ""
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "client_credentials" {
+			http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+			return
+		}
+		if !ok {
+			id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+		}
+		if id != "poke" || secret != "s3cret" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		if r.PostForm.Get("scope") != "chat.read chat.write" {
+			http.Error(w, `{"error":"invalid_scope"}`, http.StatusBadRequest)
+			return
+		}
+		n := issued.Add(1)
+		mu.Lock()
+		current = fmt.Sprintf("tok-%d", n)
+		mu.Unlock()
+		_, _ = fmt.Fprintf(w, `{"access_token":%q,"token_type":"bearer","expires_in":%d}`, current, expiresIn)
+	})
+	mux.HandleFunc("/chat", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		want := "Bearer " + current
+		mu.Unlock()
+		if r.Header.Get("Authorization") != want {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, issued, func() {
+		mu.Lock()
+		current = "revoked"
+		mu.Unlock()
+	}
+}
+
+func TestOAuth2_CachesTokenAndRetriesOnce401(t *testing.T) {
+	srv, issued, revoke := newFakeTokenServer(t, 3600)
+	t.Setenv("POKE_TEST_CLIENT_SECRET", "s3cret")
+	oa, err := newOAuth2Auth(OAuth2Config{
+		TokenURL:     srv.URL + "/token",
+		ClientID:     "poke",
+		ClientSecret: "{{env:POKE_TEST_CLIENT_SECRET}}",
+		Scopes:       "chat.read,chat.write",
+	}, nil, 5*time.Second)
+	if err != nil {
+		t.Fatalf("newOAuth2Auth: %v", err)
+	}
+	cfg := Config{URL: srv.URL + "/chat", Method: http.MethodPost, Timeout: 5 * time.Second, auth: oa}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if res := sendOne(t.Context(), srv.Client(), cfg, nil, nil, i, promptInput{Prompt: "hi"}); res.StatusCode != http.StatusOK {
+				t.Errorf("request %d: status=%d err=%v", i, res.StatusCode, res.Err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := issued.Load(); got != 1 {
+		t.Fatalf("expected one cached token for all workers, got %d", got)
+	}
+
+	revoke()
+	res := sendOne(t.Context(), srv.Client(), cfg, nil, nil, 1, promptInput{Prompt: "hi"})
+	if res.StatusCode != http.StatusOK || res.Attempts != 2 {
+		t.Fatalf("expected retry with a fresh token, got status=%d attempts=%d err=%v", res.StatusCode, res.Attempts, res.Err)
+	}
+	if got := issued.Load(); got != 2 {
+		t.Fatalf("expected 2 tokens after 401, got %d", got)
+	}
+}
+
+func TestOAuth2_RefreshesBeforeExpiry(t *testing.T) {
+	srv, issued, _ := newFakeTokenServer(t, 300)
+	oa, err := newOAuth2Auth(OAuth2Config{TokenURL: srv.URL + "/token", ClientID: "poke", ClientSecret: "s3cret", Scopes: "chat.read chat.write", AuthStyle: OAuth2AuthStyleParams}, nil, 5*time.Second)
+	if err != nil {
+		t.Fatalf("newOAuth2Auth: %v", err)
+	}
+	now := time.Now()
+	oa.now = func() time.Time { return now }
+
+	c1, err := oa.Credentials(t.Context())
+	if err != nil {
+		t.Fatalf("Credentials: %v", err)
+	}
+	now = now.Add(260 * time.Second) // still outside the 30s refresh window
+	c2, _ := oa.Credentials(t.Context())
+	if c2.Header.Get("Authorization") != c1.Header.Get("Authorization") {
+		t.Fatalf("token refreshed too early")
+	}
+	now = now.Add(15 * time.Second) // 25s before expiry
+	c3, _ := oa.Credentials(t.Context())
+	if c3.Header.Get("Authorization") != "Bearer tok-2" || issued.Load() != 2 {
+		t.Fatalf("expected proactive refresh, got %q (issued=%d)", c3.Header.Get("Authorization"), issued.Load())
+	}
+
+	// A stale generation (another worker already refreshed) does not fetch again.
+	if err := oa.Refresh(t.Context(), c1.gen); err != nil || issued.Load() != 2 {
+		t.Fatalf("stale Refresh should be a no-op: err=%v issued=%d", err, issued.Load())
+	}
+}
+
+func TestOAuth2_TokenErrors(t *testing.T) {
+	srv, _, _ := newFakeTokenServer(t, 60)
+	oa, err := newOAuth2Auth(OAuth2Config{TokenURL: srv.URL + "/token", ClientID: "poke", ClientSecret: "wrong", Scopes: "chat.read chat.write"}, nil, 5*time.Second)
+	if err != nil {
+		t.Fatalf("newOAuth2Auth: %v", err)
+	}
+	if _, err := oa.Credentials(t.Context()); err == nil {
+		t.Fatalf("expected invalid_client error")
+	}
+	if _, err := newOAuth2Auth(OAuth2Config{TokenURL: srv.URL, ClientID: "x", ClientSecret: "{{env:POKE_TEST_UNSET_SECRET}}"}, nil, time.Second); err == nil {
+		t.Fatalf("expected error for unset env secret")
+	}
+}
+
+func TestOAuth2Config_Validate(t *testing.T) {
+	bad := []OAuth2Config{
+		{ClientID: "x"},
+		{TokenURL: "ftp://x/token", ClientID: "x"},
+		{TokenURL: "https://x/token"},
+		{TokenURL: "https://x/token", ClientID: "x", AuthStyle: "jwt"},
+	}
+	for _, c := range bad {
+		if err := c.validate(); err == nil {
+			t.Fatalf("expected error for %+v", c)
+		}
+	}
+	if err := (OAuth2Config{TokenURL: "https://x/token", ClientID: "x"}).validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parseExpiresIn([]byte(`"120"`)) != 120 || parseExpiresIn([]byte(`90`)) != 90 || parseExpiresIn(nil) != 0 {
+		t.Fatalf("parseExpiresIn mismatch")
+	}
+}
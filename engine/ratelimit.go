@@ -0,0 +1,178 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// adaptiveDecrease is the multiplicative factor applied on 429/503.
+	adaptiveDecrease = 0.5
+	// adaptiveSteps is how many successful responses it takes to climb from the floor to the ceiling.
+	adaptiveSteps = 50
+	// adaptiveCooldown is the minimum time between two decreases.
+	adaptiveCooldown = time.Second
+	// maxRetryAfterPause caps how long a single Retry-After may pause all workers.
+	maxRetryAfterPause = time.Minute
+)
+
+type RateConfig struct {
+	Rate     float64
+	Burst    int
+	Adaptive bool
+	Min      float64
+	Max      float64
+}
+
+func (c RateConfig) validate() error {
+	if c.Rate < 0 || c.Min < 0 || c.Max < 0 {
+		return errors.New("-rate, -rate-min and -rate-max must be >= 0")
+	}
+	if c.Burst < 1 {
+		return errors.New("-burst must be >= 1")
+	}
+	if !c.Adaptive {
+		if c.Min != 0 || c.Max != 0 {
+			return errors.New("-rate-min/-rate-max require -rate-adaptive")
+		}
+		return nil
+	}
+	if c.Rate == 0 {
+		return errors.New("-rate-adaptive requires a starting -rate > 0")
+	}
+	if c.Min > c.Rate || (c.Max != 0 && c.Max < c.Rate) {
+		return fmt.Errorf("-rate-adaptive needs -rate-min <= -rate <= -rate-max (got %g <= %g <= %g)", c.Min, c.Rate, c.Max)
+	}
+	return nil
+}
+
+// rateLimiter is a global token bucket shared by all workers. With -rate-adaptive its rate follows
+// AIMD: halved on 429/503 (at most once per adaptiveCooldown, so a wave of throttled in-flight
+// requests counts once), paused for Retry-After, and raised linearly on successful responses.
+type rateLimiter struct {
+	now func() time.Time
+
+	mu          sync.Mutex
+	rate        float64 // tokens per second; 0 = unlimited
+	burst       float64
+	tokens      float64
+	last        time.Time
+	adaptive    bool
+	floor, ceil float64
+	decreasedAt time.Time
+	pausedUntil time.Time
+}
+
+func newRateLimiter(c RateConfig) (*rateLimiter, error) {
+	if c.Burst == 0 {
+		c.Burst = 1
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	rl := &rateLimiter{
+		now:      time.Now,
+		rate:     c.Rate,
+		burst:    float64(c.Burst),
+		tokens:   float64(c.Burst),
+		adaptive: c.Adaptive,
+		floor:    c.Min,
+		ceil:     c.Max,
+	}
+	if rl.adaptive {
+		if rl.floor == 0 {
+			rl.floor = c.Rate / 20
+		}
+		if rl.ceil == 0 {
+			rl.ceil = c.Rate
+		}
+	}
+	rl.last = rl.now()
+	return rl, nil
+}
+
+func (rl *rateLimiter) Wait(ctx context.Context) error {
+	// adaptive is checked first: rate changes under mu while an adaptive run is in flight.
+	if !rl.adaptive && rl.rate == 0 {
+		return nil
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rl.mu.Lock()
+		d := rl.reserveLocked()
+		rl.mu.Unlock()
+		if d <= 0 {
+			return nil
+		}
+		if err := sleepCtx(ctx, d); err != nil {
+			return err
+		}
+	}
+}
+
+// reserveLocked takes a token and returns 0, or returns how long to wait before trying again.
+func (rl *rateLimiter) reserveLocked() time.Duration {
+	now := rl.now()
+	if now.Before(rl.pausedUntil) {
+		return rl.pausedUntil.Sub(now)
+	}
+	if rl.rate == 0 {
+		return 0
+	}
+	rl.refillLocked(now)
+	if rl.tokens >= 1 {
+		rl.tokens--
+		return 0
+	}
+	return time.Duration((1 - rl.tokens) / rl.rate * float64(time.Second))
+}
+
+func (rl *rateLimiter) refillLocked(now time.Time) {
+	if elapsed := now.Sub(rl.last).Seconds(); elapsed > 0 {
+		rl.tokens = min(rl.burst, rl.tokens+elapsed*rl.rate)
+	}
+	rl.last = now
+}
+
+// Observe feeds one response status back into an adaptive limiter; it is a no-op otherwise.
+func (rl *rateLimiter) Observe(status int, header http.Header) {
+	if rl == nil || !rl.adaptive || status == 0 {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := rl.now()
+	rl.refillLocked(now)
+
+	throttled := status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+	if ra, ok := parseRetryAfter(header.Get("Retry-After"), now); ok && ra > 0 && status >= 400 {
+		if until := now.Add(min(ra, maxRetryAfterPause)); until.After(rl.pausedUntil) {
+			rl.pausedUntil = until
+		}
+		throttled = true
+	}
+	switch {
+	case throttled:
+		if now.Sub(rl.decreasedAt) < max(adaptiveCooldown, time.Duration(float64(time.Second)/rl.rate)) {
+			return
+		}
+		rl.rate = max(rl.floor, rl.rate*adaptiveDecrease)
+		rl.tokens = min(rl.tokens, 1)
+		rl.decreasedAt = now
+	case status < 400:
+		rl.rate = min(rl.ceil, rl.rate+(rl.ceil-rl.floor)/adaptiveSteps)
+	}
+}
+
+// Rate returns the current effective rate in requests per second (0 = unlimited).
+func (rl *rateLimiter) Rate() float64 {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.rate
+}
@@ -0,0 +1,170 @@
+package engine
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestLimiter(t *testing.T, c RateConfig) (*rateLimiter, *time.Time) {
+	t.Helper()
+	now := time.Unix(1_700_000_000, 0)
+	rl, err := newRateLimiter(c)
+	if err != nil {
+		t.Fatalf("newRateLimiter: %v", err)
+	}
+	rl.now = func() time.Time { return now }
+	rl.last = now
+	return rl, &now
+}
+
+func approx(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+func TestRateLimiter_BurstThenSteadyRate(t *testing.T) {
+	rl, now := newTestLimiter(t, RateConfig{Rate: 2, Burst: 3})
+	for i := 0; i < 3; i++ {
+		if d := rl.reserveLocked(); d != 0 {
+			t.Fatalf("burst token %d: expected no wait, got %s", i, d)
+		}
+	}
+	if d := rl.reserveLocked(); d != 500*time.Millisecond {
+		t.Fatalf("expected 500ms wait after the burst, got %s", d)
+	}
+	*now = now.Add(500 * time.Millisecond)
+	if d := rl.reserveLocked(); d != 0 {
+		t.Fatalf("expected a refilled token, got wait %s", d)
+	}
+	// Non-adaptive limiters ignore feedback.
+	rl.Observe(http.StatusTooManyRequests, nil)
+	if rl.Rate() != 2 {
+		t.Fatalf("fixed rate changed to %g", rl.Rate())
+	}
+}
+
+func TestRateLimiter_AdaptiveAIMD(t *testing.T) {
+	rl, now := newTestLimiter(t, RateConfig{Rate: 10, Burst: 1, Adaptive: true, Min: 1})
+
+	rl.Observe(http.StatusTooManyRequests, nil)
+	if rl.Rate() != 5 {
+		t.Fatalf("expected halving to 5, got %g", rl.Rate())
+	}
+	rl.Observe(http.StatusTooManyRequests, nil) // same wave of in-flight requests
+	if rl.Rate() != 5 {
+		t.Fatalf("expected cooldown to ignore the second 429, got %g", rl.Rate())
+	}
+	for i := 0; i < 4; i++ {
+		*now = now.Add(adaptiveCooldown)
+		rl.Observe(http.StatusServiceUnavailable, nil)
+	}
+	if rl.Rate() != 1 {
+		t.Fatalf("expected the floor of 1, got %g", rl.Rate())
+	}
+
+	// Retry-After pauses every worker, even with tokens available.
+	*now = now.Add(10 * time.Second)
+	rl.Observe(http.StatusTooManyRequests, http.Header{"Retry-After": {"3"}})
+	if d := rl.reserveLocked(); d != 3*time.Second {
+		t.Fatalf("expected a 3s pause, got %s", d)
+	}
+	*now = now.Add(3 * time.Second)
+	if d := rl.reserveLocked(); d != 0 {
+		t.Fatalf("expected a token after the pause, got wait %s", d)
+	}
+
+	// Additive increase: (ceiling - floor) / adaptiveSteps per success, capped at the ceiling.
+	rl.Observe(http.StatusOK, nil)
+	if want := 1 + 9.0/adaptiveSteps; !approx(rl.Rate(), want) {
+		t.Fatalf("expected %g after one success, got %g", want, rl.Rate())
+	}
+	for i := 0; i < adaptiveSteps; i++ {
+		rl.Observe(http.StatusOK, nil)
+	}
+	if rl.Rate() != 10 {
+		t.Fatalf("expected the ceiling of 10, got %g", rl.Rate())
+	}
+	rl.Observe(http.StatusInternalServerError, nil) // neither throttled nor a success
+	if rl.Rate() != 10 {
+		t.Fatalf("5xx without Retry-After should not change the rate, got %g", rl.Rate())
+	}
+}
+
+// Run with -race: Wait must not read the rate Observe adjusts without the lock.
+func TestRateLimiter_AdaptiveWaitAndObserveConcurrently(t *testing.T) {
+	rl, err := newRateLimiter(RateConfig{Rate: 1e6, Burst: 1000, Adaptive: true})
+	if err != nil {
+		t.Fatalf("newRateLimiter: %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if err := rl.Wait(t.Context()); err != nil {
+					t.Errorf("Wait: %v", err)
+					return
+				}
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				status := http.StatusOK
+				if (i+j)%7 == 0 {
+					status = http.StatusTooManyRequests
+				}
+				rl.Observe(status, nil)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestSendOne_FeedsAdaptiveLimiter(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	rl, err := newRateLimiter(RateConfig{Rate: 8, Burst: 1, Adaptive: true})
+	if err != nil {
+		t.Fatalf("newRateLimiter: %v", err)
+	}
+	cfg := Config{URL: srv.URL, Method: http.MethodPost, Timeout: 5 * time.Second, limiter: rl, Retry: RetryConfig{MaxRetries: 1, BackoffMin: time.Millisecond, BackoffMax: time.Millisecond}}
+	res := sendOne(t.Context(), srv.Client(), cfg, nil, nil, 1, promptInput{Prompt: "hi"})
+	if res.StatusCode != http.StatusOK || res.Retries != 1 {
+		t.Fatalf("unexpected result: status=%d retries=%d err=%v", res.StatusCode, res.Retries, res.Err)
+	}
+	// 8 -> 4 on the retried 429, then one additive step for the 200 (floor 0.4, ceiling 8).
+	if want := 4 + (8-0.4)/adaptiveSteps; !approx(rl.Rate(), want) {
+		t.Fatalf("expected rate %g, got %g", want, rl.Rate())
+	}
+}
+
+func TestRateConfig_Validate(t *testing.T) {
+	bad := []RateConfig{
+		{Rate: -1, Burst: 1},
+		{Rate: 1, Burst: 0},
+		{Rate: 1, Burst: 1, Min: 0.5},
+		{Burst: 1, Adaptive: true},
+		{Rate: 5, Burst: 1, Adaptive: true, Min: 6},
+		{Rate: 5, Burst: 1, Adaptive: true, Max: 4},
+	}
+	for _, c := range bad {
+		if err := c.validate(); err == nil {
+			t.Fatalf("expected error for %+v", c)
+		}
+	}
+	if err := (RateConfig{Rate: 5, Burst: 4, Adaptive: true, Min: 1, Max: 20}).validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
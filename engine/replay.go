@@ -0,0 +1,178 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ReplayConfig configures Replay (poke replay).
+type ReplayConfig struct {
+	CassetteDir     string
+	MarkersFile     string
+	ResponseExtract string
+	JSONLOut        string
+	CSVOut          string
+}
+
+// Validate checks the options (what the CLI flags accept).
+func (cfg ReplayConfig) Validate() error {
+	if cfg.CassetteDir == "" {
+		return usage(errors.New("missing required flag: -cassette"))
+	}
+	if cfg.ResponseExtract != "" {
+		if _, err := parseResponseExtractor(cfg.ResponseExtract); err != nil {
+			return usage(err)
+		}
+	}
+	if cfg.JSONLOut == "-" || cfg.CSVOut == "-" {
+		return fmt.Errorf("structured outputs must be file paths; '-' is not supported (keeps stdout human-friendly)")
+	}
+	if cfg.JSONLOut != "" && cfg.CSVOut != "" && cfg.JSONLOut == cfg.CSVOut {
+		return fmt.Errorf("-jsonl-out and -csv-out must not be the same path")
+	}
+	return nil
+}
+
+// Replay feeds every exchange of a cassette through the analyzer and report in recorded order,
+// as if the run were live. Marker thresholds are evaluated but never cut the replay short, since the
+// cassette already ends where the live run stopped. Cassettes of -matrix runs are reported per target
+// with the usual comparison.
+func Replay(ctx context.Context, rc ReplayConfig) error {
+	if err := rc.Validate(); err != nil {
+		return err
+	}
+	c, err := openCassette(rc.CassetteDir)
+	if err != nil {
+		return err
+	}
+	var extractor *responseExtractor
+	if rc.ResponseExtract != "" {
+		if extractor, err = parseResponseExtractor(rc.ResponseExtract); err != nil {
+			return err
+		}
+	}
+	sink, err := newResultSink(rc.JSONLOut, rc.CSVOut, false, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if sink != nil {
+			_ = sink.Close()
+		}
+	}()
+	run, err := newOfflineRun(rc.MarkersFile, c.manifest.Samples, sink)
+	if err != nil {
+		return err
+	}
+
+	for _, ex := range c.exchanges {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		res, err := c.result(ex)
+		if err != nil {
+			return err
+		}
+		if extractor != nil && !res.Streamed && res.Err == nil {
+			if text, ok := extractor.Extract(res.Body); ok {
+				res.Text = text
+			}
+		}
+		run.report(ex.Target).RecordResult(res)
+		run.notePrompt(ex.Target, ex.PromptID)
+	}
+	run.finishPrompts(nil)
+
+	if sink != nil {
+		if err := sink.Close(); err != nil {
+			return err
+		}
+	}
+	return run.logSummary()
+}
+
+// offlineRun reports recorded results (poke replay, poke analyze): one report per -matrix target,
+// or a single one, analyzed with the given markers.
+type offlineRun struct {
+	analyzer *responseAnalyzer
+	policy   map[MarkerCategory]categoryPolicy
+	sink     *resultSink
+	samples  int
+
+	reports  []*report
+	byTarget map[string]*report
+	prompts  [][2]string
+	seen     map[[2]string]bool
+}
+
+func newOfflineRun(markersFile string, samples int, sink *resultSink) (*offlineRun, error) {
+	mcfg := defaultMarkerConfig()
+	if markersFile != "" {
+		loaded, err := loadMarkerConfigFile(markersFile)
+		if err != nil {
+			return nil, err
+		}
+		mcfg = loaded
+	}
+	analyzer, err := newResponseAnalyzer(mcfg)
+	if err != nil {
+		return nil, err
+	}
+	return &offlineRun{
+		analyzer: analyzer,
+		policy:   mcfg.Categories,
+		sink:     sink,
+		samples:  max(samples, 1),
+		byTarget: make(map[string]*report),
+		seen:     make(map[[2]string]bool),
+	}, nil
+}
+
+func (o *offlineRun) report(target string) *report {
+	stats := o.byTarget[target]
+	if stats == nil {
+		// A no-op cancel still logs the "stop:" line where the live run would have stopped.
+		stats = newReport(o.analyzer, o.policy, func(error) {}, o.sink)
+		stats.offline = true
+		stats.samples = o.samples
+		stats.target = target
+		o.byTarget[target] = stats
+		o.reports = append(o.reports, stats)
+	}
+	return stats
+}
+
+func (o *offlineRun) notePrompt(target, promptID string) {
+	key := [2]string{target, promptID}
+	if promptID != "" && !o.seen[key] {
+		o.seen[key] = true
+		o.prompts = append(o.prompts, key)
+	}
+}
+
+// finishPrompts closes the -samples tallies in first-seen order; live runs write a prompt's summary
+// after its last sample, an offline run has all samples only at the end. fn, if set, gets each summary.
+func (o *offlineRun) finishPrompts(fn func(target string, sum PromptSummary)) {
+	for _, key := range o.prompts {
+		if sum, ok := o.report(key[0]).finishPrompt(key[1], false); ok && fn != nil {
+			fn(key[0], sum)
+		}
+	}
+}
+
+// logSummary prints the summary (per target with the comparison for -matrix results) and returns
+// the threshold error, if any.
+func (o *offlineRun) logSummary() error {
+	switch {
+	case len(o.reports) == 0:
+		newReport(o.analyzer, o.policy, nil, nil).LogSummary()
+		return nil
+	case len(o.reports) == 1 && o.reports[0].target == "":
+		o.reports[0].LogSummary()
+		return o.reports[0].ThresholdError()
+	default:
+		logMatrixSummary(o.reports)
+		return worstThresholdError(o.reports)
+	}
+}
@@ -1,8 +1,9 @@
-package main
+package engine
 
 import (
 	"fmt"
 	"log"
+	"poke/promptset"
 	"sort"
 	"strings"
 	"sync"
@@ -14,8 +15,19 @@ type report struct {
 	analyzer *responseAnalyzer
 	cancel   func(error)
 	sink     *resultSink
+	// rate reports the adaptive limiter's effective rate for the progress line; nil otherwise.
+	rate func() float64
+	// budgets accounts every recorded result against the run budgets; nil when none are set.
+	budgets *budgetTracker
+	// recorder writes every live result to the -record cassette; nil otherwise.
+	recorder *cassetteRecorder
+	// fullBodies adds the analyzed response to each -jsonl-out row (-jsonl-full-body).
+	fullBodies bool
+	// offline reports recorded results (poke replay / analyze): no progress lines.
+	offline bool
 
 	total     int
+	resumed   int
 	errs      int
 	firstErr  error
 	byStatus  map[int]int
@@ -34,39 +46,50 @@ type report struct {
 	categoryMatchCounts  map[MarkerCategory]int
 
 	categoryPolicy map[MarkerCategory]categoryPolicy
-	maxSeverity    severityLevel
+	maxSeverity    Severity
 	stopErr        error
 	elevated       map[MarkerCategory]bool
 
 	topN int
-	top  []offendingResponse
+	top  []OffendingResponse
+
+	// samples > 1 (-samples) tallies results per prompt and ranks offenders by attack success rate.
+	samples         int
+	tallies         map[string]*promptTally
+	topPrompts      []PromptSummary
+	sampleTrials    int
+	categorySamples map[MarkerCategory]int
+
+	// target labels the rows and progress of one -matrix target, whose outcomes are compared afterwards.
+	target   string
+	outcomes map[string]*promptOutcome
 }
 
-type thresholdExceededError struct {
+type ThresholdExceededError struct {
 	Category MarkerCategory
 	Kind     string // "responses" | "matches"
 	Current  int
 	Limit    int
-	Severity severityLevel
+	Severity Severity
 }
 
-func (e thresholdExceededError) Error() string {
+func (e ThresholdExceededError) Error() string {
 	return fmt.Sprintf("threshold exceeded: category %s %s %d >= %d", e.Category, e.Kind, e.Current, e.Limit)
 }
 
-func (e thresholdExceededError) ExitCode() int {
+func (e ThresholdExceededError) ExitCode() int {
 	// CI-friendly buckets: 2=warn/info, 3=error, 4=critical.
 	switch e.Severity {
-	case severityCritical:
+	case SeverityCritical:
 		return 4
-	case severityError:
+	case SeverityError:
 		return 3
 	default:
 		return 2
 	}
 }
 
-type offendingResponse struct {
+type OffendingResponse struct {
 	Score           int
 	StatusCode      int
 	Latency         time.Duration
@@ -91,9 +114,12 @@ func newReport(analyzer *responseAnalyzer, policy map[MarkerCategory]categoryPol
 		categoryRespCounts:   make(map[MarkerCategory]int),
 		categoryMatchCounts:  make(map[MarkerCategory]int),
 		categoryPolicy:       policy,
-		maxSeverity:          severityInfo,
+		maxSeverity:          SeverityInfo,
 		elevated:             make(map[MarkerCategory]bool),
 		topN:                 10,
+		tallies:              make(map[string]*promptTally),
+		categorySamples:      make(map[MarkerCategory]int),
+		outcomes:             make(map[string]*promptOutcome),
 		latencyMin:           0,
 		latencyMax:           0,
 		latencyTotal:         0,
@@ -110,12 +136,22 @@ func (r *report) RecordResult(res RequestResult) {
 	if r.analyzer != nil && res.Err == nil {
 		hits = r.analyzer.Analyze(res)
 	}
+	r.record(res, hits, false)
+}
+
+// ReplayRow folds a completed -jsonl-out row of a previous run into the aggregates (-resume). The row's
+// marker hits are reused as recorded and it is not written to the outputs again.
+func (r *report) ReplayRow(row jsonlRow) {
+	r.record(row.result(), row.MarkerHits, true)
+}
 
+// record folds one result into the aggregates and returns its score and severity.
+func (r *report) record(res RequestResult, hits []MarkerHit, replayed bool) (int, Severity) {
 	var markerIDs []string
 	var totalMatches int
 	categorySeen := make(map[MarkerCategory]bool, 4)
 	categoryMatches := make(map[MarkerCategory]int, 4)
-	reqSeverity := severityInfo
+	reqSeverity := SeverityInfo
 	for _, h := range hits {
 		markerIDs = append(markerIDs, h.ID)
 		totalMatches += h.Count
@@ -127,15 +163,15 @@ func (r *report) RecordResult(res RequestResult) {
 	}
 
 	score := offenseScoreWeighted(hits, r.categoryPolicy)
-	var offender *offendingResponse
+	var offender *OffendingResponse
 	if score > 0 {
-		off := offendingResponse{
+		off := OffendingResponse{
 			Score:           score,
 			StatusCode:      res.StatusCode,
 			Latency:         res.Latency,
 			MarkerIDs:       markerIDs,
 			PromptPreview:   previewOneLine(res.Prompt, 140),
-			ResponsePreview: previewOneLineBytes(res.Body, 240),
+			ResponsePreview: previewOneLineBytes(res.AnalysisText(), 240),
 			BodyTruncated:   res.BodyTruncated,
 		}
 		if res.Err != nil {
@@ -152,6 +188,9 @@ func (r *report) RecordResult(res RequestResult) {
 
 	r.mu.Lock()
 	r.total++
+	if replayed {
+		r.resumed++
+	}
 	if res.Seq > 0 {
 		seq = res.Seq
 	} else {
@@ -221,7 +260,7 @@ func (r *report) RecordResult(res RequestResult) {
 	if r.stopErr == nil {
 		for c, p := range r.categoryPolicy {
 			if p.StopAfterResponses > 0 && r.categoryRespCounts[c] >= p.StopAfterResponses {
-				r.stopErr = thresholdExceededError{
+				r.stopErr = ThresholdExceededError{
 					Category: c,
 					Kind:     "responses",
 					Current:  r.categoryRespCounts[c],
@@ -231,7 +270,7 @@ func (r *report) RecordResult(res RequestResult) {
 				break
 			}
 			if p.StopAfterMatches > 0 && r.categoryMatchCounts[c] >= p.StopAfterMatches {
-				r.stopErr = thresholdExceededError{
+				r.stopErr = ThresholdExceededError{
 					Category: c,
 					Kind:     "matches",
 					Current:  r.categoryMatchCounts[c],
@@ -252,8 +291,24 @@ func (r *report) RecordResult(res RequestResult) {
 	if offender != nil {
 		r.maybeAddTopLocked(*offender)
 	}
+	if r.samples > 1 && res.PromptID != "" {
+		t := r.tallies[res.PromptID]
+		if t == nil {
+			t = &promptTally{prompt: res.Prompt, samples: make(map[int]*sampleTally)}
+			r.tallies[res.PromptID] = t
+		}
+		t.add(res, categorySeen, score)
+	}
+	if r.target != "" && res.PromptID != "" {
+		o := r.outcomes[res.PromptID]
+		if o == nil {
+			o = &promptOutcome{prompt: res.Prompt, categories: make(map[MarkerCategory]bool)}
+			r.outcomes[res.PromptID] = o
+		}
+		o.add(res, categorySeen)
+	}
 
-	if r.total%progressEveryN == 0 {
+	if !replayed && !r.offline && r.total%progressEveryN == 0 {
 		s := fmt.Sprintf(
 			"%s: sent=%d last_status=%s last_latency=%s",
 			styledKey("progress", ansiCyan, ansiBold),
@@ -261,20 +316,35 @@ func (r *report) RecordResult(res RequestResult) {
 			styledStatusCode(res.StatusCode),
 			styledValue(res.Latency.String(), ansiBlue),
 		)
+		if r.rate != nil {
+			s += fmt.Sprintf(" rate=%s", styledValue(fmt.Sprintf("%.2f/s", r.rate()), ansiBlue))
+		}
+		if r.target != "" {
+			s += fmt.Sprintf(" target=%s", styledValue(r.target, ansiCyan))
+		}
 		progressLog = &s
 	}
 	r.mu.Unlock()
 
-	if r.sink != nil {
+	if r.sink != nil && !replayed {
 		bodyPreview := ""
 		if len(res.Body) > 0 {
 			bodyPreview = previewOneLineBytes(res.Body, 400)
 		}
-		ev := requestEvent{
+		textPreview := ""
+		if len(res.Text) > 0 {
+			textPreview = previewOneLineBytes(res.Text, 400)
+		}
+		ev := Event{
 			Time:          time.Now(),
 			Seq:           seq,
 			WorkerID:      res.WorkerID,
 			Prompt:        res.Prompt,
+			PromptID:      res.PromptID,
+			Sample:        res.Sample,
+			Target:        r.target,
+			ConvID:        res.ConversationID,
+			Turn:          res.Turn,
 			Attempts:      res.Attempts,
 			Retries:       res.Retries,
 			StatusCode:    res.StatusCode,
@@ -282,6 +352,11 @@ func (r *report) RecordResult(res RequestResult) {
 			BodyLen:       len(res.Body),
 			BodyTruncated: res.BodyTruncated,
 			BodyPreview:   bodyPreview,
+			TextPreview:   textPreview,
+			Streamed:      res.Streamed,
+			FirstToken:    res.FirstTokenLatency,
+			TokenEvents:   res.TokenEvents,
+			GraphQLErrors: res.GraphQLErrors,
 			MarkerHits:    hits,
 			Score:         score,
 			Severity:      reqSeverity,
@@ -289,8 +364,14 @@ func (r *report) RecordResult(res RequestResult) {
 		if res.Err != nil {
 			ev.Error = res.Err.Error()
 		}
+		if r.fullBodies {
+			ev.Full = &FullResponse{Body: res.Body, Text: res.Text, Headers: redactHeaders(res.Headers)}
+		}
 		r.sink.Write(ev)
 	}
+	if !replayed {
+		r.recorder.Record(res, seq, r.target)
+	}
 
 	if progressLog != nil {
 		log.Print(*progressLog)
@@ -301,9 +382,54 @@ func (r *report) RecordResult(res RequestResult) {
 	if thresholdCancel != nil && thresholdErr != nil {
 		thresholdCancel(thresholdErr)
 	}
+	if !replayed {
+		r.budgets.Record(res)
+	}
+	return score, reqSeverity
+}
+
+// FinishPrompt closes the -samples tally of item once all its samples are recorded: it ranks the prompt
+// by attack success rate and writes its prompt_summary row (unless replayed by -resume, whose rows are
+// already in the outputs). It is a no-op without -samples.
+func (r *report) FinishPrompt(item promptset.Item, replayed bool) {
+	r.finishPrompt(item.Key(), replayed)
 }
 
-func (r *report) maybeAddTopLocked(off offendingResponse) {
+// finishPrompt closes the tally of the prompt keyed key and returns its summary; ok is false when
+// the prompt has no answered samples.
+func (r *report) finishPrompt(key string, replayed bool) (sum PromptSummary, ok bool) {
+	r.mu.Lock()
+	t := r.tallies[key]
+	delete(r.tallies, key)
+	if t == nil {
+		r.mu.Unlock()
+		return PromptSummary{}, false
+	}
+	sum = t.summary(key)
+	if sum.Samples == 0 {
+		r.mu.Unlock()
+		return PromptSummary{}, false
+	}
+	r.sampleTrials += sum.Samples
+	for c, k := range sum.Categories {
+		r.categorySamples[c] += k
+	}
+	if sum.Successes > 0 && r.topN > 0 {
+		r.topPrompts = append(r.topPrompts, sum)
+		sort.SliceStable(r.topPrompts, func(i, j int) bool { return r.topPrompts[i].rankBefore(r.topPrompts[j]) })
+		if len(r.topPrompts) > r.topN {
+			r.topPrompts = r.topPrompts[:r.topN]
+		}
+	}
+	r.mu.Unlock()
+
+	if r.sink != nil && !replayed {
+		r.sink.Write(Event{Time: time.Now(), Target: r.target, Summary: &sum})
+	}
+	return sum, true
+}
+
+func (r *report) maybeAddTopLocked(off OffendingResponse) {
 	if r.topN <= 0 {
 		return
 	}
@@ -327,6 +453,9 @@ func (r *report) LogSummary() {
 	defer r.mu.Unlock()
 
 	log.Printf("%s: sent=%d errs=%d", styledKey("done", ansiGreen, ansiBold), r.total, r.errs)
+	if r.resumed > 0 {
+		log.Printf("%s: %d results from the previous run", styledKey("resumed", ansiCyan, ansiBold), r.resumed)
+	}
 	log.Printf("%s: %s", styledKey("severity", ansiYellow, ansiBold), styledValue(r.maxSeverity.String(), ansiYellow, ansiBold))
 	if r.retried > 0 {
 		log.Printf("%s: requests=%d retries=%d", styledKey("retried", ansiYellow, ansiBold), r.retried, r.retries)
@@ -337,6 +466,9 @@ func (r *report) LogSummary() {
 	if r.firstErr != nil {
 		log.Printf("%s: %v", styledKey("first_error", ansiRed, ansiBold), r.firstErr)
 	}
+	if r.budgets != nil {
+		log.Printf("%s: %s", styledKey("budget", ansiYellow, ansiBold), r.budgets.Summary())
+	}
 
 	if r.latencyCount > 0 {
 		avg := time.Duration(int64(r.latencyTotal) / int64(r.latencyCount))
@@ -396,6 +528,11 @@ func (r *report) LogSummary() {
 		}
 	}
 
+	if r.samples > 1 {
+		r.logSampledLocked()
+		return
+	}
+
 	if len(r.top) > 0 {
 		log.Printf("%s:", styledKey("top_offenders", ansiMagenta, ansiBold))
 		for i, off := range r.top {
@@ -428,6 +565,47 @@ func (r *report) LogSummary() {
 	}
 }
 
+// logSampledLocked prints attack success rates with 95% Wilson intervals: per category across all
+// answered samples, then the top prompts by success rate.
+func (r *report) logSampledLocked() {
+	if r.sampleTrials > 0 {
+		log.Printf("%s: (samples with hits / answered samples, 95%% CI)", styledKey("attack_success", ansiMagenta, ansiBold))
+		cats := make([]string, 0, len(r.categorySamples))
+		for c := range r.categorySamples {
+			cats = append(cats, string(c))
+		}
+		sort.Strings(cats)
+		for _, c := range cats {
+			k := r.categorySamples[MarkerCategory(c)]
+			log.Printf("%s: %s", styledKey("asr_"+c, ansiMagenta, ansiBold), rateText(k, r.sampleTrials))
+		}
+	}
+	if len(r.topPrompts) == 0 {
+		return
+	}
+	log.Printf("%s:", styledKey("top_offenders", ansiMagenta, ansiBold))
+	for i, p := range r.topPrompts {
+		log.Printf(
+			"%s asr=%s max_score=%s status=%s categories=%s",
+			styledValue("#"+intToString(i+1), ansiMagenta, ansiBold),
+			styledValue(rateText(p.Successes, p.Samples), ansiYellow, ansiBold),
+			styledValue(intToString(p.MaxScore), ansiYellow),
+			styledStatusCode(p.StatusCode),
+			styledValue(p.categoriesText(), ansiCyan),
+		)
+		log.Printf("%s%q", styledDetailPrefix("  prompt="), previewOneLine(p.Prompt, 140))
+		if p.ResponsePreview != "" {
+			log.Printf("%s%q", styledDetailPrefix("  resp="), p.ResponsePreview)
+		}
+	}
+}
+
+// rateText renders "k/n (p%, CI lo-hi%)".
+func rateText(k, n int) string {
+	lo, hi := wilsonInterval(k, n)
+	return fmt.Sprintf("%d/%d (%.0f%%, CI %.0f-%.0f%%)", k, n, 100*float64(k)/float64(n), 100*lo, 100*hi)
+}
+
 func offenseScoreWeighted(hits []MarkerHit, policy map[MarkerCategory]categoryPolicy) int {
 	if len(hits) == 0 {
 		return 0
@@ -1,4 +1,4 @@
-package main
+package engine
 
 import (
 	"bytes"
@@ -10,13 +10,13 @@ import (
 )
 
 func TestThresholdExceededError_ExitCodeBuckets(t *testing.T) {
-	if (thresholdExceededError{Severity: severityWarn}).ExitCode() != 2 {
+	if (ThresholdExceededError{Severity: SeverityWarn}).ExitCode() != 2 {
 		t.Fatalf("warn should map to 2")
 	}
-	if (thresholdExceededError{Severity: severityError}).ExitCode() != 3 {
+	if (ThresholdExceededError{Severity: SeverityError}).ExitCode() != 3 {
 		t.Fatalf("error should map to 3")
 	}
-	if (thresholdExceededError{Severity: severityCritical}).ExitCode() != 4 {
+	if (ThresholdExceededError{Severity: SeverityCritical}).ExitCode() != 4 {
 		t.Fatalf("critical should map to 4")
 	}
 }
@@ -0,0 +1,207 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// rawRequestTemplate is a Burp-style raw HTTP/1.1 request (request line, headers, blank line, body)
+// with {{prompt}} allowed in the request target, any header value and the body.
+type rawRequestTemplate struct {
+	method  string
+	target  string // origin-form path[?query] or absolute URL
+	headers []rawHeader
+	body    string
+	// bodyEscape is chosen from the file's Content-Type.
+	bodyEscape func(string) string
+}
+
+type rawHeader struct {
+	key   string
+	value string
+}
+
+func loadRawRequestTemplate(path string) (*rawRequestTemplate, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("request file: read %q: %w", path, err)
+	}
+	return parseRawRequestTemplate(string(b))
+}
+
+func parseRawRequestTemplate(s string) (*rawRequestTemplate, error) {
+	s = strings.TrimLeft(s, "\r\n")
+	if strings.TrimSpace(s) == "" {
+		return nil, fmt.Errorf("request file: empty")
+	}
+	br := bufio.NewReader(strings.NewReader(s))
+	tp := textproto.NewReader(br)
+
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, fmt.Errorf("request file: read request line: %w", err)
+	}
+	parts := strings.Fields(line)
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("request file: line 1: expected 'METHOD TARGET HTTP/1.1', got %q", line)
+	}
+	if len(parts) == 3 && !strings.HasPrefix(parts[2], "HTTP/") {
+		return nil, fmt.Errorf("request file: line 1: unexpected protocol %q", parts[2])
+	}
+	t := &rawRequestTemplate{method: strings.ToUpper(parts[0]), target: parts[1]}
+	if !strings.HasPrefix(t.target, "/") && !strings.Contains(t.target, "://") {
+		return nil, fmt.Errorf("request file: line 1: target must be a path or absolute URL, got %q", t.target)
+	}
+
+	for n := 2; ; n++ {
+		hl, err := tp.ReadLine()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("request file: line %d: %w", n, err)
+		}
+		if hl == "" {
+			break
+		}
+		k, v, ok := strings.Cut(hl, ":")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("request file: line %d: expected 'Key: Value'", n)
+		}
+		key := http.CanonicalHeaderKey(strings.TrimSpace(k))
+		switch key {
+		case "Content-Length", "Transfer-Encoding":
+			// Recomputed for every rendered body.
+			continue
+		}
+		t.headers = append(t.headers, rawHeader{key: key, value: strings.TrimSpace(v)})
+	}
+
+	rest, err := io.ReadAll(br)
+	if err != nil {
+		return nil, fmt.Errorf("request file: read body: %w", err)
+	}
+	t.body = string(rest)
+	t.bodyEscape = bodyEscaperFor(t.header("Content-Type"))
+	return t, nil
+}
+
+func (t *rawRequestTemplate) header(key string) string {
+	for _, h := range t.headers {
+		if h.key == key {
+			return h.value
+		}
+	}
+	return ""
+}
+
+// bodyEscaperFor picks how {{prompt}} is encoded in the body: JSON string escaping for JSON media types,
+// form encoding for urlencoded forms, raw otherwise.
+func bodyEscaperFor(contentType string) func(string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return rawEscape
+	}
+	switch {
+	case mt == "application/json" || strings.HasSuffix(mt, "+json"):
+		return jsonStringEscape
+	case mt == "application/x-www-form-urlencoded":
+		return url.QueryEscape
+	default:
+		return rawEscape
+	}
+}
+
+func rawEscape(s string) string { return s }
+
+// jsonStringEscape returns s escaped for use inside a JSON string literal (without the quotes).
+func jsonStringEscape(s string) string {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return s
+	}
+	return string(b[1 : len(b)-1])
+}
+
+// headerValueEscape keeps a prompt from splitting a header line.
+func headerValueEscape(s string) string {
+	return strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(s)
+}
+
+// Render builds the request. base supplies the scheme and host (from -url); when nil, the file's Host
+// header is used with https. The request target's path and query come from the file.
+func (t *rawRequestTemplate) Render(base *url.URL, in promptInput) (targetRequest, error) {
+	target := t.target
+	var pathPart, queryPart string
+	if i := strings.IndexByte(target, '?'); i >= 0 {
+		pathPart, queryPart = target[:i], target[i+1:]
+	} else {
+		pathPart = target
+	}
+	pathPart, err := in.expand(pathPart, url.PathEscape)
+	if err != nil {
+		return targetRequest{}, fmt.Errorf("request file: %w", err)
+	}
+	queryPart, err = in.expand(queryPart, url.QueryEscape)
+	if err != nil {
+		return targetRequest{}, fmt.Errorf("request file: %w", err)
+	}
+	rendered := pathPart
+	if queryPart != "" {
+		rendered += "?" + queryPart
+	}
+
+	var u *url.URL
+	if strings.Contains(pathPart, "://") {
+		u, err = url.Parse(rendered)
+		if err != nil {
+			return targetRequest{}, fmt.Errorf("request file: render target: %w", err)
+		}
+		if base != nil {
+			u.Scheme, u.Host = base.Scheme, base.Host
+		}
+	} else {
+		ref, err := url.Parse(rendered)
+		if err != nil {
+			return targetRequest{}, fmt.Errorf("request file: render target: %w", err)
+		}
+		u = &url.URL{Scheme: "https", Host: t.header("Host")}
+		if base != nil {
+			u.Scheme, u.Host = base.Scheme, base.Host
+		}
+		if u.Host == "" {
+			return targetRequest{}, fmt.Errorf("request file: no Host header and no -url to take the host from")
+		}
+		u.Path, u.RawPath, u.RawQuery = ref.Path, ref.RawPath, ref.RawQuery
+	}
+
+	h := make(http.Header, len(t.headers))
+	for _, hd := range t.headers {
+		if hd.key == "Host" {
+			continue // taken from the URL
+		}
+		v, err := in.expand(hd.value, headerValueEscape)
+		if err != nil {
+			return targetRequest{}, fmt.Errorf("request file: header %s: %w", hd.key, err)
+		}
+		h.Add(hd.key, v)
+	}
+
+	var body []byte
+	if t.body != "" {
+		b, err := in.expand(t.body, t.bodyEscape)
+		if err != nil {
+			return targetRequest{}, fmt.Errorf("request file: body: %w", err)
+		}
+		body = []byte(b)
+	}
+	return targetRequest{Method: t.method, URL: u, Header: h, Body: body}, nil
+}
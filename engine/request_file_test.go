@@ -0,0 +1,155 @@
+package engine
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const rawJSONRequest = "POST /api/{{prompt}}/chat?q={{prompt}}&v=1 HTTP/1.1\r\n" +
+	"Host: chat.example.test\r\n" +
+	"Content-Type: application/json\r\n" +
+	"Content-Length: 999\r\n" +
+	"X-Prompt: {{prompt}}\r\n" +
+	"\r\n" +
+	`{"input":"{{prompt}}","raw":true}`
+
+func TestRawRequestTemplate_RenderEscapesPerInsertionPoint(t *testing.T) {
+	tmpl, err := parseRawRequestTemplate(rawJSONRequest)
+	if err != nil {
+		t.Fatalf("parseRawRequestTemplate: %v", err)
+	}
+	prompt := "a/b \"c\"\nd&e"
+	tr, err := tmpl.Render(nil, promptInput{Prompt: prompt})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if tr.Method != http.MethodPost {
+		t.Fatalf("unexpected method %q", tr.Method)
+	}
+	if tr.URL.Scheme != "https" || tr.URL.Host != "chat.example.test" {
+		t.Fatalf("unexpected scheme/host: %s", tr.URL)
+	}
+	if tr.URL.Path != "/api/"+prompt+"/chat" {
+		t.Fatalf("unexpected decoded path %q", tr.URL.Path)
+	}
+	if got := tr.URL.Query().Get("q"); got != prompt {
+		t.Fatalf("unexpected query value %q", got)
+	}
+	if got := tr.Header.Get("X-Prompt"); got != `a/b "c" d&e` {
+		t.Fatalf("unexpected header value %q", got)
+	}
+	if tr.Header.Get("Content-Length") != "" || tr.Header.Get("Host") != "" {
+		t.Fatalf("Content-Length/Host should not be copied: %#v", tr.Header)
+	}
+	var body map[string]any
+	if err := json.Unmarshal(tr.Body, &body); err != nil {
+		t.Fatalf("body is not valid JSON: %v (%q)", err, string(tr.Body))
+	}
+	if body["input"] != prompt {
+		t.Fatalf("unexpected body input %#v", body["input"])
+	}
+
+	base, _ := url.Parse("http://127.0.0.1:8080/ignored")
+	tr, err = tmpl.Render(base, promptInput{Prompt: "x"})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if got := tr.URL.String(); got != "http://127.0.0.1:8080/api/x/chat?q=x&v=1" {
+		t.Fatalf("unexpected URL with -url base: %s", got)
+	}
+}
+
+func TestRawRequestTemplate_RawAndFormBodies(t *testing.T) {
+	tmpl, err := parseRawRequestTemplate("POST /f HTTP/1.1\nHost: h\nContent-Type: application/x-www-form-urlencoded\n\nmsg={{prompt}}&x=1")
+	if err != nil {
+		t.Fatalf("parseRawRequestTemplate: %v", err)
+	}
+	tr, err := tmpl.Render(nil, promptInput{Prompt: "a b&c"})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if string(tr.Body) != "msg=a+b%26c&x=1" {
+		t.Fatalf("unexpected form body %q", string(tr.Body))
+	}
+
+	tmpl, err = parseRawRequestTemplate("PUT /t HTTP/1.1\nHost: h\nContent-Type: text/plain\n\n<<{{prompt}}>>")
+	if err != nil {
+		t.Fatalf("parseRawRequestTemplate: %v", err)
+	}
+	tr, err = tmpl.Render(nil, promptInput{Prompt: `"x"`})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if string(tr.Body) != `<<"x">>` {
+		t.Fatalf("unexpected raw body %q", string(tr.Body))
+	}
+}
+
+func TestParseRawRequestTemplate_Errors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"GARBAGE\n\n",
+		"GET relative HTTP/1.1\n\n",
+		"GET / SPDY/3\n\n",
+		"GET / HTTP/1.1\nNoColon\n\n",
+	} {
+		if _, err := parseRawRequestTemplate(s); err == nil {
+			t.Fatalf("expected error for %q", s)
+		}
+	}
+	tmpl, err := parseRawRequestTemplate("GET /x HTTP/1.1\n\n")
+	if err != nil {
+		t.Fatalf("parseRawRequestTemplate: %v", err)
+	}
+	if _, err := tmpl.Render(nil, promptInput{Prompt: "p"}); err == nil {
+		t.Fatalf("expected error without Host header or -url")
+	}
+}
+
+func TestSendOne_RequestFile(t *testing.T) {
+	type seen struct {
+		method, path, ct, custom, cookie, body string
+	}
+	got := make(chan seen, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got <- seen{r.Method, r.URL.Path, r.Header.Get("Content-Type"), r.Header.Get("X-Custom"), r.Header.Get("Cookie"), string(b)}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "req.txt")
+	raw := "PATCH /v2/{{prompt}} HTTP/1.1\nHost: ignored.test\nContent-Type: text/plain\nX-Custom: file\nCookie: a=1\n\nsay {{prompt}}"
+	if err := os.WriteFile(path, []byte(raw), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	cfg := Config{URL: srv.URL, Method: http.MethodPost, RequestFile: path, Timeout: 5 * time.Second}
+	tmpl, err := loadRequestTemplate(cfg)
+	if err != nil {
+		t.Fatalf("loadRequestTemplate: %v", err)
+	}
+	cfg.reqTemplate = tmpl
+
+	res := sendOne(t.Context(), srv.Client(), cfg, http.Header{"X-Custom": []string{"flag"}}, []*http.Cookie{{Name: "b", Value: "2"}}, 1, promptInput{Prompt: "hi"})
+	if res.Err != nil {
+		t.Fatalf("unexpected err: %v", res.Err)
+	}
+	s := <-got
+	want := seen{method: http.MethodPatch, path: "/v2/hi", ct: "text/plain", custom: "flag", body: "say hi"}
+	if s.method != want.method || s.path != want.path || s.ct != want.ct || s.custom != want.custom || s.body != want.body {
+		t.Fatalf("unexpected request: got %#v want %#v", s, want)
+	}
+	if !strings.Contains(s.cookie, "a=1") || !strings.Contains(s.cookie, "b=2") {
+		t.Fatalf("expected file and -cookies-file cookies, got %q", s.cookie)
+	}
+}
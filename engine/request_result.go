@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"net/http"
+	"time"
+)
+
+type RequestResult struct {
+	Seq      int
+	WorkerID int
+	Prompt   string
+	// PromptID is the corpus item's key (its id, else a content hash); -resume matches on it.
+	PromptID string
+	// ConversationID and Turn (1-based) are set for turns of a multi-turn conversation.
+	ConversationID string
+	Turn           int
+	// Sample is the 1-based repetition index with -samples > 1, else 0.
+	Sample        int
+	Attempts      int
+	Retries       int
+	StatusCode    int
+	Headers       http.Header
+	Latency       time.Duration
+	Body          []byte
+	BodyTruncated bool
+	// Text is the assistant text extracted from Body by the target adapter; nil means analyze Body as-is.
+	Text []byte
+	// Streamed is set for text/event-stream responses; Text then holds the reassembled transcript.
+	Streamed          bool
+	FirstTokenLatency time.Duration
+	TokenEvents       int
+	// GraphQLErrors holds the errors[].message values of a -target-kind graphql response.
+	GraphQLErrors []string
+	Err           error
+	// exchange is the final attempt's request and full response body, captured only with -record.
+	exchange *exchangeCapture
+}
+
+// AnalysisText is what body markers run against: the extracted text when available, else the raw body.
+func (r RequestResult) AnalysisText() []byte {
+	if r.Text != nil {
+		return r.Text
+	}
+	return r.Body
+}
@@ -0,0 +1,506 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	promptPlaceholder  = "{{prompt}}"
+	historyPlaceholder = "{{history}}"
+)
+
+// promptInput is everything a single request is rendered from.
+type promptInput struct {
+	Prompt string
+	// History holds the prior turns of a conversation (alternating user/assistant messages).
+	History []chatMessage
+	// Vars backs {{var:name}}; Seq, UUID and Time back {{seq}}, {{uuid}} and {{timestamp}}.
+	Vars map[string]string
+	Seq  int
+	UUID string
+	Time time.Time
+	// Sample and Seed back {{sample}} and {{seed}}; they stay fixed across the turns of one sample.
+	Sample int
+	Seed   uint32
+}
+
+const (
+	TargetKindHTTP       = "http"
+	TargetKindOpenAIChat = "openai-chat"
+)
+
+type requestTemplate struct {
+	body       bodyTemplate
+	query      *queryTemplate
+	openAIChat *openAIChatTemplate
+	graphQL    *graphQLTemplate
+	raw        *rawRequestTemplate
+}
+
+// targetRequest is a fully rendered request, before base headers, cookies and auth are applied.
+type targetRequest struct {
+	Method string
+	URL    *url.URL
+	Header http.Header
+	Body   []byte
+}
+
+func validateTargetKind(cfg Config) error {
+	switch cfg.TargetKind {
+	case "", TargetKindHTTP:
+		return nil
+	case TargetKindOpenAIChat:
+		if cfg.BodyTemplate != "" || cfg.BodyTemplateFile != "" {
+			return fmt.Errorf("-body-template is not supported with -target-kind %s (the request body is built from -model/-system-prompt)", TargetKindOpenAIChat)
+		}
+		if strings.EqualFold(strings.TrimSpace(cfg.Method), http.MethodGet) {
+			return fmt.Errorf("-target-kind %s requires a non-GET -method", TargetKindOpenAIChat)
+		}
+		if cfg.MaxTokens < 0 {
+			return fmt.Errorf("-max-tokens must be >= 0")
+		}
+		return nil
+	case TargetKindGraphQL:
+		return nil // validateGraphQL
+	default:
+		return fmt.Errorf("unknown -target-kind %q (expected %s|%s|%s)", cfg.TargetKind, TargetKindHTTP, TargetKindOpenAIChat, TargetKindGraphQL)
+	}
+}
+
+func loadRequestTemplate(cfg Config) (requestTemplate, error) {
+	if cfg.RequestFile != "" {
+		t, err := loadRawRequestTemplate(cfg.RequestFile)
+		if err != nil {
+			return requestTemplate{}, err
+		}
+		return requestTemplate{raw: t}, nil
+	}
+
+	if cfg.TargetKind == TargetKindOpenAIChat || cfg.TargetKind == TargetKindGraphQL {
+		var out requestTemplate
+		if cfg.TargetKind == TargetKindGraphQL {
+			t, err := loadGraphQLTemplate(cfg)
+			if err != nil {
+				return requestTemplate{}, err
+			}
+			out.graphQL = t
+		} else {
+			out.openAIChat = newOpenAIChatTemplate(cfg)
+		}
+		if cfg.QueryTemplate != "" || cfg.QueryTemplateFile != "" {
+			s, err := loadTemplateText(cfg.QueryTemplate, cfg.QueryTemplateFile, "query template")
+			if err != nil {
+				return requestTemplate{}, err
+			}
+			t, err := parseQueryTemplate(s)
+			if err != nil {
+				return requestTemplate{}, err
+			}
+			out.query = t
+		}
+		return out, nil
+	}
+
+	hasBodyFormat := cfg.BodyFormat != "" && cfg.BodyFormat != BodyFormatJSON
+	if cfg.BodyTemplate == "" && cfg.BodyTemplateFile == "" && cfg.QueryTemplate == "" && cfg.QueryTemplateFile == "" && !hasBodyFormat {
+		return requestTemplate{}, nil
+	}
+
+	if cfg.Method == http.MethodGet && (cfg.BodyTemplate != "" || cfg.BodyTemplateFile != "") {
+		return requestTemplate{}, fmt.Errorf("-body-template is not supported with -method GET (GET requests do not send a body)")
+	}
+
+	var out requestTemplate
+
+	if cfg.BodyTemplate != "" || cfg.BodyTemplateFile != "" || hasBodyFormat {
+		var s string
+		if cfg.BodyTemplate != "" || cfg.BodyTemplateFile != "" {
+			var err error
+			s, err = loadTemplateText(cfg.BodyTemplate, cfg.BodyTemplateFile, "body template")
+			if err != nil {
+				return requestTemplate{}, err
+			}
+		}
+		t, err := parseBodyTemplate(cfg.BodyFormat, s)
+		if err != nil {
+			return requestTemplate{}, err
+		}
+		out.body = t
+	}
+
+	if cfg.QueryTemplate != "" || cfg.QueryTemplateFile != "" {
+		s, err := loadTemplateText(cfg.QueryTemplate, cfg.QueryTemplateFile, "query template")
+		if err != nil {
+			return requestTemplate{}, err
+		}
+		t, err := parseQueryTemplate(s)
+		if err != nil {
+			return requestTemplate{}, err
+		}
+		out.query = t
+	}
+
+	return out, nil
+}
+
+func loadTemplateText(inline string, path string, label string) (string, error) {
+	if inline != "" && path != "" {
+		return "", fmt.Errorf("%s: specify either inline or file", label)
+	}
+	if inline != "" {
+		if strings.TrimSpace(inline) == "" {
+			return "", fmt.Errorf("%s: template is empty", label)
+		}
+		return inline, nil
+	}
+	if path == "" {
+		return "", fmt.Errorf("%s: missing template (internal error)", label)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%s: read %q: %w", label, path, err)
+	}
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return "", fmt.Errorf("%s: %q is empty", label, path)
+	}
+	return s, nil
+}
+
+// buildTargetRequest renders the request for one prompt: from -request-file when set, otherwise from
+// -url/-method and the body/query templates (see buildTargetURLAndBody).
+func buildTargetRequest(cfg Config, in promptInput) (targetRequest, error) {
+	if cfg.reqTemplate.raw != nil {
+		var base *url.URL
+		if cfg.URL != "" {
+			u, err := url.Parse(cfg.URL)
+			if err != nil {
+				return targetRequest{}, fmt.Errorf("parse -url: %w", err)
+			}
+			base = u
+		}
+		return cfg.reqTemplate.raw.Render(base, in)
+	}
+
+	u, body, err := buildTargetURLAndBody(cfg, in)
+	if err != nil {
+		return targetRequest{}, err
+	}
+	h := make(http.Header)
+	if cfg.Method != http.MethodGet {
+		ct := "application/json"
+		if cfg.reqTemplate.body != nil {
+			ct = cfg.reqTemplate.body.ContentType()
+		}
+		h.Set("Content-Type", ct)
+	}
+	return targetRequest{Method: cfg.Method, URL: u, Header: h, Body: body}, nil
+}
+
+// buildTargetURLAndBody applies default behavior or user-provided request templates.
+//
+// Defaults (backward compatible):
+// - GET: attaches ?prompt=...
+// - non-GET: sends JSON {"prompt": "..."} with Content-Type: application/json (unless overridden via headers).
+//
+// -body-format form|multipart|raw swaps the JSON body template for one of the templates in body_format.go.
+//
+// With -target-kind openai-chat the body is a chat-completions request instead; with graphql it is the
+// {query, variables, operationName} envelope.
+func buildTargetURLAndBody(cfg Config, in promptInput) (*url.URL, []byte, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse -url: %w", err)
+	}
+
+	if cfg.reqTemplate.query != nil {
+		if err := cfg.reqTemplate.query.Apply(u, in); err != nil {
+			return nil, nil, err
+		}
+	} else if cfg.Method == http.MethodGet {
+		q := u.Query()
+		q.Set(defaultJSONKey, in.Prompt)
+		u.RawQuery = q.Encode()
+	}
+
+	if cfg.Method == http.MethodGet {
+		return u, nil, nil
+	}
+
+	if cfg.reqTemplate.openAIChat != nil {
+		b, err := cfg.reqTemplate.openAIChat.Render(in)
+		if err != nil {
+			return nil, nil, err
+		}
+		return u, b, nil
+	}
+
+	if cfg.reqTemplate.graphQL != nil {
+		b, err := cfg.reqTemplate.graphQL.Render(in)
+		if err != nil {
+			return nil, nil, err
+		}
+		return u, b, nil
+	}
+
+	if cfg.reqTemplate.body != nil {
+		b, err := cfg.reqTemplate.body.Render(in)
+		if err != nil {
+			return nil, nil, err
+		}
+		return u, b, nil
+	}
+
+	payload := map[string]string{defaultJSONKey: in.Prompt}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshal default json payload: %w", err)
+	}
+	return u, b, nil
+}
+
+type jsonBodyTemplate struct {
+	root any
+}
+
+func parseJSONBodyTemplate(s string) (*jsonBodyTemplate, error) {
+	var root any
+	dec := json.NewDecoder(strings.NewReader(s))
+	if err := dec.Decode(&root); err != nil {
+		return nil, fmt.Errorf("body template: invalid JSON: %w", err)
+	}
+	// Ensure there is exactly one JSON value.
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		if err == nil {
+			return nil, fmt.Errorf("body template: invalid JSON: extra trailing content")
+		}
+		return nil, fmt.Errorf("body template: invalid JSON: %w", err)
+	}
+	return &jsonBodyTemplate{root: root}, nil
+}
+
+func (t *jsonBodyTemplate) Render(in promptInput) ([]byte, error) {
+	out, err := replacePlaceholdersInJSON(t.root, in)
+	if err != nil {
+		return nil, fmt.Errorf("body template: %w", err)
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		return nil, fmt.Errorf("body template: render: %w", err)
+	}
+	return b, nil
+}
+
+// replacePlaceholdersInJSON substitutes {{prompt}}, {{var:...}} and the other placeholders inside string
+// values. A string that is exactly {{history}} becomes the conversation history as an array of
+// {"role","content"} messages; as an array element it is spliced in place, so
+// ["{{history}}", {"role":"user",...}] yields a flat messages list.
+func replacePlaceholdersInJSON(v any, in promptInput) (any, error) {
+	switch x := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(x))
+		for k, vv := range x {
+			rv, err := replacePlaceholdersInJSON(vv, in)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = rv
+		}
+		return m, nil
+	case []any:
+		out := make([]any, 0, len(x))
+		for i := range x {
+			if s, ok := x[i].(string); ok && s == historyPlaceholder {
+				out = append(out, historyJSON(in.History)...)
+				continue
+			}
+			rv, err := replacePlaceholdersInJSON(x[i], in)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, rv)
+		}
+		return out, nil
+	case string:
+		if x == historyPlaceholder {
+			return historyJSON(in.History), nil
+		}
+		return in.expand(x, rawEscape)
+	default:
+		return v, nil
+	}
+}
+
+func historyJSON(history []chatMessage) []any {
+	out := make([]any, 0, len(history))
+	for _, m := range history {
+		out = append(out, map[string]any{"role": m.Role, "content": m.Content})
+	}
+	return out
+}
+
+type queryTemplate struct {
+	values url.Values
+}
+
+func parseQueryTemplate(s string) (*queryTemplate, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "?") {
+		s = strings.TrimPrefix(s, "?")
+	}
+	vs, err := url.ParseQuery(s)
+	if err != nil {
+		return nil, fmt.Errorf("query template: invalid query string: %w", err)
+	}
+	return &queryTemplate{values: vs}, nil
+}
+
+func (t *queryTemplate) Apply(u *url.URL, in promptInput) error {
+	if u == nil {
+		return fmt.Errorf("query template: nil url (internal error)")
+	}
+	q := u.Query()
+	for k, vals := range t.values {
+		q.Del(k)
+		for _, raw := range vals {
+			v, err := in.expand(raw, rawEscape)
+			if err != nil {
+				return fmt.Errorf("query template: %w", err)
+			}
+			q.Add(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return nil
+}
+
+// openAIChatTemplate renders OpenAI-compatible /chat/completions requests.
+type openAIChatTemplate struct {
+	model        string
+	systemPrompt string
+	temperature  *float64
+	maxTokens    int
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIChatRequest struct {
+	Model       string        `json:"model,omitempty"`
+	Messages    []chatMessage `json:"messages"`
+	Temperature *float64      `json:"temperature,omitempty"`
+	MaxTokens   int           `json:"max_tokens,omitempty"`
+}
+
+func newOpenAIChatTemplate(cfg Config) *openAIChatTemplate {
+	t := &openAIChatTemplate{
+		model:        cfg.Model,
+		systemPrompt: cfg.SystemPrompt,
+		maxTokens:    cfg.MaxTokens,
+	}
+	if cfg.TemperatureSet {
+		temp := cfg.Temperature
+		t.temperature = &temp
+	}
+	return t
+}
+
+func (t *openAIChatTemplate) Render(in promptInput) ([]byte, error) {
+	model, err := in.expand(t.model, rawEscape)
+	if err != nil {
+		return nil, fmt.Errorf("openai-chat: -model: %w", err)
+	}
+	system, err := in.expand(t.systemPrompt, rawEscape)
+	if err != nil {
+		return nil, fmt.Errorf("openai-chat: -system-prompt: %w", err)
+	}
+	req := openAIChatRequest{
+		Model:       model,
+		Temperature: t.temperature,
+		MaxTokens:   t.maxTokens,
+	}
+	if system != "" {
+		req.Messages = append(req.Messages, chatMessage{Role: "system", Content: system})
+	}
+	req.Messages = append(req.Messages, in.History...)
+	req.Messages = append(req.Messages, chatMessage{Role: "user", Content: in.Prompt})
+	b, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("openai-chat: render: %w", err)
+	}
+	return b, nil
+}
+
+// extractResponseText returns the assistant text markers should see, or nil to analyze the raw body.
+// -response-extract wins over the adapter's own extraction when it matches.
+func extractResponseText(cfg Config, body []byte) []byte {
+	if cfg.extractor != nil {
+		if text, ok := cfg.extractor.Extract(body); ok {
+			return text
+		}
+	}
+	if cfg.reqTemplate.openAIChat != nil {
+		if text, ok := extractOpenAIChatText(body); ok {
+			return text
+		}
+	}
+	if cfg.reqTemplate.graphQL != nil {
+		if text, ok := cfg.reqTemplate.graphQL.extractText(body); ok {
+			return text
+		}
+	}
+	return nil
+}
+
+// extractOpenAIChatText joins choices[].message.content. Content may be a string or an array of
+// {"type":"text","text":"..."} parts. Non-JSON bodies (error pages, truncated responses) and replies
+// without any text (tool calls with null content) report ok=false, so markers see the raw body.
+func extractOpenAIChatText(body []byte) ([]byte, bool) {
+	var resp struct {
+		Choices []struct {
+			Message struct {
+				Content json.RawMessage `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || len(resp.Choices) == 0 {
+		return nil, false
+	}
+	var parts []string
+	for _, c := range resp.Choices {
+		raw := c.Message.Content
+		if len(raw) == 0 || string(raw) == "null" {
+			continue
+		}
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			parts = append(parts, s)
+			continue
+		}
+		var segs []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(raw, &segs); err != nil {
+			return nil, false
+		}
+		for _, seg := range segs {
+			if seg.Text != "" {
+				parts = append(parts, seg.Text)
+			}
+		}
+	}
+	if len(parts) == 0 {
+		return nil, false
+	}
+	return []byte(strings.Join(parts, "\n")), true
+}
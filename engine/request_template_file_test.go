@@ -1,4 +1,4 @@
-package main
+package engine
 
 import (
 	"net/http"
@@ -18,11 +18,11 @@ func TestLoadRequestTemplate_FromFiles(t *testing.T) {
 		t.Fatalf("WriteFile: %v", err)
 	}
 
-	cfg := config{
-		targetURL:     "https://example.test/api",
-		method:        http.MethodPost,
-		bodyTmplFile:  bodyPath,
-		queryTmplFile: queryPath,
+	cfg := Config{
+		URL:               "https://example.test/api",
+		Method:            http.MethodPost,
+		BodyTemplateFile:  bodyPath,
+		QueryTemplateFile: queryPath,
 	}
 	tmpl, err := loadRequestTemplate(cfg)
 	if err != nil {
@@ -1,4 +1,4 @@
-package main
+package engine
 
 import (
 	"fmt"
@@ -19,6 +19,7 @@ const (
 	CategoryKeyPhraseLeak    MarkerCategory = "key_phrase_leak"
 	CategoryHTTPError        MarkerCategory = "http_error"
 	CategoryRateLimit        MarkerCategory = "rate_limit"
+	CategoryGraphQLError     MarkerCategory = "graphql_error"
 )
 
 type MarkerHit struct {
@@ -31,7 +32,9 @@ type markerDef struct {
 	id       string
 	category MarkerCategory
 	re       *regexp.Regexp
+	scope    markerScope
 	match    func(status int, headers http.Header) bool
+	count    func(res RequestResult) int
 }
 
 type responseAnalyzer struct {
@@ -53,6 +56,7 @@ func newResponseAnalyzer(cfg markerConfig) (*responseAnalyzer, error) {
 			id:       rm.ID,
 			category: rm.Category,
 			re:       re,
+			scope:    rm.Scope,
 		})
 	}
 
@@ -68,6 +72,13 @@ func newResponseAnalyzer(cfg markerConfig) (*responseAnalyzer, error) {
 		headerPresentMarker(CategoryRateLimit, "retry_after_header", "Retry-After"),
 	)
 
+	// GraphQL errors array (-target-kind graphql).
+	markers = append(markers, markerDef{
+		id:       "errors",
+		category: CategoryGraphQLError,
+		count:    func(res RequestResult) int { return len(res.GraphQLErrors) },
+	})
+
 	slices.SortFunc(markers, func(a, b markerDef) int {
 		if a.category != b.category {
 			return strings.Compare(a.category.String(), b.category.String())
@@ -83,18 +94,32 @@ func (a *responseAnalyzer) Analyze(res RequestResult) []MarkerHit {
 		return nil
 	}
 
+	text := res.AnalysisText()
+	var headers []byte
 	out := make([]MarkerHit, 0, 4)
 	for _, m := range a.markers {
 		var n int
+		body := text
+		switch m.scope {
+		case scopeRaw:
+			body = res.Body
+		case scopeHeaders:
+			if headers == nil {
+				headers = headersText(res.Headers)
+			}
+			body = headers
+		}
 		switch {
-		case m.re != nil && len(res.Body) > 0:
+		case m.re != nil && len(body) > 0:
 			// Cap match counting for pathological responses.
 			const maxMatches = 50
-			n = len(m.re.FindAllIndex(res.Body, maxMatches))
+			n = len(m.re.FindAllIndex(body, maxMatches))
 		case m.match != nil:
 			if m.match(res.StatusCode, res.Headers) {
 				n = 1
 			}
+		case m.count != nil:
+			n = m.count(res)
 		}
 		if n > 0 {
 			out = append(out, MarkerHit{ID: m.category.String() + ":" + m.id, Category: m.category, Count: n})
@@ -137,3 +162,19 @@ func headerPresentMarker(category MarkerCategory, id string, header string) mark
 		},
 	}
 }
+
+// headersText renders response headers as sorted "Key: value" lines for scope=headers markers.
+func headersText(h http.Header) []byte {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		for _, v := range h[k] {
+			b.WriteString(k + ": " + v + "\n")
+		}
+	}
+	return []byte(b.String())
+}
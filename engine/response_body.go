@@ -0,0 +1,221 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const DefaultSSETextPath = "choices[*].delta.content"
+
+func readResponseBody(resp *http.Response, maxBytes int64, stream bool) ([]byte, bool, error) {
+	if resp == nil || resp.Body == nil {
+		return nil, false, nil
+	}
+	if maxBytes < 0 {
+		return nil, false, fmt.Errorf("maxBytes must be >= 0")
+	}
+	if maxBytes == 0 {
+		b, err := io.ReadAll(resp.Body)
+		return b, false, err
+	}
+	// bytes.Buffer and slice indexing use int; reject values that can't fit.
+	maxInt := int64(^uint(0) >> 1)
+	if maxBytes > maxInt {
+		return nil, false, fmt.Errorf("maxBytes too large: %d (max %d)", maxBytes, maxInt)
+	}
+	if stream {
+		return readResponseBodyStream(resp.Body, resp.ContentLength, maxBytes)
+	}
+	return readResponseBodyExact(resp.Body, maxBytes)
+}
+
+func readResponseBodyExact(r io.Reader, maxBytes int64) ([]byte, bool, error) {
+	if maxBytes <= 0 {
+		b, err := io.ReadAll(r)
+		return b, false, err
+	}
+	if maxBytes == math.MaxInt64 {
+		// Can't add +1 safely; fall back to streaming semantics.
+		return readResponseBodyStream(r, -1, maxBytes)
+	}
+	b, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(b)) > maxBytes {
+		return b[:maxBytes], true, nil
+	}
+	return b, false, nil
+}
+
+func readResponseBodyStream(r io.Reader, contentLength int64, maxBytes int64) ([]byte, bool, error) {
+	if maxBytes <= 0 {
+		b, err := io.ReadAll(r)
+		return b, false, err
+	}
+
+	var buf bytes.Buffer
+	// Avoid eagerly allocating maxBytes (which might be large).
+	const preallocCap = 64 * 1024
+	if maxBytes < preallocCap {
+		buf.Grow(int(maxBytes))
+	} else {
+		buf.Grow(preallocCap)
+	}
+
+	tmpFull := make([]byte, 32*1024)
+	for buf.Len() < int(maxBytes) {
+		remaining := int(maxBytes) - buf.Len()
+		tmp := tmpFull
+		if remaining < len(tmp) {
+			tmp = tmp[:remaining]
+		}
+		n, err := r.Read(tmp)
+		if n > 0 {
+			_, _ = buf.Write(tmp[:n])
+		}
+		if err != nil {
+			if err == io.EOF {
+				return buf.Bytes(), false, nil
+			}
+			return nil, false, err
+		}
+	}
+
+	// We stopped because we hit maxBytes. Avoid a "read one more byte" probe; it can
+	// block on slow endpoints. Use Content-Length when available; otherwise, be
+	// conservative and treat it as truncated.
+	if contentLength >= 0 && contentLength <= maxBytes {
+		return buf.Bytes(), false, nil
+	}
+	return buf.Bytes(), true, nil
+}
+
+func isEventStream(resp *http.Response) bool {
+	if resp == nil {
+		return false
+	}
+	mt, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return err == nil && mt == "text/event-stream"
+}
+
+type sseResult struct {
+	Raw         []byte
+	Truncated   bool
+	Text        []byte
+	FirstToken  time.Duration
+	TokenEvents int
+}
+
+// readSSEResponse reads a text/event-stream body, keeping the raw frames (up to maxBytes) as evidence and
+// reassembling the text of each delta into one transcript. textPath selects the delta text from JSON data
+// payloads; with a zero path, or when a payload is not JSON, the data itself is the delta. Reading stops at
+// a "[DONE]" sentinel, EOF or maxBytes. FirstToken is measured from start to the first non-empty delta.
+// Text stays nil when no delta matched, so analysis falls back to the raw frames.
+func readSSEResponse(r io.Reader, maxBytes int64, textPath jsonPath, start time.Time) (sseResult, error) {
+	var out sseResult
+	var raw bytes.Buffer
+	var text strings.Builder
+	var data strings.Builder
+	hasData := false
+
+	dispatch := func() bool {
+		if !hasData {
+			return false
+		}
+		payload := data.String()
+		data.Reset()
+		hasData = false
+		if strings.TrimSpace(payload) == "[DONE]" {
+			return true
+		}
+		delta := streamDeltaText(payload, textPath)
+		if delta == "" {
+			return false
+		}
+		if out.TokenEvents == 0 {
+			out.FirstToken = time.Since(start)
+		}
+		out.TokenEvents++
+		text.WriteString(delta)
+		return false
+	}
+
+	// feed handles one line and reports whether the stream is done.
+	feed := func(line string) bool {
+		field := strings.TrimRight(line, "\r\n")
+		if field == "" {
+			return dispatch()
+		}
+		if !strings.HasPrefix(field, ":") {
+			name, value, _ := strings.Cut(field, ":")
+			value = strings.TrimPrefix(value, " ")
+			if name == "data" {
+				if hasData {
+					data.WriteByte('\n')
+				}
+				data.WriteString(value)
+				hasData = true
+			}
+		}
+		return false
+	}
+
+	// Cap the reader so a stream without newlines cannot grow a line past maxBytes.
+	if maxBytes > 0 {
+		r = io.LimitReader(r, maxBytes+1)
+	}
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			if maxBytes > 0 && int64(raw.Len()+len(line)) > maxBytes {
+				// Keep what fits and deliver the event it belongs to, so a cut final event still counts.
+				line = line[:maxBytes-int64(raw.Len())]
+				raw.WriteString(line)
+				out.Truncated = true
+				if !feed(line) {
+					dispatch()
+				}
+				break
+			}
+			raw.WriteString(line)
+			if feed(line) {
+				break
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				dispatch()
+				break
+			}
+			return sseResult{}, err
+		}
+	}
+
+	out.Raw = raw.Bytes()
+	if out.TokenEvents > 0 {
+		out.Text = []byte(text.String())
+	}
+	return out, nil
+}
+
+func streamDeltaText(payload string, textPath jsonPath) string {
+	if textPath.IsZero() {
+		return payload
+	}
+	var v any
+	if err := json.Unmarshal([]byte(payload), &v); err != nil {
+		return payload
+	}
+	s, _ := textPath.LookupText(v, "")
+	return s
+}
@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// responseExtractor picks the assistant text out of a response body for -response-extract:
+// "regex:PATTERN" keeps the first capture group of every match (the whole match without groups);
+// "json:PATH" or a bare PATH selects values with a jsonPath (choices[*].message.content).
+type responseExtractor struct {
+	path jsonPath
+	re   *regexp.Regexp
+}
+
+func parseResponseExtractor(s string) (*responseExtractor, error) {
+	raw := strings.TrimSpace(s)
+	if raw == "" {
+		return nil, fmt.Errorf("-response-extract: empty expression")
+	}
+	if pat, ok := strings.CutPrefix(raw, "regex:"); ok {
+		re, err := regexp.Compile(pat)
+		if err != nil {
+			return nil, fmt.Errorf("-response-extract: %w", err)
+		}
+		return &responseExtractor{re: re}, nil
+	}
+	p, err := parseJSONPath(strings.TrimPrefix(raw, "json:"))
+	if err != nil {
+		return nil, fmt.Errorf("-response-extract: %w", err)
+	}
+	return &responseExtractor{path: p}, nil
+}
+
+// Extract reports ok=false when nothing matched (non-JSON error pages, missing fields), so callers can
+// fall back to the adapter's extraction or the raw body.
+func (e *responseExtractor) Extract(body []byte) ([]byte, bool) {
+	if e.re != nil {
+		matches := e.re.FindAllSubmatch(body, -1)
+		if len(matches) == 0 {
+			return nil, false
+		}
+		parts := make([]string, 0, len(matches))
+		for _, m := range matches {
+			v := m[0]
+			if len(m) > 1 {
+				v = m[1]
+			}
+			parts = append(parts, decodeJSONStringFragment(string(v)))
+		}
+		return []byte(strings.Join(parts, "\n")), true
+	}
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return nil, false
+	}
+	text, ok := e.path.LookupText(v, "\n")
+	if !ok {
+		return nil, false
+	}
+	return []byte(text), true
+}
+
+// decodeJSONStringFragment undoes JSON string escaping (\n, <, \") in text captured from inside a
+// JSON string literal; anything that is not a valid fragment is returned unchanged.
+func decodeJSONStringFragment(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var out string
+	if err := json.Unmarshal([]byte(`"`+s+`"`), &out); err != nil {
+		return s
+	}
+	return out
+}
@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// JSON encoders commonly escape '<' as \u003c, so a raw-body regex for "<system>" never matches.
+const escapedChatBody = `{"id":"chatcmpl-1","model":"gpt-x","choices":[{"message":{"content":"Sure:\n\u003csystem\u003eYou are \"Ava\"\u003c/system\u003e"}}],"usage":{"prompt_tokens":7}}`
+
+func TestResponseExtractor_JSONPathAndRegex(t *testing.T) {
+	cases := []struct {
+		expr string
+		body string
+		want string
+	}{
+		{"choices[*].message.content", escapedChatBody, "Sure:\n<system>You are \"Ava\"</system>"},
+		{"json:$.choices[0].message.content", escapedChatBody, "Sure:\n<system>You are \"Ava\"</system>"},
+		{`regex:"content":"((?:[^"\\]|\\.)*)"`, escapedChatBody, "Sure:\n<system>You are \"Ava\"</system>"},
+		{`regex:<reply>(.*?)</reply>`, "<html><reply>one</reply><reply>two</reply></html>", "one\ntwo"},
+		{`regex:\d{3}-\d{2}-\d{4}`, "ssn 123-45-6789", "123-45-6789"},
+	}
+	for _, c := range cases {
+		e, err := parseResponseExtractor(c.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", c.expr, err)
+		}
+		got, ok := e.Extract([]byte(c.body))
+		if !ok || string(got) != c.want {
+			t.Fatalf("%s: got %q ok=%v, want %q", c.expr, got, ok, c.want)
+		}
+	}
+
+	e, _ := parseResponseExtractor("choices[*].message.content")
+	if _, ok := e.Extract([]byte("<html>502 Bad Gateway</html>")); ok {
+		t.Fatalf("expected no match on a non-JSON body")
+	}
+	for _, bad := range []string{"", "regex:(", "a..b"} {
+		if _, err := parseResponseExtractor(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestSendOne_ResponseExtractWithMarkerScopes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Upstream", "internal-llm-7.corp")
+		_, _ = w.Write([]byte(escapedChatBody))
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "markers.json")
+	if err := os.WriteFile(path, []byte(`{
+  "version": 1,
+  "regexes": [
+    {"id": "system_tag", "category": "system_leak", "pattern": "<system>"},
+    {"id": "usage_field", "category": "system_leak", "pattern": "\"usage\"", "scope": "raw"},
+    {"id": "upstream_host", "category": "system_leak", "pattern": "(?m)^X-Upstream: .*\\.corp$", "scope": "headers"},
+    {"id": "tool_or_function_call", "category": "system_leak", "scope": "raw"}
+  ]
+}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	mcfg, err := loadMarkerConfigFile(path)
+	if err != nil {
+		t.Fatalf("loadMarkerConfigFile: %v", err)
+	}
+	a, err := newResponseAnalyzer(mcfg)
+	if err != nil {
+		t.Fatalf("newResponseAnalyzer: %v", err)
+	}
+
+	cfg := Config{URL: srv.URL, Method: http.MethodPost, Timeout: 5 * time.Second}
+	res := sendOne(t.Context(), srv.Client(), cfg, nil, nil, 1, promptInput{Prompt: "hi"})
+	if hits := a.Analyze(res); hasMarker(hits, "system_leak:system_tag") {
+		t.Fatalf("escaped raw body should not match <system> without extraction: %v", hits)
+	}
+
+	cfg.extractor, _ = parseResponseExtractor("choices[*].message.content")
+	res = sendOne(t.Context(), srv.Client(), cfg, nil, nil, 2, promptInput{Prompt: "hi"})
+	if string(res.Body) != escapedChatBody {
+		t.Fatalf("raw body must be kept for evidence, got %q", res.Body)
+	}
+	hits := a.Analyze(res)
+	for _, id := range []string{"system_leak:system_tag", "system_leak:usage_field", "system_leak:upstream_host"} {
+		if !hasMarker(hits, id) {
+			t.Fatalf("expected %s, got %v", id, hits)
+		}
+	}
+}
+
+func TestLoadMarkerConfigFile_InvalidScope(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "markers.json")
+	if err := os.WriteFile(path, []byte(`{"regexes":[{"id":"x","category":"system_leak","pattern":"x","scope":"body"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadMarkerConfigFile(path); err == nil {
+		t.Fatalf("expected error for unknown scope")
+	}
+}
@@ -0,0 +1,550 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is one result row, as written to -jsonl-out and handed to Config.OnEvent.
+type Event struct {
+	Time          time.Time
+	Seq           int
+	WorkerID      int
+	Prompt        string
+	PromptID      string
+	ConvID        string
+	Turn          int
+	Attempts      int
+	Retries       int
+	StatusCode    int
+	Latency       time.Duration
+	BodyLen       int
+	BodyTruncated bool
+	BodyPreview   string
+	TextPreview   string
+	Streamed      bool
+	FirstToken    time.Duration
+	TokenEvents   int
+	Error         string
+	GraphQLErrors []string
+
+	MarkerHits []MarkerHit
+	Score      int
+	Severity   Severity
+
+	Sample int
+	// Target names the -matrix target that answered; empty outside matrix runs.
+	Target string
+	// Full carries the analyzed response for -jsonl-full-body; nil otherwise.
+	Full *FullResponse
+	// Summary, when set, makes this a -samples prompt_summary event instead of a request.
+	Summary *PromptSummary
+}
+
+// FullResponse is what markers analyzed: the (possibly -max-response-bytes truncated) body, the
+// adapter's extracted text (nil = none) and the response headers.
+type FullResponse struct {
+	Body    []byte
+	Text    []byte
+	Headers http.Header
+}
+
+// redactedValue replaces the values of credential headers in stored results.
+const redactedValue = "[redacted]"
+
+// sensitiveHeaders never reach -jsonl-full-body rows or -record cassettes; the header name is kept.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key", "Api-Key"}
+
+// redactHeaders returns a copy of h with the values of sensitiveHeaders and extra redacted.
+func redactHeaders(h http.Header, extra ...string) http.Header {
+	if len(h) == 0 {
+		return nil
+	}
+	out := h.Clone()
+	for _, name := range append(sensitiveHeaders, extra...) {
+		if name == "" {
+			continue
+		}
+		vs := out[http.CanonicalHeaderKey(name)]
+		for i := range vs {
+			vs[i] = redactedValue
+		}
+	}
+	return out
+}
+
+type resultWriter interface {
+	Write(e Event) error
+	Close() error
+}
+
+type multiResultWriter struct {
+	ws []resultWriter
+}
+
+func (m multiResultWriter) Write(e Event) error {
+	for _, w := range m.ws {
+		if err := w.Write(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eventHook hands each row to Config.OnEvent.
+type eventHook func(Event)
+
+func (h eventHook) Write(e Event) error {
+	h(e)
+	return nil
+}
+
+func (h eventHook) Close() error { return nil }
+
+func (m multiResultWriter) Close() error {
+	var first error
+	for _, w := range m.ws {
+		if err := w.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+type jsonlWriter struct {
+	f  *os.File
+	bw *bufio.Writer
+}
+
+func newJSONLWriter(path string, appendMode bool) (*jsonlWriter, error) {
+	f, size, err := openResultFile(path, appendMode)
+	if err != nil {
+		return nil, fmt.Errorf("create -jsonl-out: %w", err)
+	}
+	w := &jsonlWriter{f: f, bw: bufio.NewWriterSize(f, 256*1024)}
+	// Terminate a row cut short by a killed run so the next row starts on its own line.
+	if size > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, size-1); err == nil && last[0] != '\n' {
+			_ = w.bw.WriteByte('\n')
+		}
+	}
+	return w, nil
+}
+
+// openResultFile truncates path, or with appendMode opens it for appending and reports its current size.
+func openResultFile(path string, appendMode bool) (*os.File, int64, error) {
+	if !appendMode {
+		f, err := os.Create(path)
+		return f, 0, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o666)
+	if err != nil {
+		return nil, 0, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, 0, err
+	}
+	return f, st.Size(), nil
+}
+
+type jsonlRow struct {
+	Time          string      `json:"time"`
+	Seq           int         `json:"seq"`
+	WorkerID      int         `json:"worker_id"`
+	Prompt        string      `json:"prompt"`
+	PromptID      string      `json:"prompt_id,omitempty"`
+	ConvID        string      `json:"conversation_id,omitempty"`
+	Turn          int         `json:"turn,omitempty"`
+	Sample        int         `json:"sample,omitempty"`
+	Target        string      `json:"target,omitempty"`
+	Attempts      int         `json:"attempts"`
+	Retries       int         `json:"retries"`
+	StatusCode    int         `json:"status_code"`
+	LatencyMS     int64       `json:"latency_ms"`
+	BodyLen       int         `json:"body_len"`
+	BodyTruncated bool        `json:"body_truncated"`
+	BodyPreview   string      `json:"body_preview,omitempty"`
+	TextPreview   string      `json:"text_preview,omitempty"`
+	TTFTMS        *int64      `json:"ttft_ms,omitempty"`
+	TokenEvents   *int        `json:"token_events,omitempty"`
+	Error         string      `json:"error,omitempty"`
+	GraphQLErrors []string    `json:"graphql_errors,omitempty"`
+	MarkerHits    []MarkerHit `json:"marker_hits,omitempty"`
+	Score         int         `json:"score"`
+	Severity      string      `json:"severity"`
+	// Body, Text and Headers hold the analyzed response with -jsonl-full-body; poke analyze re-scores
+	// them. Text is absent when the adapter extracted none (markers then ran on Body).
+	Body    *string     `json:"body,omitempty"`
+	Text    *string     `json:"text,omitempty"`
+	Headers http.Header `json:"headers,omitempty"`
+	// Kind is empty for request rows; -samples aggregate rows are promptSummaryRow (kind prompt_summary).
+	Kind string `json:"kind,omitempty"`
+}
+
+// result rebuilds the RequestResult a row was recorded from. Without -jsonl-full-body only the
+// previews are available, so the text preview (else the body preview) stands in for the response.
+func (row jsonlRow) result() RequestResult {
+	res := RequestResult{
+		Seq:            row.Seq,
+		WorkerID:       row.WorkerID,
+		Prompt:         row.Prompt,
+		PromptID:       row.PromptID,
+		ConversationID: row.ConvID,
+		Turn:           row.Turn,
+		Sample:         row.Sample,
+		Attempts:       row.Attempts,
+		Retries:        row.Retries,
+		StatusCode:     row.StatusCode,
+		Headers:        row.Headers,
+		Latency:        time.Duration(row.LatencyMS) * time.Millisecond,
+		BodyTruncated:  row.BodyTruncated,
+		GraphQLErrors:  row.GraphQLErrors,
+	}
+	if row.Error != "" {
+		res.Err = errors.New(row.Error)
+	}
+	if row.TTFTMS != nil {
+		res.Streamed = true
+		res.FirstTokenLatency = time.Duration(*row.TTFTMS) * time.Millisecond
+	}
+	if row.TokenEvents != nil {
+		res.TokenEvents = *row.TokenEvents
+	}
+	if row.Body != nil {
+		res.Body = []byte(*row.Body)
+		if row.Text != nil {
+			res.Text = []byte(*row.Text)
+		}
+		return res
+	}
+	text := row.TextPreview
+	if text == "" {
+		text = row.BodyPreview
+	}
+	res.Text = []byte(text)
+	return res
+}
+
+func (w *jsonlWriter) Write(e Event) error {
+	if e.Summary != nil {
+		row := e.Summary.row()
+		row.Time = e.Time.UTC().Format(time.RFC3339Nano)
+		row.Target = e.Target
+		return w.writeRow(row)
+	}
+	row := jsonlRow{
+		Time:          e.Time.UTC().Format(time.RFC3339Nano),
+		Seq:           e.Seq,
+		WorkerID:      e.WorkerID,
+		Prompt:        e.Prompt,
+		PromptID:      e.PromptID,
+		ConvID:        e.ConvID,
+		Turn:          e.Turn,
+		Sample:        e.Sample,
+		Target:        e.Target,
+		Attempts:      e.Attempts,
+		Retries:       e.Retries,
+		StatusCode:    e.StatusCode,
+		LatencyMS:     e.Latency.Milliseconds(),
+		BodyLen:       e.BodyLen,
+		BodyTruncated: e.BodyTruncated,
+		Error:         e.Error,
+		GraphQLErrors: e.GraphQLErrors,
+		MarkerHits:    e.MarkerHits,
+		Score:         e.Score,
+		Severity:      e.Severity.String(),
+		BodyPreview:   e.BodyPreview,
+		TextPreview:   e.TextPreview,
+	}
+	if e.Streamed {
+		ttft := e.FirstToken.Milliseconds()
+		tokens := e.TokenEvents
+		row.TTFTMS = &ttft
+		row.TokenEvents = &tokens
+	}
+	if f := e.Full; f != nil {
+		body := string(f.Body)
+		row.Body = &body
+		if f.Text != nil {
+			text := string(f.Text)
+			row.Text = &text
+		}
+		row.Headers = f.Headers
+	}
+	return w.writeRow(row)
+}
+
+func (w *jsonlWriter) writeRow(row any) error {
+	b, err := json.Marshal(row)
+	if err != nil {
+		return fmt.Errorf("encode jsonl row: %w", err)
+	}
+	if _, err := w.bw.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("write jsonl: %w", err)
+	}
+	return nil
+}
+
+func (w *jsonlWriter) Close() error {
+	if w == nil {
+		return nil
+	}
+	var first error
+	if w.bw != nil {
+		if err := w.bw.Flush(); err != nil && first == nil {
+			first = err
+		}
+	}
+	if w.f != nil {
+		if err := w.f.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+type csvWriter struct {
+	f  *os.File
+	bw *bufio.Writer
+	w  *csv.Writer
+}
+
+func newCSVWriter(path string, appendMode bool) (*csvWriter, error) {
+	f, size, err := openResultFile(path, appendMode)
+	if err != nil {
+		return nil, fmt.Errorf("create -csv-out: %w", err)
+	}
+	bw := bufio.NewWriterSize(f, 256*1024)
+	w := csv.NewWriter(bw)
+	if size > 0 {
+		return &csvWriter{f: f, bw: bw, w: w}, nil
+	}
+	// Stable columns to keep it easy to ingest.
+	if err := w.Write([]string{
+		"time",
+		"seq",
+		"worker_id",
+		"attempts",
+		"retries",
+		"status_code",
+		"latency_ms",
+		"body_len",
+		"body_truncated",
+		"severity",
+		"score",
+		"marker_hits",
+		"error",
+		"prompt",
+		"body_preview",
+		"text_preview",
+		"ttft_ms",
+		"token_events",
+		"conversation_id",
+		"turn",
+		"graphql_errors",
+		"prompt_id",
+		"sample",
+		"target",
+	}); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("write csv header: %w", err)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("flush csv header: %w", err)
+	}
+	return &csvWriter{f: f, bw: bw, w: w}, nil
+}
+
+func markerHitsCSV(hits []MarkerHit) string {
+	if len(hits) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(hits))
+	for _, h := range hits {
+		parts = append(parts, h.ID+"="+intToString(h.Count))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Write skips -samples prompt_summary events; CSV keeps one row per request.
+func (w *csvWriter) Write(e Event) error {
+	if e.Summary != nil {
+		return nil
+	}
+	ttft, tokens := "", ""
+	if e.Streamed {
+		ttft = intToString(int(e.FirstToken.Milliseconds()))
+		tokens = intToString(e.TokenEvents)
+	}
+	turn, sample := "", ""
+	if e.Turn > 0 {
+		turn = intToString(e.Turn)
+	}
+	if e.Sample > 0 {
+		sample = intToString(e.Sample)
+	}
+	rec := []string{
+		e.Time.UTC().Format(time.RFC3339Nano),
+		intToString(e.Seq),
+		intToString(e.WorkerID),
+		intToString(e.Attempts),
+		intToString(e.Retries),
+		intToString(e.StatusCode),
+		intToString(int(e.Latency.Milliseconds())),
+		intToString(e.BodyLen),
+		boolToString(e.BodyTruncated),
+		e.Severity.String(),
+		intToString(e.Score),
+		markerHitsCSV(e.MarkerHits),
+		e.Error,
+		e.Prompt,
+		e.BodyPreview,
+		e.TextPreview,
+		ttft,
+		tokens,
+		e.ConvID,
+		turn,
+		strings.Join(e.GraphQLErrors, "; "),
+		e.PromptID,
+		sample,
+		e.Target,
+	}
+	if err := w.w.Write(rec); err != nil {
+		return fmt.Errorf("write csv: %w", err)
+	}
+	return nil
+}
+
+func (w *csvWriter) Close() error {
+	if w == nil {
+		return nil
+	}
+	var first error
+	if w.w != nil {
+		w.w.Flush()
+		if err := w.w.Error(); err != nil && first == nil {
+			first = err
+		}
+	}
+	if w.bw != nil {
+		if err := w.bw.Flush(); err != nil && first == nil {
+			first = err
+		}
+	}
+	if w.f != nil {
+		if err := w.f.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+type resultSink struct {
+	ch        chan Event
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu  sync.Mutex
+	err error
+
+	w resultWriter
+}
+
+// newResultSink truncates the outputs, or appends to them with appendMode (-resume).
+func newResultSink(jsonlOut, csvOut string, appendMode bool, onEvent func(Event)) (*resultSink, error) {
+	if jsonlOut == "" && csvOut == "" && onEvent == nil {
+		return nil, nil
+	}
+	var writers []resultWriter
+	if jsonlOut != "" {
+		w, err := newJSONLWriter(jsonlOut, appendMode)
+		if err != nil {
+			return nil, err
+		}
+		writers = append(writers, w)
+	}
+	if csvOut != "" {
+		w, err := newCSVWriter(csvOut, appendMode)
+		if err != nil {
+			for _, ww := range writers {
+				_ = ww.Close()
+			}
+			return nil, err
+		}
+		writers = append(writers, w)
+	}
+	if onEvent != nil {
+		writers = append(writers, eventHook(onEvent))
+	}
+
+	s := &resultSink{
+		ch:   make(chan Event, 1024),
+		done: make(chan struct{}),
+		w:    multiResultWriter{ws: writers},
+	}
+	go s.loop()
+	return s, nil
+}
+
+func (s *resultSink) loop() {
+	defer close(s.done)
+	for e := range s.ch {
+		if s.hasErr() {
+			continue
+		}
+		if err := s.w.Write(e); err != nil {
+			s.setErr(err)
+		}
+	}
+	if err := s.w.Close(); err != nil && !s.hasErr() {
+		s.setErr(err)
+	}
+}
+
+func (s *resultSink) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+func (s *resultSink) hasErr() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err != nil
+}
+
+func (s *resultSink) Write(e Event) {
+	if s == nil {
+		return
+	}
+	if s.hasErr() {
+		return
+	}
+	s.ch <- e
+}
+
+func (s *resultSink) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.closeOnce.Do(func() { close(s.ch) })
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
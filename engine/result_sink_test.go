@@ -1,4 +1,4 @@
-package main
+package engine
 
 import (
 	"bufio"
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -15,12 +16,12 @@ func TestResultSink_JSONLAndCSV(t *testing.T) {
 	jsonlOut := filepath.Join(dir, "out.jsonl")
 	csvOut := filepath.Join(dir, "out.csv")
 
-	s, err := newResultSink(jsonlOut, csvOut)
+	s, err := newResultSink(jsonlOut, csvOut, false, nil)
 	if err != nil {
 		t.Fatalf("newResultSink: %v", err)
 	}
 
-	s.Write(requestEvent{
+	s.Write(Event{
 		Time:          time.Unix(0, 0).UTC(),
 		Seq:           1,
 		WorkerID:      2,
@@ -35,7 +36,7 @@ func TestResultSink_JSONLAndCSV(t *testing.T) {
 		Error:         "",
 		MarkerHits:    []MarkerHit{{ID: "m1", Category: CategorySystemLeak, Count: 2}},
 		Score:         9,
-		Severity:      severityError,
+		Severity:      SeverityError,
 	})
 	if err := s.Close(); err != nil {
 		t.Fatalf("Close: %v", err)
@@ -89,3 +90,36 @@ func TestResultSink_JSONLAndCSV(t *testing.T) {
 		t.Fatalf("expected marker hits in record: %#v", rec)
 	}
 }
+
+func TestJSONLWriter_StreamingFields(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.jsonl")
+	w, err := newJSONLWriter(path, false)
+	if err != nil {
+		t.Fatalf("newJSONLWriter: %v", err)
+	}
+	if err := w.Write(Event{Seq: 1}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Write(Event{Seq: 2, Streamed: true, FirstToken: 0, TokenEvents: 3}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(lines))
+	}
+	if strings.Contains(lines[0], "ttft_ms") || strings.Contains(lines[0], "token_events") {
+		t.Fatalf("non-streamed row should omit streaming fields: %s", lines[0])
+	}
+	if !strings.Contains(lines[1], `"ttft_ms":0`) || !strings.Contains(lines[1], `"token_events":3`) {
+		t.Fatalf("streamed row should include streaming fields: %s", lines[1])
+	}
+}
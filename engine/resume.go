@@ -0,0 +1,102 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"poke/promptset"
+)
+
+// resumeState holds the completed rows of a previous run's -jsonl-out, keyed by prompt_id. Rows with
+// an error (transport failures, requests canceled by Ctrl-C or a timeout) are not completed, so their
+// prompts are sent again. It is only used from the prompt reader goroutine.
+type resumeState struct {
+	rows    map[string][]jsonlRow
+	maxSeq  int
+	skipped int
+}
+
+// loadResumeState reads path; a missing file yields an empty state so -resume can be passed on the
+// first run too. Unparsable lines (e.g. a row cut short by a kill) are ignored.
+func loadResumeState(path string) (*resumeState, error) {
+	s := &resumeState{rows: make(map[string][]jsonlRow)}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open -resume results: %w", err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	for sc.Scan() {
+		var row jsonlRow
+		if err := json.Unmarshal(sc.Bytes(), &row); err != nil {
+			continue
+		}
+		s.maxSeq = max(s.maxSeq, row.Seq)
+		if row.Kind != "" || row.PromptID == "" || row.Error != "" {
+			continue
+		}
+		s.rows[row.PromptID] = append(s.rows[row.PromptID], row)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read -resume results: %w", err)
+	}
+	return s, nil
+}
+
+// take returns and consumes the rows that complete item: for each of its samples, one row for a single
+// prompt or a row for every turn of a conversation. The newest rows win, so a conversation re-run
+// after a failed turn is taken from its latest attempt. Each call consumes rows, so duplicate corpus
+// items resume once per completed copy.
+func (s *resumeState) take(item promptset.Item, samples int) ([]jsonlRow, bool) {
+	key := item.Key()
+	rows := s.rows[key]
+	if len(rows) == 0 {
+		return nil, false
+	}
+	turns := len(item.Turns)
+	picked := make([]int, 0, max(turns, 1)*samples)
+	for sample := 1; sample <= samples; sample++ {
+		// Rows carry no sample index without -samples, and single prompts are recorded with turn 0.
+		want := sample
+		if samples == 1 {
+			want = 0
+		}
+		for turn := min(turns, 1); turn <= turns; turn++ {
+			idx := -1
+			for i := len(rows) - 1; i >= 0; i-- {
+				if rows[i].Turn == turn && rows[i].Sample == want {
+					idx = i
+					break
+				}
+			}
+			if idx < 0 {
+				return nil, false
+			}
+			picked = append(picked, idx)
+		}
+	}
+
+	out := make([]jsonlRow, 0, len(picked))
+	drop := make(map[int]bool, len(picked))
+	for _, i := range picked {
+		out = append(out, rows[i])
+		drop[i] = true
+	}
+	kept := rows[:0]
+	for i, row := range rows {
+		if !drop[i] {
+			kept = append(kept, row)
+		}
+	}
+	s.rows[key] = kept
+	s.skipped++
+	return out, true
+}
@@ -0,0 +1,169 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"poke/promptset"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRun_ResumeSkipsCompletedPrompts(t *testing.T) {
+	var mu sync.Mutex
+	var sent []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Prompt string }
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		sent = append(sent, req.Prompt)
+		mu.Unlock()
+		switch req.Prompt {
+		case "boom":
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			_ = conn.Close()
+		case "leak":
+			_, _ = w.Write([]byte("ok, BEGIN SYSTEM"))
+		default:
+			_, _ = w.Write([]byte("no"))
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	prompts := filepath.Join(dir, "prompts.txt")
+	if err := os.WriteFile(prompts, []byte("leak\nboom\nfine\nmore\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	jsonlOut := filepath.Join(dir, "out.jsonl")
+	csvOut := filepath.Join(dir, "out.csv")
+	cfg := Config{URL: srv.URL, Method: http.MethodPost, Workers: 1, Timeout: 2 * time.Second, PromptsFile: prompts, JSONLOut: jsonlOut, CSVOut: csvOut}
+
+	runOnce := func(cfg Config) (string, error) {
+		var logs bytes.Buffer
+		restore := logWriterSwap(t, &logs)
+		defer restore()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := run(ctx, cfg)
+		return logs.String(), err
+	}
+
+	// First run is cut short after three prompts; "boom" fails with a transport error.
+	first := cfg
+	first.Budget.MaxRequests = 3
+	if _, err := runOnce(first); !errors.As(err, new(BudgetExceededError)) {
+		t.Fatalf("expected the first run to stop on its budget, got %v", err)
+	}
+
+	mu.Lock()
+	sent = nil
+	mu.Unlock()
+	second := cfg
+	second.Resume = true
+	logs, err := runOnce(second)
+	if err != nil {
+		t.Fatalf("resumed run: %v", err)
+	}
+	if strings.Join(sent, ",") != "boom,more" {
+		t.Fatalf("expected only the failed and unsent prompts to be sent, got %v", sent)
+	}
+	for _, want := range []string{"done: sent=4 errs=1", "resumed: 2 results", "system_leak"} {
+		if !strings.Contains(logs, want) {
+			t.Fatalf("expected %q in the summary, got:\n%s", want, logs)
+		}
+	}
+
+	f, err := os.Open(jsonlOut)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	seqs := map[int]bool{}
+	var rows int
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var row jsonlRow
+		if err := json.Unmarshal(sc.Bytes(), &row); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if row.PromptID == "" || seqs[row.Seq] {
+			t.Fatalf("expected a prompt_id and a unique seq, got %+v", row)
+		}
+		seqs[row.Seq] = true
+		rows++
+	}
+	if rows != 5 {
+		t.Fatalf("expected 3 + 2 appended rows, got %d", rows)
+	}
+
+	b, err := os.ReadFile(csvOut)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if n := strings.Count(string(b), "time,seq,"); n != 1 {
+		t.Fatalf("expected a single CSV header after appending, got %d", n)
+	}
+}
+
+func TestResumeState_Take(t *testing.T) {
+	conv := promptset.Item{ID: "c1", Turns: []string{"a", "b"}}
+	path := filepath.Join(t.TempDir(), "out.jsonl")
+	lines := []string{
+		`{"seq":1,"prompt_id":"c1","turn":1,"prompt":"a","status_code":200}`,
+		`{"seq":2,"prompt_id":"c1","turn":2,"prompt":"b","error":"context canceled"}`,
+		`{"seq":3,"prompt_id":"c1","turn":1,"prompt":"a","status_code":201}`,
+		`{"seq":4,"prompt_id":"c1","turn":2,"prompt":"b","status_code":201}`,
+		`{"seq":5,"prompt_id":"` + (promptset.Item{Prompt: "x"}).Key() + `","prompt":"x","status_code":200}`,
+		`{"seq":6,"prompt_id":"cut sh`,
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := loadResumeState(path)
+	if err != nil {
+		t.Fatalf("loadResumeState: %v", err)
+	}
+	if s.maxSeq != 5 {
+		t.Fatalf("expected maxSeq 5, got %d", s.maxSeq)
+	}
+	rows, ok := s.take(conv, 1)
+	if !ok || len(rows) != 2 || rows[0].Seq != 3 || rows[1].Seq != 4 {
+		t.Fatalf("expected the newest complete turns, got %+v ok=%v", rows, ok)
+	}
+	// Only the stale turn 1 is left, which does not complete the conversation again.
+	if _, ok := s.take(conv, 1); ok {
+		t.Fatalf("expected a duplicate conversation to be sent again")
+	}
+	if rows, ok := s.take(promptset.Item{Prompt: "x"}, 1); !ok || len(rows) != 1 {
+		t.Fatalf("expected the hashed prompt to resume, got %+v", rows)
+	}
+	if _, ok := s.take(promptset.Item{Prompt: "y"}, 1); ok {
+		t.Fatalf("unexpected resume of an unseen prompt")
+	}
+
+	// A row cut short by a kill is terminated before appending.
+	w, err := newJSONLWriter(path, true)
+	if err != nil {
+		t.Fatalf("newJSONLWriter: %v", err)
+	}
+	if err := w.Write(Event{Seq: 7, PromptID: "z"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	b, _ := os.ReadFile(path)
+	last := b[bytes.LastIndexByte(b[:len(b)-1], '\n')+1:]
+	if !json.Valid(last) {
+		t.Fatalf("expected the appended row on its own line, got %q", last)
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package engine
 
 import (
 	"context"
@@ -10,17 +10,17 @@ import (
 	"time"
 )
 
-type retryConfig struct {
+type RetryConfig struct {
 	MaxRetries int
 	BackoffMin time.Duration
 	BackoffMax time.Duration
 }
 
-func (c retryConfig) enabled() bool {
+func (c RetryConfig) enabled() bool {
 	return c.MaxRetries > 0
 }
 
-func (c retryConfig) validate() error {
+func (c RetryConfig) validate() error {
 	if c.MaxRetries < 0 {
 		return errors.New("-retries must be >= 0")
 	}
@@ -71,7 +71,7 @@ func parseRetryAfter(h string, now time.Time) (time.Duration, bool) {
 	return 0, false
 }
 
-func nextBackoffDelay(cfg retryConfig, retryNumber int, retryAfter time.Duration) time.Duration {
+func nextBackoffDelay(cfg RetryConfig, retryNumber int, retryAfter time.Duration) time.Duration {
 	if retryNumber <= 0 {
 		return 0
 	}
@@ -1,4 +1,4 @@
-package main
+package engine
 
 import (
 	"errors"
@@ -23,18 +23,18 @@ func TestSendOne_RetriesOn5xx(t *testing.T) {
 	}))
 	defer srv.Close()
 
-	cfg := config{
-		targetURL: srv.URL,
-		method:    http.MethodPost,
-		timeout:   5 * time.Second,
-		retry: retryConfig{
+	cfg := Config{
+		URL:     srv.URL,
+		Method:  http.MethodPost,
+		Timeout: 5 * time.Second,
+		Retry: RetryConfig{
 			MaxRetries: 3,
 			BackoffMin: 0,
 			BackoffMax: 0,
 		},
 	}
 
-	res := sendOne(t.Context(), srv.Client(), cfg, nil, nil, 1, "hi")
+	res := sendOne(t.Context(), srv.Client(), cfg, nil, nil, 1, promptInput{Prompt: "hi"})
 	if res.Err != nil {
 		t.Fatalf("unexpected err: %v", res.Err)
 	}
@@ -58,18 +58,18 @@ func TestSendOne_StopsAfterMaxRetries(t *testing.T) {
 	}))
 	defer srv.Close()
 
-	cfg := config{
-		targetURL: srv.URL,
-		method:    http.MethodPost,
-		timeout:   5 * time.Second,
-		retry: retryConfig{
+	cfg := Config{
+		URL:     srv.URL,
+		Method:  http.MethodPost,
+		Timeout: 5 * time.Second,
+		Retry: RetryConfig{
 			MaxRetries: 2,
 			BackoffMin: 0,
 			BackoffMax: 0,
 		},
 	}
 
-	res := sendOne(t.Context(), srv.Client(), cfg, nil, nil, 1, "hi")
+	res := sendOne(t.Context(), srv.Client(), cfg, nil, nil, 1, promptInput{Prompt: "hi"})
 	if res.Err != nil {
 		t.Fatalf("unexpected err: %v", res.Err)
 	}
@@ -106,18 +106,18 @@ func TestSendOne_RetriesOnTransportError(t *testing.T) {
 		Timeout: 5 * time.Second,
 	}
 
-	cfg := config{
-		targetURL: "http://example.invalid/test",
-		method:    http.MethodGet,
-		timeout:   5 * time.Second,
-		retry: retryConfig{
+	cfg := Config{
+		URL:     "http://example.invalid/test",
+		Method:  http.MethodGet,
+		Timeout: 5 * time.Second,
+		Retry: RetryConfig{
 			MaxRetries: 1,
 			BackoffMin: 0,
 			BackoffMax: 0,
 		},
 	}
 
-	res := sendOne(t.Context(), client, cfg, nil, nil, 1, "hi")
+	res := sendOne(t.Context(), client, cfg, nil, nil, 1, promptInput{Prompt: "hi"})
 	if res.Err != nil {
 		t.Fatalf("unexpected err: %v", res.Err)
 	}
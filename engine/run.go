@@ -0,0 +1,646 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"poke/promptset"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// runCounters number the requests and conversations of one run; -matrix targets share them.
+type runCounters struct {
+	// id prefixes conversation IDs so they stay unique across runs appending to one -jsonl-out.
+	id            string
+	seq           atomic.Uint64
+	conversations atomic.Uint64
+}
+
+// nextSeq returns the next request number. A Config without counters (sendOne in tests) gets 0.
+func (c *runCounters) nextSeq() int {
+	if c == nil {
+		return 0
+	}
+	return int(c.seq.Add(1))
+}
+
+// conversationID names one sample of a conversation item: the run ID, the item's id (else conv-N) and,
+// with -samples > 1, the sample number, e.g. "3f9a1c2e/jb-roleplay#2".
+func (c *runCounters) conversationID(itemID string, sample, samples int) string {
+	id := itemID
+	if c != nil {
+		if id == "" {
+			id = fmt.Sprintf("conv-%d", c.conversations.Add(1))
+		}
+		id = c.id + "/" + id
+	}
+	if samples > 1 {
+		id += fmt.Sprintf("#%d", sample)
+	}
+	return id
+}
+
+// Run validates cfg, sends its corpus to the target(s) and logs the summary, which it also returns.
+// The error is a BudgetExceededError or ThresholdExceededError when a stop condition ended the
+// run; the summary is valid then too.
+func Run(ctx context.Context, cfg Config) (Summary, error) {
+	if err := cfg.Validate(); err != nil {
+		return Summary{}, err
+	}
+	return run(ctx, cfg)
+}
+
+func run(ctx context.Context, cfg Config) (Summary, error) {
+	cfg.counters = &runCounters{id: fmt.Sprintf("%08x", newSeed())}
+	cfg.Samples = max(cfg.Samples, 1)
+
+	recorder, err := newCassetteRecorder(cfg.RecordDir, cfg.Samples, cfg.Sign.HMACHeader)
+	if err != nil {
+		return Summary{}, err
+	}
+	defer recorder.Close()
+	cfg.recorder = recorder
+
+	if cfg.MatrixFile != "" {
+		return runMatrix(ctx, cfg)
+	}
+
+	target, err := prepareTarget(ctx, cfg)
+	if err != nil {
+		return Summary{}, err
+	}
+	defer target.Close()
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var resume *resumeState
+	if cfg.Resume {
+		resume, err = loadResumeState(cfg.JSONLOut)
+		if err != nil {
+			return Summary{}, err
+		}
+		// Continue numbering after the previous run so seq stays unique across the appended rows.
+		cfg.counters.seq.Store(uint64(resume.maxSeq))
+	}
+
+	sink, err := newResultSink(cfg.JSONLOut, cfg.CSVOut, cfg.Resume, cfg.OnEvent)
+	if err != nil {
+		return Summary{}, err
+	}
+	defer func() {
+		if sink != nil {
+			_ = sink.Close()
+		}
+	}()
+
+	budgets := newBudgetTracker(cfg.Budget, cancel)
+	defer budgets.Stop()
+
+	stats, err := target.Run(ctx, cancel, sink, budgets, resume)
+	if err != nil {
+		return Summary{}, err
+	}
+	if sink != nil {
+		if err := sink.Close(); err != nil {
+			return Summary{}, err
+		}
+	}
+	if err := recorder.Close(); err != nil {
+		return Summary{}, err
+	}
+
+	stats.LogSummary()
+	summary := stats.summary()
+	// A budget stop is reported only when it ended the run; a later threshold hit is a side effect.
+	var be BudgetExceededError
+	if cause := context.Cause(ctx); errors.As(cause, &be) {
+		return summary, cause
+	}
+	return summary, stats.ThresholdError()
+}
+
+// targetRunner is one target with everything resolved before the first prompt is sent: templates,
+// headers, connection pool, auth, signer, rate limiter and markers.
+type targetRunner struct {
+	cfg      Config
+	client   *http.Client
+	headers  http.Header
+	cookies  []*http.Cookie
+	analyzer *responseAnalyzer
+	policy   map[MarkerCategory]categoryPolicy
+}
+
+func prepareTarget(ctx context.Context, cfg Config) (*targetRunner, error) {
+	tmpl, err := loadRequestTemplate(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cfg.reqTemplate = tmpl
+
+	if cfg.SSETextPath != "" {
+		p, err := parseJSONPath(cfg.SSETextPath)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -sse-text-path: %w", err)
+		}
+		cfg.sseText = p
+	}
+	if cfg.ResponseExtract != "" {
+		e, err := parseResponseExtractor(cfg.ResponseExtract)
+		if err != nil {
+			return nil, err
+		}
+		cfg.extractor = e
+	}
+	if cfg.WSTextPath != "" {
+		p, err := parseJSONPath(cfg.WSTextPath)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -ws-text-path: %w", err)
+		}
+		cfg.wsText = p
+	}
+	cfg.wsDone, err = parseWSDoneMatcher(cfg.WSDoneMatch)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -ws-done-match: %w", err)
+	}
+
+	headers, err := readHeadersFile(cfg.HeadersFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, hs := range []map[string]string{cfg.Headers, cfg.targetHeaders} {
+		for k, v := range hs {
+			headers.Set(k, v)
+		}
+	}
+	cookies, err := readCookiesFile(cfg.CookiesFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Cookies)) {
+		cookies = append(cookies, &http.Cookie{Name: name, Value: cfg.Cookies[name]})
+	}
+
+	transport, err := newHTTPTransport(cfg.Transport, cfg.URL, cfg.Workers)
+	if err != nil {
+		return nil, err
+	}
+	cfg.httpTransport = transport
+	t := &targetRunner{cfg: cfg, headers: headers, cookies: cookies}
+	if err := t.prepareAuth(ctx); err != nil {
+		t.Close()
+		return nil, err
+	}
+
+	t.cfg.signer, err = newRequestSigner(cfg.Sign)
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	t.cfg.limiter, err = newRateLimiter(cfg.Rate)
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	t.client = &http.Client{Transport: transport, Timeout: cfg.Timeout}
+
+	mcfg := defaultMarkerConfig()
+	switch {
+	case cfg.Markers != nil:
+		mcfg, err = parseMarkerConfig(cfg.Markers)
+	case cfg.MarkersFile != "":
+		mcfg, err = loadMarkerConfigFile(cfg.MarkersFile)
+	}
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	t.analyzer, err = newResponseAnalyzer(mcfg)
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	t.policy = mcfg.Categories
+	return t, nil
+}
+
+func (t *targetRunner) prepareAuth(ctx context.Context) error {
+	cfg := &t.cfg
+	if cfg.SessionFile != "" {
+		spec, err := loadSessionFile(cfg.SessionFile)
+		if err != nil {
+			return err
+		}
+		sess := newSessionAuth(spec, cfg.Vars, cfg.httpTransport, cfg.Timeout, cfg.Trace)
+		// Log in up front so a broken login fails the run before any prompt is sent.
+		if _, err := sess.Credentials(ctx); err != nil {
+			return fmt.Errorf("session bootstrap: %w", err)
+		}
+		cfg.auth = sess
+	}
+	if cfg.OAuth2.enabled() {
+		oa, err := newOAuth2Auth(cfg.OAuth2, cfg.httpTransport, cfg.Timeout)
+		if err != nil {
+			return err
+		}
+		if _, err := oa.Credentials(ctx); err != nil {
+			return fmt.Errorf("oauth2: initial token: %w", err)
+		}
+		cfg.auth = oa
+	}
+	return nil
+}
+
+func (t *targetRunner) Close() {
+	t.cfg.httpTransport.CloseIdleConnections()
+}
+
+// Run sends the corpus to the target and returns its report once every worker is done. cancel stops
+// the run when a marker threshold triggers; sink, budgets and resume may be shared with other targets.
+func (t *targetRunner) Run(ctx context.Context, cancel func(error), sink *resultSink, budgets *budgetTracker, resume *resumeState) (*report, error) {
+	cfg := t.cfg
+	cfg.budgets = budgets
+
+	stats := newReport(t.analyzer, t.policy, cancel, sink)
+	stats.samples = cfg.Samples
+	stats.target = cfg.targetName
+	stats.budgets = budgets
+	stats.recorder = cfg.recorder
+	stats.fullBodies = cfg.JSONLFullBody
+	if cfg.Rate.Adaptive {
+		stats.rate = cfg.limiter.Rate
+	}
+
+	prompts := make(chan promptset.Item, cfg.Workers*2)
+	var wg sync.WaitGroup
+	wg.Add(cfg.Workers)
+	for i := 0; i < cfg.Workers; i++ {
+		go func(workerID int) {
+			defer wg.Done()
+			worker(ctx, workerID, cfg, t.client, cfg.limiter, t.headers, t.cookies, prompts, stats)
+		}(i + 1)
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(prompts)
+		// -samples tallies results by prompt key, so a repeated item would be merged into one prompt.
+		opt := promptset.Options{Unique: cfg.Samples > 1}
+		if resume != nil {
+			opt.Skip = func(item promptset.Item) bool {
+				rows, ok := resume.take(item, cfg.Samples)
+				for _, row := range rows {
+					stats.ReplayRow(row)
+				}
+				if ok {
+					stats.FinishPrompt(item, true)
+				}
+				return ok
+			}
+		}
+		if cfg.Prompts != nil {
+			readErr <- promptset.SendItems(ctx, cfg.Prompts, prompts, opt)
+			return
+		}
+		readErr <- promptset.StreamItems(ctx, cfg.PromptsFile, prompts, opt)
+	}()
+
+	wg.Wait()
+
+	if err := <-readErr; err != nil && !errors.Is(err, context.Canceled) {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func worker(
+	ctx context.Context,
+	workerID int,
+	cfg Config,
+	client *http.Client,
+	limiter *rateLimiter,
+	baseHeaders http.Header,
+	cookies []*http.Cookie,
+	in <-chan promptset.Item,
+	stats *report,
+) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case item, ok := <-in:
+			if !ok {
+				return
+			}
+			for sample := 1; sample <= cfg.Samples; sample++ {
+				if err := runSample(ctx, workerID, cfg, client, limiter, baseHeaders, cookies, item, sample, stats); err != nil {
+					return
+				}
+			}
+			stats.FinishPrompt(item, false)
+		}
+	}
+}
+
+// runSample sends one repetition of item: a single request, or every turn of a conversation. The
+// returned error is non-nil only when the worker should stop.
+func runSample(
+	ctx context.Context,
+	workerID int,
+	cfg Config,
+	client *http.Client,
+	limiter *rateLimiter,
+	baseHeaders http.Header,
+	cookies []*http.Cookie,
+	item promptset.Item,
+	sample int,
+	stats *report,
+) error {
+	if item.IsConversation() {
+		return runConversation(ctx, workerID, cfg, client, limiter, baseHeaders, cookies, item, sample, stats)
+	}
+	if err := cfg.budgets.Admit(); err != nil {
+		return err
+	}
+	if err := limiter.Wait(ctx); err != nil {
+		stats.RecordError(err)
+		return err
+	}
+
+	in := newPromptInput(cfg, item, item.Prompt, nil).withSample(sample, newSeed())
+	res := sendOne(ctx, client, cfg, baseHeaders, cookies, workerID, in)
+	res.PromptID = item.Key()
+	if cfg.Samples > 1 {
+		res.Sample = sample
+	}
+	stats.RecordResult(res)
+	return nil
+}
+
+func sendOne(
+	ctx context.Context,
+	client *http.Client,
+	cfg Config,
+	baseHeaders http.Header,
+	cookies []*http.Cookie,
+	workerID int,
+	in promptInput,
+) (res RequestResult) {
+	seq := cfg.counters.nextSeq()
+	in = in.withRequestBuiltins(seq)
+
+	if target, err := url.Parse(cfg.URL); err == nil && isWebSocketURL(target) {
+		return sendOneWebSocket(ctx, cfg, baseHeaders, cookies, workerID, seq, in)
+	}
+
+	start := time.Now()
+	prompt := in.Prompt
+
+	tr, err := buildTargetRequest(cfg, in)
+	if err != nil {
+		return RequestResult{Seq: seq, WorkerID: workerID, Prompt: prompt, Latency: time.Since(start), Err: err}
+	}
+	baseHeaders, err = renderHeaders(baseHeaders, in)
+	if err != nil {
+		return RequestResult{Seq: seq, WorkerID: workerID, Prompt: prompt, Latency: time.Since(start), Err: err}
+	}
+
+	if cfg.Trace {
+		log.Printf("req_start: seq=%d worker=%d method=%s url=%s body_bytes=%d prompt=%q", seq, workerID, tr.Method, tr.URL.String(), len(tr.Body), previewOneLine(prompt, 160))
+	}
+
+	var attempts int
+	var retries int
+	reauthed := false
+	var capture *exchangeCapture
+	if cfg.recorder != nil {
+		// Runs after the response body is closed, so the capture holds the complete body.
+		defer func() { res.exchange = capture }()
+	}
+
+	for {
+		attempts++
+		attemptStart := time.Now()
+
+		reqHeaders, reqCookies := baseHeaders, cookies
+		var creds authCredentials
+		if cfg.auth != nil {
+			creds, err = cfg.auth.Credentials(ctx)
+			if err != nil {
+				return RequestResult{Seq: seq, WorkerID: workerID, Prompt: prompt, Attempts: attempts, Retries: retries, Latency: time.Since(start), Err: fmt.Errorf("auth: %w", err)}
+			}
+			reqHeaders, reqCookies = withAuth(baseHeaders, cookies, creds)
+		}
+
+		var body io.Reader
+		if tr.Body != nil {
+			body = bytes.NewReader(tr.Body)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, tr.Method, tr.URL.String(), body)
+		if err != nil {
+			return RequestResult{Seq: seq, WorkerID: workerID, Prompt: prompt, Attempts: attempts, Retries: retries, Latency: time.Since(start), Err: fmt.Errorf("build request: %w", err)}
+		}
+
+		for k, vs := range tr.Header {
+			for _, v := range vs {
+				req.Header.Add(k, v)
+			}
+		}
+		// -headers-file values override the rendered request's headers (e.g. Content-Type).
+		for k, vs := range reqHeaders {
+			req.Header.Del(k)
+			for _, v := range vs {
+				req.Header.Add(k, v)
+			}
+		}
+		for _, c := range reqCookies {
+			req.AddCookie(c)
+		}
+
+		// Signed last so the signature covers every header and a fresh timestamp on each attempt.
+		if cfg.signer != nil {
+			if err := cfg.signer.Sign(req, tr.Body, time.Now()); err != nil {
+				return RequestResult{Seq: seq, WorkerID: workerID, Prompt: prompt, Attempts: attempts, Retries: retries, Latency: time.Since(start), Err: fmt.Errorf("sign request: %w", err)}
+			}
+		}
+
+		if cfg.recorder != nil {
+			capture = newExchangeCapture(req, tr.Body, attemptStart)
+		}
+		if cfg.Trace {
+			log.Printf("req_wait: seq=%d worker=%d attempt=%d", seq, workerID, attempts)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			if cfg.Retry.enabled() && retries < cfg.Retry.MaxRetries && isRetryableDoError(err) {
+				retries++
+				delay := nextBackoffDelay(cfg.Retry, retries, 0)
+				if cfg.Trace {
+					log.Printf("req_retry: seq=%d worker=%d attempt=%d retry=%d delay=%s err=%q", seq, workerID, attempts, retries, delay.String(), previewOneLine(err.Error(), 200))
+				}
+				if sleepErr := sleepCtx(ctx, delay); sleepErr != nil {
+					return RequestResult{Seq: seq, WorkerID: workerID, Prompt: prompt, Attempts: attempts, Retries: retries - 1, Latency: time.Since(start), Err: sleepErr}
+				}
+				continue
+			}
+			if cfg.Trace {
+				log.Printf("req_done: seq=%d worker=%d attempt=%d status=ERR attempt_latency=%s total_latency=%s err=%q", seq, workerID, attempts, time.Since(attemptStart).String(), time.Since(start).String(), previewOneLine(err.Error(), 200))
+			}
+			return RequestResult{Seq: seq, WorkerID: workerID, Prompt: prompt, Attempts: attempts, Retries: retries, Latency: time.Since(start), Err: err}
+		}
+
+		cfg.limiter.Observe(resp.StatusCode, resp.Header)
+
+		if cfg.auth != nil && !reauthed && cfg.auth.RefreshOn(resp.StatusCode) {
+			_ = resp.Body.Close()
+			reauthed = true
+			if cfg.Trace {
+				log.Printf("req_reauth: seq=%d worker=%d attempt=%d status=%d", seq, workerID, attempts, resp.StatusCode)
+			}
+			if err := cfg.auth.Refresh(ctx, creds.gen); err != nil {
+				return RequestResult{Seq: seq, WorkerID: workerID, Prompt: prompt, Attempts: attempts, Retries: retries, StatusCode: resp.StatusCode, Headers: resp.Header.Clone(), Latency: time.Since(start), Err: fmt.Errorf("auth refresh: %w", err)}
+			}
+			continue
+		}
+
+		if cfg.Retry.enabled() && retries < cfg.Retry.MaxRetries && isRetryableHTTPStatus(resp.StatusCode) {
+			retryAfter, _ := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+			_ = resp.Body.Close()
+
+			retries++
+			delay := nextBackoffDelay(cfg.Retry, retries, retryAfter)
+			if cfg.Trace {
+				log.Printf("req_retry: seq=%d worker=%d attempt=%d retry=%d status=%d delay=%s", seq, workerID, attempts, retries, resp.StatusCode, delay.String())
+			}
+			if sleepErr := sleepCtx(ctx, delay); sleepErr != nil {
+				return RequestResult{Seq: seq, WorkerID: workerID, Prompt: prompt, Attempts: attempts, Retries: retries - 1, Latency: time.Since(start), Err: sleepErr}
+			}
+			continue
+		}
+
+		if capture != nil {
+			// Event streams are not drained: they may stay open after [DONE] until the request times out.
+			resp.Body = capture.wrap(resp.Body, !isEventStream(resp), cfg.RecordMaxBytes)
+		}
+		defer resp.Body.Close()
+
+		if isEventStream(resp) {
+			sse, err := readSSEResponse(resp.Body, cfg.MaxResponseBytes, cfg.sseText, attemptStart)
+			if err != nil {
+				if cfg.Trace {
+					log.Printf("req_done: seq=%d worker=%d attempt=%d status=%d attempt_latency=%s total_latency=%s err=%q", seq, workerID, attempts, resp.StatusCode, time.Since(attemptStart).String(), time.Since(start).String(), previewOneLine(err.Error(), 200))
+				}
+				return RequestResult{Seq: seq, WorkerID: workerID, Prompt: prompt, Attempts: attempts, Retries: retries, StatusCode: resp.StatusCode, Headers: resp.Header.Clone(), Latency: time.Since(start), Err: fmt.Errorf("read event stream: %w", err)}
+			}
+			if cfg.Trace {
+				log.Printf("req_done: seq=%d worker=%d attempt=%d status=%d attempt_latency=%s total_latency=%s body_bytes=%d truncated=%t ttft=%s token_events=%d", seq, workerID, attempts, resp.StatusCode, time.Since(attemptStart).String(), time.Since(start).String(), len(sse.Raw), sse.Truncated, sse.FirstToken.String(), sse.TokenEvents)
+			}
+			return RequestResult{Seq: seq, WorkerID: workerID, Prompt: prompt, Attempts: attempts, Retries: retries, StatusCode: resp.StatusCode, Headers: resp.Header.Clone(), Latency: time.Since(start), Body: sse.Raw, BodyTruncated: sse.Truncated, Text: sse.Text, Streamed: true, FirstTokenLatency: sse.FirstToken, TokenEvents: sse.TokenEvents}
+		}
+
+		b, truncated, err := readResponseBody(resp, cfg.MaxResponseBytes, cfg.StreamResponse)
+		if err != nil {
+			if cfg.Trace {
+				log.Printf("req_done: seq=%d worker=%d attempt=%d status=%d attempt_latency=%s total_latency=%s err=%q", seq, workerID, attempts, resp.StatusCode, time.Since(attemptStart).String(), time.Since(start).String(), previewOneLine(err.Error(), 200))
+			}
+			return RequestResult{Seq: seq, WorkerID: workerID, Prompt: prompt, Attempts: attempts, Retries: retries, StatusCode: resp.StatusCode, Headers: resp.Header.Clone(), Latency: time.Since(start), Err: fmt.Errorf("read response body: %w", err)}
+		}
+		if cfg.Trace {
+			log.Printf("req_done: seq=%d worker=%d attempt=%d status=%d attempt_latency=%s total_latency=%s body_bytes=%d truncated=%t", seq, workerID, attempts, resp.StatusCode, time.Since(attemptStart).String(), time.Since(start).String(), len(b), truncated)
+		}
+		return RequestResult{Seq: seq, WorkerID: workerID, Prompt: prompt, Attempts: attempts, Retries: retries, StatusCode: resp.StatusCode, Headers: resp.Header.Clone(), Latency: time.Since(start), Body: b, BodyTruncated: truncated, Text: extractResponseText(cfg, b), GraphQLErrors: extractGraphQLErrors(cfg, b)}
+	}
+}
+
+func readHeadersFile(path string) (http.Header, error) {
+	h := make(http.Header)
+	if path == "" {
+		return h, nil
+	}
+	lines, err := readLines(path, "headers")
+	if err != nil {
+		return nil, err
+	}
+	for i, raw := range lines {
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("headers file: line %d: expected 'Key: Value'", i+1)
+		}
+		k = http.CanonicalHeaderKey(strings.TrimSpace(k))
+		v = strings.TrimSpace(v)
+		if k == "" {
+			return nil, fmt.Errorf("headers file: line %d: empty header key", i+1)
+		}
+		h.Add(k, v)
+	}
+	return h, nil
+}
+
+func readCookiesFile(path string) ([]*http.Cookie, error) {
+	if path == "" {
+		return nil, nil
+	}
+	lines, err := readLines(path, "cookies")
+	if err != nil {
+		return nil, err
+	}
+	var out []*http.Cookie
+	for i, raw := range lines {
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("cookies file: line %d: expected 'name=value'", i+1)
+		}
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+		if name == "" {
+			return nil, fmt.Errorf("cookies file: line %d: empty cookie name", i+1)
+		}
+		out = append(out, &http.Cookie{Name: name, Value: value})
+	}
+	return out, nil
+}
+
+func readLines(path string, kind string) ([]string, error) {
+	var r io.Reader
+	if path == "-" {
+		r = os.Stdin
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("open %s file: %w", kind, err)
+		}
+		defer f.Close()
+		r = f
+	}
+	sc := bufio.NewScanner(r)
+	var out []string
+	for sc.Scan() {
+		out = append(out, sc.Text())
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read %s file: %w", kind, err)
+	}
+	return out, nil
+}
@@ -1,69 +1,22 @@
-package main
+package engine
 
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"poke/promptset"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 )
 
-func TestParseFlags_Help(t *testing.T) {
-	_, err := parseFlags([]string{"-h"})
-	if err == nil {
-		t.Fatalf("expected error")
-	}
-	var he helpError
-	if !errors.As(err, &he) {
-		t.Fatalf("expected helpError, got %T: %v", err, err)
-	}
-	if !strings.Contains(he.usage, "Usage:") || !strings.Contains(he.usage, "Flags:") {
-		t.Fatalf("unexpected help text: %q", he.usage)
-	}
-}
-
-func TestParseFlags_ValidatesRequiredAndConflicts(t *testing.T) {
-	if _, err := parseFlags([]string{}); err == nil {
-		t.Fatalf("expected error")
-	}
-	if _, err := parseFlags([]string{"-url=https://example.test", "-prompts=x", "-body-template={}", "-body-template-file=y"}); err == nil {
-		t.Fatalf("expected error")
-	}
-	if _, err := parseFlags([]string{"-url=https://example.test", "-prompts=x", "-query-template=a=b", "-query-template-file=y"}); err == nil {
-		t.Fatalf("expected error")
-	}
-	if _, err := parseFlags([]string{"-url=not a url", "-prompts=x"}); err == nil {
-		t.Fatalf("expected error")
-	}
-	if _, err := parseFlags([]string{"-url=https://example.test", "-prompts=x", "-workers=0"}); err == nil {
-		t.Fatalf("expected error")
-	}
-	if _, err := parseFlags([]string{"-url=https://example.test", "-prompts=x", "-rate=-1"}); err == nil {
-		t.Fatalf("expected error")
-	}
-	if _, err := parseFlags([]string{"-url=https://example.test", "-prompts=x", "-max-response-bytes=-1"}); err == nil {
-		t.Fatalf("expected error")
-	}
-	if _, err := parseFlags([]string{"-url=https://example.test", "-prompts=x", "-method=   "}); err == nil {
-		t.Fatalf("expected error")
-	}
-	if _, err := parseFlags([]string{"-url=https://example.test", "-prompts=x", "-jsonl-out=-"}); err == nil {
-		t.Fatalf("expected error")
-	}
-	if _, err := parseFlags([]string{"-url=https://example.test", "-prompts=x", "-csv-out=-"}); err == nil {
-		t.Fatalf("expected error")
-	}
-	if _, err := parseFlags([]string{"-url=https://example.test", "-prompts=x", "-jsonl-out=out", "-csv-out=out"}); err == nil {
-		t.Fatalf("expected error")
-	}
-}
-
 func TestReadHeadersAndCookiesFile(t *testing.T) {
 	dir := t.TempDir()
 
@@ -130,11 +83,10 @@ func TestReadLines_Stdin(t *testing.T) {
 }
 
 func TestRateLimiter_WaitCanceled(t *testing.T) {
-	rl, err := newRateLimiter(1)
+	rl, err := newRateLimiter(RateConfig{Rate: 1})
 	if err != nil {
 		t.Fatalf("newRateLimiter: %v", err)
 	}
-	t.Cleanup(rl.Close)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
@@ -180,29 +132,28 @@ func TestRun_Smoke(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	cfg := config{
-		targetURL:    srv.URL,
-		method:       http.MethodPost,
-		headersFile:  headersFile,
-		cookiesFile:  cookiesFile,
-		workers:      2,
-		rate:         0,
-		timeout:      2 * time.Second,
-		promptsFile:  prompts,
-		retry:        retryConfig{MaxRetries: 0},
-		jsonlOut:     jsonlOut,
-		csvOut:       csvOut,
-		ciExitCodes:  false,
-		markersFile:  "",
-		bodyTmplStr:  "",
-		queryTmplStr: "",
+	cfg := Config{
+		URL:           srv.URL,
+		Method:        http.MethodPost,
+		HeadersFile:   headersFile,
+		CookiesFile:   cookiesFile,
+		Workers:       2,
+		Rate:          RateConfig{},
+		Timeout:       2 * time.Second,
+		PromptsFile:   prompts,
+		Retry:         RetryConfig{MaxRetries: 0},
+		JSONLOut:      jsonlOut,
+		CSVOut:        csvOut,
+		MarkersFile:   "",
+		BodyTemplate:  "",
+		QueryTemplate: "",
 	}
 
 	var logs bytes.Buffer
 	origOutput := logWriterSwap(t, &logs)
 	defer origOutput()
 
-	if err := run(ctx, cfg); err != nil {
+	if _, err := run(ctx, cfg); err != nil {
 		t.Fatalf("run: %v", err)
 	}
 	if gotRequests != 2 {
@@ -219,6 +170,86 @@ func TestRun_Smoke(t *testing.T) {
 	}
 }
 
+func TestRun_InlineHeadersCookiesAndMarkers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Test") != "inline" || r.Header.Get("Cookie") != "a=1; b=2" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		_, _ = w.Write([]byte("the codename is bluebird"))
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := DefaultConfig()
+	cfg.URL, cfg.Workers = srv.URL, 1
+	cfg.Prompts = []promptset.Item{{Prompt: "p"}}
+	cfg.Headers = map[string]string{"X-Test": "inline"}
+	cfg.Cookies = map[string]string{"b": "2", "a": "1"}
+	cfg.Markers = json.RawMessage(`{"regexes":[{"id":"codename","category":"system_leak","pattern":"bluebird"}]}`)
+
+	var logs bytes.Buffer
+	restore := logWriterSwap(t, &logs)
+	defer restore()
+	sum, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if sum.MarkerResponses["system_leak:codename"] != 1 {
+		t.Fatalf("expected the inline marker to hit, got %v", sum.MarkerResponses)
+	}
+
+	cfg.MarkersFile = "markers.json"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "inline markers") {
+		t.Fatalf("expected a conflict with -markers-file, got %v", err)
+	}
+	cfg.MarkersFile, cfg.Markers = "", json.RawMessage(`{"regexes":[{"id":"x"}]}`)
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "invalid inline markers: regexes[0] (x): missing category") {
+		t.Fatalf("expected an inline markers error, got %v", err)
+	}
+}
+
+func TestRun_ConcurrentRunsNumberIndependently(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	t.Cleanup(srv.Close)
+
+	var logs bytes.Buffer
+	restore := logWriterSwap(t, &logs)
+	defer restore()
+
+	const n = 20
+	seqs := make([][]int, 2)
+	errs := make(chan error, len(seqs))
+	for i := range seqs {
+		cfg := DefaultConfig()
+		cfg.URL, cfg.Workers = srv.URL, 2
+		for j := 0; j < n; j++ {
+			cfg.Prompts = append(cfg.Prompts, promptset.Item{Prompt: "p"})
+		}
+		cfg.OnEvent = func(e Event) { seqs[i] = append(seqs[i], e.Seq) }
+		go func() {
+			_, err := Run(context.Background(), cfg)
+			errs <- err
+		}()
+	}
+	for range seqs {
+		if err := <-errs; err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+	}
+	for i, got := range seqs {
+		slices.Sort(got)
+		for j, seq := range got {
+			if seq != j+1 {
+				t.Fatalf("run %d: expected seq 1..%d, got %v", i, n, got)
+			}
+		}
+		if len(got) != n {
+			t.Fatalf("run %d: expected %d events, got %d", i, n, len(got))
+		}
+	}
+}
+
 func logWriterSwap(t *testing.T, dst *bytes.Buffer) (restore func()) {
 	t.Helper()
 	// log package writes to stderr by default; keep output contained for tests.
@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// wilsonZ is the normal quantile for the 95% confidence intervals reported with -samples.
+const wilsonZ = 1.96
+
+// wilsonInterval returns the Wilson score interval for k successes in n trials. Unlike the normal
+// approximation it stays within [0, 1] and is meaningful for the small n of typical -samples runs.
+func wilsonInterval(k, n int) (lo, hi float64) {
+	if n <= 0 {
+		return 0, 0
+	}
+	p := float64(k) / float64(n)
+	nf := float64(n)
+	z2 := wilsonZ * wilsonZ
+	denom := 1 + z2/nf
+	center := (p + z2/(2*nf)) / denom
+	half := wilsonZ * math.Sqrt(p*(1-p)/nf+z2/(4*nf*nf)) / denom
+	return max(0, center-half), min(1, center+half)
+}
+
+// sampleTally is one repetition of a prompt; for conversations it spans every turn.
+type sampleTally struct {
+	answered   bool
+	score      int
+	categories map[MarkerCategory]bool
+	preview    string
+	status     int
+}
+
+// promptTally accumulates the samples of one prompt until the worker finishes it.
+type promptTally struct {
+	prompt  string
+	samples map[int]*sampleTally
+}
+
+func (t *promptTally) add(res RequestResult, categories map[MarkerCategory]bool, score int) {
+	st := t.samples[res.Sample]
+	if st == nil {
+		st = &sampleTally{categories: make(map[MarkerCategory]bool)}
+		t.samples[res.Sample] = st
+	}
+	if res.Err != nil {
+		return
+	}
+	st.answered = true
+	for c := range categories {
+		st.categories[c] = true
+	}
+	if score > st.score {
+		st.score = score
+		st.preview = previewOneLineBytes(res.AnalysisText(), 240)
+		st.status = res.StatusCode
+	}
+}
+
+// PromptSummary is the attack success rate of one prompt: the fraction of answered samples with any
+// marker hit, and per category the fraction of samples with a hit in that category. Samples that
+// failed with a transport error are not trials and are left out of both.
+type PromptSummary struct {
+	PromptID        string
+	Prompt          string
+	Samples         int
+	Successes       int
+	Categories      map[MarkerCategory]int
+	MaxScore        int
+	StatusCode      int
+	ResponsePreview string
+}
+
+func (t *promptTally) summary(promptID string) PromptSummary {
+	s := PromptSummary{PromptID: promptID, Prompt: t.prompt, Categories: make(map[MarkerCategory]int)}
+	for _, st := range t.samples {
+		if !st.answered {
+			continue
+		}
+		s.Samples++
+		if st.score > 0 {
+			s.Successes++
+		}
+		for c := range st.categories {
+			s.Categories[c]++
+		}
+		if st.score > s.MaxScore {
+			s.MaxScore = st.score
+			s.ResponsePreview = st.preview
+			s.StatusCode = st.status
+		}
+	}
+	return s
+}
+
+func (s PromptSummary) rate() float64 {
+	if s.Samples == 0 {
+		return 0
+	}
+	return float64(s.Successes) / float64(s.Samples)
+}
+
+// rankBefore orders prompts by success rate, then by the interval's lower bound (more samples is
+// stronger evidence), then by their worst response.
+func (s PromptSummary) rankBefore(o PromptSummary) bool {
+	if s.rate() != o.rate() {
+		return s.rate() > o.rate()
+	}
+	slo, _ := wilsonInterval(s.Successes, s.Samples)
+	olo, _ := wilsonInterval(o.Successes, o.Samples)
+	if slo != olo {
+		return slo > olo
+	}
+	return s.MaxScore > o.MaxScore
+}
+
+func (s PromptSummary) sortedCategories() []MarkerCategory {
+	cats := make([]MarkerCategory, 0, len(s.Categories))
+	for c := range s.Categories {
+		cats = append(cats, c)
+	}
+	sort.Slice(cats, func(i, j int) bool { return cats[i] < cats[j] })
+	return cats
+}
+
+// categoriesText renders "category=k/n" pairs for the summary log.
+func (s PromptSummary) categoriesText() string {
+	cats := s.sortedCategories()
+	if len(cats) == 0 {
+		return "-"
+	}
+	parts := make([]string, 0, len(cats))
+	for _, c := range cats {
+		parts = append(parts, string(c)+"="+intToString(s.Categories[c])+"/"+intToString(s.Samples))
+	}
+	return strings.Join(parts, ",")
+}
+
+type categoryRate struct {
+	Successes int     `json:"successes"`
+	Rate      float64 `json:"rate"`
+	CILow     float64 `json:"ci_low"`
+	CIHigh    float64 `json:"ci_high"`
+}
+
+// promptSummaryRow is the per-prompt aggregate row -samples adds to -jsonl-out after a prompt's last sample.
+type promptSummaryRow struct {
+	Kind        string                  `json:"kind"`
+	Time        string                  `json:"time"`
+	PromptID    string                  `json:"prompt_id"`
+	Target      string                  `json:"target,omitempty"`
+	Prompt      string                  `json:"prompt"`
+	Samples     int                     `json:"samples"`
+	Successes   int                     `json:"successes"`
+	SuccessRate float64                 `json:"success_rate"`
+	CILow       float64                 `json:"ci_low"`
+	CIHigh      float64                 `json:"ci_high"`
+	Categories  map[string]categoryRate `json:"categories,omitempty"`
+	MaxScore    int                     `json:"max_score"`
+}
+
+const rowKindPromptSummary = "prompt_summary"
+
+func (s PromptSummary) row() promptSummaryRow {
+	lo, hi := wilsonInterval(s.Successes, s.Samples)
+	row := promptSummaryRow{
+		Kind:        rowKindPromptSummary,
+		PromptID:    s.PromptID,
+		Prompt:      s.Prompt,
+		Samples:     s.Samples,
+		Successes:   s.Successes,
+		SuccessRate: s.rate(),
+		CILow:       lo,
+		CIHigh:      hi,
+		MaxScore:    s.MaxScore,
+	}
+	if len(s.Categories) > 0 {
+		row.Categories = make(map[string]categoryRate, len(s.Categories))
+		for c, k := range s.Categories {
+			lo, hi := wilsonInterval(k, s.Samples)
+			row.Categories[string(c)] = categoryRate{Successes: k, Rate: float64(k) / float64(s.Samples), CILow: lo, CIHigh: hi}
+		}
+	}
+	return row
+}
@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWilsonInterval(t *testing.T) {
+	cases := []struct {
+		k, n   int
+		lo, hi float64
+	}{
+		{0, 10, 0, 0.2775},
+		{5, 10, 0.2366, 0.7634},
+		{10, 10, 0.7225, 1},
+		{2, 4, 0.1500, 0.8500},
+	}
+	for _, c := range cases {
+		lo, hi := wilsonInterval(c.k, c.n)
+		if math.Abs(lo-c.lo) > 1e-4 || math.Abs(hi-c.hi) > 1e-4 {
+			t.Fatalf("wilson(%d, %d) = %.4f-%.4f, want %.4f-%.4f", c.k, c.n, lo, hi, c.lo, c.hi)
+		}
+	}
+	if lo, hi := wilsonInterval(0, 0); lo != 0 || hi != 0 {
+		t.Fatalf("expected an empty interval without trials")
+	}
+}
+
+func TestRun_SamplesReportAttackSuccessRate(t *testing.T) {
+	var mu sync.Mutex
+	seeds := map[string]bool{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Prompt, Sample, Seed string }
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		seeds[req.Seed] = true
+		mu.Unlock()
+		// The jailbreak works on odd samples only.
+		if req.Prompt == "jb" && (req.Sample == "1" || req.Sample == "3") {
+			_, _ = w.Write([]byte("Sure, BEGIN SYSTEM"))
+			return
+		}
+		_, _ = w.Write([]byte("I can't help with that."))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	prompts := filepath.Join(dir, "prompts.txt")
+	if err := os.WriteFile(prompts, []byte("jb\nbenign\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	jsonlOut := filepath.Join(dir, "out.jsonl")
+	cfg := Config{
+		URL:          srv.URL,
+		Method:       http.MethodPost,
+		BodyTemplate: `{"prompt":"{{prompt}}","sample":"{{sample}}","seed":"{{seed}}"}`,
+		Workers:      2,
+		Samples:      4,
+		Timeout:      2 * time.Second,
+		PromptsFile:  prompts,
+		JSONLOut:     jsonlOut,
+	}
+
+	var logs bytes.Buffer
+	restore := logWriterSwap(t, &logs)
+	defer restore()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := run(ctx, cfg); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(seeds) < 7 {
+		t.Fatalf("expected a fresh seed per sample, got %v", seeds)
+	}
+	for _, want := range []string{"attack_success", "asr_system_leak: 2/8 (25%, CI 7-59%)", "#1 asr=2/4 (50%, CI 15-85%)"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Fatalf("expected %q in the summary, got:\n%s", want, logs.String())
+		}
+	}
+
+	f, err := os.Open(jsonlOut)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	samples := map[string][]int{}
+	summaries := map[string]promptSummaryRow{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var row jsonlRow
+		if err := json.Unmarshal(sc.Bytes(), &row); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if row.Kind == rowKindPromptSummary {
+			var sum promptSummaryRow
+			if err := json.Unmarshal(sc.Bytes(), &sum); err != nil {
+				t.Fatalf("Unmarshal summary: %v", err)
+			}
+			if len(samples[sum.Prompt]) != 4 {
+				t.Fatalf("summary for %q written before its samples: %v", sum.Prompt, samples)
+			}
+			summaries[sum.Prompt] = sum
+			continue
+		}
+		samples[row.Prompt] = append(samples[row.Prompt], row.Sample)
+	}
+	jb, ok := summaries["jb"]
+	if !ok || jb.Samples != 4 || jb.Successes != 2 || jb.SuccessRate != 0.5 || jb.Categories["system_leak"].Successes != 2 {
+		t.Fatalf("unexpected jb summary %+v", jb)
+	}
+	if benign := summaries["benign"]; benign.Samples != 4 || benign.Successes != 0 || benign.CIHigh == 0 {
+		t.Fatalf("unexpected benign summary %+v", benign)
+	}
+	// A repeated prompt would be tallied as one prompt with twice the samples.
+	if err := os.WriteFile(prompts, []byte("jb\nbenign\njb\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	cfg.JSONLOut = ""
+	if _, err := run(ctx, cfg); err == nil || !strings.Contains(err.Error(), "duplicate prompt item") {
+		t.Fatalf("expected a duplicate prompt error, got %v", err)
+	}
+}
@@ -0,0 +1,335 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxSessionResponseBytes = 1 << 20
+
+// sessionFile is the -session-file format: login steps run before fuzzing (and again when the target
+// rejects the session), values extracted from their responses, and the headers/cookies built from them.
+type sessionFile struct {
+	Steps     []sessionStep     `json:"steps"`
+	Headers   map[string]string `json:"headers"`
+	Cookies   map[string]string `json:"cookies"`
+	RefreshOn []int             `json:"refresh_on"`
+}
+
+type sessionStep struct {
+	Name    string            `json:"name"`
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	Extract []sessionExtract  `json:"extract"`
+}
+
+// sessionExtract sets Var from exactly one source: a JSON path into the response body, a Set-Cookie
+// value, a response header, or (alone or applied to Header) the first capture group of Regex.
+type sessionExtract struct {
+	Var    string `json:"var"`
+	JSON   string `json:"json"`
+	Cookie string `json:"cookie"`
+	Header string `json:"header"`
+	Regex  string `json:"regex"`
+
+	path jsonPath
+	re   *regexp.Regexp
+}
+
+var defaultSessionRefreshOn = []int{http.StatusUnauthorized, http.StatusForbidden}
+
+type sessionAuth struct {
+	spec    sessionFile
+	vars    map[string]string // -var values; step extractions are layered on top
+	client  *http.Client
+	trace   bool
+	refresh map[int]bool
+
+	mu    sync.Mutex
+	creds authCredentials
+}
+
+func loadSessionFile(path string) (sessionFile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return sessionFile{}, fmt.Errorf("session file: read %q: %w", path, err)
+	}
+	var spec sessionFile
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil {
+		return sessionFile{}, fmt.Errorf("session file: invalid JSON: %w", err)
+	}
+	if err := spec.compile(); err != nil {
+		return sessionFile{}, err
+	}
+	return spec, nil
+}
+
+func (s *sessionFile) compile() error {
+	if len(s.Steps) == 0 {
+		return fmt.Errorf("session file: at least one step is required")
+	}
+	for i := range s.Steps {
+		st := &s.Steps[i]
+		if st.Name == "" {
+			st.Name = fmt.Sprintf("step%d", i+1)
+		}
+		if st.URL == "" {
+			return fmt.Errorf("session file: %s: missing url", st.Name)
+		}
+		st.Method = strings.ToUpper(strings.TrimSpace(st.Method))
+		if st.Method == "" {
+			st.Method = http.MethodPost
+			if st.Body == "" {
+				st.Method = http.MethodGet
+			}
+		}
+		for j := range st.Extract {
+			ex := &st.Extract[j]
+			if ex.Var == "" {
+				return fmt.Errorf("session file: %s: extract[%d]: missing var", st.Name, j)
+			}
+			sources := 0
+			for _, s := range []string{ex.JSON, ex.Cookie, ex.Header} {
+				if s != "" {
+					sources++
+				}
+			}
+			if sources == 0 && ex.Regex != "" {
+				sources = 1
+			}
+			if sources != 1 || (ex.Regex != "" && (ex.JSON != "" || ex.Cookie != "")) {
+				return fmt.Errorf("session file: %s: extract %q: set exactly one of json, cookie, header or regex (regex may refine header)", st.Name, ex.Var)
+			}
+			if ex.JSON != "" {
+				p, err := parseJSONPath(ex.JSON)
+				if err != nil {
+					return fmt.Errorf("session file: %s: extract %q: %w", st.Name, ex.Var, err)
+				}
+				ex.path = p
+			}
+			if ex.Regex != "" {
+				re, err := regexp.Compile(ex.Regex)
+				if err != nil {
+					return fmt.Errorf("session file: %s: extract %q: %w", st.Name, ex.Var, err)
+				}
+				ex.re = re
+			}
+		}
+	}
+	return nil
+}
+
+func newSessionAuth(spec sessionFile, vars map[string]string, rt http.RoundTripper, timeout time.Duration, trace bool) *sessionAuth {
+	refreshOn := spec.RefreshOn
+	if len(refreshOn) == 0 {
+		refreshOn = defaultSessionRefreshOn
+	}
+	refresh := make(map[int]bool, len(refreshOn))
+	for _, c := range refreshOn {
+		refresh[c] = true
+	}
+	return &sessionAuth{
+		spec:    spec,
+		vars:    vars,
+		trace:   trace,
+		refresh: refresh,
+		client: &http.Client{
+			Transport: rt,
+			Timeout:   timeout,
+			// Login endpoints often answer 302 + Set-Cookie; keep that response.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+}
+
+func (a *sessionAuth) Credentials(ctx context.Context) (authCredentials, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.creds.gen == 0 {
+		if err := a.loginLocked(ctx); err != nil {
+			return authCredentials{}, err
+		}
+	}
+	return a.creds, nil
+}
+
+func (a *sessionAuth) Refresh(ctx context.Context, gen uint64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.creds.gen != gen {
+		return nil
+	}
+	return a.loginLocked(ctx)
+}
+
+func (a *sessionAuth) RefreshOn(status int) bool { return a.refresh[status] }
+
+// loginLocked runs every step in order. Cookies set by a step are sent with the following steps and
+// injected into target requests along with the spec's "cookies".
+func (a *sessionAuth) loginLocked(ctx context.Context) error {
+	in := promptInput{Vars: make(map[string]string, len(a.vars))}
+	for k, v := range a.vars {
+		in.Vars[k] = v
+	}
+	jar := map[string]*http.Cookie{}
+	var order []string
+
+	for _, st := range a.spec.Steps {
+		in = in.withRequestBuiltins(0)
+		resp, body, err := a.runStep(ctx, st, in, jar, order)
+		if err != nil {
+			return err
+		}
+		for _, c := range resp.Cookies() {
+			if _, ok := jar[c.Name]; !ok {
+				order = append(order, c.Name)
+			}
+			jar[c.Name] = &http.Cookie{Name: c.Name, Value: c.Value}
+		}
+		for _, ex := range st.Extract {
+			v, err := ex.value(resp, body)
+			if err != nil {
+				return fmt.Errorf("session %s: extract %q: %w", st.Name, ex.Var, err)
+			}
+			in.Vars[ex.Var] = v
+		}
+		if a.trace {
+			log.Printf("session_step: name=%s status=%d", st.Name, resp.StatusCode)
+		}
+	}
+
+	creds := authCredentials{Header: make(http.Header), gen: a.creds.gen + 1}
+	for k, tmpl := range a.spec.Headers {
+		v, err := in.expand(tmpl, headerValueEscape)
+		if err != nil {
+			return fmt.Errorf("session header %s: %w", k, err)
+		}
+		creds.Header.Set(k, v)
+	}
+	names := make([]string, 0, len(a.spec.Cookies))
+	for k := range a.spec.Cookies {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		tmpl := a.spec.Cookies[k]
+		v, err := in.expand(tmpl, rawEscape)
+		if err != nil {
+			return fmt.Errorf("session cookie %s: %w", k, err)
+		}
+		if _, ok := jar[k]; !ok {
+			order = append(order, k)
+		}
+		jar[k] = &http.Cookie{Name: k, Value: v}
+	}
+	for _, name := range order {
+		creds.Cookies = append(creds.Cookies, jar[name])
+	}
+	a.creds = creds
+	return nil
+}
+
+func (a *sessionAuth) runStep(ctx context.Context, st sessionStep, in promptInput, jar map[string]*http.Cookie, order []string) (*http.Response, []byte, error) {
+	u, err := in.expand(st.URL, rawEscape)
+	if err != nil {
+		return nil, nil, fmt.Errorf("session %s: url: %w", st.Name, err)
+	}
+	var body io.Reader
+	if st.Body != "" {
+		b, err := in.expand(st.Body, rawEscape)
+		if err != nil {
+			return nil, nil, fmt.Errorf("session %s: body: %w", st.Name, err)
+		}
+		body = strings.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, st.Method, u, body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("session %s: build request: %w", st.Name, err)
+	}
+	for k, tmpl := range st.Headers {
+		v, err := in.expand(tmpl, headerValueEscape)
+		if err != nil {
+			return nil, nil, fmt.Errorf("session %s: header %s: %w", st.Name, k, err)
+		}
+		req.Header.Set(k, v)
+	}
+	for _, name := range order {
+		req.AddCookie(jar[name])
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("session %s: %w", st.Name, err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxSessionResponseBytes))
+	if err != nil {
+		return nil, nil, fmt.Errorf("session %s: read response: %w", st.Name, err)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, nil, fmt.Errorf("session %s: %s %s returned status %d: %s", st.Name, st.Method, req.URL.Redacted(), resp.StatusCode, previewOneLine(string(b), 200))
+	}
+	return resp, b, nil
+}
+
+func (ex sessionExtract) value(resp *http.Response, body []byte) (string, error) {
+	switch {
+	case ex.JSON != "":
+		var v any
+		if err := json.Unmarshal(body, &v); err != nil {
+			return "", fmt.Errorf("response is not JSON: %w", err)
+		}
+		vals := ex.path.Lookup(v)
+		if len(vals) == 0 || vals[0] == nil {
+			return "", fmt.Errorf("json path %q matched nothing", ex.JSON)
+		}
+		if s, ok := vals[0].(string); ok {
+			return s, nil
+		}
+		b, err := json.Marshal(vals[0])
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	case ex.Cookie != "":
+		for _, c := range resp.Cookies() {
+			if c.Name == ex.Cookie {
+				return c.Value, nil
+			}
+		}
+		return "", fmt.Errorf("no Set-Cookie named %q", ex.Cookie)
+	}
+	src := string(body)
+	if ex.Header != "" {
+		src = resp.Header.Get(ex.Header)
+		if src == "" {
+			return "", fmt.Errorf("response header %s is missing", ex.Header)
+		}
+		if ex.re == nil {
+			return src, nil
+		}
+	}
+	m := ex.re.FindStringSubmatch(src)
+	if m == nil {
+		return "", fmt.Errorf("regex %q did not match", ex.Regex)
+	}
+	if len(m) > 1 {
+		return m[1], nil
+	}
+	return m[0], nil
+}
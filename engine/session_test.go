@@ -0,0 +1,171 @@
+package engine
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newLoginServer issues token-N on each POST /login (with a csrf cookie) and accepts only the latest
+// token on /chat; revoke() invalidates the current one to simulate expiry mid-run.
+func newLoginServer(t *testing.T) (srv *httptest.Server, logins *atomic.Int32, revoke func()) {
+	t.Helper()
+	logins = &atomic.Int32{}
+	var mu sync.Mutex
+	valid := ""
+	mux := http.NewServeMux()
+	mux.HandleFunc("/csrf", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<input name="csrf" value="tok-123">`))
+	})
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-CSRF") != "tok-123" {
+			http.Error(w, "bad csrf", http.StatusBadRequest)
+			return
+		}
+		n := logins.Add(1)
+		mu.Lock()
+		valid = fmt.Sprintf("token-%d", n)
+		mu.Unlock()
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: fmt.Sprintf("s%d", n)})
+		_, _ = fmt.Fprintf(w, `{"data":{"access_token":%q}}`, valid)
+	})
+	mux.HandleFunc("/chat", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		want := valid
+		mu.Unlock()
+		c, err := r.Cookie("sid")
+		if r.Header.Get("Authorization") != "Bearer "+want || err != nil || c.Value == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte("ok " + want))
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, logins, func() {
+		mu.Lock()
+		valid = "revoked"
+		mu.Unlock()
+	}
+}
+
+func writeSessionFile(t *testing.T, base string) string {
+	t.Helper()
+	spec := fmt.Sprintf(`{
+  "steps": [
+    {"name": "csrf", "url": "%[1]s/csrf", "extract": [{"var": "csrf", "regex": "name=\"csrf\" value=\"([^\"]+)\""}]},
+    {"name": "login", "method": "POST", "url": "%[1]s/login",
+     "headers": {"X-CSRF": "{{var:csrf}}", "Content-Type": "application/json"},
+     "body": "{\"user\":\"{{var:user}}\"}",
+     "extract": [{"var": "token", "json": "data.access_token"}, {"var": "sid", "cookie": "sid"}]}
+  ],
+  "headers": {"Authorization": "Bearer {{var:token}}"}
+}`, base)
+	path := filepath.Join(t.TempDir(), "session.json")
+	if err := os.WriteFile(path, []byte(spec), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSession_BootstrapAndRefreshOn401(t *testing.T) {
+	srv, logins, revoke := newLoginServer(t)
+	spec, err := loadSessionFile(writeSessionFile(t, srv.URL))
+	if err != nil {
+		t.Fatalf("loadSessionFile: %v", err)
+	}
+	cfg := Config{
+		URL:     srv.URL + "/chat",
+		Method:  http.MethodPost,
+		Timeout: 5 * time.Second,
+		auth:    newSessionAuth(spec, map[string]string{"user": "alice"}, nil, 5*time.Second, false),
+	}
+
+	res := sendOne(t.Context(), srv.Client(), cfg, nil, nil, 1, promptInput{Prompt: "hi"})
+	if res.Err != nil || res.StatusCode != http.StatusOK || string(res.Body) != "ok token-1" {
+		t.Fatalf("unexpected first result: status=%d body=%q err=%v", res.StatusCode, res.Body, res.Err)
+	}
+
+	revoke()
+	var wg sync.WaitGroup
+	results := make([]RequestResult, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = sendOne(t.Context(), srv.Client(), cfg, nil, nil, i, promptInput{Prompt: "hi"})
+		}(i)
+	}
+	wg.Wait()
+	for i, r := range results {
+		if r.Err != nil || r.StatusCode != http.StatusOK {
+			t.Fatalf("result %d: status=%d err=%v", i, r.StatusCode, r.Err)
+		}
+	}
+	if got := logins.Load(); got != 2 {
+		t.Fatalf("expected one re-login for concurrent 401s (2 logins total), got %d", got)
+	}
+}
+
+func TestSession_RefreshGivesUpAfterOneRetry(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			_, _ = w.Write([]byte(`{"token":"t"}`))
+			return
+		}
+		calls++
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	spec := sessionFile{
+		Steps:   []sessionStep{{URL: srv.URL + "/login", Extract: []sessionExtract{{Var: "t", JSON: "token"}}}},
+		Headers: map[string]string{"Authorization": "Bearer {{var:t}}"},
+	}
+	if err := spec.compile(); err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	cfg := Config{URL: srv.URL, Method: http.MethodPost, Timeout: 5 * time.Second, auth: newSessionAuth(spec, nil, nil, 5*time.Second, false)}
+	res := sendOne(t.Context(), srv.Client(), cfg, nil, nil, 1, promptInput{Prompt: "hi"})
+	if res.StatusCode != http.StatusForbidden || calls != 2 || res.Attempts != 2 {
+		t.Fatalf("expected a single re-auth retry, got status=%d calls=%d attempts=%d", res.StatusCode, calls, res.Attempts)
+	}
+}
+
+func TestSessionFile_Validation(t *testing.T) {
+	cases := map[string]sessionFile{
+		"no steps":     {},
+		"no url":       {Steps: []sessionStep{{Name: "a"}}},
+		"no var":       {Steps: []sessionStep{{URL: "http://x", Extract: []sessionExtract{{JSON: "a"}}}}},
+		"two sources":  {Steps: []sessionStep{{URL: "http://x", Extract: []sessionExtract{{Var: "v", JSON: "a", Cookie: "b"}}}}},
+		"bad regex":    {Steps: []sessionStep{{URL: "http://x", Extract: []sessionExtract{{Var: "v", Regex: "("}}}}},
+		"regex + json": {Steps: []sessionStep{{URL: "http://x", Extract: []sessionExtract{{Var: "v", JSON: "a", Regex: "b"}}}}},
+	}
+	for name, spec := range cases {
+		if err := spec.compile(); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestWithAuth_OverridesStaticValues(t *testing.T) {
+	base := http.Header{"Authorization": {"static"}, "X-Keep": {"1"}}
+	cookies := []*http.Cookie{{Name: "sid", Value: "old"}, {Name: "theme", Value: "dark"}}
+	h, cs := withAuth(base, cookies, authCredentials{
+		Header:  http.Header{"Authorization": {"Bearer new"}},
+		Cookies: []*http.Cookie{{Name: "sid", Value: "new"}},
+	})
+	if h.Get("Authorization") != "Bearer new" || h.Get("X-Keep") != "1" || base.Get("Authorization") != "static" {
+		t.Fatalf("unexpected headers %v (base %v)", h, base)
+	}
+	if len(cs) != 2 || cs[0].Name != "theme" || cs[1].Value != "new" {
+		t.Fatalf("unexpected cookies %v", cs)
+	}
+}
@@ -0,0 +1,366 @@
+package engine
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	signHMAC  = "hmac"
+	signSigV4 = "sigv4"
+
+	DefaultHMACHeader          = "X-Signature"
+	DefaultHMACTimestampHeader = "X-Timestamp"
+	DefaultHMACString          = "{{method}}\\n{{path}}\\n{{timestamp}}\\n{{body_sha256}}"
+)
+
+// requestSigner adds authentication headers computed over the final request. It runs on every attempt,
+// after headers, cookies and credentials are applied and right before the request is sent.
+type requestSigner interface {
+	Sign(req *http.Request, body []byte, now time.Time) error
+}
+
+type SignConfig struct {
+	Kind string
+
+	HMACSecret          string
+	HMACHeader          string
+	HMACTimestampHeader string
+	HMACString          string
+	HMACEncoding        string
+
+	SigV4Service string
+	SigV4Region  string
+	SigV4Profile string
+}
+
+func (c SignConfig) validate() error {
+	switch c.Kind {
+	case "":
+		return nil
+	case signHMAC:
+		if c.HMACSecret == "" {
+			return errors.New("-sign hmac requires -hmac-secret")
+		}
+		switch c.HMACEncoding {
+		case "", "hex", "base64":
+		default:
+			return fmt.Errorf("unknown -hmac-encoding %q (expected hex|base64)", c.HMACEncoding)
+		}
+		return nil
+	case signSigV4:
+		if c.SigV4Service == "" || c.SigV4Region == "" {
+			return errors.New("-sign sigv4 requires -sigv4-service and -sigv4-region")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown -sign %q (expected %s|%s)", c.Kind, signHMAC, signSigV4)
+	}
+}
+
+func newRequestSigner(c SignConfig) (requestSigner, error) {
+	switch c.Kind {
+	case signHMAC:
+		secret, err := promptInput{}.expand(c.HMACSecret, rawEscape)
+		if err != nil {
+			return nil, fmt.Errorf("-hmac-secret: %w", err)
+		}
+		tmpl := c.HMACString
+		if tmpl == "" {
+			tmpl = DefaultHMACString
+		}
+		return &hmacSigner{
+			secret:          []byte(secret),
+			header:          firstNonEmpty(c.HMACHeader, DefaultHMACHeader),
+			timestampHeader: c.HMACTimestampHeader,
+			canonical:       strings.NewReplacer(`\n`, "\n", `\t`, "\t").Replace(tmpl),
+			base64:          c.HMACEncoding == "base64",
+		}, nil
+	case signSigV4:
+		creds, err := loadAWSCredentials(c.SigV4Profile)
+		if err != nil {
+			return nil, err
+		}
+		return &sigV4Signer{service: c.SigV4Service, region: c.SigV4Region, creds: creds}, nil
+	default:
+		return nil, nil
+	}
+}
+
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// hmacSigner signs a canonical string built from a template with {{method}}, {{path}}, {{query}},
+// {{host}}, {{timestamp}} (Unix seconds), {{body}}, {{body_sha256}} (hex) and {{header:Name}}.
+type hmacSigner struct {
+	secret          []byte
+	header          string
+	timestampHeader string
+	canonical       string
+	base64          bool
+}
+
+var hmacPlaceholderRe = regexp.MustCompile(`\{\{(method|path|query|host|timestamp|body|body_sha256|header:[A-Za-z0-9-]+)\}\}`)
+
+func (s *hmacSigner) Sign(req *http.Request, body []byte, now time.Time) error {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	if s.timestampHeader != "" {
+		req.Header.Set(s.timestampHeader, ts)
+	}
+	msg := hmacPlaceholderRe.ReplaceAllStringFunc(s.canonical, func(m string) string {
+		switch name := m[2 : len(m)-2]; name {
+		case "method":
+			return req.Method
+		case "path":
+			return req.URL.EscapedPath()
+		case "query":
+			return req.URL.RawQuery
+		case "host":
+			return req.URL.Host
+		case "timestamp":
+			return ts
+		case "body":
+			return string(body)
+		case "body_sha256":
+			sum := sha256.Sum256(body)
+			return hex.EncodeToString(sum[:])
+		default:
+			return req.Header.Get(strings.TrimPrefix(name, "header:"))
+		}
+	})
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(msg))
+	sig := mac.Sum(nil)
+	if s.base64 {
+		req.Header.Set(s.header, base64.StdEncoding.EncodeToString(sig))
+	} else {
+		req.Header.Set(s.header, hex.EncodeToString(sig))
+	}
+	return nil
+}
+
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// loadAWSCredentials reads AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY (/ AWS_SESSION_TOKEN), falling back
+// to the shared credentials file (AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials) for profile
+// (or AWS_PROFILE, or "default").
+func loadAWSCredentials(profile string) (awsCredentials, error) {
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" && profile == "" {
+		return awsCredentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	if profile == "" {
+		profile = firstNonEmpty(os.Getenv("AWS_PROFILE"), "default")
+	}
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return awsCredentials{}, fmt.Errorf("sigv4: no AWS credentials in the environment and no home directory: %w", err)
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("sigv4: no AWS credentials in the environment and cannot read %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var creds awsCredentials
+	inProfile := false
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			inProfile = strings.TrimSpace(line[1:len(line)-1]) == profile
+			continue
+		}
+		if !inProfile {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(k)) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = strings.TrimSpace(v)
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = strings.TrimSpace(v)
+		case "aws_session_token":
+			creds.SessionToken = strings.TrimSpace(v)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return awsCredentials{}, fmt.Errorf("sigv4: read %s: %w", path, err)
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return awsCredentials{}, fmt.Errorf("sigv4: profile %q in %s has no aws_access_key_id/aws_secret_access_key", profile, path)
+	}
+	return creds, nil
+}
+
+// sigV4Signer implements AWS Signature Version 4 for non-S3 services (e.g. execute-api, bedrock).
+// It signs Host, Content-Type and every X-Amz-* header.
+type sigV4Signer struct {
+	service string
+	region  string
+	creds   awsCredentials
+}
+
+func (s *sigV4Signer) Sign(req *http.Request, body []byte, now time.Time) error {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Del("Authorization")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.creds.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, vs := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "content-type" || strings.HasPrefix(lk, "x-amz-") {
+			headers[lk] = strings.Join(trimAll(vs), ",")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	payloadHash := sha256.Sum256(body)
+	canonical := strings.Join([]string{
+		req.Method,
+		sigV4CanonicalURI(req.URL.EscapedPath()),
+		sigV4CanonicalQuery(req.URL.RawQuery),
+		canonHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := day + "/" + s.region + "/" + s.service + "/aws4_request"
+	canonHash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.creds.SecretAccessKey), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, s.service)
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.creds.AccessKeyID, scope, signedHeaders, sig))
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func trimAll(vs []string) []string {
+	out := make([]string, len(vs))
+	for i, v := range vs {
+		out[i] = strings.Join(strings.Fields(v), " ")
+	}
+	return out
+}
+
+// sigV4CanonicalURI encodes each segment of the (already escaped) path again, as non-S3 services expect.
+func sigV4CanonicalURI(escapedPath string) string {
+	if escapedPath == "" {
+		return "/"
+	}
+	segs := strings.Split(escapedPath, "/")
+	for i, seg := range segs {
+		segs[i] = awsURIEncode(seg)
+	}
+	return strings.Join(segs, "/")
+}
+
+func sigV4CanonicalQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	type kv struct{ k, v string }
+	var pairs []kv
+	for _, part := range strings.Split(rawQuery, "&") {
+		if part == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(part, "=")
+		pairs = append(pairs, kv{awsURIEncode(queryUnescapeLoose(k)), awsURIEncode(queryUnescapeLoose(v))})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].k != pairs[j].k {
+			return pairs[i].k < pairs[j].k
+		}
+		return pairs[i].v < pairs[j].v
+	})
+	out := make([]string, len(pairs))
+	for i, p := range pairs {
+		out[i] = p.k + "=" + p.v
+	}
+	return strings.Join(out, "&")
+}
+
+func queryUnescapeLoose(s string) string {
+	u, err := url.QueryUnescape(s)
+	if err != nil {
+		return s
+	}
+	return u
+}
+
+// awsURIEncode percent-encodes everything except RFC 3986 unreserved characters.
+func awsURIEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
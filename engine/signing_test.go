@@ -0,0 +1,153 @@
+package engine
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// AWS SigV4 test suite: get-vanilla.
+func TestSigV4_GetVanilla(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &sigV4Signer{service: "service", region: "us-east-1", creds: awsCredentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	if err := s.Sign(req, nil, now); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Fatalf("Authorization mismatch:\n got %s\nwant %s", got, want)
+	}
+	if req.Header.Get("X-Amz-Date") != "20150830T123600Z" {
+		t.Fatalf("unexpected X-Amz-Date %q", req.Header.Get("X-Amz-Date"))
+	}
+}
+
+func TestSigV4_CanonicalQueryAndURI(t *testing.T) {
+	if got := sigV4CanonicalQuery("b=2&a=x%20y&a=1&c"); got != "a=1&a=x%20y&b=2&c=" {
+		t.Fatalf("unexpected canonical query %q", got)
+	}
+	if got := sigV4CanonicalURI("/a%20b/c"); got != "/a%2520b/c" {
+		t.Fatalf("unexpected canonical uri %q", got)
+	}
+	if got := sigV4CanonicalURI(""); got != "/" {
+		t.Fatalf("unexpected canonical uri for empty path %q", got)
+	}
+}
+
+func TestHMACSigner_ResignsEveryAttempt(t *testing.T) {
+	const secret = "k3y"
+	var sigs []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+		msg := r.Method + "\n" + r.URL.EscapedPath() + "\n" + r.Header.Get("X-Timestamp") + "\n" + hex.EncodeToString(sum[:])
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(msg))
+		if r.Header.Get("X-Signature") != hex.EncodeToString(mac.Sum(nil)) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		sigs = append(sigs, r.Header.Get("X-Signature"))
+		if len(sigs) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	signer, err := newRequestSigner(SignConfig{Kind: signHMAC, HMACSecret: secret, HMACHeader: DefaultHMACHeader, HMACTimestampHeader: DefaultHMACTimestampHeader})
+	if err != nil {
+		t.Fatalf("newRequestSigner: %v", err)
+	}
+	cfg := Config{
+		URL:     srv.URL + "/v1/chat",
+		Method:  http.MethodPost,
+		Timeout: 5 * time.Second,
+		Retry:   RetryConfig{MaxRetries: 1},
+		signer:  signer,
+	}
+	res := sendOne(t.Context(), srv.Client(), cfg, http.Header{"X-Signature": {"stale"}}, nil, 1, promptInput{Prompt: "hi"})
+	if res.Err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected result: status=%d err=%v", res.StatusCode, res.Err)
+	}
+	if len(sigs) != 2 {
+		t.Fatalf("expected both attempts to carry a valid signature, got %d", len(sigs))
+	}
+}
+
+func TestHMACSigner_CustomCanonicalString(t *testing.T) {
+	signer, err := newRequestSigner(SignConfig{Kind: signHMAC, HMACSecret: "s", HMACHeader: "X-Sig", HMACString: `{{host}}|{{query}}|{{header:X-Tenant}}|{{body}}`, HMACEncoding: "base64"})
+	if err != nil {
+		t.Fatalf("newRequestSigner: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodPost, "https://api.example.test/x?a=1", strings.NewReader("b"))
+	req.Header.Set("X-Tenant", "t1")
+	if err := signer.Sign(req, []byte("b"), time.Unix(1700000000, 0)); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	mac := hmac.New(sha256.New, []byte("s"))
+	mac.Write([]byte("api.example.test|a=1|t1|b"))
+	if got, want := req.Header.Get("X-Sig"), base64.StdEncoding.EncodeToString(mac.Sum(nil)); got != want {
+		t.Fatalf("signature mismatch: got %q want %q", got, want)
+	}
+}
+
+func TestLoadAWSCredentials_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	if err := os.WriteFile(path, []byte("[default]\naws_access_key_id = A\naws_secret_access_key = B\n\n[gw]\naws_access_key_id=C\naws_secret_access_key=D\naws_session_token=E\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", path)
+
+	c, err := loadAWSCredentials("gw")
+	if err != nil || c.AccessKeyID != "C" || c.SecretAccessKey != "D" || c.SessionToken != "E" {
+		t.Fatalf("unexpected creds %+v err=%v", c, err)
+	}
+	c, err = loadAWSCredentials("")
+	if err != nil || c.AccessKeyID != "A" {
+		t.Fatalf("unexpected default creds %+v err=%v", c, err)
+	}
+	if _, err := loadAWSCredentials("missing"); err == nil {
+		t.Fatalf("expected error for missing profile")
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "ENVID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "ENVSECRET")
+	if c, _ := loadAWSCredentials(""); c.AccessKeyID != "ENVID" {
+		t.Fatalf("expected env credentials to win, got %+v", c)
+	}
+}
+
+func TestSignConfig_Validate(t *testing.T) {
+	bad := []SignConfig{
+		{Kind: "rsa"},
+		{Kind: signHMAC},
+		{Kind: signHMAC, HMACSecret: "x", HMACEncoding: "b32"},
+		{Kind: signSigV4, SigV4Region: "us-east-1"},
+	}
+	for _, c := range bad {
+		if err := c.validate(); err == nil {
+			t.Fatalf("expected error for %+v", c)
+		}
+	}
+}
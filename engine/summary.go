@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"maps"
+	"time"
+)
+
+// Summary is the outcome of a run: the figures of the summary Run logs when it ends.
+type Summary struct {
+	// Target names the -matrix target; empty for single-target runs.
+	Target string
+
+	Sent      int
+	Errors    int
+	Resumed   int
+	Retried   int
+	Retries   int
+	Truncated int
+	FirstErr  error
+
+	StatusCounts map[int]int
+	LatencyMin   time.Duration
+	LatencyAvg   time.Duration
+	LatencyMax   time.Duration
+
+	// Severity is the highest category severity hit, after elevate_after_responses.
+	Severity          Severity
+	CategoryResponses map[MarkerCategory]int
+	CategoryMatches   map[MarkerCategory]int
+	MarkerResponses   map[string]int
+	MarkerMatches     map[string]int
+
+	// TopOffenders ranks single responses by score; with -samples, TopPrompts ranks prompts by
+	// attack success rate instead.
+	TopOffenders []OffendingResponse
+	TopPrompts   []PromptSummary
+
+	// Targets holds one summary per target of a -matrix run; the top level then only carries the
+	// combined counts (Sent, Errors, Severity).
+	Targets []Summary
+}
+
+func (r *report) summary() Summary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := Summary{
+		Target:            r.target,
+		Sent:              r.total,
+		Errors:            r.errs,
+		Resumed:           r.resumed,
+		Retried:           r.retried,
+		Retries:           r.retries,
+		Truncated:         r.truncated,
+		FirstErr:          r.firstErr,
+		StatusCounts:      maps.Clone(r.byStatus),
+		LatencyMin:        r.latencyMin,
+		LatencyMax:        r.latencyMax,
+		Severity:          r.maxSeverity,
+		CategoryResponses: maps.Clone(r.categoryRespCounts),
+		CategoryMatches:   maps.Clone(r.categoryMatchCounts),
+		MarkerResponses:   maps.Clone(r.markerResponseCounts),
+		MarkerMatches:     maps.Clone(r.markerMatchCounts),
+		TopOffenders:      append([]OffendingResponse(nil), r.top...),
+		TopPrompts:        append([]PromptSummary(nil), r.topPrompts...),
+	}
+	if r.latencyCount > 0 {
+		s.LatencyAvg = time.Duration(int64(r.latencyTotal) / int64(r.latencyCount))
+	}
+	return s
+}
+
+// matrixSummary combines the per-target summaries of a -matrix run.
+func matrixSummary(reports []*report) Summary {
+	s := Summary{Severity: SeverityInfo}
+	for _, r := range reports {
+		t := r.summary()
+		s.Sent += t.Sent
+		s.Errors += t.Errors
+		s.Severity = max(s.Severity, t.Severity)
+		s.Targets = append(s.Targets, t)
+	}
+	return s
+}
@@ -0,0 +1,183 @@
+package engine
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"os"
+	"poke/promptset"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// placeholderRe matches every substitution templates understand except {{history}}, which is structural
+// (JSON only) and handled by replacePlaceholdersInJSON.
+var placeholderRe = regexp.MustCompile(`\{\{(prompt|uuid|seq|timestamp|sample|seed|var:[A-Za-z0-9_.\-]+|env:[A-Za-z_][A-Za-z0-9_]*)\}\}`)
+
+// Vars collects repeatable -var name=value flags.
+type Vars map[string]string
+
+func (v *Vars) String() string {
+	if v == nil || len(*v) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(*v))
+	for k := range *v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+(*v)[k])
+	}
+	return strings.Join(parts, ",")
+}
+
+func (v *Vars) Set(s string) error {
+	k, val, ok := strings.Cut(s, "=")
+	k = strings.TrimSpace(k)
+	if !ok || k == "" {
+		return fmt.Errorf("expected name=value, got %q", s)
+	}
+	if *v == nil {
+		*v = make(Vars)
+	}
+	(*v)[k] = val
+	return nil
+}
+
+// newPromptInput merges variables for one prompt: -var values, overridden by the item's "vars";
+// the item's id is available as {{var:id}} unless set explicitly.
+func newPromptInput(cfg Config, item promptset.Item, prompt string, history []chatMessage) promptInput {
+	in := promptInput{Prompt: prompt, History: history}
+	if len(cfg.Vars) == 0 && len(item.Vars) == 0 && item.ID == "" {
+		return in
+	}
+	in.Vars = make(map[string]string, len(cfg.Vars)+len(item.Vars)+1)
+	if item.ID != "" {
+		in.Vars["id"] = item.ID
+	}
+	for k, v := range cfg.Vars {
+		in.Vars[k] = v
+	}
+	for k, v := range item.Vars {
+		in.Vars[k] = v
+	}
+	return in
+}
+
+// withRequestBuiltins fixes {{seq}}, {{uuid}} and {{timestamp}} for one request, so every occurrence
+// in the URL, headers and body agrees.
+func (in promptInput) withRequestBuiltins(seq int) promptInput {
+	in.Seq = seq
+	in.UUID = newUUID()
+	in.Time = time.Now()
+	return in
+}
+
+// withSample fixes {{sample}} (1-based) and {{seed}} for every request of one -samples repetition.
+func (in promptInput) withSample(sample int, seed uint32) promptInput {
+	in.Sample = sample
+	in.Seed = seed
+	return in
+}
+
+// expand substitutes placeholders in s, passing each value through esc. Unknown variables and unset
+// environment variables are errors rather than silently empty.
+func (in promptInput) expand(s string, esc func(string) string) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	var firstErr error
+	out := placeholderRe.ReplaceAllStringFunc(s, func(m string) string {
+		v, err := in.placeholderValue(m[2 : len(m)-2])
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return m
+		}
+		return esc(v)
+	})
+	if firstErr != nil {
+		return "", firstErr
+	}
+	return out, nil
+}
+
+func (in promptInput) placeholderValue(name string) (string, error) {
+	switch name {
+	case "prompt":
+		return in.Prompt, nil
+	case "uuid":
+		return in.UUID, nil
+	case "seq":
+		return strconv.Itoa(in.Seq), nil
+	case "sample":
+		return strconv.Itoa(max(in.Sample, 1)), nil
+	case "seed":
+		return strconv.FormatUint(uint64(in.Seed), 10), nil
+	case "timestamp":
+		if in.Time.IsZero() {
+			return "", nil
+		}
+		return strconv.FormatInt(in.Time.Unix(), 10), nil
+	}
+	if k, ok := strings.CutPrefix(name, "var:"); ok {
+		v, ok := in.Vars[k]
+		if !ok {
+			return "", fmt.Errorf("template: undefined variable %q (set it with -var %s=... or the prompt's \"vars\")", k, k)
+		}
+		return v, nil
+	}
+	if k, ok := strings.CutPrefix(name, "env:"); ok {
+		v, ok := os.LookupEnv(k)
+		if !ok {
+			return "", fmt.Errorf("template: environment variable %s is not set", k)
+		}
+		return v, nil
+	}
+	return "", fmt.Errorf("template: unknown placeholder {{%s}}", name)
+}
+
+// renderHeaders expands placeholders in -headers-file values for one request.
+func renderHeaders(h http.Header, in promptInput) (http.Header, error) {
+	if len(h) == 0 {
+		return h, nil
+	}
+	out := make(http.Header, len(h))
+	for k, vs := range h {
+		for _, v := range vs {
+			ev, err := in.expand(v, headerValueEscape)
+			if err != nil {
+				return nil, fmt.Errorf("header %s: %w", k, err)
+			}
+			out[k] = append(out[k], ev)
+		}
+	}
+	return out, nil
+}
+
+// newSeed returns a random 31-bit seed, small enough for any API's integer seed parameter.
+func newSeed() uint32 {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b[:]) >> 1
+}
+
+// newUUID returns a random RFC 4122 version 4 UUID.
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
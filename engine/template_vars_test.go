@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"encoding/json"
+	"net/http"
+	"poke/promptset"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestPromptInput_ExpandPlaceholders(t *testing.T) {
+	t.Setenv("POKE_TEST_MODEL", "gpt-test")
+	cfg := Config{Vars: Vars{"tenant": "acme", "session": "default"}}
+	item := promptset.Item{ID: "case-7", Vars: map[string]string{"session": "s-123"}}
+	in := newPromptInput(cfg, item, "hi", nil).withRequestBuiltins(42)
+
+	got, err := in.expand("{{prompt}}|{{var:tenant}}|{{var:session}}|{{var:id}}|{{env:POKE_TEST_MODEL}}|{{seq}}|{{history}}", rawEscape)
+	if err != nil {
+		t.Fatalf("expand: %v", err)
+	}
+	if got != "hi|acme|s-123|case-7|gpt-test|42|{{history}}" {
+		t.Fatalf("unexpected expansion %q", got)
+	}
+
+	u, _ := in.expand("{{uuid}} {{uuid}} {{timestamp}}", rawEscape)
+	parts := strings.Fields(u)
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(parts[0]) {
+		t.Fatalf("not a v4 uuid: %q", parts[0])
+	}
+	if parts[0] != parts[1] {
+		t.Fatalf("uuid should be stable within one request: %q", u)
+	}
+	if !regexp.MustCompile(`^\d{10,}$`).MatchString(parts[2]) {
+		t.Fatalf("unexpected timestamp %q", parts[2])
+	}
+
+	if _, err := in.expand("{{var:missing}}", rawEscape); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("expected undefined variable error, got %v", err)
+	}
+	if _, err := in.expand("{{env:POKE_TEST_DEFINITELY_UNSET}}", rawEscape); err == nil {
+		t.Fatalf("expected unset env error")
+	}
+}
+
+func TestBuildTargetRequest_VarsInTemplates(t *testing.T) {
+	cfg := Config{
+		URL:           "https://example.test/chat",
+		Method:        http.MethodPost,
+		BodyTemplate:  `{"session":"{{var:session}}","nonce":"{{uuid}}","input":"{{prompt}}"}`,
+		QueryTemplate: "tenant={{var:tenant}}&n={{seq}}",
+		Vars:          Vars{"tenant": "a&b", "session": "s1"},
+	}
+	tmpl, err := loadRequestTemplate(cfg)
+	if err != nil {
+		t.Fatalf("loadRequestTemplate: %v", err)
+	}
+	cfg.reqTemplate = tmpl
+
+	in := newPromptInput(cfg, promptset.Item{}, `say "x"`, nil).withRequestBuiltins(3)
+	tr, err := buildTargetRequest(cfg, in)
+	if err != nil {
+		t.Fatalf("buildTargetRequest: %v", err)
+	}
+	if tr.URL.Query().Get("tenant") != "a&b" || tr.URL.Query().Get("n") != "3" {
+		t.Fatalf("unexpected query %q", tr.URL.RawQuery)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(tr.Body, &body); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if body["session"] != "s1" || body["nonce"] != in.UUID || body["input"] != `say "x"` {
+		t.Fatalf("unexpected body %#v", body)
+	}
+
+	headers, err := renderHeaders(http.Header{"X-Session": {"{{var:session}}-{{seq}}"}}, in)
+	if err != nil {
+		t.Fatalf("renderHeaders: %v", err)
+	}
+	if headers.Get("X-Session") != "s1-3" {
+		t.Fatalf("unexpected header %q", headers.Get("X-Session"))
+	}
+
+	cfg.Vars = nil
+	if _, err := buildTargetRequest(cfg, newPromptInput(cfg, promptset.Item{}, "p", nil)); err == nil {
+		t.Fatalf("expected undefined variable error")
+	}
+}
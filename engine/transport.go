@@ -0,0 +1,290 @@
+package engine
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	httpVersionAuto = ""
+	httpVersion11   = "1.1"
+	httpVersionH2   = "h2"
+	httpVersionH2C  = "h2c"
+
+	DefaultIdleConnTimeout = 90 * time.Second
+)
+
+// TransportConfig holds the outbound connection settings shared by every request of a run.
+type TransportConfig struct {
+	Proxy               string
+	CAFile              string
+	CertFile            string
+	KeyFile             string
+	InsecureSkipVerify  bool
+	ServerName          string
+	HTTPVersion         string
+	MaxConnsPerHost     int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	UnixSocket          string
+	Resolve             ResolveMap
+}
+
+// ResolveMap collects repeatable curl-style -resolve host:port:addr[,addr...] overrides: host:port -> IPs.
+type ResolveMap map[string][]string
+
+func (r *ResolveMap) String() string {
+	if r == nil || len(*r) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(*r))
+	for k := range *r {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		var addrs []string
+		for _, a := range (*r)[k] {
+			if strings.Contains(a, ":") {
+				a = "[" + a + "]"
+			}
+			addrs = append(addrs, a)
+		}
+		parts = append(parts, k+":"+strings.Join(addrs, ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (r *ResolveMap) Set(s string) error {
+	host, rest, ok1 := strings.Cut(s, ":")
+	port, addrs, ok2 := strings.Cut(rest, ":")
+	host = strings.ToLower(strings.TrimSpace(host))
+	if !ok1 || !ok2 || host == "" || port == "" || addrs == "" {
+		return fmt.Errorf("expected host:port:addr, got %q", s)
+	}
+	if _, err := net.LookupPort("tcp", port); err != nil {
+		return fmt.Errorf("invalid port in %q", s)
+	}
+	var list []string
+	for _, a := range strings.Split(addrs, ",") {
+		a = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(a), "["), "]")
+		if net.ParseIP(a) == nil {
+			return fmt.Errorf("invalid address %q in %q (expected an IP)", a, s)
+		}
+		list = append(list, a)
+	}
+	if *r == nil {
+		*r = make(ResolveMap)
+	}
+	(*r)[net.JoinHostPort(host, port)] = list
+	return nil
+}
+
+func (c TransportConfig) validate(targetURL string) error {
+	if c.Proxy != "" {
+		u, err := url.Parse(c.Proxy)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid -proxy %q", c.Proxy)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return fmt.Errorf("-proxy scheme must be http, https, socks5 or socks5h, got %q", u.Scheme)
+		}
+	}
+	if c.UnixSocket != "" && (c.Proxy != "" || len(c.Resolve) > 0) {
+		return errors.New("-unix-socket cannot be combined with -proxy or -resolve")
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return errors.New("-client-cert and -client-key must be set together")
+	}
+	switch c.HTTPVersion {
+	case httpVersionAuto, httpVersion11, httpVersionH2, httpVersionH2C:
+	default:
+		return fmt.Errorf("unknown -http-version %q (expected %s|%s|%s)", c.HTTPVersion, httpVersion11, httpVersionH2, httpVersionH2C)
+	}
+	if c.MaxConnsPerHost < 0 || c.MaxIdleConnsPerHost < 0 || c.IdleConnTimeout < 0 {
+		return errors.New("-max-conns-per-host, -max-idle-conns-per-host and -idle-conn-timeout must be >= 0")
+	}
+
+	u, err := url.Parse(targetURL)
+	if err != nil || targetURL == "" {
+		return nil
+	}
+	if isWebSocketURL(u) {
+		if c.Proxy != "" || c.HTTPVersion != httpVersionAuto {
+			return errors.New("-proxy and -http-version are not supported for WebSocket targets (they are dialed directly, without a proxy)")
+		}
+		return nil
+	}
+	if c.HTTPVersion == httpVersionH2 && u.Scheme != "https" {
+		return errors.New("-http-version h2 requires an https:// target (use h2c for cleartext)")
+	}
+	if c.HTTPVersion == httpVersionH2C && u.Scheme != "http" {
+		return errors.New("-http-version h2c requires an http:// target")
+	}
+	return nil
+}
+
+// newTLSConfig returns nil when no TLS flag is set so the transport keeps Go's defaults.
+func newTLSConfig(c TransportConfig) (*tls.Config, error) {
+	if c.CAFile == "" && c.CertFile == "" && !c.InsecureSkipVerify && c.ServerName == "" {
+		return nil, nil
+	}
+	tc := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read -ca-file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("-ca-file %s: no PEM certificates found", c.CAFile)
+		}
+		tc.RootCAs = pool
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load -client-cert/-client-key: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
+
+// newHTTPTransport builds the one transport every worker shares; idle connections per host default to
+// the worker count so keep-alive connections are reused instead of churned. targetURL is the -url the
+// -unix-socket stands in for.
+func newHTTPTransport(c TransportConfig, targetURL string, workers int) (*http.Transport, error) {
+	tc, err := newTLSConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if c.Proxy != "" {
+		u, err := url.Parse(c.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -proxy: %w", err)
+		}
+		proxy = http.ProxyURL(u)
+	}
+	if c.UnixSocket != "" {
+		// HTTP_PROXY would otherwise take the -url host's requests away from the socket.
+		target := ""
+		if u, err := url.Parse(targetURL); err == nil && u.Host != "" {
+			target = strings.ToLower(dialAddr(u))
+		}
+		proxy = func(req *http.Request) (*url.URL, error) {
+			if strings.ToLower(dialAddr(req.URL)) == target {
+				return nil, nil
+			}
+			return http.ProxyFromEnvironment(req)
+		}
+	}
+
+	idle := c.MaxIdleConnsPerHost
+	if idle == 0 {
+		idle = max(workers, 2)
+	}
+	idleTimeout := c.IdleConnTimeout
+	if idleTimeout == 0 {
+		idleTimeout = DefaultIdleConnTimeout
+	}
+
+	t := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           newDialContext(c, targetURL),
+		TLSClientConfig:       tc,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          max(idle, 100),
+		MaxIdleConnsPerHost:   idle,
+		MaxConnsPerHost:       c.MaxConnsPerHost,
+		IdleConnTimeout:       idleTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	var p http.Protocols
+	switch c.HTTPVersion {
+	case httpVersion11:
+		p.SetHTTP1(true)
+		t.ForceAttemptHTTP2 = false
+	case httpVersionH2:
+		p.SetHTTP2(true)
+	case httpVersionH2C:
+		p.SetUnencryptedHTTP2(true)
+	default:
+		p.SetHTTP1(true)
+		p.SetHTTP2(true)
+	}
+	t.Protocols = &p
+	return t, nil
+}
+
+// newDialContext redirects connections to -unix-socket or a -resolve address. Only the TCP target
+// changes: the URL still supplies the Host header, SNI and certificate name. The socket replaces only
+// the -url host:port, so session logins and token requests to other hosts are dialed normally.
+func newDialContext(c TransportConfig, targetURL string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if c.UnixSocket != "" {
+		target := ""
+		if u, err := url.Parse(targetURL); err == nil && u.Host != "" {
+			target = strings.ToLower(dialAddr(u))
+		}
+		return func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if strings.ToLower(addr) != target {
+				return d.DialContext(ctx, network, addr)
+			}
+			return d.DialContext(ctx, "unix", c.UnixSocket)
+		}
+	}
+	if len(c.Resolve) == 0 {
+		return d.DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		ips, ok := c.Resolve[strings.ToLower(addr)]
+		if !ok {
+			return d.DialContext(ctx, network, addr)
+		}
+		_, port, _ := net.SplitHostPort(addr)
+		var err error
+		for _, ip := range ips {
+			var conn net.Conn
+			if conn, err = d.DialContext(ctx, network, net.JoinHostPort(ip, port)); err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
+
+// dialAddr is the host:port a connection to u dials, filling in the scheme's default port.
+func dialAddr(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	switch u.Scheme {
+	case "https", "wss":
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
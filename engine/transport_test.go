@@ -0,0 +1,259 @@
+package engine
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writePEM(t *testing.T, dir, name, typ string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newClientCert writes a self-signed client certificate and key and returns their paths plus the parsed cert.
+func newClientCert(t *testing.T) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "poke-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ = x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	return writePEM(t, dir, "client.pem", "CERTIFICATE", der), writePEM(t, dir, "client-key.pem", "EC PRIVATE KEY", keyDER), cert
+}
+
+func get(t *testing.T, tr *http.Transport, url string) (*http.Response, string) {
+	t.Helper()
+	resp, err := (&http.Client{Transport: tr, Timeout: 5 * time.Second}).Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp, string(b)
+}
+
+func TestHTTPTransport_MutualTLSWithCustomCA(t *testing.T) {
+	certFile, keyFile, clientCert := newClientCert(t)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0) // the unverified handshake below is expected to fail
+	srv.StartTLS()
+	defer srv.Close()
+	caFile := writePEM(t, t.TempDir(), "ca.pem", "CERTIFICATE", srv.Certificate().Raw)
+
+	tr, err := newHTTPTransport(TransportConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}, "", 4)
+	if err != nil {
+		t.Fatalf("newHTTPTransport: %v", err)
+	}
+	if tr.MaxIdleConnsPerHost != 4 {
+		t.Fatalf("expected idle pool sized to workers, got %d", tr.MaxIdleConnsPerHost)
+	}
+	if _, body := get(t, tr, srv.URL); body != "poke-client" {
+		t.Fatalf("unexpected body %q", body)
+	}
+
+	// Without the CA bundle the server certificate is rejected; -insecure-skip-verify gets past it.
+	tr, _ = newHTTPTransport(TransportConfig{CertFile: certFile, KeyFile: keyFile}, "", 1)
+	if _, err := (&http.Client{Transport: tr}).Get(srv.URL); err == nil {
+		t.Fatalf("expected certificate verification error")
+	}
+	tr, _ = newHTTPTransport(TransportConfig{CertFile: certFile, KeyFile: keyFile, InsecureSkipVerify: true}, "", 1)
+	if _, body := get(t, tr, srv.URL); body != "poke-client" {
+		t.Fatalf("unexpected body %q", body)
+	}
+}
+
+func TestHTTPTransport_Proxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		_, _ = io.WriteString(w, "via proxy")
+	}))
+	defer proxy.Close()
+
+	tr, err := newHTTPTransport(TransportConfig{Proxy: proxy.URL}, "", 1)
+	if err != nil {
+		t.Fatalf("newHTTPTransport: %v", err)
+	}
+	if _, body := get(t, tr, "http://target.invalid/chat?q=1"); body != "via proxy" || proxied != "http://target.invalid/chat?q=1" {
+		t.Fatalf("request did not go through the proxy: body=%q proxied=%q", body, proxied)
+	}
+}
+
+func TestHTTPTransport_ForcedVersions(t *testing.T) {
+	proto := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { _, _ = io.WriteString(w, r.Proto) })
+
+	tlsSrv := httptest.NewUnstartedServer(proto)
+	tlsSrv.EnableHTTP2 = true
+	tlsSrv.StartTLS()
+	defer tlsSrv.Close()
+	caFile := writePEM(t, t.TempDir(), "ca.pem", "CERTIFICATE", tlsSrv.Certificate().Raw)
+
+	h2cSrv := httptest.NewUnstartedServer(proto)
+	h2cSrv.Config.Protocols = new(http.Protocols)
+	h2cSrv.Config.Protocols.SetHTTP1(true)
+	h2cSrv.Config.Protocols.SetUnencryptedHTTP2(true)
+	h2cSrv.Start()
+	defer h2cSrv.Close()
+
+	cases := []struct {
+		version, url, want string
+	}{
+		{httpVersionAuto, tlsSrv.URL, "HTTP/2.0"},
+		{httpVersion11, tlsSrv.URL, "HTTP/1.1"},
+		{httpVersionH2, tlsSrv.URL, "HTTP/2.0"},
+		{httpVersionH2C, h2cSrv.URL, "HTTP/2.0"},
+		{httpVersionAuto, h2cSrv.URL, "HTTP/1.1"},
+	}
+	for _, c := range cases {
+		tr, err := newHTTPTransport(TransportConfig{CAFile: caFile, HTTPVersion: c.version}, "", 1)
+		if err != nil {
+			t.Fatalf("newHTTPTransport(%q): %v", c.version, err)
+		}
+		if _, got := get(t, tr, c.url); got != c.want {
+			t.Fatalf("-http-version %q against %s: got %s, want %s", c.version, c.url, got, c.want)
+		}
+	}
+}
+
+func TestTransportConfig_Validate(t *testing.T) {
+	bad := map[string]struct {
+		cfg    TransportConfig
+		target string
+	}{
+		"proxy scheme":  {TransportConfig{Proxy: "ftp://proxy:21"}, "http://x"},
+		"proxy host":    {TransportConfig{Proxy: "http://"}, "http://x"},
+		"cert no key":   {TransportConfig{CertFile: "c.pem"}, "http://x"},
+		"version":       {TransportConfig{HTTPVersion: "3"}, "http://x"},
+		"h2 cleartext":  {TransportConfig{HTTPVersion: httpVersionH2}, "http://x"},
+		"h2c over tls":  {TransportConfig{HTTPVersion: httpVersionH2C}, "https://x"},
+		"ws proxy":      {TransportConfig{Proxy: "socks5://127.0.0.1:1080"}, "wss://x/ws"},
+		"negative pool": {TransportConfig{MaxConnsPerHost: -1}, "http://x"},
+	}
+	for name, c := range bad {
+		if err := c.cfg.validate(c.target); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+	if err := (TransportConfig{Proxy: "socks5h://127.0.0.1:1080", HTTPVersion: httpVersion11}).validate("https://x"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := newHTTPTransport(TransportConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")}, "", 1); err == nil {
+		t.Fatalf("expected error for missing -ca-file")
+	}
+}
+
+func TestHTTPTransport_UnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "model.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Host+" "+r.URL.RequestURI())
+	})}
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+
+	t.Setenv("HTTP_PROXY", "http://127.0.0.1:1")
+	tr, err := newHTTPTransport(TransportConfig{UnixSocket: sock}, "http://model.local/v1/chat", 1)
+	if err != nil {
+		t.Fatalf("newHTTPTransport: %v", err)
+	}
+	target, _ := http.NewRequest(http.MethodGet, "http://model.local/v1/chat", nil)
+	if u, err := tr.Proxy(target); u != nil || err != nil {
+		t.Fatalf("HTTP_PROXY should not apply to the -unix-socket host, got %v, %v", u, err)
+	}
+	if _, body := get(t, tr, "http://model.local/v1/chat?stream=0"); body != "model.local /v1/chat?stream=0" {
+		t.Fatalf("unexpected body %q", body)
+	}
+
+	// Other hosts, such as a token endpoint, are not dialed into the target's socket.
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "idp")
+	}))
+	defer idp.Close()
+	if _, body := get(t, tr, idp.URL+"/token"); body != "idp" {
+		t.Fatalf("expected the token host to be dialed over TCP, got %q", body)
+	}
+}
+
+func TestHTTPTransport_ResolveKeepsHostAndSNI(t *testing.T) {
+	// httptest certificates are issued for example.com, so a verified handshake proves SNI follows -url.
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Host+" "+r.TLS.ServerName)
+	}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	caFile := writePEM(t, t.TempDir(), "ca.pem", "CERTIFICATE", srv.Certificate().Raw)
+
+	var resolve ResolveMap
+	if err := resolve.Set("Example.com:" + port + ":[::1],127.0.0.1"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	tr, err := newHTTPTransport(TransportConfig{CAFile: caFile, Resolve: resolve}, "", 1)
+	if err != nil {
+		t.Fatalf("newHTTPTransport: %v", err)
+	}
+	want := "example.com:" + port + " example.com"
+	if _, body := get(t, tr, "https://example.com:"+port+"/"); body != want {
+		t.Fatalf("got %q, want %q", body, want)
+	}
+}
+
+func TestResolveFlag_Set(t *testing.T) {
+	for _, bad := range []string{"example.com", "example.com:443", "example.com:https443:1.2.3.4", ":443:1.2.3.4", "example.com:443:not-an-ip"} {
+		var r ResolveMap
+		if err := r.Set(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+	var r ResolveMap
+	_ = r.Set("api.test:8443:10.0.0.5")
+	_ = r.Set("api.test:80:[2001:db8::1]")
+	if got := r.String(); got != "api.test:80:[2001:db8::1] api.test:8443:10.0.0.5" {
+		t.Fatalf("unexpected String() %q", got)
+	}
+	if err := (TransportConfig{UnixSocket: "/tmp/s", Resolve: r}).validate("http://api.test"); err == nil {
+		t.Fatalf("expected -unix-socket/-resolve conflict")
+	}
+}
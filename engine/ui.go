@@ -1,4 +1,4 @@
-package main
+package engine
 
 import (
 	"fmt"
@@ -61,7 +61,8 @@ func trueColor(r, g, b int) string {
 	return fmt.Sprintf("\x1b[38;2;%d;%d;%dm", r, g, b)
 }
 
-func bannerFor(f *os.File) string {
+// Banner returns the startup banner, colored when f is a color terminal; "" when POKE_NO_BANNER is set.
+func Banner(f *os.File) string {
 	if os.Getenv("POKE_NO_BANNER") != "" {
 		return ""
 	}
@@ -180,7 +181,8 @@ func styledMarkerKey(id string) string {
 	return styledKey("marker_"+id, ansiCyan, ansiBold)
 }
 
-func styledErrorPrefix() string {
+// ErrorPrefix is the (colored when enabled) "error:" prefix of fatal messages.
+func ErrorPrefix() string {
 	return styledKey("error:", ansiRed, ansiBold)
 }
 
@@ -1,4 +1,4 @@
-package main
+package engine
 
 import "testing"
 
@@ -20,8 +20,8 @@ func TestUI_FormattingNoColor(t *testing.T) {
 	if got := styledMarkerKey("x"); got != "marker_x" {
 		t.Fatalf("styledMarkerKey(x)=%q", got)
 	}
-	if got := styledErrorPrefix(); got != "error:" {
-		t.Fatalf("styledErrorPrefix()=%q", got)
+	if got := ErrorPrefix(); got != "error:" {
+		t.Fatalf("ErrorPrefix()=%q", got)
 	}
 	if got := styledDetailPrefix("  prompt="); got != "  prompt=" {
 		t.Fatalf("styledDetailPrefix()=%q", got)
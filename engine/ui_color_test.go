@@ -1,4 +1,4 @@
-package main
+package engine
 
 import (
 	"os"
@@ -54,6 +54,4 @@ func TestUI_ColorHelpers(t *testing.T) {
 	if isTerminal(f) {
 		t.Fatalf("expected closed file not to be terminal")
 	}
-
-	_ = helpError{usage: "x"}.Error()
 }
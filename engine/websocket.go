@@ -0,0 +1,518 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Minimal RFC 6455 client: enough to send one templated text frame per prompt and collect replies.
+// No extensions (permessage-deflate) or subprotocol negotiation.
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+
+	wsAcceptGUID         = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsMaxFramePayload    = 64 << 20
+	DefaultWSIdleTimeout = 2 * time.Second
+)
+
+var errWSClosed = errors.New("websocket: closed by peer")
+
+func isWebSocketURL(u *url.URL) bool {
+	return u != nil && (u.Scheme == "ws" || u.Scheme == "wss")
+}
+
+type wsConn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool
+
+	wmu sync.Mutex
+}
+
+// wsHandshakeError is returned when the server answers the upgrade with a non-101 status.
+type wsHandshakeError struct {
+	StatusCode int
+	Headers    http.Header
+	Body       []byte
+}
+
+func (e *wsHandshakeError) Error() string {
+	return fmt.Sprintf("websocket: handshake failed: status %d", e.StatusCode)
+}
+
+// newWSHandshakeRequest builds the HTTP/1.1 upgrade request for u with a fresh Sec-WebSocket-Key.
+func newWSHandshakeRequest(ctx context.Context, u *url.URL, header http.Header, cookies []*http.Cookie) (*http.Request, error) {
+	keyBytes := make([]byte, 16)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return nil, err
+	}
+
+	hu := *u
+	if hu.Scheme == "wss" {
+		hu.Scheme = "https"
+	} else {
+		hu.Scheme = "http"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, hu.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(keyBytes))
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	return req, nil
+}
+
+// dialWebSocket dials u directly through tr's dialer, so -unix-socket and -resolve apply but no proxy
+// does: HTTP_PROXY / HTTPS_PROXY are ignored and Validate rejects -proxy.
+func dialWebSocket(ctx context.Context, u *url.URL, tr *http.Transport, req *http.Request) (*wsConn, *http.Response, error) {
+	host := dialAddr(u)
+
+	dial := (&net.Dialer{}).DialContext
+	if tr != nil && tr.DialContext != nil {
+		dial = tr.DialContext
+	}
+	conn, err := dial(ctx, "tcp", host)
+	if err != nil {
+		return nil, nil, err
+	}
+	if u.Scheme == "wss" {
+		tcfg := &tls.Config{}
+		if tr != nil && tr.TLSClientConfig != nil {
+			tcfg = tr.TLSClientConfig.Clone()
+		}
+		if tcfg.ServerName == "" {
+			tcfg.ServerName = u.Hostname()
+		}
+		tcfg.NextProtos = []string{"http/1.1"}
+		tc := tls.Client(conn, tcfg)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, nil, err
+		}
+		conn = tc
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		_ = resp.Body.Close()
+		_ = conn.Close()
+		return nil, resp, &wsHandshakeError{StatusCode: resp.StatusCode, Headers: resp.Header, Body: body}
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") || resp.Header.Get("Sec-WebSocket-Accept") != wsAcceptKey(key) {
+		_ = conn.Close()
+		return nil, resp, fmt.Errorf("websocket: invalid handshake response")
+	}
+	return &wsConn{conn: conn, br: br, client: true}, resp, nil
+}
+
+func wsAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + wsAcceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func (c *wsConn) WriteText(p []byte) error {
+	return c.writeFrame(wsOpText, p)
+}
+
+func (c *wsConn) writeFrame(op byte, p []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	var hdr [14]byte
+	hdr[0] = 0x80 | op
+	n := 2
+	switch {
+	case len(p) < 126:
+		hdr[1] = byte(len(p))
+	case len(p) <= 0xFFFF:
+		hdr[1] = 126
+		binary.BigEndian.PutUint16(hdr[2:], uint16(len(p)))
+		n = 4
+	default:
+		hdr[1] = 127
+		binary.BigEndian.PutUint64(hdr[2:], uint64(len(p)))
+		n = 10
+	}
+	payload := p
+	if c.client {
+		hdr[1] |= 0x80
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		copy(hdr[n:], mask[:])
+		n += 4
+		payload = make([]byte, len(p))
+		for i := range p {
+			payload[i] = p[i] ^ mask[i%4]
+		}
+	}
+	if _, err := c.conn.Write(hdr[:n]); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+// ReadMessage returns the next complete data message. Pings are answered; a close frame is echoed and
+// reported as errWSClosed.
+func (c *wsConn) ReadMessage() (byte, []byte, error) {
+	var msgOp byte
+	var msg []byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			_ = c.writeFrame(wsOpClose, payload)
+			return 0, nil, errWSClosed
+		case wsOpText, wsOpBinary:
+			msgOp = op
+			msg = append(msg[:0], payload...)
+		case wsOpContinuation:
+			msg = append(msg, payload...)
+		default:
+			return 0, nil, fmt.Errorf("websocket: unknown opcode %#x", op)
+		}
+		if len(msg) > wsMaxFramePayload {
+			return 0, nil, fmt.Errorf("websocket: message too large")
+		}
+		if fin {
+			return msgOp, msg, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = hdr[0]&0x80 != 0
+	op = hdr[0] & 0x0F
+	masked := hdr[1]&0x80 != 0
+	n := uint64(hdr[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > wsMaxFramePayload {
+		return false, 0, nil, fmt.Errorf("websocket: frame too large (%d bytes)", n)
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, op, payload, nil
+}
+
+func (c *wsConn) Close() error {
+	_ = c.writeFrame(wsOpClose, []byte{0x03, 0xE8}) // 1000 normal closure
+	return c.conn.Close()
+}
+
+// wsDoneMatcher is the "-ws-done-match path[=value]" predicate: a reply frame whose JSON value at path
+// exists (or equals value) ends the reply.
+type wsDoneMatcher struct {
+	path     jsonPath
+	value    string
+	hasValue bool
+}
+
+func parseWSDoneMatcher(s string) (*wsDoneMatcher, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	p, v, hasValue := strings.Cut(s, "=")
+	path, err := parseJSONPath(p)
+	if err != nil {
+		return nil, err
+	}
+	return &wsDoneMatcher{path: path, value: v, hasValue: hasValue}, nil
+}
+
+func (m *wsDoneMatcher) Match(frame []byte) bool {
+	if m == nil {
+		return false
+	}
+	var v any
+	if err := json.Unmarshal(frame, &v); err != nil {
+		return false
+	}
+	got, ok := m.path.LookupText(v, "")
+	if !ok {
+		return false
+	}
+	return !m.hasValue || got == m.value
+}
+
+// sendOneWebSocket is sendOne for ws:// and wss:// targets: one connection per prompt, one rendered frame
+// out, reply frames collected until the done predicate matches, the idle timeout fires or the peer closes.
+func sendOneWebSocket(
+	ctx context.Context,
+	cfg Config,
+	baseHeaders http.Header,
+	cookies []*http.Cookie,
+	workerID int,
+	seq int,
+	in promptInput,
+) RequestResult {
+	start := time.Now()
+	prompt := in.Prompt
+
+	frameCfg := cfg
+	frameCfg.Method = http.MethodPost // frames always carry the rendered body
+	u, frame, err := buildTargetURLAndBody(frameCfg, in)
+	if err != nil {
+		return RequestResult{Seq: seq, WorkerID: workerID, Prompt: prompt, Latency: time.Since(start), Err: err}
+	}
+	baseHeaders, err = renderHeaders(baseHeaders, in)
+	if err != nil {
+		return RequestResult{Seq: seq, WorkerID: workerID, Prompt: prompt, Latency: time.Since(start), Err: err}
+	}
+
+	if cfg.Trace {
+		log.Printf("req_start: seq=%d worker=%d method=WS url=%s body_bytes=%d prompt=%q", seq, workerID, u.String(), len(frame), previewOneLine(prompt, 160))
+	}
+
+	var attempts int
+	var retries int
+	reauthed := false
+	for {
+		attempts++
+		attemptStart := time.Now()
+		reqHeaders, reqCookies := baseHeaders, cookies
+		var creds authCredentials
+		if cfg.auth != nil {
+			creds, err = cfg.auth.Credentials(ctx)
+			if err != nil {
+				return RequestResult{Seq: seq, WorkerID: workerID, Prompt: prompt, Attempts: attempts, Retries: retries, Latency: time.Since(start), Err: fmt.Errorf("auth: %w", err)}
+			}
+			reqHeaders, reqCookies = withAuth(baseHeaders, cookies, creds)
+		}
+		req, err := newWSHandshakeRequest(ctx, u, reqHeaders, reqCookies)
+		if err != nil {
+			return RequestResult{Seq: seq, WorkerID: workerID, Prompt: prompt, Attempts: attempts, Retries: retries, Latency: time.Since(start), Err: fmt.Errorf("build request: %w", err)}
+		}
+		var capture *exchangeCapture
+		if cfg.recorder != nil {
+			// The handshake plus the frame sent over it; the reply frames are the recorded body.
+			capture = newExchangeCapture(req, frame, attemptStart)
+			capture.url = u.String()
+		}
+		res, retryAfter, retryable := wsExchange(ctx, cfg, u, req, frame, attemptStart)
+		res.Seq, res.WorkerID, res.Prompt, res.exchange = seq, workerID, prompt, capture
+		cfg.limiter.Observe(res.StatusCode, res.Headers)
+		if cfg.auth != nil && !reauthed && res.Err == nil && cfg.auth.RefreshOn(res.StatusCode) {
+			reauthed = true
+			if cfg.Trace {
+				log.Printf("req_reauth: seq=%d worker=%d attempt=%d status=%d", seq, workerID, attempts, res.StatusCode)
+			}
+			if err := cfg.auth.Refresh(ctx, creds.gen); err != nil {
+				res.Attempts, res.Retries, res.Latency = attempts, retries, time.Since(start)
+				res.Err = fmt.Errorf("auth refresh: %w", err)
+				return res
+			}
+			continue
+		}
+		if retryable && cfg.Retry.enabled() && retries < cfg.Retry.MaxRetries {
+			retries++
+			delay := nextBackoffDelay(cfg.Retry, retries, retryAfter)
+			if cfg.Trace {
+				log.Printf("req_retry: seq=%d worker=%d attempt=%d retry=%d status=%d delay=%s", seq, workerID, attempts, retries, res.StatusCode, delay.String())
+			}
+			if sleepErr := sleepCtx(ctx, delay); sleepErr != nil {
+				return RequestResult{Seq: seq, WorkerID: workerID, Prompt: prompt, Attempts: attempts, Retries: retries - 1, Latency: time.Since(start), Err: sleepErr}
+			}
+			continue
+		}
+		res.Attempts, res.Retries = attempts, retries
+		res.Latency = time.Since(start)
+		if cfg.Trace {
+			errStr := ""
+			if res.Err != nil {
+				errStr = previewOneLine(res.Err.Error(), 200)
+			}
+			log.Printf("req_done: seq=%d worker=%d attempt=%d status=%d attempt_latency=%s total_latency=%s body_bytes=%d frames=%d err=%q", seq, workerID, attempts, res.StatusCode, time.Since(attemptStart).String(), time.Since(start).String(), len(res.Body), res.TokenEvents, errStr)
+		}
+		return res
+	}
+}
+
+func wsExchange(
+	ctx context.Context,
+	cfg Config,
+	u *url.URL,
+	req *http.Request,
+	frame []byte,
+	attemptStart time.Time,
+) (res RequestResult, retryAfter time.Duration, retryable bool) {
+	actx := ctx
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		actx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	conn, resp, err := dialWebSocket(actx, u, cfg.httpTransport, req)
+	if err != nil {
+		var he *wsHandshakeError
+		if errors.As(err, &he) {
+			ra, _ := parseRetryAfter(he.Headers.Get("Retry-After"), time.Now())
+			// Surface the rejected upgrade like a normal HTTP response so status markers apply.
+			return RequestResult{StatusCode: he.StatusCode, Headers: he.Headers.Clone(), Body: he.Body}, ra, isRetryableHTTPStatus(he.StatusCode)
+		}
+		return RequestResult{Err: err}, 0, isRetryableDoError(err) && ctx.Err() == nil
+	}
+	defer conn.Close()
+	// Unblock reads when the run is canceled or the per-request timeout fires.
+	stop := context.AfterFunc(actx, func() { _ = conn.conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	res = RequestResult{StatusCode: resp.StatusCode, Headers: resp.Header.Clone(), Streamed: true}
+	if err := conn.WriteText(frame); err != nil {
+		res.Err = fmt.Errorf("websocket: write: %w", err)
+		return res, 0, false
+	}
+
+	idle := cfg.WSIdleTimeout
+	if idle <= 0 {
+		idle = DefaultWSIdleTimeout
+	}
+
+	var raw bytes.Buffer
+	var text strings.Builder
+	for {
+		deadline := time.Now().Add(idle)
+		if dl, ok := actx.Deadline(); ok && dl.Before(deadline) {
+			deadline = dl
+		}
+		_ = conn.conn.SetReadDeadline(deadline)
+
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			var ne net.Error
+			switch {
+			case errors.Is(err, errWSClosed), errors.Is(err, io.EOF):
+			case actx.Err() != nil:
+				res.Err = actx.Err()
+			case errors.As(err, &ne) && ne.Timeout():
+				// Idle timeout: the reply is whatever arrived so far.
+			default:
+				res.Err = fmt.Errorf("websocket: read: %w", err)
+			}
+			break
+		}
+
+		if cfg.MaxResponseBytes > 0 && int64(raw.Len()+len(msg)+1) > cfg.MaxResponseBytes {
+			remaining := int(cfg.MaxResponseBytes) - raw.Len()
+			if remaining > 0 {
+				raw.Write(msg[:min(remaining, len(msg))])
+			}
+			res.BodyTruncated = true
+			break
+		}
+		if raw.Len() > 0 {
+			raw.WriteByte('\n')
+		}
+		raw.Write(msg)
+
+		if delta := streamDeltaText(string(msg), cfg.wsText); delta != "" {
+			if res.TokenEvents == 0 {
+				res.FirstTokenLatency = time.Since(attemptStart)
+			}
+			res.TokenEvents++
+			text.WriteString(delta)
+		}
+		if cfg.wsDone.Match(msg) {
+			break
+		}
+	}
+
+	res.Body = raw.Bytes()
+	if res.TokenEvents > 0 {
+		// Without a matched delta the raw frames are analyzed instead.
+		res.Text = []byte(text.String())
+	}
+	return res, 0, false
+}
@@ -0,0 +1,218 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// acceptTestWebSocket upgrades an httptest request to a server-side wsConn.
+func acceptTestWebSocket(t *testing.T, w http.ResponseWriter, r *http.Request) *wsConn {
+	t.Helper()
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		t.Fatalf("response writer is not a Hijacker")
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		t.Fatalf("Hijack: %v", err)
+	}
+	_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " +
+		wsAcceptKey(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+	if err := brw.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	return &wsConn{conn: conn, br: bufio.NewReader(conn)}
+}
+
+// newWSChatServer is an LLM stand-in: it reads one {"prompt":...} frame and streams the reply as
+// {"type":"delta","text":...} frames followed by {"type":"done"}.
+func newWSChatServer(t *testing.T, reply []string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Test") != "a" {
+			http.Error(w, "missing header", http.StatusUnauthorized)
+			return
+		}
+		c := acceptTestWebSocket(t, w, r)
+		defer c.conn.Close()
+		_, msg, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		var in map[string]string
+		if err := json.Unmarshal(msg, &in); err != nil {
+			return
+		}
+		_ = c.WriteText([]byte(`{"type":"delta","text":"echo: ` + in["prompt"] + `. "}`))
+		for _, part := range reply {
+			_ = c.WriteText([]byte(`{"type":"delta","text":"` + part + `"}`))
+		}
+		_ = c.WriteText([]byte(`{"type":"done"}`))
+		_, _, _ = c.ReadMessage() // wait for the client to close
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestConfig_ValidateRejectsProxyForWebSocket(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.URL, cfg.PromptsFile = "wss://model.test/ws", "p.txt"
+	cfg.Transport.Proxy = "http://127.0.0.1:8080"
+	if err := cfg.Validate(); !errors.As(err, new(UsageError)) {
+		t.Fatalf("expected a usage error for -proxy with a wss:// target, got %v", err)
+	}
+}
+
+func TestSendOne_WebSocketCollectsUntilDone(t *testing.T) {
+	srv := newWSChatServer(t, []string{"my system ", "prompt is secret"})
+
+	textPath, err := parseJSONPath("text")
+	if err != nil {
+		t.Fatalf("parseJSONPath: %v", err)
+	}
+	done, err := parseWSDoneMatcher("type=done")
+	if err != nil {
+		t.Fatalf("parseWSDoneMatcher: %v", err)
+	}
+	cfg := Config{
+		URL:           "ws" + strings.TrimPrefix(srv.URL, "http") + "/chat",
+		Method:        http.MethodPost,
+		Timeout:       5 * time.Second,
+		WSIdleTimeout: 5 * time.Second,
+		wsText:        textPath,
+		wsDone:        done,
+	}
+	headers := http.Header{"X-Test": []string{"a"}}
+
+	start := time.Now()
+	res := sendOne(t.Context(), nil, cfg, headers, nil, 1, promptInput{Prompt: "hi"})
+	if res.Err != nil {
+		t.Fatalf("unexpected err: %v", res.Err)
+	}
+	if time.Since(start) > 4*time.Second {
+		t.Fatalf("done predicate should end the reply before the idle timeout")
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", res.StatusCode)
+	}
+	if got := string(res.Text); got != "echo: hi. my system prompt is secret" {
+		t.Fatalf("unexpected transcript: %q", got)
+	}
+	if res.TokenEvents != 3 || !strings.Contains(string(res.Body), `{"type":"done"}`) {
+		t.Fatalf("unexpected frames: events=%d body=%q", res.TokenEvents, string(res.Body))
+	}
+
+	a, err := newResponseAnalyzer(defaultMarkerConfig())
+	if err != nil {
+		t.Fatalf("newResponseAnalyzer: %v", err)
+	}
+	if !hasMarker(a.Analyze(res), "system_leak:mentions_system_or_developer_prompt") {
+		t.Fatalf("expected system prompt marker on the concatenated reply")
+	}
+}
+
+func TestSendOne_WebSocketIdleTimeoutAndHandshakeStatus(t *testing.T) {
+	srv := newWSChatServer(t, nil)
+	cfg := Config{
+		URL:           "ws" + strings.TrimPrefix(srv.URL, "http"),
+		Method:        http.MethodPost,
+		Timeout:       5 * time.Second,
+		WSIdleTimeout: 100 * time.Millisecond,
+	}
+
+	res := sendOne(t.Context(), nil, cfg, http.Header{"X-Test": []string{"a"}}, nil, 1, promptInput{Prompt: "x"})
+	if res.Err != nil {
+		t.Fatalf("unexpected err: %v", res.Err)
+	}
+	if !strings.HasSuffix(string(res.Body), `{"type":"done"}`) || res.TokenEvents != 2 {
+		t.Fatalf("expected both frames before idle timeout, got events=%d body=%q", res.TokenEvents, string(res.Body))
+	}
+
+	res = sendOne(t.Context(), nil, cfg, nil, nil, 2, promptInput{Prompt: "x"})
+	if res.Err != nil {
+		t.Fatalf("unexpected err: %v", res.Err)
+	}
+	if res.StatusCode != http.StatusUnauthorized || !strings.Contains(string(res.Body), "missing header") {
+		t.Fatalf("expected rejected upgrade to surface as 401, got %d %q", res.StatusCode, string(res.Body))
+	}
+}
+
+func TestSendOne_WebSocketUnmatchedTextPathFallsBackToFrames(t *testing.T) {
+	srv := newWSChatServer(t, []string{"my system prompt is secret"})
+	textPath, err := parseJSONPath("choices[*].delta.content")
+	if err != nil {
+		t.Fatalf("parseJSONPath: %v", err)
+	}
+	cfg := Config{
+		URL:           "ws" + strings.TrimPrefix(srv.URL, "http"),
+		Method:        http.MethodPost,
+		Timeout:       5 * time.Second,
+		WSIdleTimeout: 100 * time.Millisecond,
+		wsText:        textPath,
+	}
+
+	res := sendOne(t.Context(), nil, cfg, http.Header{"X-Test": []string{"a"}}, nil, 1, promptInput{Prompt: "hi"})
+	if res.Err != nil {
+		t.Fatalf("unexpected err: %v", res.Err)
+	}
+	if res.Text != nil || res.TokenEvents != 0 {
+		t.Fatalf("expected no transcript, got text=%q events=%d", string(res.Text), res.TokenEvents)
+	}
+	a, err := newResponseAnalyzer(defaultMarkerConfig())
+	if err != nil {
+		t.Fatalf("newResponseAnalyzer: %v", err)
+	}
+	if !hasMarker(a.Analyze(res), "system_leak:mentions_system_or_developer_prompt") {
+		t.Fatalf("expected markers to run over the raw frames")
+	}
+}
+
+func TestRun_RecordWebSocketExchange(t *testing.T) {
+	dir, _ := recordRun(t, func(w http.ResponseWriter, r *http.Request) {
+		c := acceptTestWebSocket(t, w, r)
+		defer c.conn.Close()
+		if _, _, err := c.ReadMessage(); err != nil {
+			return
+		}
+		_ = c.WriteText([]byte(`{"type":"delta","text":"hello"}`))
+		_ = c.WriteText([]byte(`{"type":"done"}`))
+		_, _, _ = c.ReadMessage()
+	}, "hi\n", func(cfg *Config) {
+		cfg.URL = "ws" + strings.TrimPrefix(cfg.URL, "http") + "/chat"
+		cfg.WSIdleTimeout = time.Second
+		cfg.WSDoneMatch = "type=done"
+		cfg.Headers = map[string]string{"Authorization": "Bearer tok"}
+	})
+
+	c, err := openCassette(dir)
+	if err != nil {
+		t.Fatalf("openCassette: %v", err)
+	}
+	if len(c.exchanges) != 1 {
+		t.Fatalf("expected 1 exchange, got %d", len(c.exchanges))
+	}
+	ex := c.exchanges[0]
+	if ex.Request == nil || ex.Request.Method != http.MethodGet || !strings.HasPrefix(ex.Request.URL, "ws://") || !strings.HasSuffix(ex.Request.URL, "/chat") {
+		t.Fatalf("unexpected request %+v", ex.Request)
+	}
+	if ex.Request.Header.Get("Upgrade") != "websocket" || ex.Request.Header.Get("Authorization") != redactedValue {
+		t.Fatalf("unexpected handshake headers %v", ex.Request.Header)
+	}
+	sent, err := c.blob(ex.Request.Body)
+	if err != nil || !strings.Contains(string(sent), `"hi"`) {
+		t.Fatalf("expected the sent frame in the cassette, got %q (%v)", sent, err)
+	}
+	res, err := c.result(ex)
+	if err != nil {
+		t.Fatalf("result: %v", err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols || !strings.Contains(string(res.Body), `"hello"`) {
+		t.Fatalf("unexpected replayed result %+v", res)
+	}
+}
@@ -51,19 +51,19 @@ func TestStream_JSON_Errors(t *testing.T) {
 	outCh := make(chan string, 8)
 
 	// Extra trailing content.
-	if err := streamJSON(ctx, stringsReader(t, `["a"] 123`), outCh, Options{}); err == nil {
+	if err := streamJSON(ctx, stringsReader(t, `["a"] 123`), promptEmitter(ctx, outCh), Options{}); err == nil {
 		t.Fatalf("expected error")
 	}
 	// Missing prompts key.
-	if err := streamJSON(ctx, stringsReader(t, `{"x":1}`), outCh, Options{}); err == nil {
+	if err := streamJSON(ctx, stringsReader(t, `{"x":1}`), promptEmitter(ctx, outCh), Options{}); err == nil {
 		t.Fatalf("expected error")
 	}
 	// Empty prompt.
-	if err := streamJSON(ctx, stringsReader(t, `[" "]`), outCh, Options{}); err == nil {
+	if err := streamJSON(ctx, stringsReader(t, `[" "]`), promptEmitter(ctx, outCh), Options{}); err == nil {
 		t.Fatalf("expected error")
 	}
 	// Non-string prompt.
-	if err := streamJSON(ctx, stringsReader(t, `[{"prompt":1}]`), outCh, Options{}); err == nil {
+	if err := streamJSON(ctx, stringsReader(t, `[{"prompt":1}]`), promptEmitter(ctx, outCh), Options{}); err == nil {
 		t.Fatalf("expected error")
 	}
 }
@@ -72,19 +72,19 @@ func TestStream_JSONL_Errors(t *testing.T) {
 	ctx := context.Background()
 	outCh := make(chan string, 8)
 
-	if err := streamJSONL(ctx, stringsReader(t, `not-json`), outCh, Options{}); err == nil {
+	if err := streamJSONL(ctx, stringsReader(t, `not-json`), promptEmitter(ctx, outCh), Options{}); err == nil {
 		t.Fatalf("expected error")
 	}
-	if err := streamJSONL(ctx, stringsReader(t, `"unterminated`), outCh, Options{}); err == nil {
+	if err := streamJSONL(ctx, stringsReader(t, `"unterminated`), promptEmitter(ctx, outCh), Options{}); err == nil {
 		t.Fatalf("expected error")
 	}
-	if err := streamJSONL(ctx, stringsReader(t, `""`), outCh, Options{}); err == nil {
+	if err := streamJSONL(ctx, stringsReader(t, `""`), promptEmitter(ctx, outCh), Options{}); err == nil {
 		t.Fatalf("expected error")
 	}
-	if err := streamJSONL(ctx, stringsReader(t, `{"prompt":" ","disabled":false}`), outCh, Options{}); err == nil {
+	if err := streamJSONL(ctx, stringsReader(t, `{"prompt":" ","disabled":false}`), promptEmitter(ctx, outCh), Options{}); err == nil {
 		t.Fatalf("expected error")
 	}
-	if err := streamJSONL(ctx, stringsReader(t, `{"prompt":"x","disabled":true}`), outCh, Options{}); err != nil {
+	if err := streamJSONL(ctx, stringsReader(t, `{"prompt":"x","disabled":true}`), promptEmitter(ctx, outCh), Options{}); err != nil {
 		t.Fatalf("expected nil for disabled prompt, got %v", err)
 	}
 }
@@ -120,7 +120,7 @@ func TestParsePromptJSON_Errors(t *testing.T) {
 func TestStreamText_ReadError(t *testing.T) {
 	ctx := context.Background()
 	outCh := make(chan string, 8)
-	err := streamText(ctx, &failingReader{data: []byte("a\n"), err: io.ErrUnexpectedEOF}, outCh, Options{})
+	err := streamText(ctx, &failingReader{data: []byte("a\n"), err: io.ErrUnexpectedEOF}, promptEmitter(ctx, outCh), Options{})
 	if err == nil {
 		t.Fatalf("expected error")
 	}
@@ -129,7 +129,7 @@ func TestStreamText_ReadError(t *testing.T) {
 func TestStreamJSONL_ReadError(t *testing.T) {
 	ctx := context.Background()
 	outCh := make(chan string, 8)
-	err := streamJSONL(ctx, &failingReader{data: []byte("\"a\"\n"), err: fmt.Errorf("boom")}, outCh, Options{})
+	err := streamJSONL(ctx, &failingReader{data: []byte("\"a\"\n"), err: fmt.Errorf("boom")}, promptEmitter(ctx, outCh), Options{})
 	if err == nil {
 		t.Fatalf("expected error")
 	}
@@ -3,20 +3,95 @@ package promptset
 import (
 	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
 const maxPromptBytes = 1 << 20 // 1 MiB
 
 type Options struct {
+	// Skip, when set, drops items for which it returns true (e.g. items already completed in a resumed run).
+	Skip func(Item) bool
+	// Unique stops the stream with an error at an item whose Key repeats an earlier item's.
+	Unique bool
+
+	seen map[string]bool
+}
+
+// Item is one corpus entry: either a single Prompt or a multi-turn conversation (Turns, sent in order).
+// Vars holds per-item template variables ({{var:name}}).
+type Item struct {
+	ID     string
+	Tags   []string
+	Prompt string
+	Turns  []string
+	Vars   map[string]string
+}
+
+func (it Item) IsConversation() bool { return len(it.Turns) > 0 }
+
+// Key identifies the item across runs: its ID when set, else a hash of its prompt or turns and vars.
+func (it Item) Key() string {
+	if it.ID != "" {
+		return it.ID
+	}
+	h := sha256.New()
+	if it.IsConversation() {
+		for _, t := range it.Turns {
+			h.Write([]byte(t))
+			h.Write([]byte{0})
+		}
+	} else {
+		h.Write([]byte(it.Prompt))
+	}
+	names := make([]string, 0, len(it.Vars))
+	for k := range it.Vars {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		h.Write([]byte{1})
+		h.Write([]byte(k + "=" + it.Vars[k]))
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))[:16]
 }
 
+type emitFunc func(Item) error
+
+// Stream emits every prompt in path. Conversation items are flattened into their individual turns.
 func Stream(ctx context.Context, path string, out chan<- string, opt Options) error {
+	return streamPath(ctx, path, promptEmitter(ctx, out), opt)
+}
+
+// StreamItems emits every enabled item in path, keeping conversation turns together.
+func StreamItems(ctx context.Context, path string, out chan<- Item, opt Options) error {
+	return streamPath(ctx, path, func(it Item) error { return send(ctx, out, it) }, opt)
+}
+
+// SendItems emits items the way StreamItems emits a file's, for corpora built in code.
+func SendItems(ctx context.Context, items []Item, out chan<- Item, opt Options) error {
+	if opt.Unique {
+		opt.seen = make(map[string]bool)
+	}
+	for _, it := range items {
+		if err := emitPrompt(ctx, func(it Item) error { return send(ctx, out, it) }, it, opt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func streamPath(ctx context.Context, path string, emit emitFunc, opt Options) error {
+	if opt.Unique {
+		opt.seen = make(map[string]bool)
+	}
 	r, closeFn, err := openPath(path)
 	if err != nil {
 		return err
@@ -28,15 +103,29 @@ func Stream(ctx context.Context, path string, out chan<- string, opt Options) er
 	ext := strings.ToLower(filepath.Ext(path))
 	switch ext {
 	case ".json":
-		return streamJSON(ctx, r, out, opt)
+		return streamJSON(ctx, r, emit, opt)
 	case ".jsonl", ".ndjson":
-		return streamJSONL(ctx, r, out, opt)
+		return streamJSONL(ctx, r, emit, opt)
 	default:
-		return streamText(ctx, r, out, opt)
+		return streamText(ctx, r, emit, opt)
 	}
 }
 
-func streamText(ctx context.Context, r io.Reader, out chan<- string, opt Options) error {
+func promptEmitter(ctx context.Context, out chan<- string) emitFunc {
+	return func(it Item) error {
+		if !it.IsConversation() {
+			return send(ctx, out, it.Prompt)
+		}
+		for _, turn := range it.Turns {
+			if err := send(ctx, out, turn); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func streamText(ctx context.Context, r io.Reader, emit emitFunc, opt Options) error {
 	sc := bufio.NewScanner(r)
 	buf := make([]byte, 0, 64*1024)
 	sc.Buffer(buf, maxPromptBytes)
@@ -45,7 +134,7 @@ func streamText(ctx context.Context, r io.Reader, out chan<- string, opt Options
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
-		if err := emitPrompt(ctx, out, line, opt); err != nil {
+		if err := emitPrompt(ctx, emit, Item{Prompt: line}, opt); err != nil {
 			return err
 		}
 	}
@@ -56,13 +145,38 @@ func streamText(ctx context.Context, r io.Reader, out chan<- string, opt Options
 }
 
 type jsonPromptItem struct {
-	Prompt   string   `json:"prompt"`
-	Disabled bool     `json:"disabled,omitempty"`
-	Tags     []string `json:"tags,omitempty"`
-	ID       string   `json:"id,omitempty"`
+	Prompt   string            `json:"prompt"`
+	Turns    []string          `json:"turns,omitempty"`
+	Disabled bool              `json:"disabled,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
+	ID       string            `json:"id,omitempty"`
+	Vars     map[string]string `json:"vars,omitempty"`
+}
+
+func (it jsonPromptItem) item() Item {
+	return Item{ID: it.ID, Tags: it.Tags, Prompt: it.Prompt, Turns: it.Turns, Vars: it.Vars}
+}
+
+// validate reports an error for items that are neither a non-empty prompt nor a conversation of non-empty turns.
+func (it jsonPromptItem) validate() error {
+	if len(it.Turns) == 0 {
+		if strings.TrimSpace(it.Prompt) == "" {
+			return fmt.Errorf("empty prompt")
+		}
+		return nil
+	}
+	if it.Prompt != "" {
+		return fmt.Errorf("\"prompt\" and \"turns\" are mutually exclusive")
+	}
+	for i, t := range it.Turns {
+		if strings.TrimSpace(t) == "" {
+			return fmt.Errorf("turns[%d]: empty prompt", i)
+		}
+	}
+	return nil
 }
 
-func streamJSON(ctx context.Context, r io.Reader, out chan<- string, opt Options) error {
+func streamJSON(ctx context.Context, r io.Reader, emit emitFunc, opt Options) error {
 	var root any
 	dec := json.NewDecoder(r)
 	if err := dec.Decode(&root); err != nil {
@@ -83,10 +197,10 @@ func streamJSON(ctx context.Context, r io.Reader, out chan<- string, opt Options
 		if it.Disabled {
 			continue
 		}
-		if strings.TrimSpace(it.Prompt) == "" {
-			return fmt.Errorf("read prompts json: empty prompt")
+		if err := it.validate(); err != nil {
+			return fmt.Errorf("read prompts json: %w", err)
 		}
-		if err := emitPrompt(ctx, out, it.Prompt, opt); err != nil {
+		if err := emitPrompt(ctx, emit, it.item(), opt); err != nil {
 			return err
 		}
 	}
@@ -119,16 +233,42 @@ func parsePromptJSONArray(arr []any) ([]jsonPromptItem, error) {
 		case string:
 			out = append(out, jsonPromptItem{Prompt: vv})
 		case map[string]any:
+			var it jsonPromptItem
+			if rawTurns, ok := vv["turns"]; ok {
+				turns, err := parseTurns(rawTurns)
+				if err != nil {
+					return nil, fmt.Errorf("read prompts json: item[%d]: %w", i, err)
+				}
+				it.Turns = turns
+			}
 			p, ok := vv["prompt"]
-			if !ok {
-				return nil, fmt.Errorf("read prompts json: item[%d]: missing \"prompt\"", i)
+			if !ok && len(it.Turns) == 0 {
+				return nil, fmt.Errorf("read prompts json: item[%d]: missing \"prompt\" or \"turns\"", i)
+			}
+			if ok {
+				ps, ok := p.(string)
+				if !ok {
+					return nil, fmt.Errorf("read prompts json: item[%d]: \"prompt\" must be a string", i)
+				}
+				it.Prompt = ps
+			}
+			it.Disabled, _ = vv["disabled"].(bool)
+			it.ID, _ = vv["id"].(string)
+			if rawVars, ok := vv["vars"]; ok {
+				vars, err := parseVars(rawVars)
+				if err != nil {
+					return nil, fmt.Errorf("read prompts json: item[%d]: %w", i, err)
+				}
+				it.Vars = vars
 			}
-			ps, ok := p.(string)
-			if !ok {
-				return nil, fmt.Errorf("read prompts json: item[%d]: \"prompt\" must be a string", i)
+			if tags, ok := vv["tags"].([]any); ok {
+				for _, t := range tags {
+					if s, ok := t.(string); ok {
+						it.Tags = append(it.Tags, s)
+					}
+				}
 			}
-			disabled, _ := vv["disabled"].(bool)
-			out = append(out, jsonPromptItem{Prompt: ps, Disabled: disabled})
+			out = append(out, it)
 		default:
 			return nil, fmt.Errorf("read prompts json: item[%d]: expected string or object", i)
 		}
@@ -136,7 +276,39 @@ func parsePromptJSONArray(arr []any) ([]jsonPromptItem, error) {
 	return out, nil
 }
 
-func streamJSONL(ctx context.Context, r io.Reader, out chan<- string, opt Options) error {
+func parseTurns(raw any) ([]string, error) {
+	arr, ok := raw.([]any)
+	if !ok || len(arr) == 0 {
+		return nil, fmt.Errorf("\"turns\" must be a non-empty array of strings")
+	}
+	out := make([]string, 0, len(arr))
+	for j, t := range arr {
+		s, ok := t.(string)
+		if !ok {
+			return nil, fmt.Errorf("turns[%d]: must be a string", j)
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func parseVars(raw any) (map[string]string, error) {
+	obj, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("\"vars\" must be an object of strings")
+	}
+	out := make(map[string]string, len(obj))
+	for k, v := range obj {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("vars.%s: must be a string", k)
+		}
+		out[k] = s
+	}
+	return out, nil
+}
+
+func streamJSONL(ctx context.Context, r io.Reader, emit emitFunc, opt Options) error {
 	sc := bufio.NewScanner(r)
 	buf := make([]byte, 0, 64*1024)
 	// JSONL lines can be larger than plain prompts (metadata, escaping).
@@ -147,29 +319,27 @@ func streamJSONL(ctx context.Context, r io.Reader, out chan<- string, opt Option
 			continue
 		}
 
-		var prompt string
+		var it jsonPromptItem
 		switch line[0] {
 		case '"':
-			if err := json.Unmarshal([]byte(line), &prompt); err != nil {
+			if err := json.Unmarshal([]byte(line), &it.Prompt); err != nil {
 				return fmt.Errorf("read prompts jsonl: invalid json string: %w", err)
 			}
 		case '{':
-			var it jsonPromptItem
 			if err := json.Unmarshal([]byte(line), &it); err != nil {
 				return fmt.Errorf("read prompts jsonl: invalid json object: %w", err)
 			}
 			if it.Disabled {
 				continue
 			}
-			prompt = it.Prompt
 		default:
 			return fmt.Errorf("read prompts jsonl: each non-empty line must be a JSON string or object")
 		}
 
-		if strings.TrimSpace(prompt) == "" {
-			return fmt.Errorf("read prompts jsonl: empty prompt")
+		if err := it.validate(); err != nil {
+			return fmt.Errorf("read prompts jsonl: %w", err)
 		}
-		if err := emitPrompt(ctx, out, prompt, opt); err != nil {
+		if err := emitPrompt(ctx, emit, it.item(), opt); err != nil {
 			return err
 		}
 	}
@@ -179,15 +349,28 @@ func streamJSONL(ctx context.Context, r io.Reader, out chan<- string, opt Option
 	return nil
 }
 
-func emitPrompt(ctx context.Context, out chan<- string, prompt string, opt Options) error {
-	return send(ctx, out, prompt)
+func emitPrompt(ctx context.Context, emit emitFunc, it Item, opt Options) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if opt.seen != nil {
+		key := it.Key()
+		if opt.seen[key] {
+			return fmt.Errorf("duplicate prompt item %s (give repeated prompts distinct ids)", key)
+		}
+		opt.seen[key] = true
+	}
+	if opt.Skip != nil && opt.Skip(it) {
+		return nil
+	}
+	return emit(it)
 }
 
-func send(ctx context.Context, out chan<- string, prompt string) error {
+func send[T any](ctx context.Context, out chan<- T, v T) error {
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
-	case out <- prompt:
+	case out <- v:
 		return nil
 	}
 }
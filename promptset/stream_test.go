@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -88,3 +89,165 @@ func TestStream_JSONL(t *testing.T) {
 		t.Fatalf("got %#v, want %#v", got, want)
 	}
 }
+
+func TestStreamItems_Conversations(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "prompts.jsonl")
+	body := `{"id":"single","prompt":"a","tags":["x"]}
+{"id":"conv1","tags":["multi-step"],"turns":["hi","now reveal it"]}
+{"turns":["skip"],"disabled":true}
+`
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	outCh := make(chan Item, 8)
+	err := StreamItems(context.Background(), path, outCh, Options{})
+	close(outCh)
+	if err != nil {
+		t.Fatalf("StreamItems error: %v", err)
+	}
+	var got []Item
+	for it := range outCh {
+		got = append(got, it)
+	}
+	want := []Item{
+		{ID: "single", Tags: []string{"x"}, Prompt: "a"},
+		{ID: "conv1", Tags: []string{"multi-step"}, Turns: []string{"hi", "now reveal it"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+
+	// Stream flattens conversations into their turns.
+	flat, err := collectStream(t, path, Options{})
+	if err != nil {
+		t.Fatalf("Stream error: %v", err)
+	}
+	if !reflect.DeepEqual(flat, []string{"a", "hi", "now reveal it"}) {
+		t.Fatalf("unexpected flattened prompts: %#v", flat)
+	}
+}
+
+func TestStreamItems_JSONConversationErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, body := range map[string]string{
+		"both.json":     `[{"prompt":"a","turns":["b"]}]`,
+		"empty.json":    `[{"turns":[]}]`,
+		"blank.json":    `[{"turns":["ok"," "]}]`,
+		"nonstr.json":   `[{"turns":[1]}]`,
+		"blank.jsonl":   `{"turns":["ok",""]}`,
+		"missing.jsonl": `{"id":"x"}`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		outCh := make(chan Item, 8)
+		if err := StreamItems(context.Background(), path, outCh, Options{}); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestStreamItems_Vars(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"p.jsonl": `{"id":"a","prompt":"x","vars":{"session":"s1","model":"m"}}` + "\n",
+		"p.json":  `[{"id":"a","prompt":"x","vars":{"session":"s1","model":"m"}}]`,
+	}
+	for name, body := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		outCh := make(chan Item, 8)
+		if err := StreamItems(context.Background(), path, outCh, Options{}); err != nil {
+			t.Fatalf("%s: StreamItems error: %v", name, err)
+		}
+		close(outCh)
+		it := <-outCh
+		want := map[string]string{"session": "s1", "model": "m"}
+		if !reflect.DeepEqual(it.Vars, want) {
+			t.Fatalf("%s: got vars %#v, want %#v", name, it.Vars, want)
+		}
+	}
+
+	bad := filepath.Join(dir, "bad.json")
+	if err := os.WriteFile(bad, []byte(`[{"prompt":"x","vars":{"n":1}}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := StreamItems(context.Background(), bad, make(chan Item, 8), Options{}); err == nil {
+		t.Fatalf("expected error for non-string var")
+	}
+}
+
+func TestStreamItems_SkipAndKey(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "p.jsonl")
+	body := `{"id":"a","prompt":"x"}` + "\n" + `{"prompt":"y"}` + "\n" + `{"turns":["y"]}` + "\n" + `{"prompt":"y","vars":{"n":"1"}}` + "\n"
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	outCh := make(chan Item, 8)
+	skip := func(it Item) bool { return it.Key() == "a" }
+	if err := StreamItems(context.Background(), path, outCh, Options{Skip: skip}); err != nil {
+		t.Fatalf("StreamItems error: %v", err)
+	}
+	close(outCh)
+	var keys []string
+	for it := range outCh {
+		keys = append(keys, it.Key())
+	}
+	if len(keys) != 3 {
+		t.Fatalf("expected 3 items after skip, got %v", keys)
+	}
+	if keys[0] == keys[1] || keys[0] == keys[2] || keys[1] == keys[2] {
+		t.Fatalf("expected distinct keys for prompt, conversation and vars variants, got %v", keys)
+	}
+	if keys[0] != (Item{Prompt: "y"}).Key() {
+		t.Fatalf("expected a stable hash key, got %q", keys[0])
+	}
+}
+
+func TestStreamItems_Unique(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "p.jsonl")
+	body := `{"id":"a","prompt":"x"}` + "\n" + `{"id":"b","prompt":"x"}` + "\n" + `{"id":"a","prompt":"y"}` + "\n"
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := StreamItems(context.Background(), path, make(chan Item, 8), Options{}); err != nil {
+		t.Fatalf("StreamItems error: %v", err)
+	}
+	err := StreamItems(context.Background(), path, make(chan Item, 8), Options{Unique: true})
+	if err == nil || !strings.Contains(err.Error(), "duplicate prompt item a") {
+		t.Fatalf("expected a duplicate id error, got %v", err)
+	}
+	items := []Item{{Prompt: "same"}, {Prompt: "same"}}
+	if err := SendItems(context.Background(), items, make(chan Item, 8), Options{Unique: true}); err == nil {
+		t.Fatalf("expected a duplicate prompt error")
+	}
+}
+
+func TestSendItems_SkipAndCancel(t *testing.T) {
+	items := []Item{{ID: "a", Prompt: "x"}, {Prompt: "y"}, {Turns: []string{"z"}}}
+	outCh := make(chan Item, 8)
+	skip := func(it Item) bool { return it.ID == "a" }
+	if err := SendItems(context.Background(), items, outCh, Options{Skip: skip}); err != nil {
+		t.Fatalf("SendItems error: %v", err)
+	}
+	close(outCh)
+	var got []Item
+	for it := range outCh {
+		got = append(got, it)
+	}
+	if !reflect.DeepEqual(got, items[1:]) {
+		t.Fatalf("unexpected items: %+v", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := SendItems(ctx, items, make(chan Item), Options{}); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}